@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/backup"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// administration commands, run instead of the server
+// with `dahu <command> [flags]`. Return the exit code.
+func runCommand(conf *configuration.Conf, name string, args []string) int {
+	switch name {
+	case "migrate":
+		return migrateCommand(conf, args)
+	case "backup":
+		return backupCommand(conf, args)
+	case "restore":
+		return restoreCommand(conf, args)
+	case "rotate-key":
+		return rotateKeyCommand(conf, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s. Available commands : migrate, backup, restore, rotate-key\n", name)
+		return 2
+	}
+}
+
+// play the pending migrations of the database, which
+// must not be used by a running server at the same time.
+func migrateCommand(conf *configuration.Conf, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be migrated, without writing anything")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	report, err := persistence.Migrate(conf, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR >> migration failed : %s\n", err.Error())
+		return 1
+	}
+	fmt.Print(report.String())
+	return 0
+}
+
+// the flags shared by backup and restore
+func backupFlags(name string, conf *configuration.Conf) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&conf.BackupConf.KeyFile, "key-file", conf.BackupConf.KeyFile, "file of the secret encrypting the backup")
+	return flags
+}
+
+// write a backup of the database, which must not be used
+// by a running server : its /backup endpoint is to be used instead.
+func backupCommand(conf *configuration.Conf, args []string) int {
+	flags := backupFlags("backup", conf)
+	output := flags.String("o", "", "file of the backup. The standard output when empty")
+	flags.BoolVar(&conf.BackupConf.Compress, "compress", conf.BackupConf.Compress, "gzip the backup")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR >> backup failed : %s\n", err.Error())
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	err := backup.WriteSnapshot(conf, w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR >> backup failed : %s\n", err.Error())
+		if *output != "" {
+			os.Remove(*output)
+		}
+		return 1
+	}
+	return 0
+}
+
+// replace the database with a backup. The
+// server must be stopped.
+func restoreCommand(conf *configuration.Conf, args []string) int {
+	flags := backupFlags("restore", conf)
+	input := flags.String("i", "", "file of the backup. The standard input when empty")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR >> restore failed : %s\n", err.Error())
+			return 1
+		}
+		defer file.Close()
+		r = file
+	}
+	version, err := backup.Restore(conf, r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR >> restore failed : %s\n", err.Error())
+		return 1
+	}
+	fmt.Printf("the database has been restored (schema version %d)\n", version)
+	return 0
+}
+
+// encrypt the stored credentials with a new master key. The server
+// must be stopped, then started with the new key.
+func rotateKeyCommand(conf *configuration.Conf, args []string) int {
+	flags := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	flags.StringVar(&conf.PersistenceConf.MasterKeyFile, "key-file", conf.PersistenceConf.MasterKeyFile, "file of the current master key. The credentials are stored in clear when empty")
+	newKeyFile := flags.String("new-key-file", "", "file of the new master key")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *newKeyFile == "" {
+		fmt.Fprintln(os.Stderr, "ERROR >> the file of the new master key is missing (-new-key-file)")
+		return 2
+	}
+	changes, err := persistence.RotateMasterKey(conf, *newKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR >> key rotation failed : %s\n", err.Error())
+		return 1
+	}
+	fmt.Printf("the credentials of %d job(s) and registry(ies) have been encrypted with the new master key\n", changes)
+	return 0
+}
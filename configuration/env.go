@@ -0,0 +1,219 @@
+package configuration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// override the configuration with the environment
+// variables DAHU_<SECTION>_<FIELD>, see the README.
+// lookup is os.LookupEnv, except in the tests. The
+// variables that can't be parsed are all reported
+// in the returned error.
+func ReadEnv(c *Conf, lookup func(string) (string, bool)) error {
+	r := &envReader{lookup: lookup}
+	readPersistenceEnv(r, &c.PersistenceConf)
+	readSmtpEnv(r, &c.SmtpConf)
+	readCommitStatusEnv(r, &c.CommitStatusConf)
+	readOidcEnv(r, &c.OidcConf)
+	readLdapEnv(r, &c.LdapConf)
+	readAuthenticationEnv(r, &c.ApiConf)
+	readSigningEnv(r, &c.ApiConf)
+	readBackupEnv(r, &c.BackupConf)
+	readRetentionEnv(r, &c.RetentionConf)
+	r.string("DAHU_API_EXTERNAL_URL", &c.ApiConf.ExternalUrl)
+	if len(r.errs) > 0 {
+		return fmt.Errorf("configuration >> invalid environment variables : %s", strings.Join(r.errs, ", "))
+	}
+	return nil
+}
+
+func readPersistenceEnv(r *envReader, persistence *Persistence) {
+	if value, ok := r.lookup("DAHU_PERSISTENCE_TYPE"); ok {
+		switch value {
+		case "bbolt":
+			persistence.Type = InMemory
+		case "sqlite":
+			persistence.Type = SQLite
+		case "postgres":
+			persistence.Type = PostgreSQL
+		default:
+			r.errs = append(r.errs, "DAHU_PERSISTENCE_TYPE is neither bbolt, sqlite nor postgres")
+		}
+	}
+	r.string("DAHU_PERSISTENCE_URL", &persistence.Url)
+	r.string("DAHU_PERSISTENCE_NAME", &persistence.Name)
+	r.string("DAHU_DATA_DIR", &persistence.DataDir)
+	r.string("DAHU_MASTER_KEY_FILE", &persistence.MasterKeyFile)
+	r.duration("DAHU_PERSISTENCE_TIMEOUT", &persistence.TimeOut)
+	r.duration("DAHU_PERSISTENCE_OPEN_TIMEOUT", &persistence.OpenTimeOut)
+}
+
+func readSmtpEnv(r *envReader, smtp *Smtp) {
+	r.string("DAHU_SMTP_HOST", &smtp.Host)
+	r.int("DAHU_SMTP_PORT", &smtp.Port)
+	r.string("DAHU_SMTP_USER", &smtp.User)
+	r.string("DAHU_SMTP_PASSWORD", &smtp.Password)
+	r.string("DAHU_SMTP_FROM", &smtp.From)
+	r.string("DAHU_SMTP_SUBJECT_TEMPLATE", &smtp.SubjectTemplate)
+	r.string("DAHU_SMTP_BODY_TEMPLATE", &smtp.BodyTemplate)
+	r.int("DAHU_SMTP_LOG_TAIL_SIZE", &smtp.LogTailSize)
+}
+
+func readCommitStatusEnv(r *envReader, commitStatus *CommitStatus) {
+	r.string("DAHU_COMMIT_STATUS_PROVIDER", &commitStatus.Provider)
+	r.string("DAHU_COMMIT_STATUS_API_URL", &commitStatus.ApiUrl)
+	r.string("DAHU_COMMIT_STATUS_TOKEN", &commitStatus.Token)
+}
+
+func readOidcEnv(r *envReader, oidc *Oidc) {
+	r.string("DAHU_OIDC_ISSUER", &oidc.Issuer)
+	r.string("DAHU_OIDC_CLIENT_ID", &oidc.ClientId)
+	r.string("DAHU_OIDC_CLIENT_SECRET", &oidc.ClientSecret)
+	r.list("DAHU_OIDC_SCOPES", &oidc.Scopes)
+	r.string("DAHU_OIDC_LOGIN_CLAIM", &oidc.LoginClaim)
+	r.string("DAHU_OIDC_GROUPS_CLAIM", &oidc.GroupsClaim)
+	r.roles("DAHU_OIDC_GROUP_ROLES", &oidc.GroupRoles)
+	r.bool("DAHU_OIDC_AUTO_PROVISIONING", &oidc.AutoProvisioning)
+}
+
+func readLdapEnv(r *envReader, ldap *Ldap) {
+	r.string("DAHU_LDAP_URL", &ldap.Url)
+	r.bool("DAHU_LDAP_START_TLS", &ldap.StartTls)
+	r.string("DAHU_LDAP_BIND_DN", &ldap.BindDn)
+	r.string("DAHU_LDAP_BIND_PASSWORD", &ldap.BindPassword)
+	r.string("DAHU_LDAP_BASE_DN", &ldap.BaseDn)
+	r.string("DAHU_LDAP_USER_FILTER", &ldap.UserFilter)
+	r.string("DAHU_LDAP_GROUP_ATTRIBUTE", &ldap.GroupAttribute)
+	r.roles("DAHU_LDAP_GROUP_ROLES", &ldap.GroupRoles)
+	r.duration("DAHU_LDAP_TIMEOUT", &ldap.TimeOut)
+}
+
+func readAuthenticationEnv(r *envReader, api *Api) {
+	value, ok := r.lookup("DAHU_API_AUTHENTICATION")
+	if !ok {
+		return
+	}
+	switch value {
+	case "local":
+		api.Authentication = LocalAuthentication
+	case "ldap":
+		api.Authentication = LdapAuthentication
+	default:
+		r.errs = append(r.errs, "DAHU_API_AUTHENTICATION is neither local nor ldap")
+	}
+}
+
+// the algorithm is checked here, so that a typo
+// stops Dahu before anything is started
+func readSigningEnv(r *envReader, api *Api) {
+	r.string("DAHU_API_SECRET", &api.Secret)
+	if value, ok := r.lookup("DAHU_API_SIGNING_ALGORITHM"); ok {
+		switch value {
+		case "HS256", "RS256", "ES256":
+			api.SigningAlgorithm = value
+		default:
+			r.errs = append(r.errs, "DAHU_API_SIGNING_ALGORITHM is neither HS256, RS256 nor ES256")
+		}
+	}
+	r.string("DAHU_API_SIGNING_KEY_FILE", &api.SigningKeyFile)
+}
+
+func readBackupEnv(r *envReader, backup *Backup) {
+	r.bool("DAHU_BACKUP_COMPRESS", &backup.Compress)
+	r.string("DAHU_BACKUP_KEY_FILE", &backup.KeyFile)
+	r.string("DAHU_BACKUP_DIRECTORY", &backup.Directory)
+	r.duration("DAHU_BACKUP_INTERVAL", &backup.Interval)
+	r.int("DAHU_BACKUP_RETENTION", &backup.Retention)
+}
+
+func readRetentionEnv(r *envReader, retention *Retention) {
+	r.int("DAHU_RETENTION_KEEP_LAST", &retention.KeepLast)
+	r.int("DAHU_RETENTION_KEEP_DAYS", &retention.KeepDays)
+	r.bool("DAHU_RETENTION_KEEP_LAST_SUCCESS", &retention.KeepLastSuccess)
+	r.duration("DAHU_RETENTION_JANITOR_INTERVAL", &retention.JanitorInterval)
+}
+
+// parse the environment variables into the fields
+// of the configuration. The missing ones leave the
+// fields untouched.
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []string
+}
+
+func (r *envReader) string(name string, target *string) {
+	if value, ok := r.lookup(name); ok {
+		*target = value
+	}
+}
+
+func (r *envReader) int(name string, target *int) {
+	if value, ok := r.lookup(name); ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Sprintf("%s is not an integer", name))
+			return
+		}
+		*target = parsed
+	}
+}
+
+// a duration like 10s or 1h30m
+func (r *envReader) duration(name string, target *time.Duration) {
+	if value, ok := r.lookup(name); ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Sprintf("%s is not a duration", name))
+			return
+		}
+		*target = parsed
+	}
+}
+
+func (r *envReader) bool(name string, target *bool) {
+	if value, ok := r.lookup(name); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Sprintf("%s is not a boolean", name))
+			return
+		}
+		*target = parsed
+	}
+}
+
+// comma separated values, the empty ones being ignored
+func (r *envReader) list(name string, target *[]string) {
+	if value, ok := r.lookup(name); ok {
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		*target = values
+	}
+}
+
+// group=role pairs separated by semicolons, the groups
+// being possibly ldap dn, themselves holding commas and
+// equal signs
+func (r *envReader) roles(name string, target *map[string]string) {
+	if value, ok := r.lookup(name); ok {
+		roles := make(map[string]string)
+		for _, pair := range strings.Split(value, ";") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			i := strings.LastIndex(pair, "=")
+			if i <= 0 || i == len(pair)-1 {
+				r.errs = append(r.errs, fmt.Sprintf("%s is not a list of group=role", name))
+				return
+			}
+			roles[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+		}
+		*target = roles
+	}
+}
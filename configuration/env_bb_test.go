@@ -0,0 +1,164 @@
+package configuration_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+// test that the variables override the
+// configuration, the missing ones leaving it untouched
+func TestReadEnvShouldOverrideTheConfiguration(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	env := map[string]string{
+		"DAHU_SMTP_HOST":             "smtp.some.domain",
+		"DAHU_SMTP_PORT":             "587",
+		"DAHU_COMMIT_STATUS_TOKEN":   "some-token",
+		"DAHU_PERSISTENCE_TYPE":      "postgres",
+		"DAHU_PERSISTENCE_URL":       "postgres://dahu:secret@db/dahu",
+		"DAHU_DATA_DIR":              "/var/lib/dahu",
+		"DAHU_PERSISTENCE_TIMEOUT":   "30s",
+		"DAHU_API_SECRET":            "some-secret",
+		"DAHU_API_SIGNING_ALGORITHM": "ES256",
+		"DAHU_API_SIGNING_KEY_FILE":  "/etc/dahu/signing.pem",
+	}
+
+	// when
+	err := configuration.ReadEnv(conf, lookupIn(env))
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if conf.SmtpConf.Host != "smtp.some.domain" || conf.SmtpConf.Port != 587 {
+		t.Errorf("expect the smtp server to be read, but got %+v", conf.SmtpConf)
+	}
+	if conf.CommitStatusConf.Token != "some-token" {
+		t.Errorf("expect the commit status token to be read, but got %+v", conf.CommitStatusConf)
+	}
+	if conf.PersistenceConf.Type != configuration.PostgreSQL || conf.PersistenceConf.Url != "postgres://dahu:secret@db/dahu" ||
+		conf.PersistenceConf.DataDir != "/var/lib/dahu" || conf.PersistenceConf.TimeOut != 30*time.Second || conf.PersistenceConf.OpenTimeOut != 2*time.Second {
+		t.Errorf("expect the database to be read, but got %+v", conf.PersistenceConf)
+	}
+	if conf.ApiConf.Secret != "some-secret" || conf.ApiConf.SigningAlgorithm != "ES256" || conf.ApiConf.SigningKeyFile != "/etc/dahu/signing.pem" {
+		t.Errorf("expect the signing settings to be read, but got %+v", conf.ApiConf)
+	}
+	if conf.SmtpConf.From != "dahu@localhost" {
+		t.Errorf("expect the sender to be left untouched, but got %s", conf.SmtpConf.From)
+	}
+}
+
+// test the parsing of the lists, the booleans
+// and the mapping of the groups to roles
+func TestReadEnvShouldParseTheOidcSettings(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	env := map[string]string{
+		"DAHU_OIDC_ISSUER":            "https://sso.some.domain",
+		"DAHU_OIDC_SCOPES":            "profile, groups,",
+		"DAHU_OIDC_GROUP_ROLES":       "devs=maintainer; cn=admins,dc=some,dc=domain=admin",
+		"DAHU_OIDC_AUTO_PROVISIONING": "true",
+		"DAHU_API_EXTERNAL_URL":       "https://dahu.some.domain",
+	}
+
+	// when
+	err := configuration.ReadEnv(conf, lookupIn(env))
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if len(conf.OidcConf.Scopes) != 2 || conf.OidcConf.Scopes[0] != "profile" || conf.OidcConf.Scopes[1] != "groups" {
+		t.Errorf("expect the scopes to be read, but got %v", conf.OidcConf.Scopes)
+	}
+	if len(conf.OidcConf.GroupRoles) != 2 || conf.OidcConf.GroupRoles["devs"] != "maintainer" || conf.OidcConf.GroupRoles["cn=admins,dc=some,dc=domain"] != "admin" {
+		t.Errorf("expect the group roles to be read, but got %v", conf.OidcConf.GroupRoles)
+	}
+	if !conf.OidcConf.AutoProvisioning || conf.OidcConf.Issuer != "https://sso.some.domain" || conf.ApiConf.ExternalUrl != "https://dahu.some.domain" {
+		t.Errorf("expect the single sign-on to be read, but got %+v and %s", conf.OidcConf, conf.ApiConf.ExternalUrl)
+	}
+}
+
+// test the parsing of the durations, the
+// choice of the authentication backend, the
+// scheduled backups and the retention
+func TestReadEnvShouldParseTheLdapSettings(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	env := map[string]string{
+		"DAHU_API_AUTHENTICATION":          "ldap",
+		"DAHU_LDAP_URL":                    "ldaps://ldap.some.domain:636",
+		"DAHU_LDAP_GROUP_ROLES":            "cn=devs,ou=groups,dc=some,dc=domain=maintainer",
+		"DAHU_LDAP_TIMEOUT":                "3s",
+		"DAHU_BACKUP_DIRECTORY":            "/var/backups/dahu",
+		"DAHU_BACKUP_INTERVAL":             "6h",
+		"DAHU_RETENTION_KEEP_DAYS":         "30",
+		"DAHU_RETENTION_KEEP_LAST_SUCCESS": "false",
+	}
+
+	// when
+	err := configuration.ReadEnv(conf, lookupIn(env))
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if conf.ApiConf.Authentication != configuration.LdapAuthentication {
+		t.Errorf("expect the ldap authentication, but got %d", conf.ApiConf.Authentication)
+	}
+	if conf.LdapConf.Url != "ldaps://ldap.some.domain:636" || conf.LdapConf.TimeOut != 3*time.Second {
+		t.Errorf("expect the directory to be read, but got %+v", conf.LdapConf)
+	}
+	if conf.LdapConf.GroupRoles["cn=devs,ou=groups,dc=some,dc=domain"] != "maintainer" {
+		t.Errorf("expect the group roles to be read, but got %v", conf.LdapConf.GroupRoles)
+	}
+	if conf.BackupConf.Directory != "/var/backups/dahu" || conf.BackupConf.Interval != 6*time.Hour || conf.BackupConf.Retention != 7 {
+		t.Errorf("expect the scheduled backups to be read, but got %+v", conf.BackupConf)
+	}
+	if conf.RetentionConf.KeepDays != 30 || conf.RetentionConf.KeepLastSuccess || conf.RetentionConf.JanitorInterval != time.Hour {
+		t.Errorf("expect the retention to be read, but got %+v", conf.RetentionConf)
+	}
+}
+
+// test that every invalid variable is reported
+func TestReadEnvShouldReportTheInvalidVariables(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	env := map[string]string{
+		"DAHU_SMTP_PORT":                "smtp",
+		"DAHU_SMTP_LOG_TAIL_SIZE":       "twenty",
+		"DAHU_OIDC_GROUP_ROLES":         "devs",
+		"DAHU_LDAP_TIMEOUT":             "10",
+		"DAHU_API_AUTHENTICATION":       "kerberos",
+		"DAHU_PERSISTENCE_TYPE":         "mysql",
+		"DAHU_BACKUP_COMPRESS":          "gzip",
+		"DAHU_API_SIGNING_ALGORITHM":    "HS512",
+		"DAHU_PERSISTENCE_TIMEOUT":      "5",
+		"DAHU_PERSISTENCE_OPEN_TIMEOUT": "two seconds",
+	}
+
+	// when
+	err := configuration.ReadEnv(conf, lookupIn(env))
+
+	// then
+	if err == nil || !strings.Contains(err.Error(), "DAHU_SMTP_PORT") || !strings.Contains(err.Error(), "DAHU_SMTP_LOG_TAIL_SIZE") ||
+		!strings.Contains(err.Error(), "DAHU_OIDC_GROUP_ROLES") || !strings.Contains(err.Error(), "DAHU_LDAP_TIMEOUT") ||
+		!strings.Contains(err.Error(), "DAHU_API_AUTHENTICATION") || !strings.Contains(err.Error(), "DAHU_PERSISTENCE_TYPE") ||
+		!strings.Contains(err.Error(), "DAHU_BACKUP_COMPRESS") || !strings.Contains(err.Error(), "DAHU_API_SIGNING_ALGORITHM") ||
+		!strings.Contains(err.Error(), "DAHU_PERSISTENCE_TIMEOUT") || !strings.Contains(err.Error(), "DAHU_PERSISTENCE_OPEN_TIMEOUT") {
+		t.Fatalf("expect every variable to be reported, but got %v", err)
+	}
+	if conf.SmtpConf.Port != 25 || conf.ApiConf.SigningAlgorithm != "HS256" {
+		t.Errorf("expect the port and the algorithm to be left untouched, but got %d and %s", conf.SmtpConf.Port, conf.ApiConf.SigningAlgorithm)
+	}
+}
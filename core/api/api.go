@@ -1,36 +1,81 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/websocket"
 	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/auth"
+	"github.com/jeromedoucet/dahu/core/backup"
+	job_processing "github.com/jeromedoucet/dahu/core/job"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/oidc"
 	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/core/signing"
 	"github.com/jeromedoucet/route"
 )
 
 type Api struct {
-	conf       *configuration.Conf
-	router     *route.DynamicRouter
-	repository persistence.Repository
-	upgrader   websocket.Upgrader
+	conf          *configuration.Conf
+	router        *route.DynamicRouter
+	repository    persistence.Repository
+	keys          *signing.KeySet
+	authenticator auth.Authenticator
+	throttle      *loginThrottle
+	upgrader      websocket.Upgrader
+	oidc          *oidc.Provider // nil when the single sign-on is disabled
 }
 
+// register every route with the roles
+// required to read and to modify it. The creation
+// of jobs, registries and teams content is checked by
+// the handlers against the role inside the target team.
 func (a *Api) initRouter() {
+	viewer, maintainer, admin := model.RoleViewer, model.RoleMaintainer, model.RoleAdmin
 	a.router = route.NewDynamicRouter()
-	a.router.HandleFunc("/jobs", a.handleJobs, a.authFilter)
-	a.router.HandleFunc("/jobs/:jobId/executions", a.onStartJob, a.authFilter)
-	a.router.HandleFunc("/jobs/:jobId/executions/:executionId/cancelation", a.onCancelJobExecution, a.authFilter)
-	a.router.HandleFunc("/jobs/:jobId/live", a.onJobEventRegistration, a.authFilter)
+	a.router.HandleFunc("/jobs", a.handleJobs, a.authFilter, a.roleFilter(viewer, viewer))
+	a.router.HandleFunc("/jobs/:jobId/team", a.onMoveJob, a.authFilter, a.jobRoleFilter(maintainer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/executions", a.handleJobExecutions, a.authFilter, a.jobRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/stats", a.onJobExecutionStatsGet, a.authFilter, a.jobRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/executions/:executionId/cancelation", a.onCancelJobExecution, a.authFilter, a.jobRoleFilter(maintainer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/executions/:executionId/steps/:step/logs", a.onGetStepLogs, a.authFilter, a.jobRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/executions/:executionId/logs/search", a.onSearchExecutionLogs, a.authFilter, a.jobRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/logs/search", a.onSearchJobLogs, a.authFilter, a.jobRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/jobs/:jobId/live", a.onJobEventRegistration, a.authFilter, a.jobRoleFilter(viewer, maintainer))
 	a.router.HandleFunc("/login", a.handleAuthentication)
-	a.router.HandleFunc("/scm/git/repository", a.handleGitRepositories, a.authFilter)
-	a.router.HandleFunc("/containers/docker/registries/test", a.handleDockerRegistryCheck, a.authFilter)
-	a.router.HandleFunc("/containers/docker/registries", a.handleDockerRegistries, a.authFilter)
-	a.router.HandleFunc("/containers/docker/registries/:registryId", a.handleDockerRegistry, a.authFilter)
+	a.router.HandleFunc("/refresh", a.handleRefresh)
+	a.router.HandleFunc("/logout", a.onLogout, a.authFilter)
+	if a.oidc != nil {
+		a.router.HandleFunc("/oidc/login", a.onOidcLogin)
+		a.router.HandleFunc(oidcCallbackPath, a.onOidcCallback)
+	}
+	a.router.HandleFunc("/users", a.handleUsers, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/users/:login", a.handleUser, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/users/:login/lock", a.onUserUnlock, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/logins", a.onLoginAttemptsGet, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/audit", a.onAuditGet, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/backup", a.onBackupGet, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/export", a.onConfigurationExport, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/import", a.onConfigurationImport, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/keys/rotation", a.onSigningKeyRotation, a.authFilter, a.roleFilter(admin, admin))
+	a.router.HandleFunc("/.well-known/jwks.json", a.onJwksGet)
+	a.router.HandleFunc(passwordChangePath, a.onPasswordChange, a.authFilter)
+	a.router.HandleFunc("/me/tokens", a.handleApiTokens, a.authFilter)
+	a.router.HandleFunc("/me/tokens/:tokenId", a.onApiTokenDelete, a.authFilter)
+	a.router.HandleFunc("/scm/git/repository", a.handleGitRepositories, a.authFilter, a.roleFilter(maintainer, maintainer))
+	a.router.HandleFunc("/containers/docker/registries/test", a.handleDockerRegistryCheck, a.authFilter, a.roleFilter(maintainer, maintainer))
+	a.router.HandleFunc("/containers/docker/registries", a.handleDockerRegistries, a.authFilter, a.roleFilter(viewer, viewer))
+	a.router.HandleFunc("/containers/docker/registries/:registryId", a.handleDockerRegistry, a.authFilter, a.registryRoleFilter(viewer, maintainer))
+	a.router.HandleFunc("/containers/docker/registries/:registryId/team", a.onMoveDockerRegistry, a.authFilter, a.registryRoleFilter(maintainer, maintainer))
+	a.router.HandleFunc("/teams", a.handleTeams, a.authFilter, a.roleFilter(viewer, admin))
+	a.router.HandleFunc("/teams/:teamId", a.handleTeam, a.authFilter, a.roleFilter(viewer, viewer))
 }
 
 func (a *Api) Handler() http.Handler {
-	return a.router
+	return withTokenCheck(a.router)
 }
 
 // todo pass a context for timeout
@@ -45,6 +90,36 @@ func InitRoute(c *configuration.Conf) *Api {
 	a := new(Api)
 	a.conf = c
 	a.repository = persistence.GetRepository(c)
+	keys, err := signing.NewKeySet(c.ApiConf, a.repository, context.Background())
+	if err != nil {
+		log.Fatalf("FATAL >> unable to load the signing keys : %s", err.Error())
+	}
+	a.keys = keys
+	a.authenticator = auth.GetAuthenticator(c, a.repository)
+	a.throttle = newLoginThrottle(c.ApiConf)
+	if c.OidcConf.Issuer != "" {
+		a.oidc = oidc.NewProvider(c.OidcConf)
+	}
+	if c.BackupConf.Directory != "" {
+		go backup.Schedule(c, a.repository)
+	}
+	if c.RetentionConf.JanitorInterval > 0 {
+		go job_processing.Janitor(c, a.repository)
+	}
 	a.initRouter()
 	return a
 }
+
+// writeJson answer with the given
+// status and value as json body
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("ERROR >> writeJson encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
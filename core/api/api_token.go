@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/route"
+)
+
+// the last use of an api token is
+// only recorded with this precision, to
+// avoid a write on every request.
+const apiTokenUsePrecision = time.Minute
+
+// check an api token value and return claims equivalent
+// to the ones of a session token of its owner. The
+// claims also carry the id and the scopes of the api token.
+func (a *Api) checkApiToken(ctx context.Context, value string) (jwt.MapClaims, error) {
+	hash := model.HashTokenValue(value)
+	token, err := a.repository.GetApiTokenByHash(hash, ctx)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	now := time.Now()
+	if token.IsExpired(now) {
+		return nil, errors.New("expired token")
+	}
+	user, err := a.repository.GetUser(token.Login, ctx)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenUsePrecision {
+		touchErr := a.repository.TouchApiToken(hash, now, ctx)
+		if touchErr != nil {
+			log.Printf("ERROR >> checkApiToken encounter error : %s", touchErr.Error())
+		}
+	}
+	return jwt.MapClaims{
+		"sub":    token.Login,
+		"role":   string(user.GetRole()),
+		"tid":    token.Id,
+		"scopes": token.Scopes,
+	}, nil
+}
+
+// true if the claims come from an api token
+func isApiToken(claims jwt.MapClaims) bool {
+	_, ok := claims["tid"]
+	return ok
+}
+
+// check that the scopes of an api token allow the
+// request. Session tokens are not limited by any scope.
+func scopesAllow(claims jwt.MapClaims, r *http.Request) bool {
+	if !isApiToken(claims) {
+		return true
+	}
+	path := route.SplitPath(r.URL.Path)
+	if len(path) > 1 && path[0] == "me" && path[1] == "tokens" {
+		// an api token can't be used to get new ones
+		return false
+	}
+	token := model.ApiToken{}
+	token.Scopes, _ = claims["scopes"].([]model.Scope)
+	if token.HasScope(model.ScopeWrite) {
+		return true
+	}
+	if r.Method == http.MethodGet {
+		return token.HasScope(model.ScopeRead) || token.HasScope(model.ScopeJobsRun)
+	}
+	if token.HasScope(model.ScopeJobsRun) && isJobRunPath(path) {
+		return true
+	}
+	return false
+}
+
+// true for job execution start and cancelation
+func isJobRunPath(path []string) bool {
+	if len(path) < 3 || path[0] != "jobs" || path[2] != "executions" {
+		return false
+	}
+	return len(path) == 3 || (len(path) == 5 && path[4] == "cancelation")
+}
+
+// switch choice for request on the api tokens of the authenticated user
+func (a *Api) handleApiTokens(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onApiTokensGet(ctx, w, r)
+	} else if r.Method == http.MethodPost {
+		a.onApiTokenCreation(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// list the api tokens of the authenticated user
+func (a *Api) onApiTokensGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	login := a.tokenSubject(r)
+	tokens, persistenceErr := a.repository.GetApiTokens(login, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onApiTokensGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	for _, token := range tokens {
+		token.ToPublicModel()
+	}
+	writeJson(w, http.StatusOK, tokens)
+}
+
+// create a new api token for the authenticated user.
+// The value is returned once, and never stored.
+func (a *Api) onApiTokenCreation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	login := a.tokenSubject(r)
+	if login == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("the token is not bound to any user"))
+		return
+	}
+	var creation model.ApiTokenCreation
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&creation)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !creation.IsValid() {
+		writeApiError(w, http.StatusBadRequest, errors.New("an api token must have a name and known scopes"))
+		return
+	}
+	if creation.ExpiresAt != nil && creation.ExpiresAt.Before(time.Now()) {
+		writeApiError(w, http.StatusBadRequest, errors.New("the expiration date is already passed"))
+		return
+	}
+	value, hash, err := model.NewApiTokenValue()
+	if err != nil {
+		log.Printf("ERROR >> onApiTokenCreation encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	token := model.ApiToken{
+		Name:      creation.Name,
+		Login:     login,
+		Hash:      hash,
+		Scopes:    creation.Scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: creation.ExpiresAt,
+	}
+	newToken, persistenceErr := a.repository.CreateApiToken(&token, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onApiTokenCreation encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	newToken.ToPublicModel()
+	writeJson(w, http.StatusCreated, model.ApiTokenValue{Token: *newToken, Value: value})
+}
+
+// revoke one api token of the authenticated user
+func (a *Api) onApiTokenDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	tokenId := path[len(path)-1]
+	persistenceErr := a.repository.DeleteApiToken(ctx, a.tokenSubject(r), tokenId)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onApiTokenDelete encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
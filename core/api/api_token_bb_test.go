@@ -0,0 +1,123 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// create an api token for bob through the api
+func createApiToken(t *testing.T, url string, creation model.ApiTokenCreation) model.ApiTokenValue {
+	resp := doUserRequest(t, "POST", fmt.Sprintf("%s/me/tokens", url), "bob", model.RoleMaintainer, creation)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expect 201 when creating an api token, but got %d", resp.StatusCode)
+	}
+	var created model.ApiTokenValue
+	json.NewDecoder(resp.Body).Decode(&created)
+	return created
+}
+
+// test that the scopes of an api token
+// restrict what it can do
+func TestApiTokenScopes(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleMaintainer})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	readToken := createApiToken(t, s.URL, model.ApiTokenCreation{Name: "read", Scopes: []model.Scope{model.ScopeRead}})
+	runToken := createApiToken(t, s.URL, model.ApiTokenCreation{Name: "run", Scopes: []model.Scope{model.ScopeJobsRun}})
+
+	// when
+	readGetResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), readToken.Value, nil)
+	readCancelResp := doRequest(t, "POST", fmt.Sprintf("%s/jobs/1/executions/2/cancelation", s.URL), readToken.Value, nil)
+	runCancelResp := doRequest(t, "POST", fmt.Sprintf("%s/jobs/1/executions/2/cancelation", s.URL), runToken.Value, nil)
+	runCreateResp := doRequest(t, "POST", fmt.Sprintf("%s/jobs", s.URL), runToken.Value, model.Job{Name: "test"})
+	tokenCreationResp := doRequest(t, "POST", fmt.Sprintf("%s/me/tokens", s.URL), runToken.Value,
+		model.ApiTokenCreation{Name: "other", Scopes: []model.Scope{model.ScopeWrite}})
+
+	// then
+	if readGetResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when reading with a read token, but got %d", readGetResp.StatusCode)
+	}
+	if readCancelResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when canceling with a read token, but got %d", readCancelResp.StatusCode)
+	}
+	if runCancelResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when canceling with a jobs:run token, but got %d", runCancelResp.StatusCode)
+	}
+	if runCreateResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when creating a job with a jobs:run token, but got %d", runCreateResp.StatusCode)
+	}
+	if tokenCreationResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when creating a token with an api token, but got %d", tokenCreationResp.StatusCode)
+	}
+}
+
+// test the listing, the last use
+// tracking and the revocation of tokens
+func TestApiTokenListAndRevoke(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleMaintainer})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	token := createApiToken(t, s.URL, model.ApiTokenCreation{Name: "ci", Scopes: []model.Scope{model.ScopeRead}})
+
+	// when
+	usedResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), token.Value, nil)
+	listResp := doUserRequest(t, "GET", fmt.Sprintf("%s/me/tokens", s.URL), "bob", model.RoleMaintainer, nil)
+	revokeResp := doUserRequest(t, "DELETE", fmt.Sprintf("%s/me/tokens/%s", s.URL, token.Token.Id), "bob", model.RoleMaintainer, nil)
+	revokedResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), token.Value, nil)
+
+	// then
+	if usedResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when using the token, but got %d", usedResp.StatusCode)
+	}
+	var tokens []model.ApiToken
+	json.NewDecoder(listResp.Body).Decode(&tokens)
+	if len(tokens) != 1 || tokens[0].Name != "ci" || tokens[0].Hash != "" || tokens[0].LastUsedAt == nil {
+		t.Fatalf("Expect the used token without its hash, but got %+v", tokens)
+	}
+	if revokeResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when revoking the token, but got %d", revokeResp.StatusCode)
+	}
+	if revokedResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 when using a revoked token, but got %d", revokedResp.StatusCode)
+	}
+}
+
+// test that an expired token is rejected
+func TestApiTokenExpired(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleMaintainer})
+	value, hash, _ := model.NewApiTokenValue()
+	expiresAt := time.Now().Add(-1 * time.Minute)
+	tests.InsertObject(conf, []byte("apiTokens"), []byte(hash), model.ApiToken{Id: "1", Login: "bob", Hash: hash,
+		Scopes: []model.Scope{model.ScopeRead}, ExpiresAt: &expiresAt})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), value, nil)
+
+	// then
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 when using an expired token, but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// number of audit entries returned
+// when no limit is given
+const defaultAuditLimit = 100
+
+// append an entry to the audit trail, with the owner of the token as
+// actor and the changes between before and after. A failure is logged
+// but never fails the request: the change itself is already done.
+func (a *Api) audit(ctx context.Context, r *http.Request, action model.AuditAction, resourceType model.AuditResource, resourceId string, before, after interface{}) {
+	entry := model.AuditEntry{
+		Time:         time.Now(),
+		Actor:        a.tokenSubject(r),
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceId:   resourceId,
+		Changes:      model.Diff(before, after),
+	}
+	_, persistenceErr := a.repository.CreateAuditEntry(&entry, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> audit encounter error : %s", persistenceErr.Error())
+	}
+}
+
+// list the audit entries, newest first. They may be filtered by
+// actor, action, resourceType, resourceId and by a time range
+// (since included, until excluded) given in RFC 3339.
+func (a *Api) onAuditGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	filter := model.AuditFilter{
+		Actor:        query.Get("actor"),
+		Action:       model.AuditAction(query.Get("action")),
+		ResourceType: model.AuditResource(query.Get("resourceType")),
+		ResourceId:   query.Get("resourceId"),
+	}
+	var err error
+	filter.Limit, err = intParam(query.Get("limit"), defaultAuditLimit)
+	if err != nil || filter.Limit <= 0 {
+		writeApiError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+		return
+	}
+	filter.Since, err = timeParam(query.Get("since"))
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Errorf("since must be a RFC 3339 time : %s", err))
+		return
+	}
+	filter.Until, err = timeParam(query.Get("until"))
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Errorf("until must be a RFC 3339 time : %s", err))
+		return
+	}
+	entries, persistenceErr := a.repository.GetAuditEntries(filter, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onAuditGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	writeJson(w, http.StatusOK, entries)
+}
+
+// parse an optional RFC 3339 time
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
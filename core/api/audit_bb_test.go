@@ -0,0 +1,95 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that the changes of a team are
+// audited, without the values of its secrets
+func TestAuditOfTeamChanges(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "alice", Role: model.RoleAdmin})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	creationResp := doUserRequest(t, "POST", fmt.Sprintf("%s/teams", s.URL), "alice", model.RoleAdmin,
+		model.Team{Name: "dev", Secrets: map[string]string{"TOKEN": "s3cr3t"}})
+	var team model.Team
+	json.NewDecoder(creationResp.Body).Decode(&team)
+	doUserRequest(t, "PUT", fmt.Sprintf("%s/teams/%s", s.URL, team.Id), "alice", model.RoleAdmin,
+		model.Team{Name: "developers", Secrets: map[string]string{"TOKEN": "n3w"}})
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/audit?resourceType=team&actor=alice", s.URL), "alice", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200, but got %d", resp.StatusCode)
+	}
+	var entries []model.AuditEntry
+	json.NewDecoder(resp.Body).Decode(&entries)
+	if len(entries) != 2 || entries[0].Action != model.AuditUpdate || entries[1].Action != model.AuditCreate {
+		t.Fatalf("Expect the update then the creation of the team, but got %+v", entries)
+	}
+	if entries[0].Actor != "alice" || entries[0].ResourceId != team.Id {
+		t.Fatalf("Expect the update to be done by alice on the team, but got %+v", entries[0])
+	}
+	changes := entries[0].Changes
+	if len(changes) != 2 || changes[0].Field != "name" || changes[0].Old != "dev" || changes[0].New != "developers" {
+		t.Fatalf("Expect the name change, but got %+v", changes)
+	}
+	if changes[1].Field != "secrets.TOKEN" || !changes[1].Secret || changes[1].Old != nil || changes[1].New != nil {
+		t.Fatalf("Expect the secret change without its values, but got %+v", changes[1])
+	}
+}
+
+// test that the audit trail
+// is restricted to admins
+func TestAuditForbiddenToNonAdmin(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleMaintainer})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/audit", s.URL), "bob", model.RoleMaintainer, nil)
+
+	// then
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403, but got %d", resp.StatusCode)
+	}
+}
+
+// test that a malformed time
+// range is rejected
+func TestAuditWithBadTimeRange(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "alice", Role: model.RoleAdmin})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/audit?since=yesterday", s.URL), "alice", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400, but got %d", resp.StatusCode)
+	}
+}
@@ -2,43 +2,239 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/core/auth"
 	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
 )
 
+// authenticate a user with its login and password. All failures
+// get the same answer, and are slowed down by the login throttle.
 func (a *Api) handleAuthentication(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	l := model.Login{}
 	d := json.NewDecoder(r.Body)
 	d.Decode(&l) // todo handle this error
-	u, err := a.repository.GetUser(l.Id, ctx)
-	if err != nil {
-		log.Printf("INFO >> handleAuthentication unknown user id %v for authentication", string(l.Id))
-		w.WriteHeader(http.StatusNotFound)
+	ip := clientIp(r)
+	now := time.Now()
+	if wait := a.throttle.wait(ip, l.Id, now); wait > 0 {
+		// not recorded : throttled attempts cost nothing to an
+		// attacker, and would fill the database. The lock itself
+		// is recorded with the failure causing it
+		log.Printf("INFO >> handleAuthentication login of %v from %s throttled", l.Id, ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	u, err := a.authenticator.Authenticate(l.Id, l.Password, ctx)
+	var failure string
+	if err == auth.ErrUnknownUser {
+		failure = "unknown user"
+	} else if err == auth.ErrInvalidCredentials {
+		failure = "invalid credentials"
+	} else if err != nil {
+		log.Printf("ERROR >> handleAuthentication encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, errors.New("unable to check the credentials"))
 		return
+	} else if u.Disabled {
+		failure = "disabled user"
 	}
-	errPersistence := u.ComparePassword([]byte(l.Password))
-	if errPersistence != nil {
+	if failure != "" {
+		log.Printf("INFO >> handleAuthentication failed login of %v from %s : %s", l.Id, ip, failure)
+		if a.throttle.failure(ip, l.Id, now) {
+			log.Printf("WARN >> handleAuthentication account %v locked after too many failed logins", l.Id)
+			failure += ", account locked"
+		}
+		a.recordLogin(ctx, l.Id, ip, failure)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	token := createToken(a.conf.ApiConf.Secret, time.Now().Add(a.conf.ApiConf.TokenValidityDuration))
-	res := model.Token{Value: token}
+	a.throttle.success(l.Id)
+	a.recordLogin(ctx, l.Id, ip, "")
+	res, sessionErr := a.openSession(ctx, u)
+	if sessionErr != nil {
+		log.Printf("ERROR >> handleAuthentication encounter error : %s", sessionErr.Error())
+		writeApiError(w, http.StatusInternalServerError, sessionErr)
+		return
+	}
 	body, _ := json.Marshal(res) // todo handle err
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "%s", body)
 }
 
-func createToken(secret string, exp time.Time) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// record a login attempt, successful
+// when there is no failure
+func (a *Api) recordLogin(ctx context.Context, login, ip, failure string) {
+	attempt := model.LoginAttempt{Login: login, Ip: ip, Success: failure == "", Reason: failure, Time: time.Now()}
+	_, persistenceErr := a.repository.CreateLoginAttempt(&attempt, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> recordLogin encounter error : %s", persistenceErr.Error())
+	}
+}
+
+// ip of the client that sent the request
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// exchange a refresh token against a new pair
+// of access and refresh tokens. The given refresh
+// token can't be used anymore afterward.
+func (a *Api) handleRefresh(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var refresh model.Refresh
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&refresh)
+	if err != nil || refresh.RefreshToken == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	value, hash, err := model.NewRefreshTokenValue()
+	if err != nil {
+		log.Printf("ERROR >> handleRefresh encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, persistenceErr := a.repository.RotateSession(model.HashTokenValue(refresh.RefreshToken), hash,
+		time.Now().Add(a.conf.ApiConf.RefreshTokenValidityDuration), ctx)
+	if persistenceErr != nil {
+		if persistenceErr.ErrorType() == persistence.NotFound {
+			log.Printf("INFO >> handleRefresh unknown or expired refresh token")
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			log.Printf("ERROR >> handleRefresh encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+		}
+		return
+	}
+	u, persistenceErr := a.repository.GetUser(session.Login, ctx)
+	if persistenceErr != nil || u.Disabled {
+		log.Printf("INFO >> handleRefresh refresh token of unknown or disabled user %s", session.Login)
+		a.repository.DeleteSession(ctx, session.Id)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	token, err := a.newToken(u, session.Id, value)
+	if err != nil {
+		log.Printf("ERROR >> handleRefresh encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, token)
+}
+
+// revoke the access token of the request
+// and close the session it belongs to.
+func (a *Api) onLogout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	claims, _ := a.checkToken(r)
+	tokenId, _ := claims["jti"].(string)
+	if tokenId == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("this token can't be revoked"))
+		return
+	}
+	exp, _ := claims["exp"].(float64)
+	persistenceErr := a.repository.RevokeToken(tokenId, time.Unix(int64(exp), 0), ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onLogout encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	sessionId, _ := claims["sid"].(string)
+	persistenceErr = a.repository.DeleteSession(ctx, sessionId)
+	if persistenceErr != nil && persistenceErr.ErrorType() != persistence.NotFound {
+		log.Printf("ERROR >> onLogout encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// open a new session for the given user and
+// return its first pair of tokens
+func (a *Api) openSession(ctx context.Context, u *model.User) (*model.Token, error) {
+	value, hash, err := model.NewRefreshTokenValue()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := model.Session{
+		Login:     u.Login,
+		Hash:      hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.conf.ApiConf.RefreshTokenValidityDuration),
+	}
+	newSession, persistenceErr := a.repository.CreateSession(&session, ctx)
+	if persistenceErr != nil {
+		return nil, persistenceErr
+	}
+	return a.newToken(u, newSession.Id, value)
+}
+
+// revoke every token issued for the user until now. The
+// user must be updated afterward for the revocation to apply.
+func (a *Api) revokeSessions(ctx context.Context, u *model.User) persistence.PersistenceError {
+	u.SessionsRevokedAt = unixMilli(time.Now())
+	return a.repository.DeleteSessionsOf(ctx, u.Login)
+}
+
+func (a *Api) newToken(u *model.User, sessionId, refreshToken string) (*model.Token, error) {
+	exp := time.Now().Add(a.conf.ApiConf.TokenValidityDuration)
+	token, err := a.createToken(u.Login, u.GetRole(), sessionId, exp)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Token{
+		Value:                  token,
+		ExpiresAt:              exp,
+		RefreshToken:           refreshToken,
+		PasswordChangeRequired: u.MustChangePassword,
+	}, nil
+}
+
+// create an access token for the user identified by login, inside the session
+// identified by sessionId. Each token has its own id (jti) that allows to revoke it.
+func (a *Api) createToken(login string, role model.Role, sessionId string, exp time.Time) (string, error) {
+	return a.keys.Sign(jwt.MapClaims{
+		"sub":  login,
+		"role": string(role),
+		"sid":  sessionId,
+		"jti":  newTokenId(),
+		// milliseconds precision, to be compared
+		// with the sessions revocation time of the user
+		"iat": float64(unixMilli(time.Now())) / 1000,
 		"exp": exp.Unix(),
 	})
-	res, _ := token.SignedString([]byte(secret))
-	return res
+}
+
+func newTokenId() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/jeromedoucet/dahu/configuration"
@@ -73,8 +74,9 @@ func TestAuthenticationShouldReturn200AndAToken(t *testing.T) {
 	}
 }
 
-// testing authentication when no user found
-func TestAuthenticationShouldReturn404AndNoTokenWhenNoUserFound(t *testing.T) {
+// testing authentication when no user found. The answer
+// must not differ from the one to a bad password
+func TestAuthenticationShouldReturn401AndNoTokenWhenNoUserFound(t *testing.T) {
 	// given
 
 	// setup the conf
@@ -105,8 +107,8 @@ func TestAuthenticationShouldReturn404AndNoTokenWhenNoUserFound(t *testing.T) {
 	if err != nil {
 		t.Errorf("expect to get no error when trying to authenticate without user found, but got %s", err.Error())
 	}
-	if resp.StatusCode != 404 {
-		t.Errorf("expect status code 404 when trying to authenticate without user found , but got %d", resp.StatusCode)
+	if resp.StatusCode != 401 {
+		t.Errorf("expect status code 401 when trying to authenticate without user found , but got %d", resp.StatusCode)
 	}
 	b := bytes.Buffer{}
 	b.ReadFrom(resp.Body)
@@ -150,7 +152,7 @@ func TestAuthenticationShouldReturn401AndNoTokenWhenBadPassword(t *testing.T) {
 		t.Errorf("expect to get no error when trying to authenticate with bad credential, but got %s", err.Error())
 	}
 	if resp.StatusCode != 401 {
-		t.Errorf("expect status code 404 when trying to authenticate with bad credential, but got %d", resp.StatusCode)
+		t.Errorf("expect status code 401 when trying to authenticate with bad credential, but got %d", resp.StatusCode)
 	}
 	b := bytes.Buffer{}
 	b.ReadFrom(resp.Body)
@@ -158,3 +160,110 @@ func TestAuthenticationShouldReturn401AndNoTokenWhenBadPassword(t *testing.T) {
 		t.Errorf("expect to have no answer when bad credential but got %s", b.String())
 	}
 }
+
+// testing authentication against a LDAP directory
+func TestAuthenticationWithLdap(t *testing.T) {
+	// given
+	d := tests.NewDirectory(tests.LdapEntry{Dn: "uid=bob,dc=dahu,dc=test", Password: "bob-password",
+		Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"bob"}}})
+	defer d.Close()
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	conf.ApiConf.Authentication = configuration.LdapAuthentication
+	conf.LdapConf.Url = d.Url
+	conf.LdapConf.BaseDn = "dc=dahu,dc=test"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	body, _ := json.Marshal(model.Login{Id: "bob", Password: "bob-password"})
+	wrongBody, _ := json.Marshal(model.Login{Id: "bob", Password: "wrong-password"})
+
+	// when
+	resp, err := http.Post(fmt.Sprintf("%s/login", s.URL), "application/json", bytes.NewBuffer(body))
+	wrongResp, wrongErr := http.Post(fmt.Sprintf("%s/login", s.URL), "application/json", bytes.NewBuffer(wrongBody))
+
+	// then
+	if err != nil || wrongErr != nil {
+		t.Fatalf("expect no error, but got %v and %v", err, wrongErr)
+	}
+	var tok model.Token
+	json.NewDecoder(resp.Body).Decode(&tok)
+	if resp.StatusCode != http.StatusOK || tok.Value == "" {
+		t.Fatalf("expect 200 and a token, but got %d and %+v", resp.StatusCode, tok)
+	}
+	if wrongResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expect 401 with a wrong password, but got %d", wrongResp.StatusCode)
+	}
+}
+
+// testing that a failed login delays
+// the next attempts
+func TestAuthenticationThrottled(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	u := model.User{Login: "test"}
+	u.SetPassword([]byte("test_test_test_test"))
+	insertUser(conf, u)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	wrongResp := postLogin(t, s.URL, "test", "wrong_password")
+	retryResp := postLogin(t, s.URL, "test", "test_test_test_test")
+
+	// then
+	if wrongResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expect 401 with a wrong password, but got %d", wrongResp.StatusCode)
+	}
+	if retryResp.StatusCode != http.StatusTooManyRequests || retryResp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("expect 429 and a retry delay, but got %d and %s", retryResp.StatusCode, retryResp.Header.Get("Retry-After"))
+	}
+}
+
+// testing the lock of an account after too many
+// failed logins, its unlock and the recorded attempts.
+// The throttled ones are not recorded
+func TestAuthenticationLockout(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	conf.ApiConf.LoginBaseDelay = time.Millisecond
+	conf.ApiConf.LoginMaxDelay = 10 * time.Millisecond
+	conf.ApiConf.LoginMaxFailures = 3
+	defer tests.CleanPersistence(conf)
+	u := model.User{Login: "test"}
+	u.SetPassword([]byte("test_test_test_test"))
+	insertUser(conf, u)
+	insertUser(conf, model.User{Login: "admin", Role: model.RoleAdmin})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	for i := 0; i < 3; i++ {
+		postLogin(t, s.URL, "test", "wrong_password")
+		time.Sleep(20 * time.Millisecond)
+	}
+	lockedResp := postLogin(t, s.URL, "test", "test_test_test_test")
+	unlockResp := doUserRequest(t, "DELETE", fmt.Sprintf("%s/users/test/lock", s.URL), "admin", model.RoleAdmin, nil)
+	time.Sleep(20 * time.Millisecond)
+	unlockedResp := postLogin(t, s.URL, "test", "test_test_test_test")
+	attemptsResp := doUserRequest(t, "GET", fmt.Sprintf("%s/logins?login=test", s.URL), "admin", model.RoleAdmin, nil)
+
+	// then
+	if lockedResp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expect 429 on a locked account, but got %d", lockedResp.StatusCode)
+	}
+	if unlockResp.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200 when unlocking the account, but got %d", unlockResp.StatusCode)
+	}
+	if unlockedResp.StatusCode != http.StatusOK {
+		t.Fatalf("expect 200 once the account is unlocked, but got %d", unlockedResp.StatusCode)
+	}
+	var attempts []model.LoginAttempt
+	json.NewDecoder(attemptsResp.Body).Decode(&attempts)
+	if len(attempts) != 4 || !attempts[0].Success || attempts[1].Reason != "invalid credentials, account locked" {
+		t.Fatalf("expect the four attempts but the throttled one to be recorded, but got %+v", attempts)
+	}
+}
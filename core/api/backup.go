@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/backup"
+)
+
+// stream a consistent backup of the database. It is written
+// in a temporary file first, so that the read transaction
+// doesn't last as long as the download.
+func (a *Api) onBackupGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if a.conf.PersistenceConf.Type != configuration.InMemory {
+		writeApiError(w, http.StatusNotImplemented, errors.New("the backup of the sql databases is done with their own tools"))
+		return
+	}
+	tmp, err := ioutil.TempFile("", "dahu-backup-")
+	if err != nil {
+		log.Printf("ERROR >> onBackupGet encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	err = backup.Write(ctx, a.conf.BackupConf, a.repository, tmp)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("ERROR >> onBackupGet encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"dahu-%s.backup\"", time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, tmp)
+}
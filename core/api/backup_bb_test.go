@@ -0,0 +1,69 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/backup"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func TestBackup(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	conf.BackupConf.Compress = true
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "alice", Role: model.RoleAdmin})
+	insertJob(conf, model.Job{Id: []byte("job"), Name: "job"})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	restoredConf := configuration.InitConf()
+	restoredConf.PersistenceConf.Name = "restored"
+	defer tests.CleanPersistence(restoredConf)
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/backup", s.URL), "alice", model.RoleAdmin, nil)
+	data, _ := ioutil.ReadAll(resp.Body)
+	tests.ClosePersistence(conf)
+	_, restoreErr := backup.Restore(restoredConf, bytes.NewReader(data))
+	job, jobErr := persistence.GetRepository(restoredConf).GetJob([]byte("job"), context.Background())
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	if restoreErr != nil {
+		t.Fatalf("Expect the backup to be restored, but got %s", restoreErr.Error())
+	}
+	if jobErr != nil || job.Name != "job" {
+		t.Fatalf("Expect to find the job in the restored database, but got %+v and %+v", job, jobErr)
+	}
+}
+
+func TestBackupAsMaintainer(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleMaintainer})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/backup", s.URL), "bob", model.RoleMaintainer, nil)
+
+	// then
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 return code. Got %d", resp.StatusCode)
+	}
+}
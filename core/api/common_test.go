@@ -1,12 +1,83 @@
 package api_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"testing"
+	"time"
 
 	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
 )
 
 // common var used in api_test package
 var conf *configuration.Conf
 var gitRepoIp string
 var s *httptest.Server
+
+// a request body sent as is,
+// with its content type
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// perform a request authenticated by the bearer token, which is
+// a jwt or an api token. The body is sent as json, unless it is
+// a rawBody.
+func doRequest(t *testing.T, method, url, token string, body interface{}) *http.Response {
+	var reqBody io.Reader = new(bytes.Buffer)
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case rawBody:
+		reqBody = bytes.NewReader(b.data)
+		contentType = b.contentType
+	default:
+		data, _ := json.Marshal(b)
+		reqBody = bytes.NewBuffer(data)
+	}
+	req, _ := http.NewRequest(method, url, reqBody)
+	req.Header.Add("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	return resp
+}
+
+// perform a request with a token issued for the given
+// user. The token has no subject when login is empty.
+func doUserRequest(t *testing.T, method, url, login string, role model.Role, body interface{}) *http.Response {
+	tokenStr := tests.GetUserToken(conf.ApiConf.Secret, login, string(role), time.Now().Add(1*time.Minute))
+	return doRequest(t, method, url, tokenStr, body)
+}
+
+func postLogin(t *testing.T, url, login, password string) *http.Response {
+	body, _ := json.Marshal(model.Login{Id: login, Password: password})
+	resp, err := http.Post(fmt.Sprintf("%s/login", url), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	return resp
+}
+
+// authenticate the user, whose password is
+// sessionPassword, and return its tokens
+func login(t *testing.T, url, user string) model.Token {
+	resp := postLogin(t, url, user, sessionPassword)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when authenticating, but got %d", resp.StatusCode)
+	}
+	var tok model.Token
+	json.NewDecoder(resp.Body).Decode(&tok)
+	return tok
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// http handler that export the configuration (jobs, registries,
+// users and teams) as a json or yaml (format=yaml) bundle
+func (a *Api) onConfigurationExport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" {
+		writeApiError(w, http.StatusBadRequest, errors.New("format must be json or yaml"))
+		return
+	}
+	existing, persistenceErr := a.configuration(ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onConfigurationExport encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	bundle := model.NewBundle(existing.Jobs, existing.DockerRegistries, existing.Users, existing.Teams)
+	body, err := json.Marshal(bundle)
+	contentType := "application/json"
+	if err == nil && format == "yaml" {
+		body, err = jsonToYaml(body)
+		contentType = "application/yaml"
+	}
+	if err != nil {
+		log.Printf("ERROR >> onConfigurationExport encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if format == "" {
+		format = "json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"dahu-%s.%s\"", time.Now().UTC().Format("20060102T150405Z"), format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// http handler that import a bundle, given as json or as yaml
+// (Content-Type application/yaml). With mode=merge (default), the
+// entities of the bundle are created or updated. With mode=replace,
+// the ones missing from the bundle are deleted too. Nothing is
+// applied when dryRun=true, or when an entity is in conflict.
+// The answer is the report of the import.
+func (a *Api) onConfigurationImport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	mode := model.ImportMode(query.Get("mode"))
+	if mode == "" {
+		mode = model.ImportMerge
+	}
+	if !mode.IsValid() {
+		writeApiError(w, http.StatusBadRequest, errors.New("mode must be merge or replace"))
+		return
+	}
+	dryRun := query.Get("dryRun") == "true"
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil && strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		body, err = yamlToJson(body)
+	}
+	var bundle model.Bundle
+	if err == nil {
+		err = json.Unmarshal(body, &bundle)
+	}
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if bundle.Version < 1 || bundle.Version > model.BundleVersion {
+		writeApiError(w, http.StatusBadRequest, fmt.Errorf("unsupported bundle version %d, this version of Dahu reads up to the version %d", bundle.Version, model.BundleVersion))
+		return
+	}
+	existing, persistenceErr := a.configuration(ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onConfigurationImport encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	changes, report := model.PlanImport(bundle, existing, mode, a.tokenSubject(r))
+	report.DryRun = dryRun
+	if report.Count(model.ImportConflict) > 0 {
+		writeJson(w, http.StatusConflict, report)
+		return
+	}
+	if !dryRun {
+		persistenceErr = a.repository.ApplyConfigurationChanges(ctx, &changes)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> onConfigurationImport encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return
+		}
+		a.auditImport(ctx, r, existing, changes)
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+// load the whole configuration, with its secrets
+func (a *Api) configuration(ctx context.Context) (model.Bundle, persistence.PersistenceError) {
+	var bundle model.Bundle
+	var persistenceErr persistence.PersistenceError
+	bundle.Jobs, persistenceErr = a.repository.GetJobs(ctx)
+	if persistenceErr != nil {
+		return bundle, persistenceErr
+	}
+	bundle.DockerRegistries, persistenceErr = a.repository.GetDockerRegistries(ctx)
+	if persistenceErr != nil {
+		return bundle, persistenceErr
+	}
+	bundle.Users, persistenceErr = a.repository.GetUsers(ctx)
+	if persistenceErr != nil {
+		return bundle, persistenceErr
+	}
+	bundle.Teams, persistenceErr = a.repository.GetTeams(ctx)
+	return bundle, persistenceErr
+}
+
+// one audit entry by entity created, updated or deleted by an import
+func (a *Api) auditImport(ctx context.Context, r *http.Request, existing model.Bundle, changes model.ConfigurationChanges) {
+	jobs := make(map[string]*model.Job)
+	for _, job := range existing.Jobs {
+		jobs[string(job.Id)] = job
+	}
+	for _, job := range changes.Jobs {
+		if before, ok := jobs[string(job.Id)]; ok {
+			a.audit(ctx, r, model.AuditUpdate, model.AuditJob, string(job.Id), auditedJob(*before), auditedJob(*job))
+		} else {
+			a.audit(ctx, r, model.AuditCreate, model.AuditJob, string(job.Id), nil, auditedJob(*job))
+		}
+	}
+	for _, id := range changes.DeletedJobs {
+		a.audit(ctx, r, model.AuditDelete, model.AuditJob, id, auditedJob(*jobs[id]), nil)
+	}
+	registries := make(map[string]*model.DockerRegistry)
+	for _, registry := range existing.DockerRegistries {
+		registries[registry.Id] = registry
+	}
+	for _, registry := range changes.DockerRegistries {
+		if before, ok := registries[registry.Id]; ok {
+			a.audit(ctx, r, model.AuditUpdate, model.AuditDockerRegistry, registry.Id, before, registry)
+		} else {
+			a.audit(ctx, r, model.AuditCreate, model.AuditDockerRegistry, registry.Id, nil, registry)
+		}
+	}
+	for _, id := range changes.DeletedDockerRegistries {
+		a.audit(ctx, r, model.AuditDelete, model.AuditDockerRegistry, id, registries[id], nil)
+	}
+	users := make(map[string]*model.User)
+	for _, user := range existing.Users {
+		users[user.Login] = user
+	}
+	for _, user := range changes.Users {
+		if before, ok := users[user.Login]; ok {
+			a.audit(ctx, r, model.AuditUpdate, model.AuditUser, user.Login, before, user)
+		} else {
+			a.audit(ctx, r, model.AuditCreate, model.AuditUser, user.Login, nil, user)
+		}
+	}
+	for _, login := range changes.DeletedUsers {
+		a.audit(ctx, r, model.AuditDelete, model.AuditUser, login, users[login], nil)
+	}
+	teams := make(map[string]*model.Team)
+	for _, team := range existing.Teams {
+		teams[team.Id] = team
+	}
+	for _, team := range changes.Teams {
+		if before, ok := teams[team.Id]; ok {
+			a.audit(ctx, r, model.AuditUpdate, model.AuditTeam, team.Id, before, team)
+		} else {
+			a.audit(ctx, r, model.AuditCreate, model.AuditTeam, team.Id, nil, team)
+		}
+	}
+	for _, id := range changes.DeletedTeams {
+		a.audit(ctx, r, model.AuditDelete, model.AuditTeam, id, teams[id], nil)
+	}
+}
+
+// the yaml bundles are converted from and to json,
+// so that the json tags of the model are used for both
+func jsonToYaml(data []byte) ([]byte, error) {
+	var document interface{}
+	err := json.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(document)
+}
+
+func yamlToJson(data []byte) ([]byte, error) {
+	var document interface{}
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
@@ -0,0 +1,146 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// alice is admin. The team a holds a secret, the
+// registry a password and the job a git password.
+func insertConfigurationFixture(c *configuration.Conf) {
+	insertUser(c, model.User{Login: "alice", Password: []byte("aliceHash"), Role: model.RoleAdmin})
+	tests.InsertObject(c, []byte("teams"), []byte("a"), model.Team{Id: "a", Name: "a", Secrets: map[string]string{"token": "s3cr3t"}})
+	tests.InsertObject(c, []byte("dockerRegistries"), []byte("registry"), model.DockerRegistry{Id: "registry", Name: "registry", Url: "some.url", User: "bob", Password: "registryPassword", TeamId: "a"})
+	insertJob(c, model.Job{Id: []byte("job"), Name: "job", TeamId: "a",
+		GitConf: model.GitConfig{HttpAuth: &model.HttpAuthConfig{Url: "http://some.url", User: "bob", Password: "gitPassword"}}})
+}
+
+func TestConfigurationExportYaml(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertConfigurationFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/export?format=yaml", s.URL), "alice", model.RoleAdmin, nil)
+	data, _ := ioutil.ReadAll(resp.Body)
+	body := string(data)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	if !strings.Contains(body, "version: 1") || !strings.Contains(body, "token: \"\"") {
+		t.Fatalf("Expect a yaml bundle with the name of the secret, but got %s", body)
+	}
+	for _, secret := range []string{"s3cr3t", "registryPassword", "gitPassword", "aliceHash"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Expect %s to be left out of the bundle, but got %s", secret, body)
+		}
+	}
+}
+
+func TestConfigurationImportYaml(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertConfigurationFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	exportResp := doUserRequest(t, "GET", fmt.Sprintf("%s/export?format=yaml", s.URL), "alice", model.RoleAdmin, nil)
+	exported, _ := ioutil.ReadAll(exportResp.Body)
+	bundle := bytes.Replace(exported, []byte("name: job"), []byte("name: renamed"), 1)
+
+	// when
+	dryRunResp := doUserRequest(t, "POST", fmt.Sprintf("%s/import?dryRun=true", s.URL), "alice", model.RoleAdmin, rawBody{"application/yaml", bundle})
+	var dryRunReport model.ImportReport
+	json.NewDecoder(dryRunResp.Body).Decode(&dryRunReport)
+	dryRunJob, _ := persistence.GetRepository(conf).GetJob([]byte("job"), context.Background())
+	resp := doUserRequest(t, "POST", fmt.Sprintf("%s/import", s.URL), "alice", model.RoleAdmin, rawBody{"application/yaml", bundle})
+	job, _ := persistence.GetRepository(conf).GetJob([]byte("job"), context.Background())
+	entries, _ := persistence.GetRepository(conf).GetAuditEntries(model.AuditFilter{ResourceId: "job", Limit: 10}, context.Background())
+
+	// then
+	if dryRunResp.StatusCode != http.StatusOK || !dryRunReport.DryRun || dryRunReport.Count(model.ImportUpdate) != 1 {
+		t.Fatalf("Expect a dry-run report with one update. Got %d and %+v", dryRunResp.StatusCode, dryRunReport)
+	}
+	if dryRunJob.Name != "job" {
+		t.Fatalf("Expect the dry-run to change nothing, but the job is named %s", dryRunJob.Name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	if job.Name != "renamed" || job.GitConf.HttpAuth.Password != "gitPassword" {
+		t.Fatalf("Expect the job to be renamed and to keep its password, but got %+v", job.GitConf.HttpAuth)
+	}
+	if len(entries) != 1 || entries[0].Action != model.AuditUpdate || entries[0].Actor != "alice" {
+		t.Fatalf("Expect the update to be audited, but got %+v", entries)
+	}
+}
+
+func TestConfigurationImportConflict(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertConfigurationFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	bundle := model.Bundle{
+		Version:          model.BundleVersion,
+		DockerRegistries: []*model.DockerRegistry{{Id: "new", Name: "new", Url: "some.url", TeamId: "unknown"}},
+		Users:            []*model.User{{Login: "carol"}},
+	}
+
+	// when
+	resp := doUserRequest(t, "POST", fmt.Sprintf("%s/import?mode=replace", s.URL), "alice", model.RoleAdmin, bundle)
+	var report model.ImportReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	_, userErr := persistence.GetRepository(conf).GetUser("carol", context.Background())
+
+	// then
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expect 409 return code. Got %d", resp.StatusCode)
+	}
+	if report.Count(model.ImportConflict) != 2 {
+		t.Fatalf("Expect the registry and the deletion of alice to be in conflict, but got %+v", report.Results)
+	}
+	if userErr == nil {
+		t.Fatalf("Expect nothing to be imported")
+	}
+}
+
+func TestConfigurationImportUnsupportedVersion(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "alice", Role: model.RoleAdmin})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "POST", fmt.Sprintf("%s/import", s.URL), "alice", model.RoleAdmin, model.Bundle{Version: model.BundleVersion + 1})
+
+	// then
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 return code. Got %d", resp.StatusCode)
+	}
+}
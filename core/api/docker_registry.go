@@ -64,13 +64,7 @@ func (a *Api) onDockerRegistryGet(ctx context.Context, w http.ResponseWriter, r
 	registry, persistenceErr := a.repository.GetDockerRegistry([]byte(registryId), ctx)
 	if persistenceErr != nil {
 		log.Printf("ERROR >> onDockerRegistryGet encounter error : %s", persistenceErr.Error())
-		body := fromErrorToJson(persistenceErr)
-		if persistenceErr.ErrorType() == persistence.NotFound {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write(body)
+		writePersistenceError(w, persistenceErr)
 		return
 	}
 	registry.ToPublicModel()
@@ -91,18 +85,16 @@ func (a *Api) onDockerRegistryGet(ctx context.Context, w http.ResponseWriter, r
 func (a *Api) onDockerRegistryDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	path := route.SplitPath(r.URL.Path)
 	registryId := path[len(path)-1]
-	persistenceErr := a.repository.DeleteDockerRegistry([]byte(registryId))
+	registry, persistenceErr := a.repository.GetDockerRegistry([]byte(registryId), ctx)
+	if persistenceErr == nil {
+		persistenceErr = a.repository.DeleteDockerRegistry(ctx, []byte(registryId))
+	}
 	if persistenceErr != nil {
 		log.Printf("ERROR >> onDockerRegistryDelete encounter error : %s", persistenceErr.Error())
-		body := fromErrorToJson(persistenceErr)
-		if persistenceErr.ErrorType() == persistence.NotFound {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write(body)
+		writePersistenceError(w, persistenceErr)
 		return
 	}
+	a.audit(ctx, r, model.AuditDelete, model.AuditDockerRegistry, registryId, registry, nil)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -113,28 +105,26 @@ func (a *Api) onDockerRegistryUpdate(ctx context.Context, w http.ResponseWriter,
 	d.Decode(&registryUpdate)
 	path := route.SplitPath(r.URL.Path)
 	registryId := path[len(path)-1]
+	before, _ := a.repository.GetDockerRegistry([]byte(registryId), ctx)
 	updatedRegistry, persistenceErr := a.repository.UpdateDockerRegistry([]byte(registryId), &registryUpdate, ctx)
+	if persistenceErr == nil {
+		a.audit(ctx, r, model.AuditUpdate, model.AuditDockerRegistry, registryId, before, updatedRegistry)
+	}
 	if updatedRegistry != nil {
 		updatedRegistry.ToPublicModel()
 	}
 	if persistenceErr != nil {
 		log.Printf("ERROR >> onDockerRegistryUpdate encounter error : %s", persistenceErr.Error())
-		var body []byte
-		if persistenceErr.ErrorType() == persistence.NotFound {
-			body = fromErrorToJson(persistenceErr)
-			w.WriteHeader(http.StatusNotFound)
-		} else if persistenceErr.ErrorType() == persistence.Conflict {
+		if persistenceErr.ErrorType() == persistence.Conflict && updatedRegistry != nil {
 			// in case of conflict, the "updatedRegistry" return by the persistence
 			// layer is the existing db version. We must return it to allow the
-			// front app to notify the use. We must return it to allow the
 			// front app to notify the user.
-			body, _ = json.Marshal(updatedRegistry)
+			body, _ := json.Marshal(updatedRegistry)
 			w.WriteHeader(http.StatusConflict)
-		} else {
-			body = fromErrorToJson(persistenceErr)
-			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(body)
+			return
 		}
-		w.Write(body)
+		writePersistenceError(w, persistenceErr)
 		return
 	}
 	body, err := json.Marshal(updatedRegistry)
@@ -154,16 +144,18 @@ func (a *Api) onDockerRegistryUpdate(ctx context.Context, w http.ResponseWriter,
 // is already an id in the given registry
 func (a *Api) onDockerRegistryCreation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var registry model.DockerRegistry
-	var err error
 	d := json.NewDecoder(r.Body)
 	d.Decode(&registry)
-	var newRegistry *model.DockerRegistry
-	newRegistry, err = a.repository.CreateDockerRegistry(&registry, ctx)
-	if err != nil {
-		log.Printf("ERROR >> dockerRegistryCreation encounter error : %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+	if !a.canWriteInTeam(ctx, w, r, registry.TeamId) {
+		return
+	}
+	newRegistry, persistenceErr := a.repository.CreateDockerRegistry(&registry, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> dockerRegistryCreation encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
 		return
 	}
+	a.audit(ctx, r, model.AuditCreate, model.AuditDockerRegistry, newRegistry.Id, nil, newRegistry)
 	newRegistry.ToPublicModel()
 	body, _ := json.Marshal(newRegistry)
 	w.Header().Set("Content-Type", "application/json")
@@ -176,19 +168,24 @@ func (a *Api) onDockerRegistriesGet(ctx context.Context, w http.ResponseWriter,
 	registries, persistenceErr := a.repository.GetDockerRegistries(ctx)
 	if persistenceErr != nil {
 		log.Printf("ERROR >> onDockerRegistriesGet encounter error : %s", persistenceErr.Error())
-		body := fromErrorToJson(persistenceErr)
-		if persistenceErr.ErrorType() == persistence.NotFound {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write(body)
+		writePersistenceError(w, persistenceErr)
 		return
 	}
+	claims, _ := a.checkToken(r)
+	roles, persistenceErr := a.loadTeamRoles(ctx, claims)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onDockerRegistriesGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	visibleRegistries := make([]*model.DockerRegistry, 0, len(registries))
 	for _, registry := range registries {
-		registry.ToPublicModel()
+		if roles.of(registry.TeamId).Includes(model.RoleViewer) {
+			registry.ToPublicModel()
+			visibleRegistries = append(visibleRegistries, registry)
+		}
 	}
-	body, err := json.Marshal(registries)
+	body, err := json.Marshal(visibleRegistries)
 	if err != nil {
 		log.Printf("ERROR >> onDockerRegistriesGet encounter error : %s", err.Error())
 		body := fromErrorToJson(err)
@@ -200,3 +197,38 @@ func (a *Api) onDockerRegistriesGet(ctx context.Context, w http.ResponseWriter,
 	w.WriteHeader(http.StatusOK)
 	w.Write(body)
 }
+
+// move a docker registry to another team
+func (a *Api) onMoveDockerRegistry(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var change model.TeamChange
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&change)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !a.canWriteInTeam(ctx, w, r, change.TeamId) {
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	registryId := path[len(path)-2]
+	before, persistenceErr := a.repository.GetDockerRegistry([]byte(registryId), ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onMoveDockerRegistry encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	registry, persistenceErr := a.repository.SetDockerRegistryTeam(ctx, []byte(registryId), change.TeamId)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onMoveDockerRegistry encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditUpdate, model.AuditDockerRegistry, registryId, before, registry)
+	registry.ToPublicModel()
+	writeJson(w, http.StatusOK, registry)
+}
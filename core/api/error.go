@@ -1,6 +1,11 @@
 package api
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
 
 type ApiError struct {
 	Msg string `json:"msg"`
@@ -12,3 +17,33 @@ func fromErrorToJson(err error) []byte {
 	res, _ := json.Marshal(apiErr) // todo handle err
 	return res
 }
+
+// writeApiError answer with the given status
+// and the error as an ApiError body
+func writeApiError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(fromErrorToJson(err))
+}
+
+// seconds after which an operation given up by the
+// persistence, likely busy, may be tried again
+const persistenceRetryAfter = "1"
+
+// writePersistenceError answer with the http
+// status matching the persistence error type
+func writePersistenceError(w http.ResponseWriter, err persistence.PersistenceError) {
+	switch err.ErrorType() {
+	case persistence.NotFound:
+		writeApiError(w, http.StatusNotFound, err)
+	case persistence.Conflict:
+		writeApiError(w, http.StatusConflict, err)
+	case persistence.NoMorePersisted:
+		writeApiError(w, http.StatusGone, err)
+	case persistence.Timeout:
+		w.Header().Set("Retry-After", persistenceRetryAfter)
+		writeApiError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeApiError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "persistence >> the operation has been given up : context deadline exceeded"
+}
+
+func (timeoutError) ErrorType() persistence.PersistenceErrorType {
+	return persistence.Timeout
+}
+
+// test that an operation given up by the persistence
+// is answered with a 503 and a retry delay
+func TestWritePersistenceErrorTimeout(t *testing.T) {
+	// given
+	w := httptest.NewRecorder()
+
+	// when
+	writePersistenceError(w, timeoutError{})
+
+	// then
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expect 503 and a retry delay, but got %d and %s", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/route"
+)
+
+// number of executions returned
+// when no limit is given
+const defaultExecutionsLimit = 20
+
+// switch choice for request on the executions of a job
+func (a *Api) handleJobExecutions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onJobExecutionsGet(ctx, w, r)
+	} else if r.Method == http.MethodPost {
+		a.onStartJob(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// list the executions of a job, newest first. They may be filtered
+// by status and branch, and are paginated with offset and limit.
+func (a *Api) onJobExecutionsGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-2]
+	filter, err := executionFilter(r.URL.Query())
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, persistenceErr := a.repository.GetJob([]byte(jobId), ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onJobExecutionsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	executions, persistenceErr := a.repository.SearchJobExecutions(ctx, jobId, filter)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onJobExecutionsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	writeJson(w, http.StatusOK, executions)
+}
+
+// aggregates over the executions of a job, that
+// may be filtered by status and branch.
+func (a *Api) onJobExecutionStatsGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-2]
+	filter, err := executionFilter(r.URL.Query())
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	_, persistenceErr := a.repository.GetJob([]byte(jobId), ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onJobExecutionStatsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	stats, persistenceErr := a.repository.GetJobExecutionStats(ctx, jobId, filter)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onJobExecutionStatsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	writeJson(w, http.StatusOK, stats)
+}
+
+func executionFilter(query url.Values) (model.ExecutionFilter, error) {
+	filter := model.ExecutionFilter{Status: model.ExecutionStatus(query.Get("status")), Branch: query.Get("branch")}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		return filter, fmt.Errorf("unknown status %s", filter.Status)
+	}
+	var err error
+	filter.Offset, err = intParam(query.Get("offset"), 0)
+	if err != nil || filter.Offset < 0 {
+		return filter, errors.New("offset must be a positive number")
+	}
+	filter.Limit, err = intParam(query.Get("limit"), defaultExecutionsLimit)
+	if err != nil || filter.Limit <= 0 {
+		return filter, errors.New("limit must be a positive number")
+	}
+	return filter, nil
+}
@@ -0,0 +1,102 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// insert the job "job" with five executions, one per hour, the
+// oldest first. The executions 2 and 4 failed on the branch feature.
+func insertExecutionsHistory(c *configuration.Conf) {
+	tests.InsertObject(c, []byte("jobs"), []byte("job"), model.Job{Id: []byte("job"), Name: "job"})
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		execution := &model.JobExecution{Id: fmt.Sprint(i), BranchName: "master", Status: model.Success, Duration: time.Duration(i) * time.Minute}
+		if i%2 == 0 {
+			execution.BranchName = "feature"
+			execution.Status = model.Failure
+		}
+		execution.Date = now.Add(time.Duration(i-5) * time.Hour)
+		rep.UpsertJobExecution(ctx, "job", execution)
+	}
+}
+
+func TestJobExecutionsHistory(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertExecutionsHistory(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions?branch=master&offset=1&limit=2", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	var executions []model.JobExecution
+	json.NewDecoder(resp.Body).Decode(&executions)
+	if len(executions) != 2 || executions[0].Id != "3" || executions[1].Id != "1" {
+		t.Fatalf("expect the executions 3 then 1, but got %+v", executions)
+	}
+}
+
+func TestJobExecutionsHistoryWithUnknownStatus(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertExecutionsHistory(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions?status=broken", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 return code. Got %d", resp.StatusCode)
+	}
+}
+
+func TestJobExecutionStats(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertExecutionsHistory(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/stats", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	var stats model.ExecutionStats
+	json.NewDecoder(resp.Body).Decode(&stats)
+	if stats.Count != 5 || stats.ByStatus[model.Success] != 3 || stats.ByStatus[model.Failure] != 2 {
+		t.Fatalf("expect 3 successes and 2 failures, but got %+v", stats)
+	}
+	if stats.AverageDuration != 3*time.Minute {
+		t.Fatalf("expect an average duration of %s, but got %s", 3*time.Minute, stats.AverageDuration)
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 type execution struct {
 	Branch string `json:"branch"`
+	Commit string `json:"commit"` // optional sha of the commit to build
 }
 
 type executionResult struct {
@@ -33,7 +34,6 @@ func (a *Api) handleJobs(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 func (a *Api) onCreateJob(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	var reqJob model.Job
-	var err error
 	d := json.NewDecoder(r.Body)
 	d.Decode(&reqJob)
 	if !reqJob.IsValid() {
@@ -41,13 +41,16 @@ func (a *Api) onCreateJob(ctx context.Context, w http.ResponseWriter, r *http.Re
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	var newJob *model.Job
-	newJob, err = a.repository.CreateJob(&reqJob, ctx)
-	if err != nil {
-		log.Printf("ERROR >> createJob encounter error : %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+	if !a.canWriteInTeam(ctx, w, r, reqJob.TeamId) {
 		return
 	}
+	newJob, persistenceErr := a.repository.CreateJob(&reqJob, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> createJob encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditCreate, model.AuditJob, string(newJob.Id), nil, auditedJob(*newJob))
 	body, _ := json.Marshal(newJob) // todo handle err
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -55,18 +58,27 @@ func (a *Api) onCreateJob(ctx context.Context, w http.ResponseWriter, r *http.Re
 }
 
 func (a *Api) onGetJobs(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	var err error
-	var jobs []*model.Job
-	jobs, err = a.repository.GetJobs(ctx)
-	if err != nil {
-		log.Printf("ERROR >> GetJobs encounter error : %s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+	jobs, persistenceErr := a.repository.GetJobs(ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> GetJobs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	claims, _ := a.checkToken(r)
+	roles, persistenceErr := a.loadTeamRoles(ctx, claims)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> GetJobs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
 		return
 	}
+	visibleJobs := make([]*model.Job, 0, len(jobs))
 	for _, job := range jobs {
-		job.ToPublicModel()
+		if job.RoleOf(subject(claims), roles.of(job.TeamId)).Includes(model.RoleViewer) {
+			job.ToPublicModel()
+			visibleJobs = append(visibleJobs, job)
+		}
 	}
-	body, err := json.Marshal(jobs)
+	body, err := json.Marshal(visibleJobs)
 	if err != nil {
 		log.Printf("ERROR >> GetJobs encounter error : %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
@@ -90,25 +102,39 @@ func (a *Api) onStartJob(ctx context.Context, w http.ResponseWriter, r *http.Req
 	job, err = a.repository.GetJob([]byte(jobId), ctx)
 	if err != nil {
 		log.Printf("ERROR >> onStartJob encounter error : %s", err.Error())
-		body := fromErrorToJson(err)
-		if err.ErrorType() == persistence.NotFound {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write(body)
+		writePersistenceError(w, err)
 		return
 	}
 
+	if job.TeamId != "" {
+		team, err := a.repository.GetTeam(job.TeamId, ctx)
+		if err != nil {
+			log.Printf("ERROR >> onStartJob encounter error : %s", err.Error())
+			writePersistenceError(w, err)
+			return
+		}
+		secretErr := team.ResolveSecrets(job)
+		if secretErr != nil {
+			writeApiError(w, http.StatusBadRequest, secretErr)
+			return
+		}
+	}
+
 	log.Printf("INFO >> onStartJob asked for job id %s", string(job.Id))
-	jobExecution := job_processing.Start(*job, exec.Branch, a.conf, ctx)
+	jobExecution, startErr := job_processing.Start(*job, exec.Branch, exec.Commit, a.conf, ctx)
+	if startErr != nil {
+		log.Printf("ERROR >> onStartJob encounter error : %s", startErr.Error())
+		writePersistenceError(w, startErr)
+		return
+	}
 	log.Printf("INFO >> onStartJob start execution %s", jobExecution.Id)
+	a.audit(ctx, r, model.AuditStart, model.AuditExecution, jobExecution.Id, nil, exec)
 
 	result := executionResult{Id: jobExecution.Id}
 
 	body, marshErr := json.Marshal(result)
 	if marshErr != nil {
-		log.Printf("ERROR >> startJob encounter error : %s", err.Error())
+		log.Printf("ERROR >> startJob encounter error : %s", marshErr.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -125,6 +151,7 @@ func (a *Api) onCancelJobExecution(ctx context.Context, w http.ResponseWriter, r
 	log.Printf("INFO >> onCancelJobExecution asked for job id %s and job execution id : %s", jobId, executionId)
 
 	job_processing.AskForCancelation(jobId, executionId)
+	a.audit(ctx, r, model.AuditCancel, model.AuditExecution, executionId, nil, nil)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -141,3 +168,45 @@ func (a *Api) onJobEventRegistration(ctx context.Context, w http.ResponseWriter,
 	jobId := path[len(path)-2]
 	job_processing.AddWsEventListener(jobId, ws)
 }
+
+// move a job to another team
+func (a *Api) onMoveJob(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var change model.TeamChange
+	d := json.NewDecoder(r.Body)
+	decodeErr := d.Decode(&change)
+	if decodeErr != nil {
+		writeApiError(w, http.StatusBadRequest, decodeErr)
+		return
+	}
+	if !a.canWriteInTeam(ctx, w, r, change.TeamId) {
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-2]
+	before, err := a.repository.GetJob([]byte(jobId), ctx)
+	if err != nil {
+		log.Printf("ERROR >> onMoveJob encounter error : %s", err.Error())
+		writePersistenceError(w, err)
+		return
+	}
+	job, err := a.repository.SetJobTeam(ctx, []byte(jobId), change.TeamId)
+	if err != nil {
+		log.Printf("ERROR >> onMoveJob encounter error : %s", err.Error())
+		writePersistenceError(w, err)
+		return
+	}
+	a.audit(ctx, r, model.AuditUpdate, model.AuditJob, jobId, auditedJob(*before), auditedJob(*job))
+	job.ToPublicModel()
+	writeJson(w, http.StatusOK, job)
+}
+
+// the configuration of a job, without its
+// executions, that are not part of the audit
+func auditedJob(job model.Job) model.Job {
+	job.Executions = nil
+	return job
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+type conflictError struct{}
+
+func (conflictError) Error() string {
+	return "A job with id some-id already exists"
+}
+
+func (conflictError) ErrorType() persistence.PersistenceErrorType {
+	return persistence.Conflict
+}
+
+// fail every job creation with a conflict,
+// like a colliding id would
+type conflictingJobRepository struct {
+	persistence.Repository
+}
+
+func (r *conflictingJobRepository) CreateJob(job *model.Job, ctx context.Context) (*model.Job, persistence.PersistenceError) {
+	return nil, conflictError{}
+}
+
+// test that a conflict on the creation
+// of a job is answered with a 409
+func TestCreateJobConflict(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	a := InitRoute(conf)
+	a.repository = &conflictingJobRepository{Repository: a.repository}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"role": "admin",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+	value, _ := token.SignedString([]byte(conf.ApiConf.Secret))
+	body, _ := json.Marshal(model.Job{Name: "dahu", GitConf: model.GitConfig{SshAuth: &model.SshAuthConfig{Url: "git@some-domain/some-repo.git", Key: "some-key"}}})
+	req := httptest.NewRequest("POST", "/jobs", bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+value)
+	w := httptest.NewRecorder()
+
+	// when
+	a.Handler().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expect 409, but got %d", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+// consecutive failed logins of
+// a client ip or of an account
+type failedLogins struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottle slows down password guessing. After each failure,
+// the client ip and the account must wait a delay that doubles
+// on each consecutive failure. An account is locked after too many
+// consecutive failures. Unknown logins are counted like known ones,
+// to not tell them apart.
+type loginThrottle struct {
+	conf    configuration.Api
+	mutex   sync.Mutex
+	byIp    map[string]*failedLogins
+	byLogin map[string]*failedLogins
+}
+
+func newLoginThrottle(conf configuration.Api) *loginThrottle {
+	return &loginThrottle{
+		conf:    conf,
+		byIp:    make(map[string]*failedLogins),
+		byLogin: make(map[string]*failedLogins),
+	}
+}
+
+// return how long a login attempt must
+// wait, zero when it is allowed now
+func (t *loginThrottle) wait(ip, login string, now time.Time) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	wait := t.delay(t.byIp[ip], now)
+	if accountWait := t.delay(t.byLogin[login], now); accountWait > wait {
+		wait = accountWait
+	}
+	return wait
+}
+
+// record a failed login. Return
+// true if the account is now locked.
+func (t *loginThrottle) failure(ip, login string, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.purge(now)
+	t.increment(t.byIp, ip, now)
+	account := t.increment(t.byLogin, login, now)
+	if account.count >= t.conf.LoginMaxFailures {
+		account.count = 0
+		account.lockedUntil = now.Add(t.conf.LoginLockDuration)
+		return true
+	}
+	return false
+}
+
+// forget the failures of the account. Those of the
+// ip only expire : an attacker owning one account
+// could otherwise log in with it between guesses
+func (t *loginThrottle) success(login string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.byLogin, login)
+}
+
+// unlock an account and forget its failures
+func (t *loginThrottle) unlock(login string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.byLogin, login)
+}
+
+func (t *loginThrottle) increment(failures map[string]*failedLogins, key string, now time.Time) *failedLogins {
+	f, ok := failures[key]
+	if !ok {
+		f = new(failedLogins)
+		failures[key] = f
+	}
+	f.count++
+	f.last = now
+	return f
+}
+
+func (t *loginThrottle) delay(f *failedLogins, now time.Time) time.Duration {
+	if f == nil {
+		return 0
+	}
+	if now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	if f.count == 0 {
+		return 0
+	}
+	delay := t.conf.LoginBaseDelay
+	for i := 1; i < f.count && delay < t.conf.LoginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.conf.LoginMaxDelay {
+		delay = t.conf.LoginMaxDelay
+	}
+	if wait := f.last.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// forget the failures older
+// than the lock duration
+func (t *loginThrottle) purge(now time.Time) {
+	for _, failures := range []map[string]*failedLogins{t.byIp, t.byLogin} {
+		for key, f := range failures {
+			if now.After(f.lockedUntil) && now.Sub(f.last) > t.conf.LoginLockDuration {
+				delete(failures, key)
+			}
+		}
+	}
+}
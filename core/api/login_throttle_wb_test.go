@@ -0,0 +1,111 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+func throttleConf() configuration.Api {
+	conf := configuration.InitConf().ApiConf
+	conf.LoginBaseDelay = time.Second
+	conf.LoginMaxDelay = 4 * time.Second
+	conf.LoginMaxFailures = 5
+	conf.LoginLockDuration = time.Minute
+	return conf
+}
+
+// test that the delay doubles on each
+// consecutive failure, up to the max delay
+func TestLoginThrottleDelay(t *testing.T) {
+	// given
+	throttle := newLoginThrottle(throttleConf())
+	now := time.Now()
+
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		// when
+		throttle.failure("127.0.0.1", "bob", now)
+		wait := throttle.wait("127.0.0.1", "bob", now)
+
+		// then
+		if wait != expected {
+			t.Fatalf("expect to wait %s after %d failures, but got %s", expected, i+1, wait)
+		}
+	}
+	if wait := throttle.wait("127.0.0.1", "bob", now.Add(5*time.Second)); wait != 0 {
+		t.Fatalf("expect no wait after the delay, but got %s", wait)
+	}
+}
+
+// test that an account is locked after too many failures
+// whatever the ip, until it is unlocked
+func TestLoginThrottleLock(t *testing.T) {
+	// given
+	throttle := newLoginThrottle(throttleConf())
+	now := time.Now()
+	var locked bool
+
+	// when
+	for i := 0; i < 5; i++ {
+		locked = throttle.failure("10.0.0.1", "bob", now)
+	}
+	later := now.Add(10 * time.Second)
+	lockedWait := throttle.wait("10.0.0.2", "bob", later)
+	otherWait := throttle.wait("10.0.0.2", "alice", later)
+	throttle.unlock("bob")
+	unlockedWait := throttle.wait("10.0.0.2", "bob", later)
+
+	// then
+	if !locked {
+		t.Fatal("expect the account to be locked")
+	}
+	if lockedWait != 50*time.Second {
+		t.Fatalf("expect to wait the end of the lock, but got %s", lockedWait)
+	}
+	if otherWait != 0 || unlockedWait != 0 {
+		t.Fatalf("expect no wait for other and unlocked accounts, but got %s and %s", otherWait, unlockedWait)
+	}
+}
+
+// test that a successful login forget the
+// previous failures of the account only
+func TestLoginThrottleSuccess(t *testing.T) {
+	// given
+	throttle := newLoginThrottle(throttleConf())
+	now := time.Now()
+	throttle.failure("127.0.0.1", "bob", now)
+
+	// when
+	throttle.success("bob")
+
+	// then
+	if wait := throttle.wait("10.0.0.1", "bob", now); wait != 0 {
+		t.Fatalf("expect no wait for the account after a success, but got %s", wait)
+	}
+	if wait := throttle.wait("127.0.0.1", "bob", now); wait != time.Second {
+		t.Fatalf("expect the ip to still wait after a success, but got %s", wait)
+	}
+}
+
+// test that the successful logins of an account
+// don't reset the delay of the ip guessing the
+// password of another one
+func TestLoginThrottleSuccessInterleavedWithFailures(t *testing.T) {
+	// given
+	throttle := newLoginThrottle(throttleConf())
+	now := time.Now()
+
+	// when
+	for i := 0; i < 3; i++ {
+		now = now.Add(throttle.wait("127.0.0.1", "bob", now))
+		throttle.failure("127.0.0.1", "bob", now)
+		throttle.success("alice")
+	}
+	wait := throttle.wait("127.0.0.1", "charlie", now)
+
+	// then
+	if wait != 4*time.Second {
+		t.Fatalf("expect the ip to wait for its third failure, but got %s", wait)
+	}
+}
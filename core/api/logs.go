@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/route"
+)
+
+// delay between two checks of new
+// lines when following logs
+var logFollowDelay = 500 * time.Millisecond
+
+const defaultSearchContext = 2
+const maxSearchContext = 10
+const defaultSearchedExecutions = 10
+
+// one line matching a log search,
+// with the lines around it
+type logMatch struct {
+	ExecutionId string          `json:"executionId"`
+	Step        int             `json:"step"`
+	StepName    string          `json:"stepName"`
+	Line        model.LogLine   `json:"line"`
+	Before      []model.LogLine `json:"before"`
+	After       []model.LogLine `json:"after"`
+}
+
+// http handler that deals with get request on the logs of one step
+// of an execution. Query parameters :
+//   - from, to : range of lines, both included
+//   - format=text : plain text download instead of json
+//   - follow=true : stream the new lines while the step is running
+func (a *Api) onGetStepLogs(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-6]
+	executionId := path[len(path)-4]
+	step, convErr := strconv.Atoi(path[len(path)-2])
+	if convErr != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Errorf("invalid step %s", path[len(path)-2]))
+		return
+	}
+	query := r.URL.Query()
+	from, fromErr := intParam(query.Get("from"), 1)
+	to, toErr := intParam(query.Get("to"), 0)
+	if fromErr != nil || toErr != nil {
+		writeApiError(w, http.StatusBadRequest, errors.New("from and to must be line numbers"))
+		return
+	}
+	text := query.Get("format") == "text" || strings.Contains(r.Header.Get("Accept"), "text/plain")
+
+	execution, persistenceErr := a.repository.GetJobExecution(ctx, jobId, executionId)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onGetStepLogs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	if step < 0 || step >= len(execution.Steps) {
+		writeApiError(w, http.StatusNotFound, fmt.Errorf("no step %d in execution %s", step, executionId))
+		return
+	}
+
+	if query.Get("follow") == "true" {
+		a.followStepLogs(ctx, w, jobId, executionId, step, from, to)
+		return
+	}
+
+	lines, persistenceErr := a.repository.GetLogs(ctx, jobId, executionId, step, from, to)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onGetStepLogs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	if text {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%d.log"`, jobId, executionId, step))
+		w.WriteHeader(http.StatusOK)
+		for _, line := range lines {
+			fmt.Fprintln(w, line.Content)
+		}
+		return
+	}
+	body, err := json.Marshal(lines)
+	if err != nil {
+		log.Printf("ERROR >> onGetStepLogs encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// followStepLogs write the lines of the step as plain text, and then
+// the new ones as soon as they are stored. It stops when the step is
+// over, when the line to is reached, or when the client is gone.
+func (a *Api) followStepLogs(ctx context.Context, w http.ResponseWriter, jobId, executionId string, step, from, to int) {
+	flusher, canFlush := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	next := from
+	for {
+		// the status must be read before the lines. If read after,
+		// lines written between the two reads would be lost.
+		execution, persistenceErr := a.repository.GetJobExecution(ctx, jobId, executionId)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> followStepLogs encounter error : %s", persistenceErr.Error())
+			return
+		}
+		running := execution.Steps[step].Status == model.Running || execution.Steps[step].Status == model.Pending
+		lines, persistenceErr := a.repository.GetLogs(ctx, jobId, executionId, step, next, to)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> followStepLogs encounter error : %s", persistenceErr.Error())
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line.Content)
+			next = line.Number + 1
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+		if !running || (to > 0 && next > to) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logFollowDelay):
+		}
+	}
+}
+
+// http handler that deals with a search in the logs of one execution.
+// Query parameters :
+//   - q : the searched string, mandatory
+//   - regex=true : q is a regular expression
+//   - context : number of lines returned around each match
+func (a *Api) onSearchExecutionLogs(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-5]
+	executionId := path[len(path)-3]
+	matcher, contextSize, err := parseSearch(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	execution, persistenceErr := a.repository.GetJobExecution(ctx, jobId, executionId)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onSearchExecutionLogs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	matches, persistenceErr := a.searchExecutionLogs(ctx, jobId, execution, matcher, contextSize)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onSearchExecutionLogs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	writeJson(w, http.StatusOK, matches)
+}
+
+// http handler that deals with a search in the logs of the recent executions
+// of one job. Same query parameters than #onSearchExecutionLogs, plus
+// executions, the number of searched executions, the most recent first.
+func (a *Api) onSearchJobLogs(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	jobId := path[len(path)-3]
+	matcher, contextSize, err := parseSearch(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	count, err := intParam(r.URL.Query().Get("executions"), defaultSearchedExecutions)
+	if err != nil || count <= 0 {
+		writeApiError(w, http.StatusBadRequest, errors.New("executions must be a positive number"))
+		return
+	}
+	executions, persistenceErr := a.repository.GetJobExecutions(ctx, jobId)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onSearchJobLogs encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	matches := make([]logMatch, 0)
+	for i := len(executions) - 1; i >= 0 && i >= len(executions)-count; i-- {
+		executionMatches, persistenceErr := a.searchExecutionLogs(ctx, jobId, executions[i], matcher, contextSize)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> onSearchJobLogs encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return
+		}
+		matches = append(matches, executionMatches...)
+	}
+	writeJson(w, http.StatusOK, matches)
+}
+
+func (a *Api) searchExecutionLogs(ctx context.Context, jobId string, execution *model.JobExecution, matcher func(string) bool, contextSize int) ([]logMatch, persistence.PersistenceError) {
+	matches := make([]logMatch, 0)
+	for step, stepExecution := range execution.Steps {
+		lines, err := a.repository.GetLogs(ctx, jobId, execution.Id, step, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range lines {
+			if !matcher(line.Content) {
+				continue
+			}
+			start := i - contextSize
+			if start < 0 {
+				start = 0
+			}
+			end := i + contextSize + 1
+			if end > len(lines) {
+				end = len(lines)
+			}
+			matches = append(matches, logMatch{
+				ExecutionId: execution.Id,
+				Step:        step,
+				StepName:    stepExecution.Name,
+				Line:        line,
+				Before:      lines[start:i],
+				After:       lines[i+1 : end],
+			})
+		}
+	}
+	return matches, nil
+}
+
+// parseSearch read the search parameters of the request
+// and return the function matching the lines
+func parseSearch(r *http.Request) (func(string) bool, int, error) {
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		return nil, 0, errors.New("the searched string q is mandatory")
+	}
+	contextSize, err := intParam(query.Get("context"), defaultSearchContext)
+	if err != nil || contextSize < 0 || contextSize > maxSearchContext {
+		return nil, 0, fmt.Errorf("context must be a number between 0 and %d", maxSearchContext)
+	}
+	if query.Get("regex") == "true" {
+		re, err := regexp.Compile(q)
+		if err != nil {
+			return nil, 0, err
+		}
+		return re.MatchString, contextSize, nil
+	}
+	return func(line string) bool {
+		return strings.Contains(line, q)
+	}, contextSize, nil
+}
+
+// intParam parse an optional int
+// query parameter
+func intParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,241 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// insert one finished execution of the job "job" with two steps. The
+// first one has no log, the second one has ten lines : "line 1" ... "line 10"
+func insertExecutionWithLogs(c *configuration.Conf, executionId string, date time.Time) {
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	execution := &model.JobExecution{
+		Id:     executionId,
+		Date:   date,
+		Status: model.Failure,
+		Steps: []*model.StepExecution{
+			&model.StepExecution{Name: "Code fetching", Status: model.Success},
+			&model.StepExecution{Name: "tests", Status: model.Failure, LogLines: 10},
+		},
+	}
+	rep.UpsertJobExecution(ctx, "job", execution)
+	var lines []model.LogLine
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, model.LogLine{Number: i, Time: date, Stream: model.Stdout, Content: fmt.Sprintf("line %d", i)})
+	}
+	rep.AppendLogs(ctx, "job", executionId, 1, lines)
+}
+
+func TestGetStepLogsAsJsonWithRange(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	insertExecutionWithLogs(conf, "1", time.Now())
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/1/steps/1/logs?from=3&to=5", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	var lines []model.LogLine
+	json.NewDecoder(resp.Body).Decode(&lines)
+	if len(lines) != 3 || lines[0].Content != "line 3" || lines[2].Content != "line 5" {
+		t.Fatalf("expect lines 3 to 5, but got %+v", lines)
+	}
+}
+
+func TestGetStepLogsAsText(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	insertExecutionWithLogs(conf, "1", time.Now())
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/1/steps/1/logs?format=text&from=9", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	b := bytes.Buffer{}
+	b.ReadFrom(resp.Body)
+	if b.String() != "line 9\nline 10\n" {
+		t.Fatalf("expect the two last lines as text, but got %s", b.String())
+	}
+}
+
+func TestFollowStepLogsOfFinishedStep(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	insertExecutionWithLogs(conf, "1", time.Now())
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/1/steps/1/logs?follow=true&from=10", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	b := bytes.Buffer{}
+	b.ReadFrom(resp.Body)
+	if b.String() != "line 10\n" {
+		t.Fatalf("expect the stream to end with the step, but got %s", b.String())
+	}
+}
+
+func TestGetStepLogsUnknownStep(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	insertExecutionWithLogs(conf, "1", time.Now())
+
+	// when
+	unknownStep := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/1/steps/5/logs", s.URL), "", model.RoleAdmin, nil)
+	unknownExecution := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/2/steps/1/logs", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if unknownStep.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expect 404 return code for an unknown step. Got %d", unknownStep.StatusCode)
+	}
+	if unknownExecution.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expect 404 return code for an unknown execution. Got %d", unknownExecution.StatusCode)
+	}
+}
+
+func TestGetStepLogsNotAuthenticated(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp, err := http.Get(fmt.Sprintf("%s/jobs/job/executions/1/steps/1/logs", s.URL))
+
+	// then
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 return code. Got %d", resp.StatusCode)
+	}
+}
+
+func TestSearchExecutionLogs(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	insertExecutionWithLogs(conf, "1", time.Now())
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/executions/1/logs/search?q=line%%205&context=1", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	var matches []struct {
+		ExecutionId string          `json:"executionId"`
+		Step        int             `json:"step"`
+		StepName    string          `json:"stepName"`
+		Line        model.LogLine   `json:"line"`
+		Before      []model.LogLine `json:"before"`
+		After       []model.LogLine `json:"after"`
+	}
+	json.NewDecoder(resp.Body).Decode(&matches)
+	if len(matches) != 1 {
+		t.Fatalf("expect one match, but got %d", len(matches))
+	}
+	match := matches[0]
+	if match.Line.Number != 5 || match.Step != 1 || match.StepName != "tests" {
+		t.Fatalf("expect the line 5 of tests to match, but got %+v", match)
+	}
+	if len(match.Before) != 1 || match.Before[0].Number != 4 || len(match.After) != 1 || match.After[0].Number != 6 {
+		t.Fatalf("expect one line of context around the match, but got %+v", match)
+	}
+}
+
+func TestSearchJobLogsWithRegex(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	now := time.Now()
+	insertExecutionWithLogs(conf, "old", now.Add(-2*time.Hour))
+	insertExecutionWithLogs(conf, "middle", now.Add(-1*time.Hour))
+	insertExecutionWithLogs(conf, "recent", now)
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/logs/search?q=%%5Eline%%201%%24&regex=true&executions=2", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 return code. Got %d", resp.StatusCode)
+	}
+	var matches []struct {
+		ExecutionId string        `json:"executionId"`
+		Line        model.LogLine `json:"line"`
+	}
+	json.NewDecoder(resp.Body).Decode(&matches)
+	if len(matches) != 2 {
+		t.Fatalf("expect two matches, but got %d", len(matches))
+	}
+	if matches[0].ExecutionId != "recent" || matches[1].ExecutionId != "middle" {
+		t.Fatalf("expect the matches of the two recent executions, most recent first, but got %+v", matches)
+	}
+}
+
+func TestSearchLogsBadRequest(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	noQuery := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/logs/search", s.URL), "", model.RoleAdmin, nil)
+	badRegex := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/job/logs/search?q=%%28&regex=true", s.URL), "", model.RoleAdmin, nil)
+
+	// then
+	if noQuery.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 return code without query. Got %d", noQuery.StatusCode)
+	}
+	if badRegex.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 return code with an invalid regex. Got %d", badRegex.StatusCode)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/oidc"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// path the identity provider
+// redirects the users to
+const oidcCallbackPath = "/oidc/callback"
+
+func (a *Api) oidcRedirectUrl() string {
+	return strings.TrimSuffix(a.conf.ApiConf.ExternalUrl, "/") + oidcCallbackPath
+}
+
+// redirect the user to the
+// identity provider
+func (a *Api) onOidcLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	authUrl, err := a.oidc.AuthenticationUrl(ctx, a.oidcRedirectUrl())
+	if err != nil {
+		log.Printf("ERROR >> onOidcLogin encounter error : %s", err.Error())
+		writeApiError(w, http.StatusBadGateway, errors.New("the identity provider is unreachable"))
+		return
+	}
+	http.Redirect(w, r, authUrl, http.StatusFound)
+}
+
+// end of the single sign-on: the user coming back from
+// the identity provider is mapped to a Dahu user, and a
+// session is opened like with /login
+func (a *Api) onOidcCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("INFO >> onOidcCallback authentication refused by the identity provider : %s", providerErr)
+		writeApiError(w, http.StatusUnauthorized, fmt.Errorf("authentication refused by the identity provider : %s", providerErr))
+		return
+	}
+	identity, err := a.oidc.Authenticate(ctx, query.Get("code"), query.Get("state"))
+	if err != nil {
+		log.Printf("INFO >> onOidcCallback authentication failure : %s", err.Error())
+		writeApiError(w, http.StatusUnauthorized, errors.New("authentication failure"))
+		return
+	}
+	u, ok := a.ssoUser(ctx, w, identity)
+	if !ok {
+		return
+	}
+	token, err := a.openSession(ctx, u)
+	if err != nil {
+		log.Printf("ERROR >> onOidcCallback encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, token)
+}
+
+// load the Dahu user bound to an identity, creating it when auto-provisioning
+// is enabled. An existing user is never bound on the fly : an identity
+// provider may let anyone choose its login claim. The role given by the
+// groups of the identity, if any, replaces the one of the user.
+func (a *Api) ssoUser(ctx context.Context, w http.ResponseWriter, identity *oidc.Identity) (*model.User, bool) {
+	u, persistenceErr := a.boundUser(ctx, identity.Issuer, identity.Subject)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> ssoUser encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return nil, false
+	}
+	if u == nil {
+		_, persistenceErr = a.repository.GetUser(identity.Login, ctx)
+		if persistenceErr == nil {
+			log.Printf("INFO >> ssoUser the user %s is not bound to the identity %s of %s", identity.Login, identity.Subject, identity.Issuer)
+			writeApiError(w, http.StatusUnauthorized, errors.New("authentication failure"))
+			return nil, false
+		}
+		if persistenceErr.ErrorType() != persistence.NotFound {
+			log.Printf("ERROR >> ssoUser encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return nil, false
+		}
+		if !a.conf.OidcConf.AutoProvisioning {
+			log.Printf("INFO >> ssoUser unknown user %s", identity.Login)
+			writeApiError(w, http.StatusUnauthorized, errors.New("authentication failure"))
+			return nil, false
+		}
+		newUser := model.User{Login: identity.Login, Role: identity.Role, SsoIssuer: identity.Issuer, SsoSubject: identity.Subject}
+		if !newUser.Role.IsValid() {
+			newUser.Role = model.RoleViewer
+		}
+		u, persistenceErr = a.repository.CreateUser(&newUser, ctx)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> ssoUser encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return nil, false
+		}
+		log.Printf("INFO >> ssoUser user %s provisioned with the role %s", u.Login, u.Role)
+		return u, true
+	}
+	if u.Disabled {
+		log.Printf("INFO >> ssoUser disabled user %s tried to authenticate", u.Login)
+		writeApiError(w, http.StatusUnauthorized, errors.New("authentication failure"))
+		return nil, false
+	}
+	if identity.Role.IsValid() && identity.Role != u.GetRole() {
+		u.Role = identity.Role
+		u, persistenceErr = a.repository.UpdateUser(u, ctx)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> ssoUser encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return nil, false
+		}
+	}
+	return u, true
+}
+
+// the user bound to the identity subject of issuer,
+// or nil when there is none
+func (a *Api) boundUser(ctx context.Context, issuer, subject string) (*model.User, persistence.PersistenceError) {
+	users, persistenceErr := a.repository.GetUsers(ctx)
+	if persistenceErr != nil {
+		return nil, persistenceErr
+	}
+	for _, u := range users {
+		if u.IsBoundTo(issuer, subject) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,155 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// start Dahu with the single sign-on delegated to
+// the stand-in identity provider, and the given users
+func startWithOidc(idp *tests.IdentityProvider, autoProvisioning bool, users ...model.User) *httptest.Server {
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	for _, u := range users {
+		insertUser(conf, u)
+	}
+	conf.OidcConf.Issuer = idp.Issuer()
+	conf.OidcConf.ClientId = idp.ClientId
+	conf.OidcConf.ClientSecret = idp.ClientSecret
+	conf.OidcConf.GroupRoles = map[string]string{"devs": "maintainer"}
+	conf.OidcConf.AutoProvisioning = autoProvisioning
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	conf.ApiConf.ExternalUrl = s.URL
+	return s
+}
+
+// test the whole single sign-on flow, with the
+// provisioning of a user unknown by Dahu
+func TestOidcLoginProvisionsTheUser(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	idp.Claims["groups"] = []string{"devs"}
+	s := startWithOidc(idp, true)
+	defer tests.CleanPersistence(conf)
+	defer s.Close()
+
+	// when
+	resp, err := http.Get(fmt.Sprintf("%s/oidc/login", s.URL))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	var tok model.Token
+	json.NewDecoder(resp.Body).Decode(&tok)
+	jobsResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), tok.Value, nil)
+	userResp := doRequest(t, "GET", fmt.Sprintf("%s/users/bob", s.URL), tests.GetToken(conf.ApiConf.Secret, time.Now().Add(time.Minute)), nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK || tok.Value == "" || tok.RefreshToken == "" {
+		t.Fatalf("Expect 200 and a pair of tokens, but got %d and %+v", resp.StatusCode, tok)
+	}
+	if jobsResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 with the access token of bob, but got %d", jobsResp.StatusCode)
+	}
+	var u model.User
+	json.NewDecoder(userResp.Body).Decode(&u)
+	if u.Login != "bob" || u.Role != model.RoleMaintainer || u.MustChangePassword {
+		t.Fatalf("Expect bob to be provisioned as maintainer, but got %+v", u)
+	}
+}
+
+// test that unknown users are rejected
+// without auto-provisioning
+func TestOidcLoginUnknownUser(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	s := startWithOidc(idp, false)
+	defer tests.CleanPersistence(conf)
+	defer s.Close()
+
+	// when
+	resp, err := http.Get(fmt.Sprintf("%s/oidc/login", s.URL))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+
+	// then
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 for an unknown user, but got %d", resp.StatusCode)
+	}
+}
+
+// test that a callback that doesn't
+// follow a login is rejected
+func TestOidcCallbackWithUnknownState(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	s := startWithOidc(idp, true)
+	defer tests.CleanPersistence(conf)
+	defer s.Close()
+
+	// when
+	resp, err := http.Get(fmt.Sprintf("%s/oidc/callback?code=some-code&state=some-state", s.URL))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+
+	// then
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 for an unknown state, but got %d", resp.StatusCode)
+	}
+}
+
+// test that an existing account is only logged in by the
+// identity an admin has bound to it, whatever the login claim
+func TestOidcLoginOnlyWithTheBoundIdentity(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	idp.Claims["sub"] = "bob-subject"
+	bob := model.User{Login: "bob", Role: model.RoleViewer}
+	bob.SetPassword([]byte(sessionPassword))
+	s := startWithOidc(idp, true, bob, model.User{Login: "alice", Role: model.RoleAdmin})
+	defer tests.CleanPersistence(conf)
+	defer s.Close()
+
+	// when
+	unboundResp, err := http.Get(fmt.Sprintf("%s/oidc/login", s.URL))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	subject := "bob-subject"
+	bindResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/users/bob", s.URL), "alice", model.RoleAdmin, model.UserUpdate{SsoSubject: &subject})
+	conflictResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/users/alice", s.URL), "alice", model.RoleAdmin, model.UserUpdate{SsoSubject: &subject})
+	boundResp, _ := http.Get(fmt.Sprintf("%s/oidc/login", s.URL))
+	idp.Claims["sub"] = "mallory-subject"
+	otherResp, _ := http.Get(fmt.Sprintf("%s/oidc/login", s.URL))
+
+	// then
+	if unboundResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 for an account not bound to the identity, but got %d", unboundResp.StatusCode)
+	}
+	if bindResp.StatusCode != http.StatusOK || conflictResp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expect the identity to be bound to bob only, but got %d and %d", bindResp.StatusCode, conflictResp.StatusCode)
+	}
+	if boundResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 with the bound identity, but got %d", boundResp.StatusCode)
+	}
+	if otherResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 for another identity with the same login claim, but got %d", otherResp.StatusCode)
+	}
+}
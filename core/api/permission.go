@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/route"
+)
+
+// return the role required to perform
+// the request: read for GET, write otherwise.
+func requiredRole(r *http.Request, read, write model.Role) model.Role {
+	if r.Method == http.MethodGet {
+		return read
+	}
+	return write
+}
+
+func forbidden(w http.ResponseWriter, required model.Role) bool {
+	writeApiError(w, http.StatusForbidden, fmt.Errorf("the %s role is required for this operation", required))
+	return false
+}
+
+// filter that check the role carried by the token
+// of the request. The read role is required for GET
+// requests, the write one for any other method.
+// Must be placed after the authFilter.
+func (a *Api) roleFilter(read, write model.Role) func(w http.ResponseWriter, r *http.Request) bool {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		required := requiredRole(r, read, write)
+		claims, _ := a.checkToken(r)
+		if !role(claims).Includes(required) {
+			return forbidden(w, required)
+		}
+		return true
+	}
+}
+
+// same as roleFilter, but for routes under /jobs/:jobId.
+// The role inside the team owning the job, then the
+// grants of the job, override the role of the token.
+func (a *Api) jobRoleFilter(read, write model.Role) func(w http.ResponseWriter, r *http.Request) bool {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		required := requiredRole(r, read, write)
+		claims, _ := a.checkToken(r)
+		userRole := role(claims)
+		jobId := route.SplitPath(r.URL.Path)[1]
+		job, err := a.repository.GetJob([]byte(jobId), r.Context())
+		if err == nil {
+			var roles teamRoles
+			roles, err = a.loadTeamRoles(r.Context(), claims)
+			userRole = job.RoleOf(subject(claims), roles.of(job.TeamId))
+		}
+		if err != nil && err.ErrorType() != persistence.NotFound {
+			log.Printf("ERROR >> jobRoleFilter encounter error : %s", err.Error())
+			writePersistenceError(w, err)
+			return false
+		}
+		// an unknown job has no owner, the handler
+		// will deal with it once the global role is checked.
+		if !userRole.Includes(required) {
+			return forbidden(w, required)
+		}
+		return true
+	}
+}
+
+// same as roleFilter, but for routes under
+// /containers/docker/registries/:registryId. The role inside
+// the team owning the registry override the role of the token.
+func (a *Api) registryRoleFilter(read, write model.Role) func(w http.ResponseWriter, r *http.Request) bool {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		required := requiredRole(r, read, write)
+		claims, _ := a.checkToken(r)
+		userRole := role(claims)
+		registryId := route.SplitPath(r.URL.Path)[3]
+		registry, err := a.repository.GetDockerRegistry([]byte(registryId), r.Context())
+		if err == nil {
+			var roles teamRoles
+			roles, err = a.loadTeamRoles(r.Context(), claims)
+			userRole = roles.of(registry.TeamId)
+		}
+		if err != nil && err.ErrorType() != persistence.NotFound {
+			log.Printf("ERROR >> registryRoleFilter encounter error : %s", err.Error())
+			writePersistenceError(w, err)
+			return false
+		}
+		if !userRole.Includes(required) {
+			return forbidden(w, required)
+		}
+		return true
+	}
+}
+
+// roles of a user inside every team
+type teamRoles struct {
+	global model.Role            // role of the user outside of any team
+	byTeam map[string]model.Role // role inside each existing team, by team id
+}
+
+// return the role of the user on a resource
+// owned by the given team. Resources without
+// team rely on the global role.
+func (t teamRoles) of(teamId string) model.Role {
+	if teamId == "" {
+		return t.global
+	}
+	return t.byTeam[teamId]
+}
+
+func (t teamRoles) exists(teamId string) bool {
+	_, ok := t.byTeam[teamId]
+	return ok
+}
+
+// load the roles of the owner of the token inside every team
+func (a *Api) loadTeamRoles(ctx context.Context, claims jwt.MapClaims) (teamRoles, persistence.PersistenceError) {
+	roles := teamRoles{global: role(claims), byTeam: make(map[string]model.Role)}
+	teams, err := a.repository.GetTeams(ctx)
+	if err != nil {
+		return roles, err
+	}
+	for _, team := range teams {
+		roles.byTeam[team.Id] = team.RoleOf(subject(claims), roles.global)
+	}
+	return roles, nil
+}
+
+// check that the owner of the token of the request may create
+// or move resources into the given team (or outside of any team
+// when teamId is empty). Answer with 403 or 404 when it is not the case.
+func (a *Api) canWriteInTeam(ctx context.Context, w http.ResponseWriter, r *http.Request, teamId string) bool {
+	claims, _ := a.checkToken(r)
+	roles, err := a.loadTeamRoles(ctx, claims)
+	if err != nil {
+		log.Printf("ERROR >> canWriteInTeam encounter error : %s", err.Error())
+		writePersistenceError(w, err)
+		return false
+	}
+	if teamId != "" && !roles.exists(teamId) {
+		writeApiError(w, http.StatusNotFound, fmt.Errorf("No team with id %s found", teamId))
+		return false
+	}
+	if !roles.of(teamId).Includes(model.RoleMaintainer) {
+		return forbidden(w, model.RoleMaintainer)
+	}
+	return true
+}
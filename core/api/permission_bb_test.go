@@ -0,0 +1,114 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func insertJob(c *configuration.Conf, job model.Job) {
+	tests.InsertObject(c, []byte("jobs"), job.Id, job)
+}
+
+// test that a viewer can read, but
+// can't modify anything
+func TestPermissionViewerIsReadOnly(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleViewer})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	readResp := doUserRequest(t, "GET", fmt.Sprintf("%s/containers/docker/registries", s.URL), "bob", model.RoleViewer, nil)
+	writeResp := doUserRequest(t, "POST", fmt.Sprintf("%s/containers/docker/registries", s.URL), "bob", model.RoleViewer,
+		model.DockerRegistry{Name: "test", Url: "localhost:5000"})
+	usersResp := doUserRequest(t, "GET", fmt.Sprintf("%s/users", s.URL), "bob", model.RoleViewer, nil)
+
+	// then
+	if readResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when a viewer list registries, but got %d", readResp.StatusCode)
+	}
+	if writeResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when a viewer create a registry, but got %d", writeResp.StatusCode)
+	}
+	var apiErr api.ApiError
+	json.NewDecoder(writeResp.Body).Decode(&apiErr)
+	if apiErr.Msg == "" {
+		t.Fatal("Expect an ApiError body with the 403")
+	}
+	if usersResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when a viewer list users, but got %d", usersResp.StatusCode)
+	}
+}
+
+// test that a token without any role
+// gives access to nothing
+func TestPermissionTokenWithoutRole(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob"})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "bob", "", nil)
+
+	// then
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 with a token without role, but got %d", resp.StatusCode)
+	}
+}
+
+// test that the grants of a job restrict
+// who can see it and what they can do on it
+func TestPermissionJobGrants(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "bob", Role: model.RoleViewer})
+	insertUser(conf, model.User{Login: "alice", Role: model.RoleMaintainer})
+	insertJob(conf, model.Job{Id: []byte("public"), Name: "public"})
+	insertJob(conf, model.Job{Id: []byte("private"), Name: "private", Grants: map[string]model.Role{"bob": model.RoleViewer}})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	aliceListResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "alice", model.RoleMaintainer, nil)
+	aliceSearchResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/private/logs/search?q=test", s.URL), "alice", model.RoleMaintainer, nil)
+	bobListResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, nil)
+	bobSearchResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs/private/logs/search?q=test", s.URL), "bob", model.RoleViewer, nil)
+	bobRunResp := doUserRequest(t, "POST", fmt.Sprintf("%s/jobs/private/executions", s.URL), "bob", model.RoleViewer, nil)
+
+	// then
+	var aliceJobs, bobJobs []model.Job
+	json.NewDecoder(aliceListResp.Body).Decode(&aliceJobs)
+	json.NewDecoder(bobListResp.Body).Decode(&bobJobs)
+	if len(aliceJobs) != 1 || aliceJobs[0].Name != "public" {
+		t.Fatalf("Expect alice to only see the public job, but got %+v", aliceJobs)
+	}
+	if len(bobJobs) != 2 {
+		t.Fatalf("Expect bob to see both jobs, but got %+v", bobJobs)
+	}
+	if aliceSearchResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when alice search in the private job, but got %d", aliceSearchResp.StatusCode)
+	}
+	if bobSearchResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when bob search in the private job, but got %d", bobSearchResp.StatusCode)
+	}
+	if bobRunResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when bob run the private job, but got %d", bobRunResp.StatusCode)
+	}
+}
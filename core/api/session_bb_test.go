@@ -0,0 +1,137 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+const sessionPassword = "test_test_test_test"
+
+// insert bob, a viewer whose
+// password is sessionPassword
+func insertSessionUser(c *configuration.Conf) {
+	u := model.User{Login: "bob", Role: model.RoleViewer}
+	u.SetPassword([]byte(sessionPassword))
+	insertUser(c, u)
+}
+
+func refresh(t *testing.T, url, refreshToken string) *http.Response {
+	body, _ := json.Marshal(model.Refresh{RefreshToken: refreshToken})
+	resp, err := http.Post(fmt.Sprintf("%s/refresh", url), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	return resp
+}
+
+// test that a refresh token gives a new pair
+// of tokens and can't be used twice
+func TestSessionRefresh(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertSessionUser(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	tok := login(t, s.URL, "bob")
+
+	// when
+	refreshResp := refresh(t, s.URL, tok.RefreshToken)
+	var newTok model.Token
+	json.NewDecoder(refreshResp.Body).Decode(&newTok)
+	reuseResp := refresh(t, s.URL, tok.RefreshToken)
+	jobsResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), newTok.Value, nil)
+
+	// then
+	if tok.RefreshToken == "" || tok.ExpiresAt.IsZero() {
+		t.Fatalf("Expect the login to return a refresh token and an expiration, but got %+v", tok)
+	}
+	if refreshResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when refreshing, but got %d", refreshResp.StatusCode)
+	}
+	if newTok.RefreshToken == "" || newTok.RefreshToken == tok.RefreshToken {
+		t.Fatalf("Expect a new refresh token, but got %+v", newTok)
+	}
+	if reuseResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 when reusing a refresh token, but got %d", reuseResp.StatusCode)
+	}
+	if jobsResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 with the refreshed access token, but got %d", jobsResp.StatusCode)
+	}
+}
+
+// test that a logout revokes the access
+// token and closes the session
+func TestSessionLogout(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertSessionUser(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	tok := login(t, s.URL, "bob")
+
+	// when
+	logoutResp := doRequest(t, "POST", fmt.Sprintf("%s/logout", s.URL), tok.Value, nil)
+	jobsResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), tok.Value, nil)
+	refreshResp := refresh(t, s.URL, tok.RefreshToken)
+
+	// then
+	if logoutResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when logging out, but got %d", logoutResp.StatusCode)
+	}
+	if jobsResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 with a revoked access token, but got %d", jobsResp.StatusCode)
+	}
+	if refreshResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 with the refresh token of a closed session, but got %d", refreshResp.StatusCode)
+	}
+}
+
+// test that a password change closes
+// every other session of the user
+func TestSessionPasswordChange(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertSessionUser(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	otherTok := login(t, s.URL, "bob")
+	tok := login(t, s.URL, "bob")
+
+	// when
+	changeResp := doRequest(t, "PUT", fmt.Sprintf("%s/me/password", s.URL), tok.Value,
+		model.PasswordChange{OldPassword: sessionPassword, NewPassword: "a_much_better_password"})
+	var newTok model.Token
+	json.NewDecoder(changeResp.Body).Decode(&newTok)
+	oldJobsResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), otherTok.Value, nil)
+	oldRefreshResp := refresh(t, s.URL, otherTok.RefreshToken)
+	newJobsResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), newTok.Value, nil)
+
+	// then
+	if changeResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when changing the password, but got %d", changeResp.StatusCode)
+	}
+	if oldJobsResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 with an access token issued before the password change, but got %d", oldJobsResp.StatusCode)
+	}
+	if oldRefreshResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 with a refresh token issued before the password change, but got %d", oldRefreshResp.StatusCode)
+	}
+	if newJobsResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 with the token returned by the password change, but got %d", newJobsResp.StatusCode)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/signing"
+)
+
+// publish the public keys checking the access tokens,
+// so that other services may validate them
+func (a *Api) onJwksGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJson(w, http.StatusOK, a.keys.Jwks())
+}
+
+// generate a new signing key. The tokens signed by the
+// previous key stay valid until their expiration.
+func (a *Api) onSigningKeyRotation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key, err := a.keys.Rotate(ctx)
+	if err == signing.ErrConfiguredKey {
+		writeApiError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR >> onSigningKeyRotation encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.audit(ctx, r, model.AuditCreate, model.AuditSigningKey, key.Id, nil, key)
+	writeJson(w, http.StatusCreated, key)
+}
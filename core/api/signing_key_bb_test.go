@@ -0,0 +1,135 @@
+package api_test
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/signing"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func getJwks(t *testing.T, url string) signing.JsonWebKeySet {
+	resp, err := http.Get(fmt.Sprintf("%s/.well-known/jwks.json", url))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	var jwks signing.JsonWebKeySet
+	json.NewDecoder(resp.Body).Decode(&jwks)
+	return jwks
+}
+
+// check the token with the published key identified by its kid
+func checkWithJwks(jwks signing.JsonWebKeySet, value string) (*jwt.Token, error) {
+	return jwt.Parse(value, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range jwks.Keys {
+			if jwk.Kid == token.Header["kid"] {
+				n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+				e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+				return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key %v", token.Header["kid"])
+	})
+}
+
+// test that RS256 tokens can be checked with the published
+// keys, and stay valid after a rotation of the key
+func TestSigningKeyRotationWithRs256(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.SigningAlgorithm = "RS256"
+	defer tests.CleanPersistence(conf)
+	u := model.User{Login: "alice", Role: model.RoleAdmin}
+	u.SetPassword([]byte(sessionPassword))
+	insertUser(conf, u)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	oldToken := login(t, s.URL, "alice").Value
+	oldJwks := getJwks(t, s.URL)
+
+	// when
+	rotationResp := doRequest(t, "POST", fmt.Sprintf("%s/keys/rotation", s.URL), oldToken, nil)
+	var newKey signing.KeyInfo
+	json.NewDecoder(rotationResp.Body).Decode(&newKey)
+	oldTokenResp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), oldToken, nil)
+	newToken := login(t, s.URL, "alice").Value
+	newJwks := getJwks(t, s.URL)
+
+	// then
+	if len(oldJwks.Keys) != 1 || oldJwks.Keys[0].Kty != "RSA" || oldJwks.Keys[0].Alg != "RS256" {
+		t.Fatalf("Expect one RSA key to be published, but got %+v", oldJwks)
+	}
+	if token, err := checkWithJwks(oldJwks, oldToken); err != nil || !token.Valid {
+		t.Fatalf("Expect the token to be checked with the published key, but got %v", err)
+	}
+	if rotationResp.StatusCode != http.StatusCreated || newKey.Id == "" || newKey.Id == oldJwks.Keys[0].Kid {
+		t.Fatalf("Expect 201 and a new key, but got %d and %+v", rotationResp.StatusCode, newKey)
+	}
+	if oldTokenResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect the token signed by the previous key to stay valid, but got %d", oldTokenResp.StatusCode)
+	}
+	if len(newJwks.Keys) != 2 || newJwks.Keys[0].Kid != newKey.Id || newJwks.Keys[1].Kid != oldJwks.Keys[0].Kid {
+		t.Fatalf("Expect the new key then the previous one to be published, but got %+v", newJwks)
+	}
+	token, err := checkWithJwks(newJwks, newToken)
+	if err != nil || !token.Valid || token.Header["kid"] != newKey.Id {
+		t.Fatalf("Expect the new token to be signed by the new key, but got %v", err)
+	}
+}
+
+// test that a HS256 token forged with the public
+// key of a RS256 key is rejected
+func TestSigningKeyAlgorithmConfusion(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.SigningAlgorithm = "RS256"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	jwks := getJwks(t, s.URL)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": "admin", "exp": time.Now().Add(time.Minute).Unix()})
+	forged.Header["kid"] = jwks.Keys[0].Kid
+	forgedValue, _ := forged.SignedString([]byte(jwks.Keys[0].N))
+
+	// when
+	resp := doRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), forgedValue, nil)
+
+	// then
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401, but got %d", resp.StatusCode)
+	}
+}
+
+// test that a secret given by the
+// configuration is neither published nor rotated
+func TestSigningKeyRotationWithConfiguredSecret(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	resp := doRequest(t, "POST", fmt.Sprintf("%s/keys/rotation", s.URL), tests.GetToken(conf.ApiConf.Secret, time.Now().Add(time.Minute)), nil)
+	jwks := getJwks(t, s.URL)
+
+	// then
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expect 409, but got %d", resp.StatusCode)
+	}
+	if len(jwks.Keys) != 0 {
+		t.Fatalf("Expect no published key, but got %+v", jwks)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/route"
+)
+
+// switch choice for request on all teams resources
+func (a *Api) handleTeams(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onTeamsGet(ctx, w, r)
+	} else if r.Method == http.MethodPost {
+		a.onTeamCreation(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// switch choice for request on a single team resource
+func (a *Api) handleTeam(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onTeamGet(ctx, w, r)
+	} else if r.Method == http.MethodPut {
+		a.onTeamUpdate(ctx, w, r)
+	} else if r.Method == http.MethodDelete {
+		a.onTeamDelete(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// list the teams of the authenticated
+// user, or all teams for an admin
+func (a *Api) onTeamsGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	teams, persistenceErr := a.repository.GetTeams(ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onTeamsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	claims, _ := a.checkToken(r)
+	visibleTeams := make([]*model.Team, 0, len(teams))
+	for _, team := range teams {
+		if team.RoleOf(subject(claims), role(claims)).Includes(model.RoleViewer) {
+			team.ToPublicModel()
+			visibleTeams = append(visibleTeams, team)
+		}
+	}
+	writeJson(w, http.StatusOK, visibleTeams)
+}
+
+// create a new team. Will fail if
+// there is already an id in the given team
+func (a *Api) onTeamCreation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var team model.Team
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&team)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !team.IsValid() {
+		writeApiError(w, http.StatusBadRequest, errors.New("a team must have a name and members with known roles"))
+		return
+	}
+	newTeam, persistenceErr := a.repository.CreateTeam(&team, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onTeamCreation encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditCreate, model.AuditTeam, newTeam.Id, nil, newTeam)
+	newTeam.ToPublicModel()
+	writeJson(w, http.StatusCreated, newTeam)
+}
+
+// load the team targeted by the request and check that the
+// owner of the token has the required role inside it.
+func (a *Api) getTeamWithRole(ctx context.Context, w http.ResponseWriter, r *http.Request, required model.Role) (*model.Team, bool) {
+	path := route.SplitPath(r.URL.Path)
+	teamId := path[len(path)-1]
+	team, persistenceErr := a.repository.GetTeam(teamId, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> getTeamWithRole encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return nil, false
+	}
+	claims, _ := a.checkToken(r)
+	if !team.RoleOf(subject(claims), role(claims)).Includes(required) {
+		return nil, forbidden(w, required)
+	}
+	return team, true
+}
+
+// http handler that deals with get request on a single team resource
+func (a *Api) onTeamGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	team, ok := a.getTeamWithRole(ctx, w, r, model.RoleViewer)
+	if !ok {
+		return
+	}
+	team.ToPublicModel()
+	writeJson(w, http.StatusOK, team)
+}
+
+// http handler that deals with put request on a team resource.
+// Secrets left empty keep their current value.
+func (a *Api) onTeamUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	existingTeam, ok := a.getTeamWithRole(ctx, w, r, model.RoleAdmin)
+	if !ok {
+		return
+	}
+	var team model.Team
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&team)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !team.IsValid() {
+		writeApiError(w, http.StatusBadRequest, errors.New("a team must have a name and members with known roles"))
+		return
+	}
+	team.Id = existingTeam.Id
+	team.MergeSecrets(existingTeam)
+	updatedTeam, persistenceErr := a.repository.UpdateTeam(&team, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onTeamUpdate encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditUpdate, model.AuditTeam, updatedTeam.Id, existingTeam, updatedTeam)
+	updatedTeam.ToPublicModel()
+	writeJson(w, http.StatusOK, updatedTeam)
+}
+
+// http handler that deals with delete request on a team resource
+func (a *Api) onTeamDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	team, ok := a.getTeamWithRole(ctx, w, r, model.RoleAdmin)
+	if !ok {
+		return
+	}
+	persistenceErr := a.repository.DeleteTeam(ctx, team.Id)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onTeamDelete encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditDelete, model.AuditTeam, team.Id, team, nil)
+	w.WriteHeader(http.StatusOK)
+}
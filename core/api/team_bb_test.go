@@ -0,0 +1,181 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// insert two teams, one job and one registry per team
+// and one global job and registry. bob is maintainer
+// of the team a, alice of the team b. Both are viewers.
+func insertTeamsFixture(c *configuration.Conf) {
+	insertUser(c, model.User{Login: "bob", Role: model.RoleViewer})
+	insertUser(c, model.User{Login: "alice", Role: model.RoleViewer})
+	insertUser(c, model.User{Login: "admin", Role: model.RoleAdmin})
+	tests.InsertObject(c, []byte("teams"), []byte("a"), model.Team{Id: "a", Name: "a",
+		Members: map[string]model.Role{"bob": model.RoleMaintainer}, Secrets: map[string]string{"token": "s3cr3t"}})
+	tests.InsertObject(c, []byte("teams"), []byte("b"), model.Team{Id: "b", Name: "b",
+		Members: map[string]model.Role{"alice": model.RoleMaintainer}})
+	insertJob(c, model.Job{Id: []byte("jobA"), Name: "jobA", TeamId: "a"})
+	insertJob(c, model.Job{Id: []byte("jobB"), Name: "jobB", TeamId: "b"})
+	insertJob(c, model.Job{Id: []byte("global"), Name: "global"})
+	tests.InsertObject(c, []byte("dockerRegistries"), []byte("registryA"), model.DockerRegistry{Id: "registryA", Name: "registryA", TeamId: "a"})
+	tests.InsertObject(c, []byte("dockerRegistries"), []byte("registryB"), model.DockerRegistry{Id: "registryB", Name: "registryB", TeamId: "b"})
+}
+
+func jobNames(t *testing.T, resp *http.Response) []string {
+	var jobs []model.Job
+	json.NewDecoder(resp.Body).Decode(&jobs)
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+// test that jobs and registries are
+// scoped to the teams of the caller
+func TestTeamScopedLists(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertTeamsFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	jobsResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, nil)
+	registriesResp := doUserRequest(t, "GET", fmt.Sprintf("%s/containers/docker/registries", s.URL), "bob", model.RoleViewer, nil)
+	otherRegistryResp := doUserRequest(t, "GET", fmt.Sprintf("%s/containers/docker/registries/registryB", s.URL), "bob", model.RoleViewer, nil)
+
+	// then
+	names := jobNames(t, jobsResp)
+	if len(names) != 2 || names[0] != "global" || names[1] != "jobA" {
+		t.Fatalf("Expect bob to see the global job and the job of its team, but got %v", names)
+	}
+	var registries []model.DockerRegistry
+	json.NewDecoder(registriesResp.Body).Decode(&registries)
+	if len(registries) != 1 || registries[0].Name != "registryA" {
+		t.Fatalf("Expect bob to only see the registry of its team, but got %+v", registries)
+	}
+	if otherRegistryResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when bob get a registry of another team, but got %d", otherRegistryResp.StatusCode)
+	}
+}
+
+// test that a team maintainer may create
+// jobs in its team, but not elsewhere
+func TestTeamJobCreation(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertTeamsFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	sshAuth := model.SshAuthConfig{Url: "git@some-domain/some-repo.git", Key: "some-key", KeyPassword: "some-password"}
+	job := model.Job{Name: "new", GitConf: model.GitConfig{SshAuth: &sshAuth}}
+
+	// when
+	job.TeamId = "a"
+	ownTeamResp := doUserRequest(t, "POST", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, job)
+	job.TeamId = "b"
+	otherTeamResp := doUserRequest(t, "POST", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, job)
+	job.TeamId = ""
+	globalResp := doUserRequest(t, "POST", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, job)
+	job.TeamId = "unknown"
+	unknownTeamResp := doUserRequest(t, "POST", fmt.Sprintf("%s/jobs", s.URL), "admin", model.RoleAdmin, job)
+
+	// then
+	if ownTeamResp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expect 201 when bob create a job in its team, but got %d", ownTeamResp.StatusCode)
+	}
+	if otherTeamResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when bob create a job in another team, but got %d", otherTeamResp.StatusCode)
+	}
+	if globalResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when bob create a global job, but got %d", globalResp.StatusCode)
+	}
+	if unknownTeamResp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expect 404 when creating a job in an unknown team, but got %d", unknownTeamResp.StatusCode)
+	}
+}
+
+// test moving a job from one team to another
+func TestTeamMoveJob(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertTeamsFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	bobMoveResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/jobs/jobA/team", s.URL), "bob", model.RoleViewer, model.TeamChange{TeamId: "b"})
+	adminMoveResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/jobs/jobA/team", s.URL), "admin", model.RoleAdmin, model.TeamChange{TeamId: "b"})
+	bobJobsResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "bob", model.RoleViewer, nil)
+	aliceJobsResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "alice", model.RoleViewer, nil)
+
+	// then
+	if bobMoveResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when bob move a job to a team he isn't maintainer of, but got %d", bobMoveResp.StatusCode)
+	}
+	if adminMoveResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when an admin move a job, but got %d", adminMoveResp.StatusCode)
+	}
+	if names := jobNames(t, bobJobsResp); len(names) != 1 {
+		t.Fatalf("Expect bob to only see the global job anymore, but got %v", names)
+	}
+	if names := jobNames(t, aliceJobsResp); len(names) != 3 {
+		t.Fatalf("Expect alice to see the global job and both jobs of its team, but got %v", names)
+	}
+}
+
+// test that the secrets of a team are hidden
+// and that only members can see the team
+func TestTeamGet(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertTeamsFixture(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	memberResp := doUserRequest(t, "GET", fmt.Sprintf("%s/teams/a", s.URL), "bob", model.RoleViewer, nil)
+	otherResp := doUserRequest(t, "GET", fmt.Sprintf("%s/teams/a", s.URL), "alice", model.RoleViewer, nil)
+	updateResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/teams/a", s.URL), "bob", model.RoleViewer, model.Team{Name: "renamed"})
+	listResp := doUserRequest(t, "GET", fmt.Sprintf("%s/teams", s.URL), "alice", model.RoleViewer, nil)
+
+	// then
+	if memberResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when a member get its team, but got %d", memberResp.StatusCode)
+	}
+	var team model.Team
+	json.NewDecoder(memberResp.Body).Decode(&team)
+	if value, ok := team.Secrets["token"]; !ok || value != "" {
+		t.Fatalf("Expect the secret name without its value, but got %+v", team.Secrets)
+	}
+	if otherResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when a non member get a team, but got %d", otherResp.StatusCode)
+	}
+	if updateResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when a team maintainer update the team, but got %d", updateResp.StatusCode)
+	}
+	var teams []model.Team
+	json.NewDecoder(listResp.Body).Decode(&teams)
+	if len(teams) != 1 || teams[0].Id != "b" {
+		t.Fatalf("Expect alice to only see its team, but got %+v", teams)
+	}
+}
@@ -1,36 +1,116 @@
 package api
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
 )
 
+// path of the only endpoint reachable by
+// a user that must change its password
+const passwordChangePath = "/me/password"
+
 func (a *Api) authFilter(w http.ResponseWriter, r *http.Request) bool {
-	err := a.checkToken(r)
-	if err == nil {
+	claims, err := a.checkToken(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	login := subject(claims)
+	if login == "" {
+		// token issued without subject (technical
+		// token). There is no user to check.
 		return true
-	} else {
+	}
+	u, persistenceErr := a.repository.GetUser(login, r.Context())
+	if persistenceErr != nil {
+		if persistenceErr.ErrorType() == persistence.NotFound {
+			log.Printf("INFO >> authFilter token of unknown user %s", login)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			log.Printf("ERROR >> authFilter encounter error : %s", persistenceErr.Error())
+			writeApiError(w, http.StatusInternalServerError, persistenceErr)
+		}
+		return false
+	}
+	if u.Disabled {
+		log.Printf("INFO >> authFilter token of disabled user %s", login)
 		w.WriteHeader(http.StatusUnauthorized)
 		return false
 	}
+	if iat, ok := claims["iat"].(float64); ok && int64(math.Round(iat*1000)) < u.SessionsRevokedAt {
+		log.Printf("INFO >> authFilter token of user %s issued before the revocation of its sessions", login)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if u.MustChangePassword && r.URL.Path != passwordChangePath {
+		writeApiError(w, http.StatusForbidden, errors.New("password change required"))
+		return false
+	}
+	if !scopesAllow(claims, r) {
+		writeApiError(w, http.StatusForbidden, errors.New("the scopes of the api token don't allow this operation"))
+		return false
+	}
+	return true
+}
+
+// key of the tokenCheck in the context of a request
+type tokenCheckKey struct{}
+
+// the check of the token of a request. The filters and the
+// handlers share it, so that the token is parsed, and the
+// repository queried, only once by request.
+type tokenCheck struct {
+	once   sync.Once
+	claims jwt.MapClaims
+	err    error
+}
+
+// give every request its tokenCheck
+func withTokenCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), tokenCheckKey{}, &tokenCheck{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the claims of the token of the request, checked by parseToken
+// on the first call (authFilter), and read from the context of
+// the request on the next ones.
+func (a *Api) checkToken(r *http.Request) (jwt.MapClaims, error) {
+	check, ok := r.Context().Value(tokenCheckKey{}).(*tokenCheck)
+	if !ok {
+		return a.parseToken(r)
+	}
+	check.once.Do(func() {
+		check.claims, check.err = a.parseToken(r)
+	})
+	return check.claims, check.err
 }
 
 // check if the given request contains a valid JWT token
-// This function will search the token in the authorization
+// or api token. This function will search the token in the authorization
 // header. The supported authentication scheme is bearer.
 // it means that the expected header is Authorization : Bearer <TOKEN>
-func (a *Api) checkToken(r *http.Request) (err error) {
+func (a *Api) parseToken(r *http.Request) (claims jwt.MapClaims, err error) {
 	authContent := r.Header.Get("Authorization")
 	chunck := strings.Split(strings.TrimSpace(authContent), " ")
 	if len(chunck) != 2 {
 		err = errors.New("invalid authorization data. Must have the form Bearer <TOKEN>")
 		return
 	}
-	token, parsingError := jwt.Parse(chunck[1], a.keyFunc)
+	if model.IsApiTokenValue(chunck[1]) {
+		return a.checkApiToken(r.Context(), chunck[1])
+	}
+	token, parsingError := jwt.Parse(chunck[1], a.keys.KeyFunc)
 	if parsingError != nil {
 		err = parsingError
 		return
@@ -39,12 +119,38 @@ func (a *Api) checkToken(r *http.Request) (err error) {
 		err = errors.New("invalid token")
 		return
 	}
+	claims, _ = token.Claims.(jwt.MapClaims)
+	if tokenId, ok := claims["jti"].(string); ok {
+		revoked, persistenceErr := a.repository.IsTokenRevoked(tokenId, r.Context())
+		if persistenceErr != nil {
+			err = persistenceErr
+			return
+		}
+		if revoked {
+			err = errors.New("revoked token")
+			return
+		}
+	}
 	return
 }
 
-func (a *Api) keyFunc(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// return the login of the user that own
+// the token of the request, or an empty string
+// if there is no valid token or no subject in it.
+func (a *Api) tokenSubject(r *http.Request) string {
+	claims, err := a.checkToken(r)
+	if err != nil {
+		return ""
 	}
-	return []byte(a.conf.ApiConf.Secret), nil
+	return subject(claims)
+}
+
+func subject(claims jwt.MapClaims) string {
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+func role(claims jwt.MapClaims) model.Role {
+	r, _ := claims["role"].(string)
+	return model.Role(r)
 }
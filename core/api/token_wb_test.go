@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// count the checks of the deny-list
+type revocationCountingRepository struct {
+	persistence.Repository
+	count int
+}
+
+func (r *revocationCountingRepository) IsTokenRevoked(id string, ctx context.Context) (bool, persistence.PersistenceError) {
+	r.count++
+	return r.Repository.IsTokenRevoked(id, ctx)
+}
+
+// test that the token is checked once by request, even
+// though the filters and the handler all read its claims
+func TestCheckTokenOnceByRequest(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	a := InitRoute(conf)
+	repository := &revocationCountingRepository{Repository: a.repository}
+	a.repository = repository
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"role": "admin",
+		"jti":  "some-token",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+	value, _ := token.SignedString([]byte(conf.ApiConf.Secret))
+	req := httptest.NewRequest("GET", "/teams", nil)
+	req.Header.Add("Authorization", "Bearer "+value)
+	w := httptest.NewRecorder()
+
+	// when
+	a.Handler().ServeHTTP(w, req)
+
+	// then
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, but got %d", w.Code)
+	}
+	if repository.count != 1 {
+		t.Fatalf("expect the deny-list to be checked once, but got %d checks", repository.count)
+	}
+}
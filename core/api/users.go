@@ -0,0 +1,330 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/route"
+)
+
+// number of login attempts returned
+// when no limit is given
+const defaultLoginAttemptsLimit = 100
+
+// switch choice for request on all users resources
+func (a *Api) handleUsers(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onUsersGet(ctx, w, r)
+	} else if r.Method == http.MethodPost {
+		a.onUserCreation(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// switch choice for request on a single user resource
+func (a *Api) handleUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		a.onUserGet(ctx, w, r)
+	} else if r.Method == http.MethodPut {
+		a.onUserUpdate(ctx, w, r)
+	} else if r.Method == http.MethodDelete {
+		a.onUserDelete(ctx, w, r)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// http handler that deals with get request on all users resources
+func (a *Api) onUsersGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	users, persistenceErr := a.repository.GetUsers(ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUsersGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	for _, user := range users {
+		user.ToPublicModel()
+	}
+	writeJson(w, http.StatusOK, users)
+}
+
+// create a new user. The given password is a temporary
+// one that the user will have to change on first login.
+func (a *Api) onUserCreation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var creation model.UserCreation
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&creation)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	if creation.Login == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("the login is mandatory"))
+		return
+	}
+	if creation.Role == "" {
+		creation.Role = model.RoleViewer
+	}
+	if !creation.Role.IsValid() {
+		writeApiError(w, http.StatusBadRequest, fmt.Errorf("unknown role %s", creation.Role))
+		return
+	}
+	user := model.User{Login: creation.Login, Role: creation.Role, Disabled: creation.Disabled, MustChangePassword: true}
+	err = user.SetPassword([]byte(creation.Password))
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	newUser, persistenceErr := a.repository.CreateUser(&user, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserCreation encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditCreate, model.AuditUser, newUser.Login, nil, newUser)
+	newUser.ToPublicModel()
+	writeJson(w, http.StatusCreated, newUser)
+}
+
+// http handler that deals with get request on a single user resource
+func (a *Api) onUserGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	path := route.SplitPath(r.URL.Path)
+	login := path[len(path)-1]
+	user, persistenceErr := a.repository.GetUser(login, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	user.ToPublicModel()
+	writeJson(w, http.StatusOK, user)
+}
+
+// http handler that deals with put request on a user resource.
+// Setting a password here is a reset: the user will have
+// to change it on next login.
+func (a *Api) onUserUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var update model.UserUpdate
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&update)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	login := path[len(path)-1]
+	user, persistenceErr := a.repository.GetUser(login, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserUpdate encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	before := *user
+	if update.Password != "" {
+		err = user.SetPassword([]byte(update.Password))
+		if err != nil {
+			writeApiError(w, http.StatusBadRequest, err)
+			return
+		}
+		user.MustChangePassword = true
+	}
+	if update.Role != "" {
+		if !update.Role.IsValid() {
+			writeApiError(w, http.StatusBadRequest, fmt.Errorf("unknown role %s", update.Role))
+			return
+		}
+		if update.Role != user.GetRole() && login == a.tokenSubject(r) {
+			writeApiError(w, http.StatusBadRequest, errors.New("a user cannot change his own role"))
+			return
+		}
+		user.Role = update.Role
+	}
+	if update.Disabled != nil {
+		if *update.Disabled && login == a.tokenSubject(r) {
+			writeApiError(w, http.StatusBadRequest, errors.New("a user cannot disable himself"))
+			return
+		}
+		user.Disabled = *update.Disabled
+	}
+	if update.SsoSubject != nil {
+		ok := a.bindUser(ctx, w, user, *update.SsoSubject)
+		if !ok {
+			return
+		}
+	}
+	if update.Password != "" || user.Disabled {
+		persistenceErr = a.revokeSessions(ctx, user)
+		if persistenceErr != nil {
+			log.Printf("ERROR >> onUserUpdate encounter error : %s", persistenceErr.Error())
+			writePersistenceError(w, persistenceErr)
+			return
+		}
+	}
+	updatedUser, persistenceErr := a.repository.UpdateUser(user, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserUpdate encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditUpdate, model.AuditUser, login, before, updatedUser)
+	updatedUser.ToPublicModel()
+	writeJson(w, http.StatusOK, updatedUser)
+}
+
+// bind the user to the identity subject of the configured OpenID
+// Connect provider, or unbind it when subject is empty. An identity
+// may only be bound to one user.
+func (a *Api) bindUser(ctx context.Context, w http.ResponseWriter, user *model.User, subject string) bool {
+	if subject == "" {
+		user.SsoIssuer, user.SsoSubject = "", ""
+		return true
+	}
+	issuer := a.conf.OidcConf.Issuer
+	if issuer == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("the single sign-on is disabled"))
+		return false
+	}
+	bound, persistenceErr := a.boundUser(ctx, issuer, subject)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserUpdate encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return false
+	}
+	if bound != nil && bound.Login != user.Login {
+		writeApiError(w, http.StatusConflict, fmt.Errorf("the identity %s is already bound to the user %s", subject, bound.Login))
+		return false
+	}
+	user.SsoIssuer, user.SsoSubject = issuer, subject
+	return true
+}
+
+// http handler that deals with delete request on a user resource
+func (a *Api) onUserDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	path := route.SplitPath(r.URL.Path)
+	login := path[len(path)-1]
+	if login == a.tokenSubject(r) {
+		writeApiError(w, http.StatusBadRequest, errors.New("a user cannot delete himself"))
+		return
+	}
+	user, persistenceErr := a.repository.GetUser(login, ctx)
+	if persistenceErr == nil {
+		persistenceErr = a.repository.DeleteUser(ctx, login)
+	}
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserDelete encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditDelete, model.AuditUser, login, user, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// self-service password change of the user that own the token
+// of the request. Every other session of the user is closed and
+// a new pair of tokens is returned.
+func (a *Api) onPasswordChange(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	login := a.tokenSubject(r)
+	if login == "" {
+		writeApiError(w, http.StatusBadRequest, errors.New("the token is not bound to any user"))
+		return
+	}
+	var change model.PasswordChange
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&change)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	user, persistenceErr := a.repository.GetUser(login, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onPasswordChange encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	before := *user
+	if user.ComparePassword([]byte(change.OldPassword)) != nil {
+		writeApiError(w, http.StatusForbidden, errors.New("wrong current password"))
+		return
+	}
+	if change.NewPassword == change.OldPassword {
+		writeApiError(w, http.StatusBadRequest, errors.New("the new password must differ from the current one"))
+		return
+	}
+	err = user.SetPassword([]byte(change.NewPassword))
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err)
+		return
+	}
+	user.MustChangePassword = false
+	persistenceErr = a.revokeSessions(ctx, user)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onPasswordChange encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	updatedUser, persistenceErr := a.repository.UpdateUser(user, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onPasswordChange encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.audit(ctx, r, model.AuditUpdate, model.AuditUser, login, before, updatedUser)
+	token, err := a.openSession(ctx, updatedUser)
+	if err != nil {
+		log.Printf("ERROR >> onPasswordChange encounter error : %s", err.Error())
+		writeApiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJson(w, http.StatusOK, token)
+}
+
+// unlock an account locked after too many failed logins
+func (a *Api) onUserUnlock(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := route.SplitPath(r.URL.Path)
+	login := path[len(path)-2]
+	_, persistenceErr := a.repository.GetUser(login, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onUserUnlock encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	a.throttle.unlock(login)
+	log.Printf("INFO >> onUserUnlock account %s unlocked by %s", login, a.tokenSubject(r))
+	w.WriteHeader(http.StatusOK)
+}
+
+// list the last login attempts, newest first.
+// They may be filtered by login.
+func (a *Api) onLoginAttemptsGet(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	limit, err := intParam(query.Get("limit"), defaultLoginAttemptsLimit)
+	if err != nil || limit <= 0 {
+		writeApiError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+		return
+	}
+	attempts, persistenceErr := a.repository.GetLoginAttempts(query.Get("login"), limit, ctx)
+	if persistenceErr != nil {
+		log.Printf("ERROR >> onLoginAttemptsGet encounter error : %s", persistenceErr.Error())
+		writePersistenceError(w, persistenceErr)
+		return
+	}
+	writeJson(w, http.StatusOK, attempts)
+}
@@ -0,0 +1,163 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/api"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func insertUser(c *configuration.Conf, u model.User) {
+	tests.InsertObject(c, []byte("users"), []byte(u.Login), u)
+}
+
+// test the first start flow: the default user
+// must change its password before doing anything else
+func TestUsersDefaultUserMustChangeItsPassword(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	body, _ := json.Marshal(model.Login{Id: "dahu", Password: "dahuDefaultPassword"})
+
+	// when
+	resp, err := http.Post(fmt.Sprintf("%s/login", s.URL), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Expect to have to error, but got %s", err.Error())
+	}
+	var tok model.Token
+	json.NewDecoder(resp.Body).Decode(&tok)
+	blockedResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "dahu", model.RoleAdmin, nil)
+	changeResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/me/password", s.URL), "dahu", model.RoleAdmin,
+		model.PasswordChange{OldPassword: "dahuDefaultPassword", NewPassword: "a_much_better_password"})
+	allowedResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "dahu", model.RoleAdmin, nil)
+
+	// then
+	if resp.StatusCode != http.StatusOK || !tok.PasswordChangeRequired {
+		t.Fatalf("Expect a token requiring a password change, but got %d and %+v", resp.StatusCode, tok)
+	}
+	if blockedResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 before the password change, but got %d", blockedResp.StatusCode)
+	}
+	if changeResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when changing the password, but got %d", changeResp.StatusCode)
+	}
+	if allowedResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 after the password change, but got %d", allowedResp.StatusCode)
+	}
+}
+
+// test that the password length rule is
+// applied on self-service password change
+func TestUsersPasswordChangeTooShort(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	u := model.User{Login: "test"}
+	u.SetPassword([]byte("test_test_test_test"))
+	insertUser(conf, u)
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+
+	// when
+	shortResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/me/password", s.URL), "test", model.RoleViewer,
+		model.PasswordChange{OldPassword: "test_test_test_test", NewPassword: "short"})
+	wrongResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/me/password", s.URL), "test", model.RoleViewer,
+		model.PasswordChange{OldPassword: "wrong_password", NewPassword: "a_much_better_password"})
+
+	// then
+	if shortResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 when the new password is too short, but got %d", shortResp.StatusCode)
+	}
+	if wrongResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expect 403 when the current password is wrong, but got %d", wrongResp.StatusCode)
+	}
+}
+
+// test the creation and the listing of users
+func TestUsersCreateAndList(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "admin", Role: model.RoleAdmin})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	creation := model.UserCreation{Login: "newcomer", Password: "a_temporary_password"}
+
+	// when
+	createResp := doUserRequest(t, "POST", fmt.Sprintf("%s/users", s.URL), "admin", model.RoleAdmin, creation)
+	conflictResp := doUserRequest(t, "POST", fmt.Sprintf("%s/users", s.URL), "admin", model.RoleAdmin, creation)
+	listResp := doUserRequest(t, "GET", fmt.Sprintf("%s/users", s.URL), "admin", model.RoleAdmin, nil)
+
+	// then
+	if createResp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expect 201 when creating a user, but got %d", createResp.StatusCode)
+	}
+	var created model.User
+	json.NewDecoder(createResp.Body).Decode(&created)
+	if created.Login != "newcomer" || created.Password != nil || !created.MustChangePassword {
+		t.Fatalf("Expect the public model of the created user, but got %+v", created)
+	}
+	if conflictResp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expect 409 when creating an existing user, but got %d", conflictResp.StatusCode)
+	}
+	var users []model.User
+	json.NewDecoder(listResp.Body).Decode(&users)
+	if len(users) != 2 {
+		t.Fatalf("Expect to get 2 users, but got %+v", users)
+	}
+	for _, user := range users {
+		if user.Password != nil {
+			t.Fatalf("Expect no password hash to be returned, but got one for %s", user.Login)
+		}
+	}
+}
+
+// test that a disabled user can't use its token anymore
+// and that a user can't delete himself
+func TestUsersDisableAndDelete(t *testing.T) {
+	// given
+	conf = configuration.InitConf()
+	conf.ApiConf.Secret = "secret"
+	defer tests.CleanPersistence(conf)
+	insertUser(conf, model.User{Login: "admin", Role: model.RoleAdmin})
+	insertUser(conf, model.User{Login: "other"})
+	s := httptest.NewServer(api.InitRoute(conf).Handler())
+	defer s.Close()
+	disabled := true
+
+	// when
+	disableResp := doUserRequest(t, "PUT", fmt.Sprintf("%s/users/other", s.URL), "admin", model.RoleAdmin, model.UserUpdate{Disabled: &disabled})
+	disabledCallResp := doUserRequest(t, "GET", fmt.Sprintf("%s/jobs", s.URL), "other", model.RoleViewer, nil)
+	selfDeleteResp := doUserRequest(t, "DELETE", fmt.Sprintf("%s/users/admin", s.URL), "admin", model.RoleAdmin, nil)
+	deleteResp := doUserRequest(t, "DELETE", fmt.Sprintf("%s/users/other", s.URL), "admin", model.RoleAdmin, nil)
+	getResp := doUserRequest(t, "GET", fmt.Sprintf("%s/users/other", s.URL), "admin", model.RoleAdmin, nil)
+
+	// then
+	if disableResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when disabling a user, but got %d", disableResp.StatusCode)
+	}
+	if disabledCallResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expect 401 when a disabled user call the api, but got %d", disabledCallResp.StatusCode)
+	}
+	if selfDeleteResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expect 400 when a user delete himself, but got %d", selfDeleteResp.StatusCode)
+	}
+	if deleteResp.StatusCode != http.StatusOK {
+		t.Fatalf("Expect 200 when deleting a user, but got %d", deleteResp.StatusCode)
+	}
+	if getResp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expect 404 when getting a deleted user, but got %d", getResp.StatusCode)
+	}
+}
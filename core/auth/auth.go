@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+var (
+	ErrUnknownUser        = errors.New("auth >> unknown user")
+	ErrInvalidCredentials = errors.New("auth >> invalid credentials")
+)
+
+// Authenticator check the credentials given on /login.
+// Any error other than ErrUnknownUser and ErrInvalidCredentials
+// means that the credentials couldn't be checked.
+type Authenticator interface {
+	Authenticate(login, password string, ctx context.Context) (*model.User, error)
+}
+
+// return the authenticator selected
+// by the configuration
+func GetAuthenticator(conf *configuration.Conf, repository persistence.Repository) Authenticator {
+	switch conf.ApiConf.Authentication {
+	case configuration.LdapAuthentication:
+		return &ldapAuthenticator{conf: conf.LdapConf, repository: repository}
+	default:
+		return &localAuthenticator{repository: repository}
+	}
+}
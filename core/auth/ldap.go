@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"gopkg.in/ldap.v3"
+)
+
+// authenticate the users against a LDAP directory.
+// The Dahu user is created on the first successful
+// login, and its role follows its groups when one of
+// them is mapped to a role.
+type ldapAuthenticator struct {
+	conf       configuration.Ldap
+	repository persistence.Repository
+}
+
+func (l *ldapAuthenticator) Authenticate(login, password string, ctx context.Context) (*model.User, error) {
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if l.conf.BindDn != "" {
+		err = conn.Bind(l.conf.BindDn, l.conf.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("auth >> bind of the service account failed : %s", err.Error())
+		}
+	}
+	entry, err := l.searchUser(conn, login)
+	if err != nil {
+		return nil, err
+	}
+	// an empty password would be an unauthenticated
+	// bind, that most directories accept
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	role := model.RoleOfGroups(entry.GetAttributeValues(l.conf.GroupAttribute), l.conf.GroupRoles)
+	return l.dahuUser(login, role, ctx)
+}
+
+func (l *ldapAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	u, err := url.Parse(l.conf.Url)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" && u.Scheme == "ldaps" {
+		host = net.JoinHostPort(u.Hostname(), ldap.DefaultLdapsPort)
+	} else if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), ldap.DefaultLdapPort)
+	}
+	dialer := net.Dialer{Timeout: l.conf.TimeOut}
+	c, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{ServerName: u.Hostname()}
+	if u.Scheme == "ldaps" {
+		c = tls.Client(c, tlsConf)
+	}
+	conn := ldap.NewConn(c, u.Scheme == "ldaps")
+	conn.Start()
+	conn.SetTimeout(l.conf.TimeOut)
+	if l.conf.StartTls && u.Scheme != "ldaps" {
+		err = conn.StartTLS(tlsConf)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// find the single entry of
+// the directory matching login
+func (l *ldapAuthenticator) searchUser(conn *ldap.Conn, login string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(l.conf.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(l.conf.TimeOut.Seconds()), false,
+		fmt.Sprintf(l.conf.UserFilter, ldap.EscapeFilter(login)), []string{l.conf.GroupAttribute}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) == 0 {
+		return nil, ErrUnknownUser
+	}
+	if len(res.Entries) > 1 {
+		return nil, fmt.Errorf("auth >> several entries of the directory match the user %s", login)
+	}
+	return res.Entries[0], nil
+}
+
+// return the Dahu user of an authenticated
+// directory user, creating it if needed. A local
+// user of the same login is never taken over
+func (l *ldapAuthenticator) dahuUser(login string, role model.Role, ctx context.Context) (*model.User, error) {
+	u, persistenceErr := l.repository.GetUser(login, ctx)
+	if persistenceErr != nil && persistenceErr.ErrorType() != persistence.NotFound {
+		return nil, persistenceErr
+	}
+	if persistenceErr != nil {
+		newUser := model.User{Login: login, Role: role, Source: model.LdapSource}
+		if !newUser.Role.IsValid() {
+			newUser.Role = model.RoleViewer
+		}
+		u, persistenceErr = l.repository.CreateUser(&newUser, ctx)
+		if persistenceErr != nil {
+			return nil, persistenceErr
+		}
+		log.Printf("INFO >> dahuUser user %s provisioned with the role %s", u.Login, u.Role)
+		return u, nil
+	}
+	update := role.IsValid() && role != u.GetRole()
+	if u.Source != model.LdapSource {
+		// the directory users created before their source was
+		// recorded have neither password nor single sign-on
+		if len(u.Password) > 0 || u.SsoSubject != "" {
+			log.Printf("WARN >> dahuUser directory user %s refused, a local user has the same login", login)
+			return nil, ErrInvalidCredentials
+		}
+		u.Source = model.LdapSource
+		update = true
+	}
+	if update {
+		if role.IsValid() {
+			u.Role = role
+		}
+		u, persistenceErr = l.repository.UpdateUser(u, ctx)
+		if persistenceErr != nil {
+			return nil, persistenceErr
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,146 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/auth"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// start a directory with a service account
+// and bob, member of the devs group
+func startDirectory() *tests.Directory {
+	return tests.NewDirectory(
+		tests.LdapEntry{Dn: "cn=dahu,ou=services,dc=dahu,dc=test", Password: "service-password",
+			Attributes: map[string][]string{"objectClass": {"account"}, "uid": {"dahu"}}},
+		tests.LdapEntry{Dn: "uid=bob,ou=people,dc=dahu,dc=test", Password: "bob-password",
+			Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"bob"}, "memberOf": {"cn=devs,ou=groups,dc=dahu,dc=test"}}},
+	)
+}
+
+func ldapConf(d *tests.Directory) *configuration.Conf {
+	c := configuration.InitConf()
+	c.ApiConf.Authentication = configuration.LdapAuthentication
+	c.LdapConf.Url = d.Url
+	c.LdapConf.BindDn = "cn=dahu,ou=services,dc=dahu,dc=test"
+	c.LdapConf.BindPassword = "service-password"
+	c.LdapConf.BaseDn = "dc=dahu,dc=test"
+	c.LdapConf.GroupRoles = map[string]string{"cn=devs,ou=groups,dc=dahu,dc=test": "maintainer"}
+	return c
+}
+
+// test that a directory user is created
+// with the role of its groups
+func TestLdapAuthenticate(t *testing.T) {
+	// given
+	d := startDirectory()
+	defer d.Close()
+	c := ldapConf(d)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	authenticator := auth.GetAuthenticator(c, rep)
+
+	// when
+	user, err := authenticator.Authenticate("bob", "bob-password", ctx)
+	persisted, getErr := rep.GetUser("bob", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || getErr != nil {
+		t.Fatalf("expect no error, but got %v and %v", err, getErr)
+	}
+	if user.Login != "bob" || user.Role != model.RoleMaintainer {
+		t.Fatalf("expect bob to be maintainer, but got %+v", user)
+	}
+	if persisted.Role != model.RoleMaintainer || len(persisted.Password) != 0 || persisted.Source != model.LdapSource {
+		t.Fatalf("expect bob to be created from the directory without password, but got %+v", persisted)
+	}
+}
+
+// test that a directory user can't log
+// in as the local user of the same login
+func TestLdapAuthenticateShouldNotTakeOverALocalUser(t *testing.T) {
+	// given
+	d := startDirectory()
+	defer d.Close()
+	c := ldapConf(d)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	local := model.User{Login: "bob", Role: model.RoleAdmin}
+	local.SetPassword([]byte("local-password"))
+	rep.CreateUser(&local, ctx)
+	authenticator := auth.GetAuthenticator(c, rep)
+
+	// when
+	_, err := authenticator.Authenticate("bob", "bob-password", ctx)
+	persisted, getErr := rep.GetUser("bob", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != auth.ErrInvalidCredentials {
+		t.Fatalf("expect ErrInvalidCredentials, but got %v", err)
+	}
+	if getErr != nil || persisted.Role != model.RoleAdmin || persisted.Source != "" {
+		t.Fatalf("expect the local user to be left untouched, but got %+v and %v", persisted, getErr)
+	}
+}
+
+// test the rejection of wrong
+// credentials and unknown users
+func TestLdapAuthenticateFailures(t *testing.T) {
+	// given
+	d := startDirectory()
+	defer d.Close()
+	c := ldapConf(d)
+	ctx := context.Background()
+	authenticator := auth.GetAuthenticator(c, persistence.GetRepository(c))
+
+	// when
+	_, wrongErr := authenticator.Authenticate("bob", "wrong-password", ctx)
+	_, emptyErr := authenticator.Authenticate("bob", "", ctx)
+	_, unknownErr := authenticator.Authenticate("alice", "alice-password", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if wrongErr != auth.ErrInvalidCredentials {
+		t.Fatalf("expect ErrInvalidCredentials with a wrong password, but got %v", wrongErr)
+	}
+	if emptyErr != auth.ErrInvalidCredentials {
+		t.Fatalf("expect ErrInvalidCredentials with an empty password, but got %v", emptyErr)
+	}
+	if unknownErr != auth.ErrUnknownUser {
+		t.Fatalf("expect ErrUnknownUser, but got %v", unknownErr)
+	}
+}
+
+// test that the credentials can't be checked
+// when the service account can't bind
+func TestLdapAuthenticateWithWrongServiceAccount(t *testing.T) {
+	// given
+	d := startDirectory()
+	defer d.Close()
+	c := ldapConf(d)
+	c.LdapConf.BindPassword = "wrong"
+	authenticator := auth.GetAuthenticator(c, persistence.GetRepository(c))
+
+	// when
+	_, err := authenticator.Authenticate("bob", "bob-password", context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err == auth.ErrInvalidCredentials || err == auth.ErrUnknownUser {
+		t.Fatalf("expect an error telling the directory can't be used, but got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// user whose password is checked for unknown
+// logins, so that they take as long as known ones
+var unknownUser = func() model.User {
+	u := model.User{}
+	u.SetPassword([]byte("password-of-an-unknown-user"))
+	return u
+}()
+
+// check the password against the
+// bcrypt hash stored with the user
+type localAuthenticator struct {
+	repository persistence.Repository
+}
+
+func (l *localAuthenticator) Authenticate(login, password string, ctx context.Context) (*model.User, error) {
+	u, persistenceErr := l.repository.GetUser(login, ctx)
+	if persistenceErr != nil {
+		if persistenceErr.ErrorType() == persistence.NotFound {
+			unknownUser.ComparePassword([]byte(password))
+			return nil, ErrUnknownUser
+		}
+		return nil, persistenceErr
+	}
+	if u.ComparePassword([]byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/auth"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test the default authenticator, based
+// on the bcrypt hashes of the users
+func TestLocalAuthenticate(t *testing.T) {
+	// given
+	c := configuration.InitConf()
+	u := model.User{Login: "bob", Role: model.RoleViewer}
+	u.SetPassword([]byte("test_test_test_test"))
+	tests.InsertObject(c, []byte("users"), []byte(u.Login), u)
+	ctx := context.Background()
+	authenticator := auth.GetAuthenticator(c, persistence.GetRepository(c))
+
+	// when
+	user, err := authenticator.Authenticate("bob", "test_test_test_test", ctx)
+	_, wrongErr := authenticator.Authenticate("bob", "wrong_password", ctx)
+	_, unknownErr := authenticator.Authenticate("alice", "test_test_test_test", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if user.Login != "bob" {
+		t.Fatalf("expect to get bob, but got %+v", user)
+	}
+	if wrongErr != auth.ErrInvalidCredentials {
+		t.Fatalf("expect ErrInvalidCredentials with a wrong password, but got %v", wrongErr)
+	}
+	if unknownErr != auth.ErrUnknownUser {
+		t.Fatalf("expect ErrUnknownUser, but got %v", unknownErr)
+	}
+}
@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+/*
+* A backup is a bbolt snapshot, optionally gzipped then
+* encrypted. It starts with a header : the magic, the
+* version of the format and the flags telling how the
+* snapshot is encoded. An encrypted backup then holds the
+* nonce prefix, and chunks of at most chunkSize bytes of
+* data sealed with AES-256-GCM. Each chunk is preceded by
+* its flag (last or not) and its length. The flag is
+* authenticated, so that a truncated backup is detected.
+ */
+
+var magic = []byte("DAHUBAK")
+
+const formatVersion byte = 1
+
+const (
+	compressed byte = 1 << iota
+	encrypted
+)
+
+const (
+	chunkSize       = 64 * 1024
+	noncePrefixSize = 8
+	lastChunk       = 1
+)
+
+var ErrNotABackup = errors.New("backup >> not a Dahu backup, or of an unknown format")
+
+var ErrTruncated = errors.New("backup >> the backup is truncated")
+
+var ErrMissingKey = errors.New("backup >> the backup is encrypted, a key file is required")
+
+// write a consistent snapshot of the repository to w,
+// encoded according to conf
+func Write(ctx context.Context, conf configuration.Backup, repository persistence.Repository, w io.Writer) error {
+	encoder, err := NewWriter(w, conf)
+	if err != nil {
+		return err
+	}
+	persistenceErr := repository.Backup(ctx, encoder)
+	if persistenceErr != nil {
+		return persistenceErr
+	}
+	return encoder.Close()
+}
+
+// write a consistent snapshot of the database of conf to
+// w, without starting the repository. The server must
+// be stopped, see persistence.Snapshot.
+func WriteSnapshot(conf *configuration.Conf, w io.Writer) error {
+	encoder, err := NewWriter(w, conf.BackupConf)
+	if err != nil {
+		return err
+	}
+	err = persistence.Snapshot(conf, encoder)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// replace the database of conf with the backup read from
+// r. The backup is decoded next to the database, then
+// checked and swapped in by persistence.RestoreSnapshot.
+// Return the schema version of the backup.
+func Restore(conf *configuration.Conf, r io.Reader) (int, error) {
+	decoder, err := NewReader(r, conf.BackupConf)
+	if err != nil {
+		return 0, err
+	}
+	err = os.MkdirAll(filepath.Dir(conf.PersistenceConf.Path()), 0700)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(conf.PersistenceConf.Path()), ".dahu-restore-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once moved
+	_, err = io.Copy(tmp, decoder)
+	closeErr := tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+	if closeErr != nil {
+		return 0, closeErr
+	}
+	return persistence.RestoreSnapshot(conf, tmp.Name())
+}
+
+// return a writer encoding the snapshot written in it
+// into w. It must be closed to flush the last bytes.
+func NewWriter(w io.Writer, conf configuration.Backup) (io.WriteCloser, error) {
+	var flags byte
+	var out io.WriteCloser = nopCloser{w}
+	var noncePrefix []byte
+	if conf.KeyFile != "" {
+		aead, err := loadKey(conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		noncePrefix = make([]byte, noncePrefixSize)
+		_, err = rand.Read(noncePrefix)
+		if err != nil {
+			return nil, err
+		}
+		flags |= encrypted
+		out = &encryptingWriter{w: w, aead: aead, noncePrefix: noncePrefix}
+	}
+	if conf.Compress {
+		flags |= compressed
+		out = &gzipWriter{Writer: gzip.NewWriter(out), next: out}
+	}
+	header := append(append(append([]byte{}, magic...), formatVersion, flags), noncePrefix...)
+	_, err := w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// return a reader decoding the backup read from r. The
+// encoding is given by the backup itself, conf is only
+// used for the key of the encrypted backups.
+func NewReader(r io.Reader, conf configuration.Backup) (io.Reader, error) {
+	header := make([]byte, len(magic)+2)
+	_, err := io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrNotABackup
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(magic)], magic) || header[len(magic)] != formatVersion {
+		return nil, ErrNotABackup
+	}
+	flags := header[len(magic)+1]
+	in := r
+	if flags&encrypted != 0 {
+		if conf.KeyFile == "" {
+			return nil, ErrMissingKey
+		}
+		aead, err := loadKey(conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		noncePrefix := make([]byte, noncePrefixSize)
+		_, err = io.ReadFull(r, noncePrefix)
+		if err != nil {
+			return nil, ErrTruncated
+		}
+		in = &decryptingReader{r: bufio.NewReader(r), aead: aead, noncePrefix: noncePrefix}
+	}
+	if flags&compressed != 0 {
+		return gzip.NewReader(in)
+	}
+	return in, nil
+}
+
+// the AES-256 key is the sha256 of the content
+// of the file, a random secret of any length
+func loadKey(keyFile string) (cipher.AEAD, error) {
+	secret, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("backup >> unable to read the key file : %s", err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("backup >> the key file %s is empty", keyFile)
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce of the n-th chunk
+func chunkNonce(prefix []byte, n uint32) []byte {
+	nonce := make([]byte, noncePrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], n)
+	return nonce
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (n nopCloser) Close() error {
+	return nil
+}
+
+// close the gzip stream, then the
+// writer it is written to
+type gzipWriter struct {
+	*gzip.Writer
+	next io.WriteCloser
+}
+
+func (g *gzipWriter) Close() error {
+	err := g.Writer.Close()
+	if err != nil {
+		return err
+	}
+	return g.next.Close()
+}
+
+type encryptingWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	count       uint32
+	buf         []byte
+}
+
+// the data is sealed by chunk. A full chunk is kept
+// until more data comes, the last one being sealed
+// as such by Close.
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > chunkSize {
+		err := e.seal(e.buf[:chunkSize], 0)
+		if err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[chunkSize:]
+	}
+	return len(p), nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(e.buf, lastChunk)
+}
+
+func (e *encryptingWriter) seal(chunk []byte, flag byte) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.noncePrefix, e.count), chunk, []byte{flag})
+	e.count++
+	header := make([]byte, 5)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	_, err := e.w.Write(append(header, sealed...))
+	return err
+}
+
+type decryptingReader struct {
+	r           *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	count       uint32
+	buf         []byte
+	last        bool
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// read and open the next chunk
+func (d *decryptingReader) open() error {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(d.r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	if err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrNotABackup
+	}
+	sealed := make([]byte, length)
+	_, err = io.ReadFull(d.r, sealed)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	if err != nil {
+		return err
+	}
+	d.buf, err = d.aead.Open(nil, chunkNonce(d.noncePrefix, d.count), sealed, header[:1])
+	if err != nil {
+		return errors.New("backup >> unable to decrypt the backup. The key may be wrong, or the backup corrupted")
+	}
+	d.count++
+	d.last = header[0] == lastChunk
+	return nil
+}
@@ -0,0 +1,167 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/backup"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// a configuration whose backups are compressed and
+// encrypted with a key written in dir
+func backupConf(t *testing.T, dir, secret string) *configuration.Conf {
+	conf := configuration.InitConf()
+	conf.BackupConf.Compress = true
+	conf.BackupConf.KeyFile = filepath.Join(dir, secret+".key")
+	err := ioutil.WriteFile(conf.BackupConf.KeyFile, []byte(secret), 0600)
+	if err != nil {
+		t.Fatalf("expect to write the key file, but got %s", err.Error())
+	}
+	return conf
+}
+
+// write a backup of a database holding
+// the job "job", then remove the database
+func writeBackup(t *testing.T, conf *configuration.Conf) []byte {
+	tests.InsertObject(conf, []byte("jobs"), []byte("job"), model.Job{Id: []byte("job"), Name: "job"})
+	rep := persistence.GetRepository(conf)
+	var res bytes.Buffer
+	err := backup.Write(context.Background(), conf.BackupConf, rep, &res)
+	tests.CleanPersistence(conf)
+	if err != nil {
+		t.Fatalf("expect to have no error when writing the backup, but got %s", err.Error())
+	}
+	return res.Bytes()
+}
+
+// test that a compressed and encrypted backup
+// is restored in place of the database
+func TestRestoreShouldReplaceTheDatabase(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-backup-test")
+	defer os.RemoveAll(dir)
+	conf := backupConf(t, dir, "secret")
+	data := writeBackup(t, conf)
+	restoredConf := backupConf(t, dir, "secret")
+	restoredConf.PersistenceConf.Name = filepath.Join(dir, "restored")
+
+	// when
+	version, err := backup.Restore(restoredConf, bytes.NewReader(data))
+	job, jobErr := persistence.GetRepository(restoredConf).GetJob([]byte("job"), context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(restoredConf)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when restoring the backup, but got %s", err.Error())
+	}
+	if version != persistence.LatestSchemaVersion() {
+		t.Fatalf("expect to restore a backup of version %d, but got %d", persistence.LatestSchemaVersion(), version)
+	}
+	if jobErr != nil || job.Name != "job" {
+		t.Fatalf("expect to find the job in the restored database, but got %+v and %+v", job, jobErr)
+	}
+}
+
+// test that a backup is not restored with a wrong key,
+// nor when truncated, and that the database is then kept
+func TestRestoreShouldRejectInvalidBackups(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-backup-test")
+	defer os.RemoveAll(dir)
+	conf := backupConf(t, dir, "secret")
+	data := writeBackup(t, conf)
+	restoredConf := backupConf(t, dir, "other")
+	restoredConf.PersistenceConf.Name = filepath.Join(dir, "restored")
+	ioutil.WriteFile(restoredConf.PersistenceConf.Name, []byte("current"), 0600)
+	truncatedConf := backupConf(t, dir, "secret")
+	truncatedConf.PersistenceConf.Name = restoredConf.PersistenceConf.Name
+
+	// when
+	_, wrongKeyErr := backup.Restore(restoredConf, bytes.NewReader(data))
+	_, truncatedErr := backup.Restore(truncatedConf, bytes.NewReader(data[:len(data)-10]))
+	_, notABackupErr := backup.Restore(truncatedConf, bytes.NewReader([]byte("current")))
+	current, _ := ioutil.ReadFile(restoredConf.PersistenceConf.Name)
+
+	// then
+	if wrongKeyErr == nil {
+		t.Fatal("expect to have an error when restoring with a wrong key, but got nil")
+	}
+	if truncatedErr == nil {
+		t.Fatal("expect to have an error when restoring a truncated backup, but got nil")
+	}
+	if notABackupErr != backup.ErrNotABackup {
+		t.Fatalf("expect to have an ErrNotABackup error, but got %v", notABackupErr)
+	}
+	if string(current) != "current" {
+		t.Fatalf("expect the database to be kept, but got %s", string(current))
+	}
+}
+
+// test that a snapshot written by a more
+// recent version of Dahu is not restored
+func TestRestoreShouldRejectNewerSchemaVersion(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-backup-test")
+	defer os.RemoveAll(dir)
+	conf := configuration.InitConf()
+	conf.PersistenceConf.Name = filepath.Join(dir, "newer")
+	tests.InsertObject(conf, []byte("meta"), []byte("schemaVersion"), persistence.LatestSchemaVersion()+1)
+	var data bytes.Buffer
+	snapshotErr := backup.WriteSnapshot(conf, &data)
+	restoredConf := configuration.InitConf()
+	restoredConf.PersistenceConf.Name = filepath.Join(dir, "restored")
+
+	// when
+	_, err := backup.Restore(restoredConf, &data)
+
+	// then
+	if snapshotErr != nil {
+		t.Fatalf("expect to have no error when writing the snapshot, but got %s", snapshotErr.Error())
+	}
+	if err == nil {
+		t.Fatal("expect to have an error when restoring a newer snapshot, but got nil")
+	}
+}
+
+// test that the scheduled backups beyond
+// the retention are deleted
+func TestWriteScheduledShouldKeepTheRetention(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-backup-test")
+	defer os.RemoveAll(dir)
+	conf := configuration.InitConf()
+	conf.BackupConf.Directory = filepath.Join(dir, "backups")
+	conf.BackupConf.Retention = 2
+	rep := persistence.GetRepository(conf)
+	ctx := context.Background()
+
+	// when
+	first, err := backup.WriteScheduled(ctx, conf.BackupConf, rep)
+	_, secondErr := backup.WriteScheduled(ctx, conf.BackupConf, rep)
+	last, lastErr := backup.WriteScheduled(ctx, conf.BackupConf, rep)
+	files, _ := ioutil.ReadDir(conf.BackupConf.Directory)
+
+	// close and remove the db
+	tests.CleanPersistence(conf)
+
+	// then
+	if err != nil || secondErr != nil || lastErr != nil {
+		t.Fatalf("expect to have no error when writing the backups, but got %+v, %+v and %+v", err, secondErr, lastErr)
+	}
+	if len(files) != 2 || files[1].Name() != filepath.Base(last) {
+		t.Fatalf("expect to keep the 2 last backups, but got %+v", files)
+	}
+	if _, statErr := os.Stat(first); !os.IsNotExist(statErr) {
+		t.Fatalf("expect the first backup to be deleted, but got %v", statErr)
+	}
+}
@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+const (
+	scheduledPrefix = "dahu-"
+	scheduledSuffix = ".backup"
+)
+
+// write a backup every conf.BackupConf.Interval into
+// conf.BackupConf.Directory, until conf.Close is closed
+func Schedule(conf *configuration.Conf, repository persistence.Repository) {
+	ticker := time.NewTicker(conf.BackupConf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conf.Close:
+			return
+		case <-ticker.C:
+			path, err := WriteScheduled(context.Background(), conf.BackupConf, repository)
+			if err != nil {
+				log.Printf("ERROR >> scheduled backup encounter error : %s", err.Error())
+			} else {
+				log.Printf("INFO >> backup written to %s", path)
+			}
+		}
+	}
+}
+
+// write a backup into conf.Directory, named after the current
+// time, then delete the oldest ones beyond conf.Retention.
+// Return the path of the new backup.
+func WriteScheduled(ctx context.Context, conf configuration.Backup, repository persistence.Repository) (string, error) {
+	err := os.MkdirAll(conf.Directory, 0700)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%s%s", scheduledPrefix, time.Now().UTC().Format("20060102T150405.000000000Z"), scheduledSuffix)
+	path := filepath.Join(conf.Directory, name)
+	err = WriteFile(ctx, conf, repository, path)
+	if err != nil {
+		return "", err
+	}
+	return path, prune(conf.Directory, conf.Retention)
+}
+
+// write a backup of the repository into the file path. The
+// backup is written beside then renamed, so that a file
+// with this name is always a complete backup.
+func WriteFile(ctx context.Context, conf configuration.Backup, repository persistence.Repository, path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".dahu-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	err = Write(ctx, conf, repository, tmp)
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// delete the oldest scheduled backups of dir, so that
+// only retention are kept. All are kept when retention <= 0
+func prune(dir string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backups := make([]string, 0)
+	for _, file := range files {
+		if !file.IsDir() && strings.HasPrefix(file.Name(), scheduledPrefix) && strings.HasSuffix(file.Name(), scheduledSuffix) {
+			backups = append(backups, file.Name())
+		}
+	}
+	// the names hold the time of the backup
+	sort.Strings(backups)
+	for len(backups) > retention {
+		err = os.Remove(filepath.Join(dir, backups[0]))
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
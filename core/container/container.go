@@ -26,6 +26,31 @@ type Port struct {
 	Protocol string
 }
 
+// File is copied inside a container
+// before it starts
+type File struct {
+	Path    string // absolute path of the file inside the container
+	Content []byte
+	Mode    int64
+}
+
+// output stream of a container
+type Stream int
+
+// values are the ones used by
+// docker in multiplexed logs
+const (
+	Stdout Stream = 1
+	Stderr Stream = 2
+)
+
+// StreamWriter may be implemented by the writers
+// given to FollowLogs. In that case, logs are written
+// with the stream (stdout or stderr) they come from.
+type StreamWriter interface {
+	WriteStream(stream Stream, p []byte) (n int, err error)
+}
+
 type ContainerEnvs map[string]string
 
 func (e ContainerEnvs) ToArray() []string {
@@ -46,6 +71,7 @@ type ContainerStartConf struct {
 	Envs          ContainerEnvs
 	ExposedPorts  []Port
 	Mounts        []Mount
+	Files         []File
 	WorkingDir    string
 	WaitFn        func(ip string) error
 	NetworkId     string
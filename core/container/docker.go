@@ -1,12 +1,15 @@
 package container
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/docker/docker/api/types"
@@ -88,6 +91,14 @@ func (d dockerClient) StartContainer(ctx context.Context, conf ContainerStartCon
 		}
 	}
 
+	// files must be there before the start
+	for _, file := range conf.Files {
+		err = copyFile(ctx, cli, createdContainer.ID, file)
+		if err != nil {
+			return instance, fromDockerToContainerError(err)
+		}
+	}
+
 	// Now the container will start
 	err = cli.ContainerStart(ctx, createdContainer.ID, types.ContainerStartOptions{})
 	if err != nil {
@@ -213,7 +224,7 @@ func (d dockerClient) FollowLogs(ctx context.Context, containerId string, logWri
 		ShowStdout: true,
 		Timestamps: false,
 		Follow:     true,
-		Tail:       "40",
+		Tail:       "all",
 	})
 	if err != nil {
 		log.Printf("ERROR >> FollowLogs encounter error : %s", err.Error())
@@ -226,9 +237,12 @@ func (d dockerClient) FollowLogs(ctx context.Context, containerId string, logWri
 
 // todo use a context for timeout ?
 func (d dockerClient) doFollowLogs(logChan chan interface{}, in io.ReadCloser, logWriter io.Writer) {
+	streamWriter, withStream := logWriter.(StreamWriter)
+	// the logs are multiplexed. Each frame has a 8 bytes header :
+	// the first byte is the stream, the last four the size of the frame.
 	hdr := make([]byte, 8)
 	for {
-		_, err := in.Read(hdr)
+		_, err := io.ReadFull(in, hdr)
 		if err != nil {
 			close(logChan)
 			in.Close()
@@ -239,7 +253,7 @@ func (d dockerClient) doFollowLogs(logChan chan interface{}, in io.ReadCloser, l
 		}
 		count := binary.BigEndian.Uint32(hdr[4:])
 		logs := make([]byte, count)
-		_, err = in.Read(logs)
+		_, err = io.ReadFull(in, logs)
 		if err != nil {
 			close(logChan)
 			in.Close()
@@ -248,7 +262,11 @@ func (d dockerClient) doFollowLogs(logChan chan interface{}, in io.ReadCloser, l
 			}
 			return
 		}
-		logWriter.Write(logs) // todo handle error
+		if withStream {
+			streamWriter.WriteStream(Stream(hdr[0]), logs) // todo handle error
+		} else {
+			logWriter.Write(logs) // todo handle error
+		}
 	}
 }
 
@@ -265,6 +283,30 @@ func pullImage(ctx context.Context, conf ContainerStartConf, cli *client.Client)
 	return out.Close()
 }
 
+// copyFile put the given file inside a created container. The docker
+// api only accept tar archives, so a single entry archive is built.
+func copyFile(ctx context.Context, cli *client.Client, containerId string, file File) error {
+	mode := file.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	err := tw.WriteHeader(&tar.Header{Name: path.Base(file.Path), Mode: mode, Size: int64(len(file.Content))})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(file.Content)
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return cli.CopyToContainer(ctx, containerId, path.Dir(file.Path), &archive, types.CopyToContainerOptions{})
+}
+
 type empty struct{}
 
 func createPortsConf(exposedPorts []Port) (nat.PortSet, ContainerError) {
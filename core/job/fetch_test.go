@@ -11,6 +11,8 @@ import (
 	"github.com/jeromedoucet/dahu-tests/ssh"
 	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
 )
 
 var gitRepoIp string
@@ -33,9 +35,12 @@ func TestFetchSourcesWithKeyAuth(t *testing.T) {
 	authConfig := model.SshAuthConfig{Url: fmt.Sprintf("ssh://git@%s/tester/test-repo.git", gitRepoIp), Key: ssh.PrivateProtected, KeyPassword: "tester"}
 	gitConfig := model.GitConfig{SshAuth: &authConfig}
 	jobExecution := model.JobExecution{BranchName: "master", Id: "1"}
-	job := model.Job{GitConf: gitConfig, Name: "test"}
+	job := model.Job{Id: []byte("job"), GitConf: gitConfig, Name: "test"}
 	gitVolumeName := fmt.Sprintf("%s-%s-sources", job.Name, "1")
-	exec := execution{ctx: context.Background(), jobExecution: jobExecution, job: job, sourcesVolume: gitVolumeName}
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	exec := execution{ctx: context.Background(), jobExecution: jobExecution, job: job, sourcesVolume: gitVolumeName, conf: conf, repository: repository}
 	fetchExecution := &model.StepExecution{Name: "Code fetching", Status: model.Running}
 
 	// when
@@ -49,8 +54,19 @@ func TestFetchSourcesWithKeyAuth(t *testing.T) {
 		t.Fatalf("expect the volume %s to exist, but it doesn't", gitVolumeName)
 	}
 	container.CleanVolume(gitVolumeName, dockerApiVersion)
-	if !strings.Contains(fetchExecution.Logs, "Clone finished without error") {
-		t.Fatalf("expect the logs of the clone to contains 'Clone finished without error', but got %s", fetchExecution.Logs)
+	lines, err := repository.GetLogs(context.Background(), string(job.Id), jobExecution.Id, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching logs, but got %s", err.Error())
+	}
+	var logs []string
+	for _, line := range lines {
+		logs = append(logs, line.Content)
+	}
+	if !strings.Contains(strings.Join(logs, "\n"), "Clone finished without error") {
+		t.Fatalf("expect the logs of the clone to contains 'Clone finished without error', but got %s", logs)
+	}
+	if fetchExecution.LogLines != len(lines) {
+		t.Fatalf("expect the step execution to count %d lines, but got %d", len(lines), fetchExecution.LogLines)
 	}
 }
 
@@ -61,9 +77,12 @@ func TestFetchSourcesWithBadAuth(t *testing.T) {
 	authConfig := model.SshAuthConfig{Url: fmt.Sprintf("ssh://git@%s/tester/test-repo.git", gitRepoIp)}
 	gitConfig := model.GitConfig{SshAuth: &authConfig}
 	jobExecution := model.JobExecution{BranchName: "master", Id: "1"}
-	job := model.Job{GitConf: gitConfig, Name: "test"}
+	job := model.Job{Id: []byte("job"), GitConf: gitConfig, Name: "test"}
 	gitVolumeName := fmt.Sprintf("%s-%s-sources", job.Name, "1")
-	exec := execution{ctx: context.Background(), jobExecution: jobExecution, job: job, sourcesVolume: gitVolumeName}
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	exec := execution{ctx: context.Background(), jobExecution: jobExecution, job: job, sourcesVolume: gitVolumeName, conf: conf, repository: repository}
 	fetchExecution := &model.StepExecution{Name: "Code fetching", Status: model.Running}
 
 	// when
@@ -0,0 +1,107 @@
+package job
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/container"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// the janitor purges the executions that are not kept by the retention policy
+// of their job, or by the global one. The logs of these executions are deleted
+// with them, and so are their workspace volumes, kept when the job doesn't remove
+// its workspace. Dahu doesn't store any other artifact of the executions.
+
+// the purged executions are remembered, to reject their late updates, as
+// long as the longest retention window, and at least for this duration
+const minPurgedExecutionsMemory = 24 * time.Hour
+
+// purge the executions every conf.RetentionConf.JanitorInterval,
+// until conf.Close is closed
+func Janitor(conf *configuration.Conf, repository persistence.Repository) {
+	ticker := time.NewTicker(conf.RetentionConf.JanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conf.Close:
+			return
+		case <-ticker.C:
+			purged, err := Purge(context.Background(), conf.RetentionConf, repository)
+			if err != nil {
+				log.Printf("ERROR >> Janitor encounter error : %s", err.Error())
+			}
+			if purged > 0 {
+				log.Printf("INFO >> %d execution(s) purged", purged)
+			}
+		}
+	}
+}
+
+// delete the expired executions of every job, and
+// return the number of purged executions. The executions
+// purged before the longest retention window are forgotten
+func Purge(ctx context.Context, conf configuration.Retention, repository persistence.Repository) (int, error) {
+	global := model.RetentionPolicy{KeepLast: conf.KeepLast, KeepDays: conf.KeepDays, KeepLastSuccess: conf.KeepLastSuccess}
+	jobs, err := repository.GetJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	now := time.Now()
+	memory := retentionWindow(global)
+	for _, job := range jobs {
+		policy := global
+		if job.Retention != nil {
+			policy = *job.Retention
+		}
+		if window := retentionWindow(policy); window > memory {
+			memory = window
+		}
+		executions, err := repository.GetJobExecutions(ctx, string(job.Id))
+		if err != nil {
+			return purged, err
+		}
+		expired := policy.Expired(executions, now)
+		if len(expired) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(expired))
+		for _, execution := range expired {
+			ids = append(ids, execution.Id)
+			if execution.VolumeName != "" {
+				// the volume may have been removed with
+				// the workspace, at the end of the execution
+				volumeErr := container.DockerClient.RemoveVolume(ctx, execution.VolumeName)
+				if volumeErr != nil {
+					log.Printf("WARN >> unable to remove the volume %s of a purged execution : %s", execution.VolumeName, volumeErr.Error())
+				}
+			}
+		}
+		err = repository.DeleteJobExecutions(ctx, string(job.Id), ids)
+		if err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+	}
+	if memory < minPurgedExecutionsMemory {
+		memory = minPurgedExecutionsMemory
+	}
+	forgotten, err := repository.ForgetPurgedExecutions(ctx, now.Add(-memory))
+	if err != nil {
+		return purged, err
+	}
+	if forgotten > 0 {
+		log.Printf("INFO >> %d purged execution(s) forgotten", forgotten)
+	}
+	return purged, nil
+}
+
+// duration during which the
+// policy keeps the executions
+func retentionWindow(policy model.RetentionPolicy) time.Duration {
+	return time.Duration(policy.KeepDays) * 24 * time.Hour
+}
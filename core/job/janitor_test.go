@@ -0,0 +1,70 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/container"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// record the removed volumes
+type volumeRemovalMock struct {
+	container.ContainerClient
+	removed []string
+}
+
+func (m *volumeRemovalMock) RemoveVolume(ctx context.Context, volumeName string) container.ContainerError {
+	m.removed = append(m.removed, volumeName)
+	return nil
+}
+
+// test that the policy of the job is used when
+// it has one, and the global one otherwise
+func TestPurgeShouldApplyTheRetentionPolicies(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	conf.RetentionConf.KeepDays = 1
+	conf.RetentionConf.KeepLastSuccess = false
+	tests.InsertObject(conf, []byte("jobs"), []byte("own"), model.Job{Id: []byte("own"), Name: "own", Retention: &model.RetentionPolicy{KeepLast: 1}})
+	tests.InsertObject(conf, []byte("jobs"), []byte("global"), model.Job{Id: []byte("global"), Name: "global"})
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	ctx := context.Background()
+	now := time.Now()
+	for _, jobId := range []string{"own", "global"} {
+		repository.UpsertJobExecution(ctx, jobId, &model.JobExecution{Id: "old", Date: now.AddDate(0, 0, -2), Status: model.Success, VolumeName: jobId + "-old"})
+		repository.UpsertJobExecution(ctx, jobId, &model.JobExecution{Id: "recent", Date: now.Add(-time.Hour), Status: model.Success})
+		repository.UpsertJobExecution(ctx, jobId, &model.JobExecution{Id: "last", Date: now, Status: model.Success})
+	}
+	mock := &volumeRemovalMock{}
+	previousClient := container.DockerClient
+	container.DockerClient = mock
+	defer func() { container.DockerClient = previousClient }()
+
+	// when
+	purged, err := Purge(ctx, conf.RetentionConf, repository)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when purging, but got %s", err.Error())
+	}
+	if purged != 3 {
+		t.Fatalf("expect 3 executions to be purged, but got %d", purged)
+	}
+	own, _ := repository.GetJobExecutions(ctx, "own")
+	if len(own) != 1 || own[0].Id != "last" {
+		t.Fatalf("expect to keep the last execution of the job own, but got %+v", own)
+	}
+	global, _ := repository.GetJobExecutions(ctx, "global")
+	if len(global) != 2 || global[0].Id != "recent" || global[1].Id != "last" {
+		t.Fatalf("expect to keep the executions of the last day of the job global, but got %+v", global)
+	}
+	if len(mock.removed) != 2 {
+		t.Fatalf("expect the volumes of the purged executions to be removed, but got %+v", mock.removed)
+	}
+}
@@ -29,39 +29,59 @@
 //
 // - Notifications
 // A websocket channel is available to listen for job executions's events. An event may be a job start, a job stop, a step start, a step stop or a log event.
+// Once the outcome of an execution is known, the notification package is used to warn people when the job breaks or is fixed.
+// Jobs may also define notifier containers. They are run at the very end of the execution, with its summary as env variables
+// and as a json file. Their failure is logged but doesn't change the outcome of the execution.
 
 package job
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/container"
 	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/notification"
 	"github.com/jeromedoucet/dahu/core/persistence"
 	"github.com/jeromedoucet/dahu/core/scm"
 )
 
-// Start launch a new job execution. It runs in a dedicated goroutine.
-func Start(job model.Job, branchName string, conf *configuration.Conf, ctx context.Context) model.JobExecution {
-	jobExecution := model.JobExecution{BranchName: branchName}
-	jobExecution.GenerateId()
+// Start launch a new job execution. It runs in a dedicated goroutine,
+// once the execution has been persisted. commitSha is optional. When
+// empty, the built commit is resolved once the sources are fetched.
+func Start(job model.Job, branchName, commitSha string, conf *configuration.Conf, ctx context.Context) (model.JobExecution, persistence.PersistenceError) {
+	jobExecution := model.JobExecution{BranchName: branchName, CommitSha: commitSha, Date: time.Now(), Status: model.Running}
+	repository := persistence.GetRepository(conf)
+	_, persistenceErr := repository.CreateJobExecution(ctx, string(job.Id), &jobExecution)
+	if persistenceErr != nil {
+		return jobExecution, persistenceErr
+	}
+	// the volume of the sources is named after the id, and recorded
+	// so that the janitor removes it with the execution
+	jobExecution.VolumeName = fmt.Sprintf("%s-%s-sources", job.Name, jobExecution.Id)
+	_, persistenceErr = repository.UpsertJobExecution(ctx, string(job.Id), &jobExecution)
+	if persistenceErr != nil {
+		return jobExecution, persistenceErr
+	}
 	e := execution{
 		job:           job,
 		jobExecution:  jobExecution,
 		ctx:           ctx,
-		sourcesVolume: fmt.Sprintf("%s-%s-sources", job.Name, jobExecution.Id),
+		sourcesVolume: jobExecution.VolumeName,
 		conf:          conf,
-		repository:    persistence.GetRepository(conf),
+		repository:    repository,
 	}
 	go e.run()
-	return jobExecution
+	return jobExecution, nil
 }
 
 // Inner type that contains all informations
@@ -106,6 +126,8 @@ func (e execution) run() {
 
 	e.networkId = networkId
 
+	e.postCommitStatus()
+
 	fetchExecution := &model.StepExecution{Name: "Code fetching", Status: model.Running}
 	e.jobExecution.Steps = append(e.jobExecution.Steps, fetchExecution)
 
@@ -114,13 +136,19 @@ func (e execution) run() {
 	e.repository.UpsertJobExecution(e.ctx, string(e.job.Id), &e.jobExecution)
 
 	if fetchExecution.Status == model.Success {
+		// the sha is only needed by the forge, resolving it
+		// costs a container
+		if e.jobExecution.CommitSha == "" && notification.CommitStatusEnabled(e.conf, e.job) {
+			e.resolveCommit()
+			e.postCommitStatus()
+		}
 		for _, step := range e.job.Steps {
 
 			stepExecution := &model.StepExecution{Name: step.Name, Status: model.Running}
 			e.jobExecution.Steps = append(e.jobExecution.Steps, stepExecution)
 
 			e.repository.UpsertJobExecution(e.ctx, string(e.job.Id), &e.jobExecution)
-			e.executeStep(&step, stepExecution)
+			e.executeStep(&step, stepExecution, len(e.jobExecution.Steps)-1)
 			e.repository.UpsertJobExecution(e.ctx, string(e.job.Id), &e.jobExecution)
 
 			if stepExecution.Status == model.Failure || stepExecution.Status == model.Canceled {
@@ -168,14 +196,181 @@ func (e execution) endJob(terminationStatus model.ExecutionStatus) {
 	// Don't forget that. This is permit to clean references
 	// in the job execution scheduler.
 	unRegisterJobExecution(string(e.job.Id), e.jobExecution.Id)
+	e.jobExecution.Status = terminationStatus
+	e.jobExecution.Duration = time.Since(e.jobExecution.Date)
 	e.repository.UpsertJobExecution(e.ctx, string(e.job.Id), &e.jobExecution)
 
+	e.postCommitStatus()
+	e.notify()
+	e.runNotifiers()
+
 	// at the end, the network should be remove
 	containerCli.DeleteNetwork(e.ctx, e.networkId)
 }
 
+// notify warn the outside world of the execution outcome
+// when it is a meaningful transition for the job.
+func (e execution) notify() {
+	previous, err := e.previousExecution()
+	if err != nil {
+		log.Printf("ERROR >> notify encounter error : %s", err.Error())
+		return
+	}
+	var failedStepLogs []model.LogLine
+	for index, step := range e.jobExecution.Steps {
+		if step.Status == model.Failure {
+			// only the tail is needed
+			from := step.LogLines - e.conf.SmtpConf.LogTailSize + 1
+			failedStepLogs, err = e.repository.GetLogs(e.ctx, string(e.job.Id), e.jobExecution.Id, index, from, 0)
+			if err != nil {
+				log.Printf("ERROR >> notify encounter error : %s", err.Error())
+			}
+			break
+		}
+	}
+	err = notification.NotifyByMail(e.conf, e.job, previous, &e.jobExecution, failedStepLogs)
+	if err != nil {
+		log.Printf("ERROR >> notify encounter error when sending mail : %s", err.Error())
+	}
+}
+
+// previousExecution return the last finished execution of the job
+// on the same branch before the current one, or nil if there is none.
+// The other branches don't tell whether this one is broken or fixed.
+func (e execution) previousExecution() (*model.JobExecution, error) {
+	executions, err := e.repository.GetJobExecutions(e.ctx, string(e.job.Id))
+	if err != nil {
+		return nil, err
+	}
+	var previous *model.JobExecution
+	for _, execution := range executions {
+		if execution.Id != e.jobExecution.Id && execution.BranchName == e.jobExecution.BranchName &&
+			execution.IsFinished() && execution.Date.Before(e.jobExecution.Date) {
+			previous = execution
+		}
+	}
+	return previous, nil
+}
+
+// postCommitStatus report the current status of the execution
+// to the forge hosting the sources. A failure is only logged,
+// it must never break the build.
+func (e execution) postCommitStatus() {
+	err := notification.PostCommitStatus(e.ctx, e.conf, e.job, &e.jobExecution)
+	if err != nil {
+		log.Printf("ERROR >> postCommitStatus encounter error : %s", err.Error())
+		Broadcast(string(e.job.Id), model.Event{
+			Type:        model.NewLog,
+			ExecutionId: e.jobExecution.Id,
+			Value:       fmt.Sprintf("Error when posting the commit status : %s", err.Error()),
+		})
+	}
+}
+
+// resolveCommit find the sha of the fetched commit by
+// running git inside a container with the sources mounted.
+func (e *execution) resolveCommit() {
+	dockerCli := container.DockerClient
+	gitConf := container.ContainerStartConf{
+		ImageName:  "alpine/git",
+		Command:    []string{"rev-parse", "HEAD"},
+		Mounts:     []container.Mount{container.Mount{Source: e.sourcesVolume, Destination: "/git"}},
+		WorkingDir: "/git",
+	}
+	c, err := dockerCli.StartContainer(e.ctx, gitConf)
+	if err != nil {
+		log.Printf("ERROR >> resolveCommit encounter error : %s", err.Error())
+		return
+	}
+	removeOptions := container.ContainerRemoveOptions{Force: true, RemoveVolumes: true}
+	defer dockerCli.RemoveContainer(e.ctx, c.Id, removeOptions)
+
+	var out bytes.Buffer
+	err, waitLog := dockerCli.FollowLogs(e.ctx, c.Id, &out)
+	if err != nil {
+		log.Printf("ERROR >> resolveCommit encounter error : %s", err.Error())
+		return
+	}
+	containerResult := c.WaitForStop(e.cancelChan)
+	<-waitLog
+	if containerResult.Status != container.Success {
+		log.Printf("ERROR >> resolveCommit encounter error : %s", containerResult.ErrMsg)
+		return
+	}
+	e.jobExecution.CommitSha = strings.TrimSpace(out.String())
+}
+
+// runNotifiers start the notifier containers of the job,
+// one after the other, and wait for their end.
+func (e execution) runNotifiers() {
+	if len(e.job.Notifiers) == 0 {
+		return
+	}
+	summary := notification.NewSummary(e.conf, e.job, &e.jobExecution)
+	summaryFile, err := json.Marshal(summary)
+	if err != nil {
+		log.Printf("ERROR >> runNotifiers encounter error : %s", err.Error())
+		return
+	}
+	for _, notifier := range e.job.Notifiers {
+		err = e.runNotifier(notifier, summary, summaryFile)
+		if err != nil {
+			log.Printf("ERROR >> runNotifiers encounter error with notifier %s : %s", notifier.Name, err.Error())
+			Broadcast(string(e.job.Id), model.Event{
+				Type:        model.NewLog,
+				ExecutionId: e.jobExecution.Id,
+				Value:       fmt.Sprintf("Notifier %s has failed : %s", notifier.Name, err.Error()),
+			})
+		}
+	}
+}
+
+func (e execution) runNotifier(notifier model.Notifier, summary notification.Summary, summaryFile []byte) error {
+	envs := make(map[string]string)
+	for key, value := range notifier.Envs {
+		envs[key] = value
+	}
+	// the summary can't be overridden
+	for key, value := range summary.Envs() {
+		envs[key] = value
+	}
+	dockerCli := container.DockerClient
+	notifierConf := container.ContainerStartConf{
+		ImageName:     notifier.Image.ComputeName(),
+		RegistryToken: getRegistryAuth(notifier.Image),
+		Command:       notifier.Command,
+		Envs:          envs,
+		Files:         []container.File{container.File{Path: notification.SummaryFilePath, Content: summaryFile}},
+	}
+	c, err := dockerCli.StartContainer(e.ctx, notifierConf)
+	if err != nil {
+		return err
+	}
+	removeOptions := container.ContainerRemoveOptions{Force: true, RemoveVolumes: true}
+	defer dockerCli.RemoveContainer(e.ctx, c.Id, removeOptions)
+
+	// notifiers logs are not part of the execution. They
+	// are only broadcasted.
+	w := &broadcastWriter{
+		jobId:       string(e.job.Id),
+		executionId: e.jobExecution.Id,
+	}
+	err, _ = dockerCli.FollowLogs(e.ctx, c.Id, w)
+	if err != nil {
+		return err
+	}
+	// the execution is already unregistered from the
+	// scheduler, so a notifier can't be canceled.
+	containerResult := c.WaitForStop(make(chan interface{}))
+	if containerResult.Status != container.Success {
+		return errors.New(containerResult.ErrMsg)
+	}
+	return nil
+}
+
 // fetchSources is the first step of a job execution. Like
-// its mame suggests, it will get the sources.
+// its mame suggests, it will get the sources. Its logs are
+// the ones of the step 0.
 func (e execution) fetchSources(stepExecution *model.StepExecution) {
 
 	Broadcast(string(e.job.Id), model.Event{
@@ -188,10 +383,13 @@ func (e execution) fetchSources(stepExecution *model.StepExecution) {
 
 	containerCli.CreateVolume(e.ctx, e.sourcesVolume) // TODO handle error
 
-	w := &logWriter{
-		jobId:       string(e.job.Id),
-		executionId: e.jobExecution.Id,
-	}
+	start := time.Now()
+	w := e.newLogWriter(0)
+	defer func() {
+		w.Close()
+		stepExecution.LogLines = w.Count()
+		stepExecution.Duration = time.Since(start)
+	}()
 
 	cloneConf := scm.CloneConfiguration{
 		GitConfig:  e.job.GitConf,
@@ -211,6 +409,7 @@ func (e execution) fetchSources(stepExecution *model.StepExecution) {
 		})
 	} else {
 		log.Printf("Job >> issue when fetching sources %s", err.Error())
+		w.writeError(err.Error())
 		stepExecution.Status = model.Failure
 		Broadcast(string(e.job.Id), model.Event{
 			Type:        model.StepFailed,
@@ -218,17 +417,25 @@ func (e execution) fetchSources(stepExecution *model.StepExecution) {
 			Value:       "Failed fetching code",
 		})
 	}
-	stepExecution.Logs = string(w.logs)
 }
 
 // executeStep is responsible for preparing a step, run it, notifying
-// events.
+// events. index is the position of the step in the execution, used
+// to store its logs. The duration of the step is set once it is over.
 // It heavily rely on container package, that abstract container manipulations.
-func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecution) {
+func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecution, index int) {
 	var c container.ContainerInstance
 	var err error
 	var services []*container.ContainerInstance
 
+	start := time.Now()
+	w := e.newLogWriter(index)
+	defer func() {
+		w.Close()
+		stepExecution.LogLines = w.Count()
+		stepExecution.Duration = time.Since(start)
+	}()
+
 	Broadcast(string(e.job.Id), model.Event{
 		Type:        model.StepStart,
 		ExecutionId: e.jobExecution.Id,
@@ -245,7 +452,7 @@ func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecut
 			Value:       fmt.Sprintf("%s has failed : %s", step.Name, err.Error()),
 		})
 		stepExecution.Status = model.Failure
-		stepExecution.Logs = err.Error()
+		w.writeError(err.Error())
 		return
 	}
 
@@ -272,16 +479,12 @@ func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecut
 			Value:       fmt.Sprintf("%s has failed : %s", step.Name, err.Error()),
 		})
 		stepExecution.Status = model.Failure
-		stepExecution.Logs = err.Error()
+		w.writeError(err.Error())
 		return
 	}
 
-	w := &logWriter{
-		jobId:       string(e.job.Id),
-		executionId: e.jobExecution.Id,
-	}
-
-	err, _ = dockerCli.FollowLogs(e.ctx, c.Id, w)
+	var waitLog chan interface{}
+	err, waitLog = dockerCli.FollowLogs(e.ctx, c.Id, w)
 
 	if err != nil {
 		Broadcast(string(e.job.Id), model.Event{
@@ -290,15 +493,20 @@ func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecut
 			Value:       fmt.Sprintf("%s failed : %s", step.Name, err.Error()),
 		})
 		stepExecution.Status = model.Failure
-		stepExecution.Logs = err.Error()
+		w.writeError(err.Error())
 		return
 	}
 
 	containerResult := c.WaitForStop(e.cancelChan)
 
+	// the logs are complete only once
+	// the container is gone
+	removeOptions := container.ContainerRemoveOptions{Force: true, RemoveVolumes: true}
+	dockerCli.RemoveContainer(e.ctx, c.Id, removeOptions)
+	<-waitLog
+
 	if containerResult.Status == container.Success {
 		stepExecution.Status = model.Success
-		stepExecution.Logs = string(w.logs)
 		Broadcast(string(e.job.Id), model.Event{
 			Type:        model.StepSucceed,
 			ExecutionId: e.jobExecution.Id,
@@ -306,7 +514,6 @@ func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecut
 		})
 	} else if containerResult.Status == container.Error {
 		stepExecution.Status = model.Failure
-		stepExecution.Logs = string(w.logs)
 		Broadcast(string(e.job.Id), model.Event{
 			Type:        model.StepFailed,
 			ExecutionId: e.jobExecution.Id,
@@ -314,17 +521,12 @@ func (e execution) executeStep(step *model.Step, stepExecution *model.StepExecut
 		})
 	} else {
 		stepExecution.Status = model.Canceled
-		stepExecution.Logs = string(w.logs)
 		Broadcast(string(e.job.Id), model.Event{
 			Type:        model.StepCanceled,
 			ExecutionId: e.jobExecution.Id,
 			Value:       fmt.Sprintf("Finished %s", step.Name),
 		})
 	}
-
-	removeOptions := container.ContainerRemoveOptions{Force: true, RemoveVolumes: true}
-
-	dockerCli.RemoveContainer(e.ctx, c.Id, removeOptions)
 }
 
 // startServices launch all services registered for a
@@ -386,22 +588,3 @@ func getRegistryAuth(image model.Image) string {
 		return ""
 	}
 }
-
-type logWriter struct {
-	jobId       string
-	executionId string
-	logs        []byte
-}
-
-func (l *logWriter) Write(p []byte) (n int, err error) {
-	if len(p) > 0 {
-		Broadcast(string(l.jobId), model.Event{
-			Type:        model.NewLog,
-			ExecutionId: l.executionId,
-			Value:       strings.TrimSpace(string(p)),
-		})
-
-		l.logs = append(l.logs, p...)
-	}
-	return len(p), nil
-}
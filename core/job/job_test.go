@@ -0,0 +1,159 @@
+package job
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/container"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that the previous execution is the last
+// finished one on the branch of the current one
+func TestPreviousExecutionShouldBeOnTheSameBranch(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	ctx := context.Background()
+	now := time.Now()
+	repository.UpsertJobExecution(ctx, "job", &model.JobExecution{Id: "master", BranchName: "master", Date: now.Add(-3 * time.Hour), Status: model.Success})
+	repository.UpsertJobExecution(ctx, "job", &model.JobExecution{Id: "feature", BranchName: "feature", Date: now.Add(-2 * time.Hour), Status: model.Failure})
+	repository.UpsertJobExecution(ctx, "job", &model.JobExecution{Id: "running", BranchName: "master", Date: now.Add(-time.Hour), Status: model.Running})
+	current := model.JobExecution{Id: "current", BranchName: "master", Date: now, Status: model.Failure}
+	repository.UpsertJobExecution(ctx, "job", &current)
+	exec := execution{ctx: ctx, jobExecution: current, job: model.Job{Id: []byte("job"), Name: "test"}, conf: conf, repository: repository}
+
+	// when
+	previous, err := exec.previousExecution()
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if previous == nil || previous.Id != "master" {
+		t.Fatalf("expect the previous execution to be the one of master, but got %+v", previous)
+	}
+}
+
+// run a container lasting for duration
+type stepContainerMock struct {
+	container.ContainerClient
+	duration time.Duration
+}
+
+func (m *stepContainerMock) StartContainer(ctx context.Context, conf container.ContainerStartConf) (container.ContainerInstance, container.ContainerError) {
+	return container.ContainerInstance{Id: "step", WaitForStop: func(cancel chan interface{}) container.ContainerResult {
+		time.Sleep(m.duration)
+		return container.ContainerResult{Status: container.Success}
+	}}, nil
+}
+
+func (m *stepContainerMock) FollowLogs(ctx context.Context, containerId string, logWriter io.Writer) (container.ContainerError, chan interface{}) {
+	done := make(chan interface{})
+	close(done)
+	return nil, done
+}
+
+func (m *stepContainerMock) RemoveContainer(ctx context.Context, id string, options container.ContainerRemoveOptions) container.ContainerError {
+	return nil
+}
+
+// test that the duration of a step is
+// set once its container is over
+func TestExecuteStepShouldSetTheDurationOfTheStep(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	mock := &stepContainerMock{duration: 20 * time.Millisecond}
+	previousClient := container.DockerClient
+	container.DockerClient = mock
+	defer func() { container.DockerClient = previousClient }()
+	exec := execution{ctx: context.Background(), jobExecution: model.JobExecution{Id: "1"}, job: model.Job{Id: []byte("job"), Name: "test"}, conf: conf, repository: repository}
+	stepExecution := &model.StepExecution{Name: "build", Status: model.Running}
+
+	// when
+	exec.executeStep(&model.Step{Name: "build"}, stepExecution, 1)
+
+	// then
+	if stepExecution.Status != model.Success {
+		t.Fatalf("expect the step to succeed, but got %s", stepExecution.Status)
+	}
+	if stepExecution.Duration < mock.duration {
+		t.Fatalf("expect the step to last at least %s, but got %s", mock.duration, stepExecution.Duration)
+	}
+}
+
+// fail to create the network of the executions,
+// ending them as soon as they are started
+type networkFailureMock struct {
+	container.ContainerClient
+	ended   chan interface{}
+	removed []string
+}
+
+type networkError string
+
+func (e networkError) Error() string {
+	return string(e)
+}
+
+func (e networkError) ErrorType() container.ContainerErrorType {
+	return container.OtherError
+}
+
+func (m *networkFailureMock) CreateNetwork(ctx context.Context, name string) (container.ContainerError, string) {
+	return networkError("no network"), ""
+}
+
+func (m *networkFailureMock) DeleteNetwork(ctx context.Context, id string) container.ContainerError {
+	m.ended <- nil
+	return nil
+}
+
+func (m *networkFailureMock) RemoveVolume(ctx context.Context, volumeName string) container.ContainerError {
+	m.removed = append(m.removed, volumeName)
+	return nil
+}
+
+// test that the volume of the sources is recorded
+// with the execution, and removed when it is purged
+func TestStartShouldRecordTheVolumeOfTheSources(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	job := model.Job{Id: []byte("job"), Name: "test", Retention: &model.RetentionPolicy{KeepLast: 1}}
+	tests.InsertObject(conf, []byte("jobs"), job.Id, job)
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	ctx := context.Background()
+	mock := &networkFailureMock{ended: make(chan interface{})}
+	previousClient := container.DockerClient
+	container.DockerClient = mock
+	defer func() { container.DockerClient = previousClient }()
+
+	// when
+	first, firstErr := Start(job, "master", "", conf, ctx)
+	<-mock.ended
+	stored, storedErr := repository.GetJobExecution(ctx, "job", first.Id)
+	_, secondErr := Start(job, "master", "", conf, ctx)
+	<-mock.ended
+	purged, purgeErr := Purge(ctx, conf.RetentionConf, repository)
+
+	// then
+	if firstErr != nil || secondErr != nil {
+		t.Fatalf("expect to have no error when starting, but got %+v and %+v", firstErr, secondErr)
+	}
+	volumeName := "test-" + first.Id + "-sources"
+	if first.VolumeName != volumeName || storedErr != nil || stored.VolumeName != volumeName {
+		t.Fatalf("expect the volume %s to be recorded, but got %+v and %+v", volumeName, stored, storedErr)
+	}
+	if purgeErr != nil || purged != 1 || len(mock.removed) != 1 || mock.removed[0] != volumeName {
+		t.Fatalf("expect the volume of the purged execution to be removed, but got %d, %+v and %+v", purged, mock.removed, purgeErr)
+	}
+}
@@ -0,0 +1,184 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/container"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// pending lines are persisted once
+// one of theses limits is reached. The
+// delay is also checked by a ticker, for
+// the steps that stay silent.
+const logFlushSize = 100
+const logFlushDelay = time.Second
+
+// logWriter receive the logs of one step. Every chunk
+// is broadcasted as it comes, and split in lines that
+// are persisted in chunks, with their stream and the
+// instant they have been received.
+type logWriter struct {
+	mu          sync.Mutex // the ticker flushes concurrently with the writes
+	stop        chan struct{}
+	stopped     chan struct{}
+	ctx         context.Context
+	repository  persistence.Repository
+	jobId       string
+	executionId string
+	step        int                        // index of the step in the execution
+	partials    map[model.LogStream][]byte // last incomplete line of each stream
+	pending     []model.LogLine            // lines not persisted yet
+	lastFlush   time.Time
+	count       int // number of lines of the step
+}
+
+func (e execution) newLogWriter(step int) *logWriter {
+	l := &logWriter{
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+		ctx:         e.ctx,
+		repository:  e.repository,
+		jobId:       string(e.job.Id),
+		executionId: e.jobExecution.Id,
+		step:        step,
+		partials:    make(map[model.LogStream][]byte),
+		lastFlush:   time.Now(),
+	}
+	go l.flushPeriodically()
+	return l
+}
+
+// flushPeriodically persist the pending lines that
+// have waited too long, until the writer is closed
+func (l *logWriter) flushPeriodically() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(logFlushDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if time.Since(l.lastFlush) >= logFlushDelay {
+				l.flush()
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Write is used when the stream
+// is unknown. Stdout is assumed.
+func (l *logWriter) Write(p []byte) (n int, err error) {
+	return l.WriteStream(container.Stdout, p)
+}
+
+func (l *logWriter) WriteStream(stream container.Stream, p []byte) (n int, err error) {
+	if len(p) > 0 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		Broadcast(string(l.jobId), model.Event{
+			Type:        model.NewLog,
+			ExecutionId: l.executionId,
+			Value:       strings.TrimSpace(string(p)),
+		})
+		logStream := model.Stdout
+		if stream == container.Stderr {
+			logStream = model.Stderr
+		}
+		data := append(l.partials[logStream], p...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			l.addLine(logStream, strings.TrimSuffix(string(data[:i]), "\r"))
+			data = data[i+1:]
+		}
+		l.partials[logStream] = data
+		if len(l.pending) >= logFlushSize || time.Since(l.lastFlush) >= logFlushDelay {
+			l.flush()
+		}
+	}
+	return len(p), nil
+}
+
+// writeError add a line on stderr for errors
+// that are not coming from the step itself
+func (l *logWriter) writeError(msg string) {
+	l.WriteStream(container.Stderr, []byte(msg+"\n"))
+}
+
+// Close persist the remaining lines, incomplete ones
+// included. Must be called once the step is over.
+func (l *logWriter) Close() error {
+	close(l.stop)
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, stream := range []model.LogStream{model.Stdout, model.Stderr} {
+		if len(l.partials[stream]) > 0 {
+			l.addLine(stream, string(l.partials[stream]))
+			l.partials[stream] = nil
+		}
+	}
+	return l.flush()
+}
+
+// Count return the number
+// of lines of the step
+func (l *logWriter) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+func (l *logWriter) addLine(stream model.LogStream, content string) {
+	l.count++
+	l.pending = append(l.pending, model.LogLine{
+		Number:  l.count,
+		Time:    time.Now(),
+		Stream:  stream,
+		Content: content,
+	})
+}
+
+func (l *logWriter) flush() error {
+	l.lastFlush = time.Now()
+	if len(l.pending) == 0 {
+		return nil
+	}
+	err := l.repository.AppendLogs(l.ctx, l.jobId, l.executionId, l.step, l.pending)
+	if err != nil {
+		log.Printf("ERROR >> flush of logs encounter error : %s", err.Error())
+		return err
+	}
+	l.pending = nil
+	return nil
+}
+
+// broadcastWriter only broadcast the
+// logs it receives, without storing them
+type broadcastWriter struct {
+	jobId       string
+	executionId string
+}
+
+func (b *broadcastWriter) Write(p []byte) (n int, err error) {
+	if len(p) > 0 {
+		Broadcast(b.jobId, model.Event{
+			Type:        model.NewLog,
+			ExecutionId: b.executionId,
+			Value:       strings.TrimSpace(string(p)),
+		})
+	}
+	return len(p), nil
+}
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/container"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that logs are split in lines, with their
+// stream, and persisted when the writer is closed
+func TestLogWriterSplitAndPersistLines(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	job := model.Job{Id: []byte("job"), Name: "test"}
+	exec := execution{ctx: context.Background(), jobExecution: model.JobExecution{Id: "1"}, job: job, conf: conf, repository: repository}
+	w := exec.newLogWriter(2)
+
+	// when
+	w.WriteStream(container.Stdout, []byte("first line\nsecond "))
+	w.WriteStream(container.Stderr, []byte("some error\n"))
+	w.WriteStream(container.Stdout, []byte("line\nunfinished"))
+	w.Close()
+
+	// then
+	lines, err := repository.GetLogs(context.Background(), "job", "1", 2, 0, 0)
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching logs, but got %s", err.Error())
+	}
+	expected := []model.LogLine{
+		model.LogLine{Number: 1, Stream: model.Stdout, Content: "first line"},
+		model.LogLine{Number: 2, Stream: model.Stderr, Content: "some error"},
+		model.LogLine{Number: 3, Stream: model.Stdout, Content: "second line"},
+		model.LogLine{Number: 4, Stream: model.Stdout, Content: "unfinished"},
+	}
+	if len(lines) != len(expected) || w.Count() != len(expected) {
+		t.Fatalf("expect %d lines, but got %d (count %d)", len(expected), len(lines), w.Count())
+	}
+	for i, line := range lines {
+		if line.Number != expected[i].Number || line.Stream != expected[i].Stream || line.Content != expected[i].Content {
+			t.Fatalf("expect line %d to be %+v, but got %+v", i, expected[i], line)
+		}
+		if line.Time.IsZero() {
+			t.Fatalf("expect line %d to have a timestamp", i)
+		}
+	}
+}
+
+// test that a line is persisted while
+// the step stays silent, before the
+// writer is closed
+func TestLogWriterFlushSilentStep(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	defer tests.CleanPersistence(conf)
+	repository := persistence.GetRepository(conf)
+	job := model.Job{Id: []byte("job"), Name: "test"}
+	exec := execution{ctx: context.Background(), jobExecution: model.JobExecution{Id: "1"}, job: job, conf: conf, repository: repository}
+	w := exec.newLogWriter(0)
+	defer w.Close()
+
+	// when
+	w.WriteStream(container.Stdout, []byte("only line\n"))
+
+	// then
+	var lines []model.LogLine
+	deadline := time.Now().Add(3 * logFlushDelay)
+	for len(lines) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		lines, _ = repository.GetLogs(context.Background(), "job", "1", 0, 0, 0)
+	}
+	if len(lines) != 1 || lines[0].Content != "only line" {
+		t.Fatalf("expect the line to be persisted before the end of the step, but got %+v", lines)
+	}
+}
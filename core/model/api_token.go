@@ -0,0 +1,126 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// prefix of every api token value. It allows
+// to tell an api token from a session JWT.
+const ApiTokenPrefix = "dahu_"
+
+// scope of an api token. A token may only
+// do what its scopes and its owner role allow.
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"     // read-only access
+	ScopeJobsRun Scope = "jobs:run" // read access, plus starting and canceling job executions
+	ScopeWrite   Scope = "write"    // everything the owner of the token can do
+)
+
+func (s Scope) IsValid() bool {
+	return s == ScopeRead || s == ScopeJobsRun || s == ScopeWrite
+}
+
+// long-lived token used by scripts
+// and automation. Only the hash of the
+// value is kept.
+type ApiToken struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Login      string     `json:"login"`          // login of the owner of the token
+	Hash       string     `json:"hash,omitempty"` // sha256 of the token value. Removed by ToPublicModel
+	Scopes     []Scope    `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`  // no expiration when nil
+	LastUsedAt *time.Time `json:"lastUsedAt"` // nil until the token is used
+}
+
+// body of an api token creation request
+type ApiTokenCreation struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// answer to an api token creation. This is
+// the only time the value is available.
+type ApiTokenValue struct {
+	Token ApiToken `json:"token"`
+	Value string   `json:"value"`
+}
+
+func (t *ApiToken) GenerateId() error {
+	id, err := generateId([]byte(t.Id))
+	if err == nil {
+		t.Id = string(id)
+	}
+	return err
+}
+
+func (t *ApiToken) String() string {
+	return fmt.Sprintf("{Id:%s, Name:%s, Login:%s}", t.Id, t.Name, t.Login)
+}
+
+func (t *ApiToken) ToPublicModel() {
+	t.Hash = ""
+}
+
+func (t *ApiToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+func (t *ApiToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ApiTokenCreation) IsValid() bool {
+	if c.Name == "" || len(c.Scopes) == 0 {
+		return false
+	}
+	for _, scope := range c.Scopes {
+		if !scope.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// generate a new random api token value
+// and return it with its hash.
+func NewApiTokenValue() (value string, hash string, err error) {
+	return newTokenValue(ApiTokenPrefix)
+}
+
+func newTokenValue(prefix string) (value string, hash string, err error) {
+	random := make([]byte, 32)
+	_, err = rand.Read(random)
+	if err != nil {
+		return
+	}
+	value = prefix + hex.EncodeToString(random)
+	hash = HashTokenValue(value)
+	return
+}
+
+// hash of api tokens and refresh tokens values. The
+// values are random and long enough to not need
+// a slow hash function.
+func HashTokenValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsApiTokenValue(value string) bool {
+	return strings.HasPrefix(value, ApiTokenPrefix)
+}
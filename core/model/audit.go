@@ -0,0 +1,177 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// action recorded in the audit trail
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+	AuditStart  AuditAction = "start"  // start of a job execution
+	AuditCancel AuditAction = "cancel" // cancelation of a job execution
+)
+
+// kind of resource an audit entry is about
+type AuditResource string
+
+const (
+	AuditJob            AuditResource = "job"
+	AuditDockerRegistry AuditResource = "dockerRegistry"
+	AuditUser           AuditResource = "user"
+	AuditTeam           AuditResource = "team" // teams hold the secrets
+	AuditExecution      AuditResource = "execution"
+	AuditSigningKey     AuditResource = "signingKey"
+)
+
+// names of the fields whose values are
+// never written in the audit trail
+var secretFields = map[string]bool{
+	"password":    true,
+	"key":         true,
+	"keyPassword": true,
+	"token":       true,
+	"hash":        true,
+}
+
+// entry of the audit trail. Entries
+// are never updated nor deleted.
+type AuditEntry struct {
+	Id           string        `json:"id"`
+	Time         time.Time     `json:"time"`
+	Actor        string        `json:"actor"` // login of the owner of the token. Empty for tokens not bound to any user
+	Action       AuditAction   `json:"action"`
+	ResourceType AuditResource `json:"resourceType"`
+	ResourceId   string        `json:"resourceId"`
+	Changes      []FieldChange `json:"changes,omitempty"`
+}
+
+// change of one field of a resource. Nested fields are
+// joined by dots. The values of secrets are left empty.
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+	Secret bool        `json:"secret,omitempty"`
+}
+
+// criteria of an audit trail search.
+// Empty criteria match every entry.
+type AuditFilter struct {
+	Actor        string
+	Action       AuditAction
+	ResourceType AuditResource
+	ResourceId   string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+func (e *AuditEntry) GenerateId() error {
+	id, err := generateId([]byte(e.Id))
+	if err == nil {
+		e.Id = string(id)
+	}
+	return err
+}
+
+func (e *AuditEntry) String() string {
+	return fmt.Sprintf("{Id:%s, Actor:%s, Action:%s, ResourceType:%s, ResourceId:%s}", e.Id, e.Actor, e.Action, e.ResourceType, e.ResourceId)
+}
+
+func (f AuditFilter) Matches(e *AuditEntry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.ResourceType == "" || e.ResourceType == f.ResourceType) &&
+		(f.ResourceId == "" || e.ResourceId == f.ResourceId) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Diff return the fields that differ between two versions of a
+// resource, sorted by name. before is nil for a creation, after
+// is nil for a deletion. The values of secret fields are omitted.
+func Diff(before, after interface{}) []FieldChange {
+	oldFields, newFields := flatten(before), flatten(after)
+	names := make([]string, 0, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names = append(names, name)
+	}
+	for name := range newFields {
+		if _, ok := oldFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := make([]FieldChange, 0)
+	for _, name := range names {
+		oldValue, newValue := oldFields[name], newFields[name]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if isSecretField(name) {
+			changes = append(changes, FieldChange{Field: name, Secret: true})
+		} else {
+			changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+// a field is secret when its own name is in secretFields,
+// or when it is one of the secrets of a team
+func isSecretField(name string) bool {
+	segments := strings.Split(name, ".")
+	return secretFields[segments[len(segments)-1]] || segments[0] == "secrets"
+}
+
+// flatten the json form of a value into
+// a map of its leaf fields, by dotted name
+func flatten(value interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil() {
+		return fields
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	var generic interface{}
+	if json.Unmarshal(data, &generic) != nil {
+		return fields
+	}
+	flattenInto(fields, "", generic)
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			flattenInto(fields, join(prefix, name), child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenInto(fields, join(prefix, fmt.Sprint(i)), child)
+		}
+	default:
+		if v != nil && v != "" {
+			fields[prefix] = v
+		}
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
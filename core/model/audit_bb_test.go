@@ -0,0 +1,49 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// test that the diff list the changed fields
+// only, and never the values of the secrets
+func TestDiff(t *testing.T) {
+	// given
+	before := model.DockerRegistry{Name: "registry", Url: "localhost:5000", User: "bob", Password: "old"}
+	after := model.DockerRegistry{Name: "registry", Url: "localhost:5001", User: "bob", Password: "new"}
+
+	// when
+	changes := model.Diff(before, after)
+
+	// then
+	if len(changes) != 2 {
+		t.Fatalf("expect 2 changes, but got %+v", changes)
+	}
+	if changes[0].Field != "password" || !changes[0].Secret || changes[0].Old != nil || changes[0].New != nil {
+		t.Fatalf("expect the password change without its values, but got %+v", changes[0])
+	}
+	if changes[1].Field != "url" || changes[1].Old != "localhost:5000" || changes[1].New != "localhost:5001" {
+		t.Fatalf("expect the url change, but got %+v", changes[1])
+	}
+}
+
+// test the diff of a creation
+func TestDiffOfCreation(t *testing.T) {
+	// given
+	team := &model.Team{Name: "dev", Secrets: map[string]string{"TOKEN": "s3cr3t"}}
+
+	// when
+	changes := model.Diff(nil, team)
+
+	// then
+	if len(changes) != 2 {
+		t.Fatalf("expect 2 changes, but got %+v", changes)
+	}
+	if changes[0].Field != "name" || changes[0].Old != nil || changes[0].New != "dev" {
+		t.Fatalf("expect the name change, but got %+v", changes[0])
+	}
+	if changes[1].Field != "secrets.TOKEN" || !changes[1].Secret || changes[1].New != nil {
+		t.Fatalf("expect the secret change without its value, but got %+v", changes[1])
+	}
+}
@@ -1,10 +1,13 @@
 package model
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	"math/rand"
-	"strconv"
+	"io"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,10 +15,84 @@ type PublicModel interface {
 	ToPublicModel()
 }
 
+// Crockford's base32, whose order is the one of the bytes
+const idAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+const idLength = 26
+
+// state of the id generator, see generateId
+var idGenerator = struct {
+	sync.Mutex
+	time   uint64
+	random [10]byte
+}{}
+
+// generate a ULID : 48 bits of unix time in milliseconds followed by
+// 80 random bits, as 26 characters of Crockford's base32. The ids are
+// sorted by creation time. Inside a millisecond, the random part of the
+// previous id is incremented, so that the ids stay sorted and unique.
 func generateId(id []byte) ([]byte, error) {
 	if id != nil && string(id) != "" {
 		return nil, errors.New(fmt.Sprintf("the id %+v already defined", string(id)))
 	}
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return []byte(strconv.Itoa(r.Int())), nil
+	idGenerator.Lock()
+	defer idGenerator.Unlock()
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if now <= idGenerator.time && incrementRandom(&idGenerator.random) {
+		now = idGenerator.time
+	} else {
+		if _, err := io.ReadFull(rand.Reader, idGenerator.random[:]); err != nil {
+			return nil, err
+		}
+		if now <= idGenerator.time {
+			// the random part is exhausted, or the clock went
+			// backward : the id is taken in the next millisecond
+			now = idGenerator.time + 1
+		}
+		idGenerator.time = now
+	}
+	var value [16]byte
+	binary.BigEndian.PutUint64(value[:8], now<<16)
+	copy(value[6:], idGenerator.random[:])
+	return encodeId(value), nil
+}
+
+// add one to the random part. Return false on overflow
+func incrementRandom(random *[10]byte) bool {
+	for index := len(random) - 1; index >= 0; index-- {
+		random[index]++
+		if random[index] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 128 bits as 26 characters of 5 bits,
+// the first one holding only 3 bits
+func encodeId(value [16]byte) []byte {
+	high := binary.BigEndian.Uint64(value[:8])
+	low := binary.BigEndian.Uint64(value[8:])
+	res := make([]byte, idLength)
+	for index := idLength - 1; index >= 0; index-- {
+		res[index] = idAlphabet[low&31]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return res
+}
+
+// true for the ids generated by generateId. The
+// ones generated by the former versions of Dahu
+// are random integers, which carry no order.
+func IsSortableId(id string) bool {
+	if len(id) != idLength {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(idAlphabet, c) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,70 @@
+package model_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// test that the generated ids are sortable
+// and follow each other in creation order
+func TestGeneratedIdsShouldBeSortedByCreation(t *testing.T) {
+	// given
+	previous := ""
+
+	for index := 0; index < 1000; index++ {
+		// when
+		var execution model.JobExecution
+		err := execution.GenerateId()
+
+		// then
+		if err != nil {
+			t.Fatalf("expect to have no error, but got %s", err.Error())
+		}
+		if !model.IsSortableId(execution.Id) || execution.Id <= previous {
+			t.Fatalf("expect %s to be a sortable id greater than %s", execution.Id, previous)
+		}
+		previous = execution.Id
+	}
+}
+
+// test that concurrent generations never collide
+func TestGeneratedIdsShouldBeUniqueWhenConcurrent(t *testing.T) {
+	// given
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	ids := make(map[string]bool)
+
+	// when
+	for routine := 0; routine < 8; routine++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := 0; index < 1000; index++ {
+				var registry model.DockerRegistry
+				registry.GenerateId()
+				mutex.Lock()
+				ids[registry.Id] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// then
+	if len(ids) != 8000 {
+		t.Fatalf("expect 8000 distinct ids, but got %d", len(ids))
+	}
+}
+
+// test that the random integers of the former
+// versions of Dahu are not taken for sortable ids
+func TestIsSortableIdWithLegacyId(t *testing.T) {
+	if model.IsSortableId("5577006791947779410") {
+		t.Fatal("expect a legacy id not to be sortable")
+	}
+	if !model.IsSortableId("01ARZ3NDEKTSV4RRFFQ69G5FAV") {
+		t.Fatal("expect a ulid to be sortable")
+	}
+}
@@ -0,0 +1,482 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// version of the configuration bundles
+// written by this version of Dahu
+const BundleVersion = 1
+
+// configuration of a Dahu instance, exported by GET /export
+// and applied by POST /import. It holds neither password hashes
+// nor secret values : the secrets are only given by their names,
+// and kept from the existing configuration on import.
+type Bundle struct {
+	Version          int               `json:"version"`
+	ExportedAt       time.Time         `json:"exportedAt"`
+	Jobs             []*Job            `json:"jobs"`
+	DockerRegistries []*DockerRegistry `json:"dockerRegistries"`
+	Users            []*User           `json:"users"`
+	Teams            []*Team           `json:"teams"`
+}
+
+// build the bundle of the given configuration. The
+// entities are modified to remove the secret values.
+func NewBundle(jobs []*Job, registries []*DockerRegistry, users []*User, teams []*Team) Bundle {
+	for _, job := range jobs {
+		job.toConfiguration()
+		job.ToPublicModel()
+	}
+	for _, registry := range registries {
+		registry.ToPublicModel()
+		registry.LastModificationTime = ""
+	}
+	for _, user := range users {
+		user.ToPublicModel()
+		user.SessionsRevokedAt = 0
+	}
+	for _, team := range teams {
+		team.ToPublicModel()
+	}
+	sort.Slice(jobs, func(i, j int) bool { return string(jobs[i].Id) < string(jobs[j].Id) })
+	sort.Slice(registries, func(i, j int) bool { return registries[i].Id < registries[j].Id })
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Id < teams[j].Id })
+	return Bundle{Version: BundleVersion, ExportedAt: time.Now(), Jobs: jobs, DockerRegistries: registries, Users: users, Teams: teams}
+}
+
+// how a bundle is applied
+type ImportMode string
+
+const (
+	ImportMerge   ImportMode = "merge"   // create and update the entities of the bundle
+	ImportReplace ImportMode = "replace" // also delete the entities missing from the bundle
+)
+
+func (m ImportMode) IsValid() bool {
+	return m == ImportMerge || m == ImportReplace
+}
+
+// what the import does with an entity
+type ImportAction string
+
+const (
+	ImportCreate    ImportAction = "create"
+	ImportUpdate    ImportAction = "update"
+	ImportUnchanged ImportAction = "unchanged"
+	ImportDelete    ImportAction = "delete"
+	ImportConflict  ImportAction = "conflict" // the entity is left as it is
+)
+
+// outcome of the import of one entity
+type ImportResult struct {
+	ResourceType   AuditResource `json:"resourceType"`
+	Id             string        `json:"id"`
+	Name           string        `json:"name"`
+	Action         ImportAction  `json:"action"`
+	Reason         string        `json:"reason,omitempty"`         // cause of a conflict
+	MissingSecrets []string      `json:"missingSecrets,omitempty"` // secrets without value, to be set after the import
+}
+
+// answer of POST /import
+type ImportReport struct {
+	Mode    ImportMode     `json:"mode"`
+	DryRun  bool           `json:"dryRun"`
+	Results []ImportResult `json:"results"`
+}
+
+// count the results with the given action
+func (r ImportReport) Count(action ImportAction) int {
+	count := 0
+	for _, result := range r.Results {
+		if result.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// entities to store as they are, and to
+// delete, in a single transaction
+type ConfigurationChanges struct {
+	Jobs                    []*Job
+	DockerRegistries        []*DockerRegistry
+	Users                   []*User
+	Teams                   []*Team
+	DeletedJobs             []string
+	DeletedDockerRegistries []string
+	DeletedUsers            []string
+	DeletedTeams            []string
+}
+
+// compare the bundle with the existing configuration, and return the
+// changes to apply with the report of the import. The existing entities
+// hold their secrets, which are kept when the bundle leaves them empty.
+// actor, the login of the user importing the bundle, is never deleted.
+func PlanImport(bundle Bundle, existing Bundle, mode ImportMode, actor string) (ConfigurationChanges, ImportReport) {
+	p := importPlan{mode: mode, report: ImportReport{Mode: mode, Results: make([]ImportResult, 0)}}
+	existingTeams := make(map[string]*Team)
+	for _, team := range existing.Teams {
+		existingTeams[team.Id] = team
+	}
+	existingRegistries := make(map[string]*DockerRegistry)
+	for _, registry := range existing.DockerRegistries {
+		existingRegistries[registry.Id] = registry
+	}
+	// the teams and registries available after the import
+	p.teams = make(map[string]*Team)
+	p.registries = make(map[string]bool)
+	if mode == ImportMerge {
+		for id, team := range existingTeams {
+			p.teams[id] = team
+		}
+		for id := range existingRegistries {
+			p.registries[id] = true
+		}
+	}
+	bundleTeams := make(map[string]bool)
+	for _, team := range bundle.Teams {
+		bundleTeams[team.Id] = true
+		p.planTeam(team, existingTeams)
+	}
+	bundleRegistries := make(map[string]bool)
+	for _, registry := range bundle.DockerRegistries {
+		bundleRegistries[registry.Id] = true
+		p.planRegistry(registry, existingRegistries)
+	}
+	bundleJobs := make(map[string]bool)
+	existingJobs := make(map[string]*Job)
+	for _, job := range existing.Jobs {
+		existingJobs[string(job.Id)] = job
+	}
+	for _, job := range bundle.Jobs {
+		bundleJobs[string(job.Id)] = true
+		p.planJob(job, existingJobs)
+	}
+	bundleUsers := make(map[string]bool)
+	existingUsers := make(map[string]*User)
+	for _, user := range existing.Users {
+		existingUsers[user.Login] = user
+	}
+	for _, user := range bundle.Users {
+		bundleUsers[user.Login] = true
+		p.planUser(user, existingUsers)
+	}
+	if mode == ImportReplace {
+		p.planDeletions(existing, bundleJobs, bundleRegistries, bundleUsers, bundleTeams, actor)
+	}
+	return p.changes, p.report
+}
+
+type importPlan struct {
+	mode       ImportMode
+	changes    ConfigurationChanges
+	report     ImportReport
+	teams      map[string]*Team // by id
+	registries map[string]bool  // ids
+	kept       []*Job           // existing jobs left as they are because of a conflict
+}
+
+func (p *importPlan) add(result ImportResult) {
+	p.report.Results = append(p.report.Results, result)
+}
+
+// action of an entity found, or not, in the existing configuration
+func changeAction(found bool, existing, imported interface{}) ImportAction {
+	if !found {
+		return ImportCreate
+	}
+	if reflect.DeepEqual(existing, imported) {
+		return ImportUnchanged
+	}
+	return ImportUpdate
+}
+
+func (p *importPlan) planTeam(team *Team, existing map[string]*Team) {
+	result := ImportResult{ResourceType: AuditTeam, Id: team.Id, Name: team.Name}
+	current, found := existing[team.Id]
+	if team.Id == "" || !team.IsValid() {
+		result.Action, result.Reason = ImportConflict, "the team is invalid"
+	} else if conflict := sameName(existing, team.Id, team.Name); conflict != "" {
+		result.Action, result.Reason = ImportConflict, fmt.Sprintf("a team named %s already exists with the id %s", team.Name, conflict)
+	} else {
+		if found {
+			team.MergeSecrets(current)
+		}
+		for name, value := range team.Secrets {
+			if value == "" {
+				result.MissingSecrets = append(result.MissingSecrets, name)
+			}
+		}
+		sort.Strings(result.MissingSecrets)
+		result.Action = changeAction(found, current, team)
+		if result.Action != ImportUnchanged {
+			p.changes.Teams = append(p.changes.Teams, team)
+		}
+		p.teams[team.Id] = team
+	}
+	if found && result.Action == ImportConflict {
+		p.teams[team.Id] = current
+	}
+	p.add(result)
+}
+
+// id of another team with the same name, if any
+func sameName(existing map[string]*Team, id, name string) string {
+	for _, team := range existing {
+		if team.Id != id && team.Name == name {
+			return team.Id
+		}
+	}
+	return ""
+}
+
+func (p *importPlan) planRegistry(registry *DockerRegistry, existing map[string]*DockerRegistry) {
+	result := ImportResult{ResourceType: AuditDockerRegistry, Id: registry.Id, Name: registry.Name}
+	current, found := existing[registry.Id]
+	conflict := ""
+	for _, other := range existing {
+		if other.Id != registry.Id && other.Name == registry.Name {
+			conflict = other.Id
+		}
+	}
+	if _, teamFound := p.teams[registry.TeamId]; registry.TeamId != "" && !teamFound {
+		result.Action, result.Reason = ImportConflict, fmt.Sprintf("the team %s doesn't exist", registry.TeamId)
+	} else if registry.Id == "" || registry.Name == "" || registry.Url == "" {
+		result.Action, result.Reason = ImportConflict, "the registry is invalid"
+	} else if conflict != "" {
+		result.Action, result.Reason = ImportConflict, fmt.Sprintf("a registry named %s already exists with the id %s", registry.Name, conflict)
+	} else {
+		if found {
+			if registry.Password == "" {
+				registry.Password = current.Password
+			}
+			registry.LastModificationTime = current.LastModificationTime
+		}
+		if registry.User != "" && registry.Password == "" {
+			result.MissingSecrets = []string{"password"}
+		}
+		result.Action = changeAction(found, current, registry)
+		if result.Action != ImportUnchanged {
+			registry.NewLastModificationTime()
+			p.changes.DockerRegistries = append(p.changes.DockerRegistries, registry)
+		}
+		p.registries[registry.Id] = true
+	}
+	if found && result.Action == ImportConflict {
+		p.registries[registry.Id] = true
+	}
+	p.add(result)
+}
+
+func (p *importPlan) planJob(job *Job, existing map[string]*Job) {
+	result := ImportResult{ResourceType: AuditJob, Id: string(job.Id), Name: job.Name}
+	current, found := existing[string(job.Id)]
+	job.toConfiguration()
+	if found {
+		current.toConfiguration()
+		mergeJobSecrets(job, current)
+	}
+	if len(job.Id) == 0 || !job.isValidWithoutSecrets() {
+		result.Action, result.Reason = ImportConflict, "the job is invalid"
+	} else if reason := p.jobReferences(job); reason != "" {
+		result.Action, result.Reason = ImportConflict, reason
+	} else {
+		if job.GitConf.HttpAuth != nil && job.GitConf.HttpAuth.User != "" && job.GitConf.HttpAuth.Password == "" {
+			result.MissingSecrets = append(result.MissingSecrets, "httpAuth.password")
+		}
+		if job.GitConf.SshAuth != nil && job.GitConf.SshAuth.Key == "" {
+			result.MissingSecrets = append(result.MissingSecrets, "sshAuth.key")
+		}
+		result.Action = changeAction(found, current, job)
+		if result.Action != ImportUnchanged {
+			p.changes.Jobs = append(p.changes.Jobs, job)
+		}
+	}
+	if found && result.Action == ImportConflict {
+		p.kept = append(p.kept, current)
+	}
+	p.add(result)
+}
+
+// take the secrets of the existing version
+// of the job when they are left empty
+func mergeJobSecrets(job *Job, existing *Job) {
+	if job.GitConf.HttpAuth != nil && existing.GitConf.HttpAuth != nil && job.GitConf.HttpAuth.Password == "" {
+		// the exported jobs have no git user. An imported
+		// one is kept, without the password of another user
+		if job.GitConf.HttpAuth.User == "" {
+			job.GitConf.HttpAuth.User = existing.GitConf.HttpAuth.User
+		}
+		if job.GitConf.HttpAuth.User == existing.GitConf.HttpAuth.User {
+			job.GitConf.HttpAuth.Password = existing.GitConf.HttpAuth.Password
+		}
+	}
+	if job.GitConf.SshAuth != nil && existing.GitConf.SshAuth != nil && job.GitConf.SshAuth.Key == "" {
+		job.GitConf.SshAuth.Key = existing.GitConf.SshAuth.Key
+		job.GitConf.SshAuth.KeyPassword = existing.GitConf.SshAuth.KeyPassword
+	}
+	if job.CommitStatus != nil && existing.CommitStatus != nil && job.CommitStatus.Token == "" {
+		job.CommitStatus.Token = existing.CommitStatus.Token
+	}
+}
+
+// return why the team, registries or secrets used
+// by the job won't exist after the import, if so
+func (p *importPlan) jobReferences(job *Job) string {
+	team, teamFound := p.teams[job.TeamId]
+	if job.TeamId != "" && !teamFound {
+		return fmt.Sprintf("the team %s doesn't exist", job.TeamId)
+	}
+	for _, step := range job.Steps {
+		if step.Image.RegistryId != "" && !p.registries[step.Image.RegistryId] {
+			return fmt.Sprintf("the registry %s used by the step %s doesn't exist", step.Image.RegistryId, step.Name)
+		}
+		for _, value := range step.Envs {
+			if !strings.HasPrefix(value, SecretReferencePrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(value, SecretReferencePrefix)
+			if !teamFound {
+				return fmt.Sprintf("the step %s uses the secret %s, but the job has no team", step.Name, name)
+			}
+			if _, ok := team.Secrets[name]; !ok {
+				return fmt.Sprintf("the secret %s used by the step %s doesn't exist in team %s", name, step.Name, team.Name)
+			}
+		}
+	}
+	for _, notifier := range job.Notifiers {
+		if notifier.Image.RegistryId != "" && !p.registries[notifier.Image.RegistryId] {
+			return fmt.Sprintf("the registry %s used by the notifier %s doesn't exist", notifier.Image.RegistryId, notifier.Name)
+		}
+	}
+	return ""
+}
+
+func (p *importPlan) planUser(user *User, existing map[string]*User) {
+	result := ImportResult{ResourceType: AuditUser, Id: user.Login, Name: user.Login}
+	current, found := existing[user.Login]
+	if user.Login == "" || (user.Role != "" && !user.Role.IsValid()) {
+		result.Action, result.Reason = ImportConflict, "the user is invalid"
+		p.add(result)
+		return
+	}
+	if found {
+		user.Password = current.Password
+		user.MustChangePassword = current.MustChangePassword
+		user.SessionsRevokedAt = current.SessionsRevokedAt
+	} else {
+		// the password must be reset by an admin, unless
+		// the users are authenticated by an external system
+		user.Password = nil
+		user.MustChangePassword = true
+		user.SessionsRevokedAt = 0
+		result.MissingSecrets = []string{"password"}
+	}
+	result.Action = changeAction(found, current, user)
+	if result.Action != ImportUnchanged {
+		p.changes.Users = append(p.changes.Users, user)
+	}
+	p.add(result)
+}
+
+// with the replace mode, the entities missing from the bundle
+// are deleted, unless they are still used by an entity left
+// as it is because of a conflict.
+func (p *importPlan) planDeletions(existing Bundle, jobs, registries, users, teams map[string]bool, actor string) {
+	for _, job := range existing.Jobs {
+		if !jobs[string(job.Id)] {
+			p.changes.DeletedJobs = append(p.changes.DeletedJobs, string(job.Id))
+			p.add(ImportResult{ResourceType: AuditJob, Id: string(job.Id), Name: job.Name, Action: ImportDelete})
+		}
+	}
+	for _, registry := range existing.DockerRegistries {
+		if registries[registry.Id] {
+			continue
+		}
+		result := ImportResult{ResourceType: AuditDockerRegistry, Id: registry.Id, Name: registry.Name, Action: ImportDelete}
+		for _, job := range p.kept {
+			if job.usesRegistry(registry.Id) {
+				result.Action, result.Reason = ImportConflict, fmt.Sprintf("the registry is still used by the job %s", job.Name)
+			}
+		}
+		if result.Action == ImportDelete {
+			p.changes.DeletedDockerRegistries = append(p.changes.DeletedDockerRegistries, registry.Id)
+		}
+		p.add(result)
+	}
+	for _, user := range existing.Users {
+		if users[user.Login] {
+			continue
+		}
+		result := ImportResult{ResourceType: AuditUser, Id: user.Login, Name: user.Login, Action: ImportDelete}
+		if user.Login == actor {
+			result.Action, result.Reason = ImportConflict, "the user importing the bundle can't be deleted"
+		} else {
+			p.changes.DeletedUsers = append(p.changes.DeletedUsers, user.Login)
+		}
+		p.add(result)
+	}
+	for _, team := range existing.Teams {
+		if teams[team.Id] {
+			continue
+		}
+		result := ImportResult{ResourceType: AuditTeam, Id: team.Id, Name: team.Name, Action: ImportDelete}
+		for _, job := range p.kept {
+			if job.TeamId == team.Id {
+				result.Action, result.Reason = ImportConflict, fmt.Sprintf("the team still owns the job %s", job.Name)
+			}
+		}
+		if result.Action == ImportDelete {
+			p.changes.DeletedTeams = append(p.changes.DeletedTeams, team.Id)
+		}
+		p.add(result)
+	}
+}
+
+// remove what isn't part of the configuration of
+// the job : its executions and the registries
+// loaded for an execution
+func (j *Job) toConfiguration() {
+	j.Executions = nil
+	for index := range j.Steps {
+		j.Steps[index].Image.Registry = nil
+	}
+	for index := range j.Notifiers {
+		j.Notifiers[index].Image.Registry = nil
+	}
+}
+
+// IsValid, a missing ssh key being
+// reported instead of rejected
+func (j *Job) isValidWithoutSecrets() bool {
+	if j.GitConf.SshAuth == nil || j.GitConf.SshAuth.Key != "" {
+		return j.IsValid()
+	}
+	job := *j
+	sshAuth := *j.GitConf.SshAuth
+	sshAuth.Key = "missing"
+	job.GitConf.SshAuth = &sshAuth
+	return job.IsValid()
+}
+
+// true when a step or a notifier of the
+// job uses the registry with the given id
+func (j *Job) usesRegistry(registryId string) bool {
+	for _, step := range j.Steps {
+		if step.Image.RegistryId == registryId {
+			return true
+		}
+	}
+	for _, notifier := range j.Notifiers {
+		if notifier.Image.RegistryId == registryId {
+			return true
+		}
+	}
+	return false
+}
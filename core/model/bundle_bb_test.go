@@ -0,0 +1,148 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func validJob(id, teamId string) *model.Job {
+	return &model.Job{Id: []byte(id), Name: id, TeamId: teamId, GitConf: model.GitConfig{HttpAuth: &model.HttpAuthConfig{Url: "http://some.url"}}}
+}
+
+// the existing configuration, with its secrets
+func existingConfiguration() model.Bundle {
+	job := validJob("job", "team")
+	job.GitConf.HttpAuth.User = "bob"
+	job.GitConf.HttpAuth.Password = "gitPassword"
+	return model.Bundle{
+		Jobs:             []*model.Job{job},
+		DockerRegistries: []*model.DockerRegistry{{Id: "registry", Name: "registry", Url: "some.url", User: "bob", Password: "registryPassword", LastModificationTime: "1"}},
+		Users:            []*model.User{{Login: "admin", Password: []byte("hash"), Role: model.RoleAdmin}, {Login: "bob", Password: []byte("hash")}},
+		Teams:            []*model.Team{{Id: "team", Name: "team", Secrets: map[string]string{"token": "s3cr3t"}}},
+	}
+}
+
+func resultOf(report model.ImportReport, resourceType model.AuditResource, id string) model.ImportResult {
+	for _, result := range report.Results {
+		if result.ResourceType == resourceType && result.Id == id {
+			return result
+		}
+	}
+	return model.ImportResult{}
+}
+
+func TestNewBundleShouldRemoveSecrets(t *testing.T) {
+	// given
+	existing := existingConfiguration()
+
+	// when
+	bundle := model.NewBundle(existing.Jobs, existing.DockerRegistries, existing.Users, existing.Teams)
+
+	// then
+	if bundle.Version != model.BundleVersion {
+		t.Errorf("expect the bundle version to be %d, but got %d", model.BundleVersion, bundle.Version)
+	}
+	if bundle.Jobs[0].GitConf.HttpAuth.Password != "" || bundle.DockerRegistries[0].Password != "" {
+		t.Errorf("expect the passwords to be removed")
+	}
+	if len(bundle.Users[0].Password) != 0 {
+		t.Errorf("expect the password hashes to be removed")
+	}
+	if value, ok := bundle.Teams[0].Secrets["token"]; !ok || value != "" {
+		t.Errorf("expect the team secrets to be kept by name only, but got %+v", bundle.Teams[0].Secrets)
+	}
+}
+
+func TestPlanImportShouldKeepTheExistingSecrets(t *testing.T) {
+	// given
+	existing := existingConfiguration()
+	bundle := model.NewBundle(existingConfiguration().Jobs, existingConfiguration().DockerRegistries, existingConfiguration().Users, existingConfiguration().Teams)
+	bundle.Jobs[0].Name = "renamed"
+	bundle.Users = append(bundle.Users, &model.User{Login: "carol"})
+
+	// when
+	changes, report := model.PlanImport(bundle, existing, model.ImportMerge, "admin")
+
+	// then
+	if len(changes.Jobs) != 1 || changes.Jobs[0].GitConf.HttpAuth.Password != "gitPassword" {
+		t.Fatalf("expect the job to be updated with its git password, but got %+v", changes.Jobs)
+	}
+	if len(changes.Users) != 1 || changes.Users[0].Login != "carol" || !changes.Users[0].MustChangePassword {
+		t.Fatalf("expect carol to be created and to change her password, but got %+v", changes.Users)
+	}
+	if len(changes.Teams) != 0 || len(changes.DockerRegistries) != 0 {
+		t.Fatalf("expect the team and the registry to be unchanged, but got %+v and %+v", changes.Teams, changes.DockerRegistries)
+	}
+	if action := resultOf(report, model.AuditJob, "job").Action; action != model.ImportUpdate {
+		t.Errorf("expect the job to be updated, but got %s", action)
+	}
+	if result := resultOf(report, model.AuditUser, "carol"); result.Action != model.ImportCreate || len(result.MissingSecrets) != 1 {
+		t.Errorf("expect carol to be created without password, but got %+v", result)
+	}
+}
+
+// test that the git user of the bundle is kept, the
+// password of the existing one being left to be set
+func TestPlanImportShouldKeepTheImportedGitUser(t *testing.T) {
+	// given
+	existing := existingConfiguration()
+	bundle := model.NewBundle(existingConfiguration().Jobs, existingConfiguration().DockerRegistries, existingConfiguration().Users, existingConfiguration().Teams)
+	bundle.Jobs[0].GitConf.HttpAuth.User = "carol"
+
+	// when
+	changes, report := model.PlanImport(bundle, existing, model.ImportMerge, "admin")
+
+	// then
+	if len(changes.Jobs) != 1 || changes.Jobs[0].GitConf.HttpAuth.User != "carol" || changes.Jobs[0].GitConf.HttpAuth.Password != "" {
+		t.Fatalf("expect the job to be updated with the git user carol and no password, but got %+v", changes.Jobs)
+	}
+	result := resultOf(report, model.AuditJob, "job")
+	if result.Action != model.ImportUpdate || len(result.MissingSecrets) != 1 || result.MissingSecrets[0] != "httpAuth.password" {
+		t.Fatalf("expect the git password to be reported as missing, but got %+v", result)
+	}
+}
+
+func TestPlanImportShouldReplace(t *testing.T) {
+	// given
+	existing := existingConfiguration()
+	bundle := model.Bundle{Version: model.BundleVersion, Teams: []*model.Team{{Id: "team", Name: "team", Secrets: map[string]string{"token": ""}}}}
+
+	// when
+	changes, report := model.PlanImport(bundle, existing, model.ImportReplace, "admin")
+
+	// then
+	if len(changes.DeletedJobs) != 1 || len(changes.DeletedDockerRegistries) != 1 || len(changes.DeletedTeams) != 0 {
+		t.Fatalf("expect the job and the registry to be deleted, but got %+v", changes)
+	}
+	if len(changes.DeletedUsers) != 1 || changes.DeletedUsers[0] != "bob" {
+		t.Fatalf("expect only bob to be deleted, but got %+v", changes.DeletedUsers)
+	}
+	if result := resultOf(report, model.AuditUser, "admin"); result.Action != model.ImportConflict {
+		t.Errorf("expect a conflict on the deletion of the importing user, but got %+v", result)
+	}
+}
+
+func TestPlanImportShouldReportConflicts(t *testing.T) {
+	// given
+	existing := existingConfiguration()
+	job := validJob("other", "unknown")
+	stepJob := validJob("step", "team")
+	stepJob.Steps = []model.Step{{Name: "build", Envs: map[string]string{"TOKEN": "secret:unknown"}}}
+	bundle := model.Bundle{
+		Version: model.BundleVersion,
+		Jobs:    []*model.Job{job, stepJob},
+		Teams:   []*model.Team{{Id: "otherTeam", Name: "team"}},
+	}
+
+	// when
+	changes, report := model.PlanImport(bundle, existing, model.ImportMerge, "admin")
+
+	// then
+	if len(changes.Jobs) != 0 || len(changes.Teams) != 0 {
+		t.Fatalf("expect nothing to be changed, but got %+v", changes)
+	}
+	if report.Count(model.ImportConflict) != 3 {
+		t.Fatalf("expect 3 conflicts, but got %+v", report.Results)
+	}
+}
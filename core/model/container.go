@@ -19,6 +19,7 @@ type DockerRegistry struct {
 	Url                  string `json:"url"`
 	User                 string `json:"user"`
 	Password             string `json:"password"`
+	TeamId               string `json:"teamId"` // team owning the registry. Empty for a registry visible by everyone
 	LastModificationTime string `json:"lastModificationTime"`
 }
 
@@ -14,12 +14,18 @@ import (
 
 // configuration of a dahu job
 type Job struct {
-	Id              []byte         `json:"id"`              // id of the job
-	Name            string         `json:"name"`            // simple label used for display
-	GitConf         GitConfig      `json:"gitConfig"`       // repository configuration
-	Steps           []Step         `json:"steps"`           // job steps execution
-	Executions      []JobExecution `json:"executions"`      // list of past executions that are still available
-	RemoveWorkspace bool           `json:"removeWorkspace"` // if true, the workspace is removed after every execution of the job
+	Id              []byte              `json:"id"`              // id of the job
+	Name            string              `json:"name"`            // simple label used for display
+	GitConf         GitConfig           `json:"gitConfig"`       // repository configuration
+	Steps           []Step              `json:"steps"`           // job steps execution
+	Executions      []JobExecution      `json:"executions"`      // list of past executions that are still available
+	RemoveWorkspace bool                `json:"removeWorkspace"` // if true, the workspace is removed after every execution of the job
+	MailRecipients  []string            `json:"mailRecipients"`  // mail addresses notified when the job breaks or is fixed
+	Notifiers       []Notifier          `json:"notifiers"`       // containers run once the outcome of an execution is known
+	CommitStatus    *CommitStatusConfig `json:"commitStatus"`    // optional configuration of the commit status reporting
+	Grants          map[string]Role     `json:"grants"`          // optional role by login. When set, only the listed users (and admins) have access to the job
+	TeamId          string              `json:"teamId"`          // team owning the job. Empty for a job visible by everyone
+	Retention       *RetentionPolicy    `json:"retention"`       // optional retention of the executions. The global one is used when nil
 }
 
 func (j *Job) GenerateId() error {
@@ -34,6 +40,9 @@ func (j *Job) IsValid() bool {
 	if j.Name == "" || !j.GitConf.IsValid() {
 		return false
 	}
+	if j.Retention != nil && !j.Retention.IsValid() {
+		return false
+	}
 	return true
 }
 
@@ -41,8 +50,20 @@ func (j *Job) String() string {
 	return fmt.Sprintf("{Id:%s, Name:%s}", j.Id, j.Name)
 }
 
+// return the role that a user with the
+// given login and global role has on this job
+func (j *Job) RoleOf(login string, role Role) Role {
+	if role == RoleAdmin || len(j.Grants) == 0 {
+		return role
+	}
+	return j.Grants[login]
+}
+
 func (j *Job) ToPublicModel() {
 	j.GitConf.ToPublicModel()
+	if j.CommitStatus != nil {
+		j.CommitStatus.ToPublicModel()
+	}
 }
 
 // step of a Job. It is defined
@@ -76,6 +97,18 @@ func (s Step) ComputeEnvs() map[string]string {
 	return res
 }
 
+// Notifier is a container run at the end of each
+// execution of a job, once its outcome is known. It
+// allows to plug any notification system (chat, mail, etc...)
+// without changing Dahu. The summary of the execution is given
+// to the container through env variables and a json file.
+type Notifier struct {
+	Name    string
+	Image   Image
+	Envs    map[string]string
+	Command []string
+}
+
 // Service that may needed for
 // some step (for example integration tests).
 // A name, the image and exposed port have to
@@ -126,15 +159,39 @@ const (
 	Canceled ExecutionStatus = "canceled"
 )
 
+// true for the statuses above
+func (s ExecutionStatus) IsValid() bool {
+	return s == Pending || s == Running || s == Success || s == Failure || s == Canceled
+}
+
 // contains everything related to
 // one particular execution of a Job
 type JobExecution struct {
 	Id         string // the id of this execution Job. Used to update on particular execution
 	BranchName string
+	CommitSha  string           // the sha of the built commit
 	VolumeName string           // the name of the volume where the workspace is stored
 	Steps      []*StepExecution // execution of step related to that job execution
 	Date       time.Time        // the instant when the job execution has start
 	Duration   time.Duration    // global duration of the job execution
+	Status     ExecutionStatus  // status of the whole execution
+}
+
+// IsFinished return true when the execution
+// has reached a terminal status
+func (j *JobExecution) IsFinished() bool {
+	return j.Status == Success || j.Status == Failure || j.Status == Canceled
+}
+
+// FailedStep return the first step execution
+// in failure, or nil if there is none
+func (j *JobExecution) FailedStep() *StepExecution {
+	for _, step := range j.Steps {
+		if step.Status == Failure {
+			return step
+		}
+	}
+	return nil
 }
 
 func (j *JobExecution) GenerateId() error {
@@ -151,9 +208,30 @@ type StepExecution struct {
 	Name     string
 	Status   ExecutionStatus // status of the step execution
 	Duration time.Duration   // global duration of the step execution
-	Logs     string          // logs attached to the step
+	LogLines int             // number of log lines of the step. The lines themselves are stored apart
 }
 
 func (e StepExecution) IsSuccess() bool {
 	return e.Status == Success
 }
+
+// criteria of a search in the executions of a job.
+// Empty criteria match every execution.
+type ExecutionFilter struct {
+	Status ExecutionStatus
+	Branch string
+	Offset int // number of matching executions skipped, newest first
+	Limit  int // no limit when <= 0
+}
+
+func (f ExecutionFilter) Matches(e *JobExecution) bool {
+	return (f.Status == "" || e.Status == f.Status) &&
+		(f.Branch == "" || e.BranchName == f.Branch)
+}
+
+// aggregates over the executions of a job
+type ExecutionStats struct {
+	Count           int                     `json:"count"`
+	ByStatus        map[ExecutionStatus]int `json:"byStatus"`
+	AverageDuration time.Duration           `json:"averageDuration"` // of the successful executions. Zero without any
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// stream on which a log
+// line has been written
+type LogStream string
+
+const (
+	Stdout LogStream = "stdout"
+	Stderr LogStream = "stderr"
+)
+
+// LogLine is one line of the logs
+// of a step execution.
+type LogLine struct {
+	Number  int       `json:"number"` // position of the line in the step logs, starting at 1
+	Time    time.Time `json:"time"`   // the instant when the line has been received
+	Stream  LogStream `json:"stream"`
+	Content string    `json:"content"`
+}
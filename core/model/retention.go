@@ -0,0 +1,54 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// rules telling which executions of a job are kept. An execution
+// is kept as soon as one rule keeps it, and a zero rule keeps
+// nothing : the zero policy doesn't purge anything. Unfinished
+// executions are always kept.
+type RetentionPolicy struct {
+	KeepLast        int  `json:"keepLast"`        // number of most recent executions kept
+	KeepDays        int  `json:"keepDays"`        // executions younger than this number of days are kept
+	KeepLastSuccess bool `json:"keepLastSuccess"` // the most recent successful execution is kept
+}
+
+func (p RetentionPolicy) IsValid() bool {
+	return p.KeepLast >= 0 && p.KeepDays >= 0
+}
+
+// true when the policy never purges anything
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLast == 0 && p.KeepDays == 0
+}
+
+// return the executions that are not kept by the
+// policy at the instant now, newest first
+func (p RetentionPolicy) Expired(executions []*JobExecution, now time.Time) []*JobExecution {
+	expired := make([]*JobExecution, 0)
+	if p.IsZero() {
+		return expired
+	}
+	sorted := make([]*JobExecution, len(executions))
+	copy(sorted, executions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	limit := now.AddDate(0, 0, -p.KeepDays)
+	lastSuccessFound := false
+	for index, execution := range sorted {
+		kept := !execution.IsFinished() ||
+			index < p.KeepLast ||
+			(p.KeepDays > 0 && execution.Date.After(limit)) ||
+			(p.KeepLastSuccess && !lastSuccessFound && execution.Status == Success)
+		if execution.Status == Success {
+			lastSuccessFound = true
+		}
+		if !kept {
+			expired = append(expired, execution)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,74 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// five finished executions, one per day, the newest
+// first. Only the fourth one is successful.
+func executionsHistory(now time.Time) []*model.JobExecution {
+	executions := make([]*model.JobExecution, 0)
+	for i := 0; i < 5; i++ {
+		execution := &model.JobExecution{Id: string(rune('a' + i)), Date: now.AddDate(0, 0, -i), Status: model.Failure}
+		if i == 3 {
+			execution.Status = model.Success
+		}
+		executions = append(executions, execution)
+	}
+	return executions
+}
+
+func expiredIds(expired []*model.JobExecution) string {
+	res := ""
+	for _, execution := range expired {
+		res += execution.Id
+	}
+	return res
+}
+
+func TestRetentionPolicyKeepLast(t *testing.T) {
+	// given
+	now := time.Now()
+	p := model.RetentionPolicy{KeepLast: 2}
+
+	// when
+	expired := p.Expired(executionsHistory(now), now)
+
+	// then
+	if expiredIds(expired) != "cde" {
+		t.Errorf("expect the executions c, d and e to be expired, but got %s", expiredIds(expired))
+	}
+}
+
+func TestRetentionPolicyKeepDaysAndLastSuccess(t *testing.T) {
+	// given
+	now := time.Now()
+	p := model.RetentionPolicy{KeepDays: 2, KeepLastSuccess: true}
+	executions := executionsHistory(now)
+	executions = append(executions, &model.JobExecution{Id: "r", Date: now.AddDate(0, 0, -10), Status: model.Running})
+
+	// when
+	expired := p.Expired(executions, now)
+
+	// then
+	if expiredIds(expired) != "ce" {
+		t.Errorf("expect the executions c and e to be expired, but got %s", expiredIds(expired))
+	}
+}
+
+func TestZeroRetentionPolicyKeepsEverything(t *testing.T) {
+	// given
+	now := time.Now()
+	p := model.RetentionPolicy{KeepLastSuccess: true}
+
+	// when
+	expired := p.Expired(executionsHistory(now), now)
+
+	// then
+	if len(expired) != 0 {
+		t.Errorf("expect no execution to be expired, but got %s", expiredIds(expired))
+	}
+}
@@ -0,0 +1,48 @@
+package model
+
+// role of a user. Each role
+// includes the previous ones.
+type Role string
+
+const (
+	RoleViewer     Role = "viewer"     // may only read jobs, executions and registries
+	RoleMaintainer Role = "maintainer" // may also create, modify and run jobs and registries
+	RoleAdmin      Role = "admin"      // may do everything, including users management
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleMaintainer:
+		return 2
+	case RoleAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// true if r is one of the known roles
+func (r Role) IsValid() bool {
+	return r.level() > 0
+}
+
+// true if r grants at least
+// the same rights as other.
+func (r Role) Includes(other Role) bool {
+	return r.IsValid() && r.level() >= other.level()
+}
+
+// highest of the roles given to the groups by
+// groupRoles. Empty when none of the groups has a role.
+func RoleOfGroups(groups []string, groupRoles map[string]string) Role {
+	var res Role
+	for _, group := range groups {
+		role := Role(groupRoles[group])
+		if role.IsValid() && !res.Includes(role) {
+			res = role
+		}
+	}
+	return res
+}
@@ -0,0 +1,75 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// test the hierarchy of roles
+func TestRoleIncludes(t *testing.T) {
+	if !model.RoleAdmin.Includes(model.RoleMaintainer) || !model.RoleMaintainer.Includes(model.RoleViewer) {
+		t.Fatal("expect each role to include the previous ones")
+	}
+	if model.RoleViewer.Includes(model.RoleMaintainer) {
+		t.Fatal("expect viewer to not include maintainer")
+	}
+	if model.Role("").Includes(model.RoleViewer) || model.Role("root").Includes(model.RoleViewer) {
+		t.Fatal("expect unknown roles to include nothing")
+	}
+}
+
+// test that a job without grants rely on the global role
+func TestJobRoleOfWithoutGrants(t *testing.T) {
+	// given
+	job := model.Job{Name: "test"}
+
+	// when
+	role := job.RoleOf("bob", model.RoleViewer)
+
+	// then
+	if role != model.RoleViewer {
+		t.Fatalf("expect the global role to be used, but got %s", role)
+	}
+}
+
+// test that the grants of a job override the global role,
+// except for admins
+func TestJobRoleOfWithGrants(t *testing.T) {
+	// given
+	job := model.Job{Name: "test", Grants: map[string]model.Role{"bob": model.RoleMaintainer}}
+
+	// when
+	bobRole := job.RoleOf("bob", model.RoleViewer)
+	aliceRole := job.RoleOf("alice", model.RoleMaintainer)
+	adminRole := job.RoleOf("admin", model.RoleAdmin)
+
+	// then
+	if bobRole != model.RoleMaintainer {
+		t.Fatalf("expect bob to be maintainer of the job, but got %s", bobRole)
+	}
+	if aliceRole.IsValid() {
+		t.Fatalf("expect alice to have no role on the job, but got %s", aliceRole)
+	}
+	if adminRole != model.RoleAdmin {
+		t.Fatalf("expect admin to stay admin, but got %s", adminRole)
+	}
+}
+
+// test that the highest role of the groups wins
+func TestRoleOfGroups(t *testing.T) {
+	// given
+	groupRoles := map[string]string{"devs": "maintainer", "ops": "admin", "typo": "root"}
+
+	// when
+	role := model.RoleOfGroups([]string{"devs", "ops", "others"}, groupRoles)
+	noRole := model.RoleOfGroups([]string{"typo", "others"}, groupRoles)
+
+	// then
+	if role != model.RoleAdmin {
+		t.Fatalf("expect the admin role, but got %s", role)
+	}
+	if noRole != "" {
+		t.Fatalf("expect no role, but got %s", noRole)
+	}
+}
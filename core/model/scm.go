@@ -43,6 +43,17 @@ type GitConfig struct {
 	SshAuth  *SshAuthConfig  `json:"sshAuth"`
 }
 
+// Url return the url of the
+// configured repository
+func (g GitConfig) Url() string {
+	if g.HttpAuth != nil {
+		return g.HttpAuth.Url
+	} else if g.SshAuth != nil {
+		return g.SshAuth.Url
+	}
+	return ""
+}
+
 // todo test me
 func (g GitConfig) IsValid() bool {
 	if (g.HttpAuth == nil && g.SshAuth == nil) || (g.HttpAuth != nil && g.SshAuth != nil) {
@@ -64,3 +75,16 @@ func (g *GitConfig) ToPublicModel() {
 		g.SshAuth.ToPublicModel()
 	}
 }
+
+// configuration of the commit status reporting
+// of a job. Empty fields fallback on the global
+// configuration.
+type CommitStatusConfig struct {
+	Provider string `json:"provider"` // github, gitlab or gitea
+	ApiUrl   string `json:"apiUrl"`   // base url of the forge api
+	Token    string `json:"token"`
+}
+
+func (c *CommitStatusConfig) ToPublicModel() {
+	c.Token = ""
+}
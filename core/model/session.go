@@ -0,0 +1,44 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// a session is opened by a successful login and
+// lives as long as its refresh token is valid.
+// The refresh token changes on every use.
+type Session struct {
+	Id        string    `json:"id"`
+	Login     string    `json:"login"` // login of the owner of the session
+	Hash      string    `json:"hash"`  // sha256 of the current refresh token value
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// body of a refresh request
+type Refresh struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (s *Session) GenerateId() error {
+	id, err := generateId([]byte(s.Id))
+	if err == nil {
+		s.Id = string(id)
+	}
+	return err
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("{Id:%s, Login:%s}", s.Id, s.Login)
+}
+
+func (s *Session) IsExpired(now time.Time) bool {
+	return now.After(s.ExpiresAt)
+}
+
+// generate a new random refresh
+// token value and return it with its hash.
+func NewRefreshTokenValue() (value string, hash string, err error) {
+	return newTokenValue("")
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// key signing the access tokens issued by Dahu. Only
+// the current key signs, the retired ones are kept to
+// check the tokens they signed until these expire.
+type SigningKey struct {
+	Id        string    `json:"id"`        // kid header of the tokens signed by the key
+	Algorithm string    `json:"algorithm"` // HS256, RS256 or ES256
+	Secret    []byte    `json:"secret"`    // HMAC secret, or private key in PKCS #8 form
+	CreatedAt time.Time `json:"createdAt"`
+	RetiredAt time.Time `json:"retiredAt"` // zero for the current key
+}
+
+func (k *SigningKey) GenerateId() error {
+	id, err := generateId([]byte(k.Id))
+	if err == nil {
+		k.Id = string(id)
+	}
+	return err
+}
+
+func (k *SigningKey) String() string {
+	return fmt.Sprintf("{Id:%s, Algorithm:%s}", k.Id, k.Algorithm)
+}
+
+func (k *SigningKey) IsRetired() bool {
+	return !k.RetiredAt.IsZero()
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// prefix of a step env value that references
+// a secret of the team owning the job.
+const SecretReferencePrefix = "secret:"
+
+// a team (or project) groups users and
+// owns jobs, docker registries and secrets.
+type Team struct {
+	Id      string            `json:"id"`
+	Name    string            `json:"name"`
+	Members map[string]Role   `json:"members"` // role of each member inside the team, by login
+	Secrets map[string]string `json:"secrets"` // secrets usable by the jobs of the team. Values are removed by ToPublicModel
+}
+
+// body of a request that moves
+// a resource to another team
+type TeamChange struct {
+	TeamId string `json:"teamId"` // empty to make the resource global
+}
+
+func (t *Team) GenerateId() error {
+	id, err := generateId([]byte(t.Id))
+	if err == nil {
+		t.Id = string(id)
+	}
+	return err
+}
+
+func (t *Team) IsValid() bool {
+	if t.Name == "" {
+		return false
+	}
+	for _, role := range t.Members {
+		if !role.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Team) String() string {
+	return fmt.Sprintf("{Id:%s, Name:%s}", t.Id, t.Name)
+}
+
+// hide the secrets values, but
+// keep their names.
+func (t *Team) ToPublicModel() {
+	for name := range t.Secrets {
+		t.Secrets[name] = ""
+	}
+}
+
+// return the role that a user with the given
+// login and global role has inside the team.
+func (t *Team) RoleOf(login string, role Role) Role {
+	if role == RoleAdmin {
+		return role
+	}
+	return t.Members[login]
+}
+
+// take the secrets values of the existing version of the
+// team when they are left empty, as the public model does.
+func (t *Team) MergeSecrets(existing *Team) {
+	for name, value := range t.Secrets {
+		if value == "" {
+			t.Secrets[name] = existing.Secrets[name]
+		}
+	}
+}
+
+// replace in the steps envs every
+// reference to a secret of the team by its value
+func (t *Team) ResolveSecrets(job *Job) error {
+	for _, step := range job.Steps {
+		for key, value := range step.Envs {
+			if !strings.HasPrefix(value, SecretReferencePrefix) {
+				continue
+			}
+			name := strings.TrimPrefix(value, SecretReferencePrefix)
+			secret, ok := t.Secrets[name]
+			if !ok {
+				return fmt.Errorf("the secret %s used by the step %s doesn't exist in team %s", name, step.Name, t.Name)
+			}
+			step.Envs[key] = secret
+		}
+	}
+	return nil
+}
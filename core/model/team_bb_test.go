@@ -0,0 +1,76 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// test that the references to secrets
+// are replaced by their values
+func TestTeamResolveSecrets(t *testing.T) {
+	// given
+	team := model.Team{Name: "test", Secrets: map[string]string{"token": "s3cr3t"}}
+	job := model.Job{Steps: []model.Step{{Name: "deploy", Envs: map[string]string{"TOKEN": "secret:token", "ENV": "prod"}}}}
+
+	// when
+	err := team.ResolveSecrets(&job)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if job.Steps[0].Envs["TOKEN"] != "s3cr3t" || job.Steps[0].Envs["ENV"] != "prod" {
+		t.Fatalf("expect only the secret reference to be replaced, but got %+v", job.Steps[0].Envs)
+	}
+}
+
+// test that a reference to an unknown secret is an error
+func TestTeamResolveUnknownSecret(t *testing.T) {
+	// given
+	team := model.Team{Name: "test"}
+	job := model.Job{Steps: []model.Step{{Name: "deploy", Envs: map[string]string{"TOKEN": "secret:token"}}}}
+
+	// when
+	err := team.ResolveSecrets(&job)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error when using an unknown secret")
+	}
+}
+
+// test that the public model hides the secrets
+// values and that they can be merged back
+func TestTeamSecretsPublicModelAndMerge(t *testing.T) {
+	// given
+	existing := model.Team{Name: "test", Secrets: map[string]string{"token": "s3cr3t", "old": "value"}}
+	update := model.Team{Name: "test", Secrets: map[string]string{"token": "s3cr3t"}}
+	update.ToPublicModel()
+	update.Secrets["new"] = "other"
+
+	// when
+	update.MergeSecrets(&existing)
+
+	// then
+	if len(update.Secrets) != 2 || update.Secrets["token"] != "s3cr3t" || update.Secrets["new"] != "other" {
+		t.Fatalf("expect the hidden secret to be restored and the removed one to be dropped, but got %+v", update.Secrets)
+	}
+}
+
+// test the role of users inside a team
+func TestTeamRoleOf(t *testing.T) {
+	// given
+	team := model.Team{Name: "test", Members: map[string]model.Role{"bob": model.RoleMaintainer}}
+
+	// then
+	if team.RoleOf("bob", model.RoleViewer) != model.RoleMaintainer {
+		t.Fatal("expect bob to be maintainer inside the team")
+	}
+	if team.RoleOf("alice", model.RoleMaintainer).IsValid() {
+		t.Fatal("expect alice to have no role inside the team")
+	}
+	if team.RoleOf("root", model.RoleAdmin) != model.RoleAdmin {
+		t.Fatal("expect admins to be admin of every team")
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 var regexPassword *regexp.Regexp = regexp.MustCompile(".{12}")
 
+// source of the users created by the LDAP authentication
+// on their first login. The local users have none
+const LdapSource = "ldap"
+
 // type used for authentication
 // on /login
 type Login struct {
@@ -17,24 +22,85 @@ type Login struct {
 	Password string `json:"password"`
 }
 
+// record of a login attempt,
+// kept for auditing
+type LoginAttempt struct {
+	Id      string    `json:"id"`
+	Login   string    `json:"login"`
+	Ip      string    `json:"ip"`
+	Success bool      `json:"success"`
+	Reason  string    `json:"reason,omitempty"` // cause of the failure
+	Time    time.Time `json:"time"`
+}
+
 // this is the answer to
 // a successfull login call
 type Token struct {
-	Value string `json:"value"`
+	Value                  string    `json:"value"`
+	ExpiresAt              time.Time `json:"expiresAt"`              // expiration of the value. A new one must be asked with the refresh token
+	RefreshToken           string    `json:"refreshToken"`           // single use token that allows to get a new pair of tokens
+	PasswordChangeRequired bool      `json:"passwordChangeRequired"` // when true, every call but the password change is rejected
 }
 
 // User of Dahu system.
 // A user can be a human, or not.
 // It represents only an identity.
 type User struct {
+	Login              string `json:"login"`
+	Password           []byte `json:"password,omitempty"`   // bcrypt hash. Removed by ToPublicModel
+	Role               Role   `json:"role"`                 // global role of the user, viewer when empty
+	Disabled           bool   `json:"disabled"`             // a disabled user can't log in anymore
+	MustChangePassword bool   `json:"mustChangePassword"`   // true until the user has changed its password himself
+	SessionsRevokedAt  int64  `json:"sessionsRevokedAt"`    // unix time in milliseconds before which every token issued for the user is rejected
+	SsoIssuer          string `json:"ssoIssuer,omitempty"`  // OpenID Connect provider of the identity bound to the user
+	SsoSubject         string `json:"ssoSubject,omitempty"` // sub claim of this identity. Only it may log in as the user by single sign-on
+	Source             string `json:"source,omitempty"`     // LdapSource for a directory user. Only the directory may log in as it
+}
+
+// body of a user creation
+// request
+type UserCreation struct {
 	Login    string `json:"login"`
-	Password []byte // unexported and excluded from json marshaling
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+// body of a user update request. Only
+// the fields that are set are updated.
+type UserUpdate struct {
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+	Disabled *bool  `json:"disabled"`
+	// sub claim of the identity, at the configured OpenID Connect
+	// provider, bound to the user. Empty to unbind it
+	SsoSubject *string `json:"ssoSubject"`
+}
+
+// body of a self-service
+// password change request
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
 }
 
 func (u *User) String() string {
 	return fmt.Sprintf("{Login:%s}", u.Login)
 }
 
+func (u *User) ToPublicModel() {
+	u.Password = nil
+}
+
+// return the role of the user,
+// viewer being the default one.
+func (u *User) GetRole() Role {
+	if u.Role == "" {
+		return RoleViewer
+	}
+	return u.Role
+}
+
 // hash and affect a new password
 func (u *User) SetPassword(password []byte) error {
 	if !regexPassword.Match(password) {
@@ -48,6 +114,20 @@ func (u *User) SetPassword(password []byte) error {
 	return nil
 }
 
+// true when the single sign-on of the
+// given identity logs in as the user
+func (u *User) IsBoundTo(issuer, subject string) bool {
+	return u.SsoSubject != "" && u.SsoIssuer == issuer && u.SsoSubject == subject
+}
+
 func (u *User) ComparePassword(password []byte) error {
 	return bcrypt.CompareHashAndPassword(u.Password, password)
 }
+
+func (a *LoginAttempt) GenerateId() error {
+	id, err := generateId([]byte(a.Id))
+	if err == nil {
+		a.Id = string(id)
+	}
+	return err
+}
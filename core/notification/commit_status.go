@@ -0,0 +1,222 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// supported forges
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+	Gitea  = "gitea"
+)
+
+// match http(s)://host[:port]/owner/repo[.git], ssh://user@host[:port]/owner/repo[.git]
+// and user@host:owner/repo[.git]
+var repositoryUrlRegexp = regexp.MustCompile(`^(?:(https?)://(?:[^@/]+@)?([^/]+)/|ssh://(?:[^@/]+@)?([^/:]+)(?::\d+)?/|(?:[^@/]+@)?([^/:]+):)(.+?)(?:\.git)?/?$`)
+
+// the statuses are posted synchronously at the end of
+// the executions, a forge that hangs must not stall them
+var forgeClient = &http.Client{Timeout: 10 * time.Second}
+
+// repository hosted on a forge
+type repository struct {
+	scheme string
+	host   string
+	path   string // owner/repo
+}
+
+func parseRepositoryUrl(rawUrl string) (repository, error) {
+	matches := repositoryUrlRegexp.FindStringSubmatch(strings.TrimSpace(rawUrl))
+	if matches == nil {
+		return repository{}, fmt.Errorf("notification >> unable to parse the repository url %s", rawUrl)
+	}
+	repo := repository{scheme: "https", path: matches[5]}
+	if matches[1] != "" {
+		repo.scheme = matches[1]
+		repo.host = matches[2]
+	} else if matches[3] != "" {
+		repo.host = matches[3]
+	} else {
+		repo.host = matches[4]
+	}
+	return repo, nil
+}
+
+// commitStatusSettings merge the job configuration
+// with the global one
+func commitStatusSettings(conf *configuration.Conf, job model.Job) configuration.CommitStatus {
+	res := conf.CommitStatusConf
+	if job.CommitStatus != nil {
+		if job.CommitStatus.Provider != "" {
+			res.Provider = job.CommitStatus.Provider
+		}
+		if job.CommitStatus.ApiUrl != "" {
+			res.ApiUrl = job.CommitStatus.ApiUrl
+		}
+		if job.CommitStatus.Token != "" {
+			res.Token = job.CommitStatus.Token
+		}
+	}
+	return res
+}
+
+// guessProvider deduce the forge from
+// the host of the repository
+func guessProvider(repo repository) string {
+	host := strings.Split(repo.host, ":")[0]
+	switch {
+	case host == "github.com":
+		return GitHub
+	case strings.Contains(host, "gitlab"):
+		return GitLab
+	case strings.Contains(host, "gitea"):
+		return Gitea
+	default:
+		return ""
+	}
+}
+
+// defaultApiUrl return the base url of
+// the api of the given forge
+func defaultApiUrl(provider string, repo repository) string {
+	switch provider {
+	case GitHub:
+		if repo.host == "github.com" {
+			return "https://api.github.com"
+		}
+		return fmt.Sprintf("%s://%s/api/v3", repo.scheme, repo.host)
+	case GitLab:
+		return fmt.Sprintf("%s://%s/api/v4", repo.scheme, repo.host)
+	default:
+		return fmt.Sprintf("%s://%s/api/v1", repo.scheme, repo.host)
+	}
+}
+
+// return the state of a commit
+// status as expected by the forge
+func commitState(provider string, status model.ExecutionStatus) string {
+	switch status {
+	case model.Success:
+		return "success"
+	case model.Failure:
+		if provider == GitLab {
+			return "failed"
+		}
+		return "failure"
+	case model.Canceled:
+		if provider == GitLab {
+			return "canceled"
+		}
+		return "error"
+	default:
+		return "pending"
+	}
+}
+
+func commitDescription(status model.ExecutionStatus) string {
+	switch status {
+	case model.Success:
+		return "The Dahu build succeeded"
+	case model.Failure:
+		return "The Dahu build failed"
+	case model.Canceled:
+		return "The Dahu build has been canceled"
+	default:
+		return "The Dahu build is in progress"
+	}
+}
+
+// CommitStatusEnabled tells whether the statuses of the
+// given job are reported to its forge, either by the job
+// configuration or by the global one.
+func CommitStatusEnabled(conf *configuration.Conf, job model.Job) bool {
+	settings := commitStatusSettings(conf, job)
+	return settings.Token != "" || settings.ApiUrl != ""
+}
+
+// PostCommitStatus report the status of the execution as a commit
+// status of the built commit to the forge hosting the job repository.
+// Nothing is done when no token is configured, or when the
+// commit is still unknown.
+func PostCommitStatus(ctx context.Context, conf *configuration.Conf, job model.Job, execution *model.JobExecution) error {
+	settings := commitStatusSettings(conf, job)
+	if settings.Token == "" || execution.CommitSha == "" {
+		return nil
+	}
+	repo, err := parseRepositoryUrl(job.GitConf.Url())
+	if err != nil {
+		return err
+	}
+	provider := settings.Provider
+	if provider == "" {
+		provider = guessProvider(repo)
+	}
+	if provider != GitHub && provider != GitLab && provider != Gitea {
+		return fmt.Errorf("notification >> unable to find the forge of %s. Please configure the provider", job.GitConf.Url())
+	}
+	apiUrl := settings.ApiUrl
+	if apiUrl == "" {
+		apiUrl = defaultApiUrl(provider, repo)
+	}
+	apiUrl = strings.TrimSuffix(apiUrl, "/")
+
+	state := commitState(provider, execution.Status)
+	link := ExecutionLink(conf, string(job.Id), execution.Id)
+	statusContext := fmt.Sprintf("dahu/%s", job.Name)
+	var endpoint string
+	var payload map[string]string
+	if provider == GitLab {
+		endpoint = fmt.Sprintf("%s/projects/%s/statuses/%s", apiUrl, url.PathEscape(repo.path), execution.CommitSha)
+		payload = map[string]string{
+			"state":       state,
+			"target_url":  link,
+			"description": commitDescription(execution.Status),
+			"name":        statusContext,
+		}
+	} else {
+		endpoint = fmt.Sprintf("%s/repos/%s/statuses/%s", apiUrl, repo.path, execution.CommitSha)
+		payload = map[string]string{
+			"state":       state,
+			"target_url":  link,
+			"description": commitDescription(execution.Status),
+			"context":     statusContext,
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if provider == GitLab {
+		req.Header.Set("PRIVATE-TOKEN", settings.Token)
+	} else {
+		req.Header.Set("Authorization", "token "+settings.Token)
+	}
+	resp, err := forgeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("notification >> the %s api answered %d when posting the commit status", provider, resp.StatusCode))
+	}
+	return nil
+}
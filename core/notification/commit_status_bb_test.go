@@ -0,0 +1,191 @@
+package notification_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/notification"
+)
+
+// request received by the
+// forge stand-in
+type forgeRequest struct {
+	path    string
+	headers http.Header
+	body    map[string]string
+}
+
+func startFakeForge(status int) (*httptest.Server, chan forgeRequest) {
+	requests := make(chan forgeRequest, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := forgeRequest{path: r.URL.EscapedPath(), headers: r.Header}
+		json.NewDecoder(r.Body).Decode(&req.body)
+		requests <- req
+		w.WriteHeader(status)
+	}))
+	return s, requests
+}
+
+func commitStatusJob(provider, apiUrl string) model.Job {
+	httpAuth := model.HttpAuthConfig{Url: "https://forge.test.com/team/project.git"}
+	return model.Job{
+		Id:           []byte("42"),
+		Name:         "test",
+		GitConf:      model.GitConfig{HttpAuth: &httpAuth},
+		CommitStatus: &model.CommitStatusConfig{Provider: provider, ApiUrl: apiUrl, Token: "some-token"},
+	}
+}
+
+func TestPostCommitStatusGitHub(t *testing.T) {
+	// given
+	s, requests := startFakeForge(http.StatusCreated)
+	defer s.Close()
+	conf := configuration.InitConf()
+	conf.ApiConf.ExternalUrl = "http://dahu.test.com"
+	job := commitStatusJob(notification.GitHub, s.URL)
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Failure}
+
+	// when
+	err := notification.PostCommitStatus(context.Background(), conf, job, execution)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when posting the status, but got %s", err.Error())
+	}
+	req := <-requests
+	if req.path != "/repos/team/project/statuses/abc123" {
+		t.Fatalf("expect the status to be posted on the commit, but got path %s", req.path)
+	}
+	if req.headers.Get("Authorization") != "token some-token" {
+		t.Fatalf("expect the token to be sent, but got %s", req.headers.Get("Authorization"))
+	}
+	if req.body["state"] != "failure" || req.body["context"] != "dahu/test" {
+		t.Fatalf("expect a failure status for dahu/test, but got %+v", req.body)
+	}
+	if req.body["target_url"] != "http://dahu.test.com/jobs/42/executions/2" {
+		t.Fatalf("expect a link to the execution, but got %s", req.body["target_url"])
+	}
+}
+
+func TestPostCommitStatusGitLab(t *testing.T) {
+	// given
+	s, requests := startFakeForge(http.StatusCreated)
+	defer s.Close()
+	conf := configuration.InitConf()
+	job := commitStatusJob(notification.GitLab, s.URL)
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Running}
+
+	// when
+	err := notification.PostCommitStatus(context.Background(), conf, job, execution)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when posting the status, but got %s", err.Error())
+	}
+	req := <-requests
+	if req.path != "/projects/team%2Fproject/statuses/abc123" {
+		t.Fatalf("expect the status to be posted on the commit, but got path %s", req.path)
+	}
+	if req.headers.Get("PRIVATE-TOKEN") != "some-token" {
+		t.Fatalf("expect the token to be sent, but got %s", req.headers.Get("PRIVATE-TOKEN"))
+	}
+	if req.body["state"] != "pending" || req.body["name"] != "dahu/test" {
+		t.Fatalf("expect a pending status for dahu/test, but got %+v", req.body)
+	}
+}
+
+func TestPostCommitStatusGiteaWithGlobalConf(t *testing.T) {
+	// given
+	s, requests := startFakeForge(http.StatusCreated)
+	defer s.Close()
+	conf := configuration.InitConf()
+	conf.CommitStatusConf = configuration.CommitStatus{Provider: notification.Gitea, ApiUrl: s.URL, Token: "global-token"}
+	job := commitStatusJob("", "")
+	job.CommitStatus = nil
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Success}
+
+	// when
+	err := notification.PostCommitStatus(context.Background(), conf, job, execution)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when posting the status, but got %s", err.Error())
+	}
+	req := <-requests
+	if req.headers.Get("Authorization") != "token global-token" {
+		t.Fatalf("expect the global token to be sent, but got %s", req.headers.Get("Authorization"))
+	}
+	if req.body["state"] != "success" {
+		t.Fatalf("expect a success status, but got %+v", req.body)
+	}
+}
+
+func TestPostCommitStatusForgeError(t *testing.T) {
+	// given
+	s, _ := startFakeForge(http.StatusUnauthorized)
+	defer s.Close()
+	conf := configuration.InitConf()
+	job := commitStatusJob(notification.GitHub, s.URL)
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Success}
+
+	// when
+	err := notification.PostCommitStatus(context.Background(), conf, job, execution)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error when the forge reject the status, but got nil")
+	}
+}
+
+func TestPostCommitStatusWithoutToken(t *testing.T) {
+	// given
+	s, requests := startFakeForge(http.StatusCreated)
+	defer s.Close()
+	conf := configuration.InitConf()
+	job := commitStatusJob(notification.GitHub, s.URL)
+	job.CommitStatus.Token = ""
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Success}
+
+	// when
+	err := notification.PostCommitStatus(context.Background(), conf, job, execution)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if len(requests) != 0 {
+		t.Fatal("expect no status to be posted without token")
+	}
+}
+
+func TestCommitStatusEnabled(t *testing.T) {
+	withToken := configuration.InitConf()
+	withToken.CommitStatusConf.Token = "some-token"
+	withApiUrl := configuration.InitConf()
+	withApiUrl.CommitStatusConf.ApiUrl = "https://forge.test.com/api/v1"
+	cases := []struct {
+		conf     *configuration.Conf
+		job      model.Job
+		expected bool
+	}{
+		{configuration.InitConf(), model.Job{}, false},
+		{configuration.InitConf(), model.Job{CommitStatus: &model.CommitStatusConfig{Provider: notification.GitHub}}, false},
+		{configuration.InitConf(), commitStatusJob(notification.GitHub, ""), true},
+		{withToken, model.Job{}, true},
+		{withApiUrl, model.Job{}, true},
+	}
+	for i, c := range cases {
+		// when
+		enabled := notification.CommitStatusEnabled(c.conf, c.job)
+
+		// then
+		if enabled != c.expected {
+			t.Fatalf("case %d : expect %t but got %t", i, c.expected, enabled)
+		}
+	}
+}
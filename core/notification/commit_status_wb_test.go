@@ -0,0 +1,81 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func TestParseRepositoryUrl(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected repository
+	}{
+		{"https://github.com/jeromedoucet/dahu.git", repository{"https", "github.com", "jeromedoucet/dahu"}},
+		{"http://user@gitea.local:3000/team/project", repository{"http", "gitea.local:3000", "team/project"}},
+		{"git@github.com:jeromedoucet/dahu.git", repository{"https", "github.com", "jeromedoucet/dahu"}},
+		{"ssh://git@gitlab.com:2222/group/sub-group/project.git", repository{"https", "gitlab.com", "group/sub-group/project"}},
+	}
+	for _, c := range cases {
+		// when
+		repo, err := parseRepositoryUrl(c.url)
+
+		// then
+		if err != nil {
+			t.Fatalf("expect to parse %s without error, but got %s", c.url, err.Error())
+		}
+		if repo != c.expected {
+			t.Fatalf("expect %s to be parsed as %+v, but got %+v", c.url, c.expected, repo)
+		}
+	}
+}
+
+func TestParseRepositoryUrlError(t *testing.T) {
+	// when
+	_, err := parseRepositoryUrl("not an url")
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error when parsing an invalid url, but got nil")
+	}
+}
+
+// test that a forge that never answers
+// doesn't stall the execution
+func TestPostCommitStatusTimeout(t *testing.T) {
+	// given
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer s.Close()
+	defer close(release)
+	timeout := forgeClient.Timeout
+	forgeClient.Timeout = 100 * time.Millisecond
+	defer func() { forgeClient.Timeout = timeout }()
+	httpAuth := model.HttpAuthConfig{Url: "https://forge.test.com/team/project.git"}
+	job := model.Job{
+		Id:           []byte("42"),
+		Name:         "test",
+		GitConf:      model.GitConfig{HttpAuth: &httpAuth},
+		CommitStatus: &model.CommitStatusConfig{Provider: GitHub, ApiUrl: s.URL, Token: "some-token"},
+	}
+	execution := &model.JobExecution{Id: "2", CommitSha: "abc123", Status: model.Success}
+	start := time.Now()
+
+	// when
+	err := PostCommitStatus(context.Background(), configuration.InitConf(), job, execution)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error when the forge doesn't answer, but got nil")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("expect the request to be aborted by the timeout, but it took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,163 @@
+// notification package is where Dahu tells the outside world about the
+// outcome of job executions. Unlike the websocket events of the job package,
+// notifications here are only sent on meaningful transitions, for instance
+// when a job that was working breaks, or when a broken job is fixed.
+package notification
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"text/template"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// transition of a job between
+// two executions
+type Transition string
+
+const (
+	Broken Transition = "broken" // success (or nothing) -> failure
+	Fixed  Transition = "fixed"  // failure -> success
+)
+
+const defaultSubjectTemplate = `[Dahu] {{.JobName}} on {{.Branch}} is {{.Transition}}`
+
+const defaultBodyTemplate = `Job {{.JobName}} on branch {{.Branch}} is {{.Transition}}.
+
+Execution {{.ExecutionId}} finished with status {{.Status}}.
+Details : {{.Link}}
+{{if .FailedStep}}
+Step "{{.FailedStep}}" has failed. Last lines of its logs :
+
+{{.LogTail}}
+{{end}}`
+
+// data available inside the subject
+// and body templates
+type mailData struct {
+	Transition  Transition
+	JobId       string
+	JobName     string
+	Branch      string
+	ExecutionId string
+	Status      model.ExecutionStatus
+	Link        string
+	FailedStep  string
+	LogTail     string
+}
+
+// ComputeTransition tells if current execution is a transition that worth a
+// notification regarding the previous finished execution of the same job.
+// previous may be nil when current is the first execution of the job.
+// Canceled executions are never considered as transitions.
+func ComputeTransition(previous *model.JobExecution, current *model.JobExecution) (Transition, bool) {
+	if current.Status == model.Failure && (previous == nil || previous.Status != model.Failure) {
+		return Broken, true
+	}
+	if current.Status == model.Success && previous != nil && previous.Status == model.Failure {
+		return Fixed, true
+	}
+	return "", false
+}
+
+// ExecutionLink return the url of the given
+// execution, based on the external url of Dahu
+func ExecutionLink(conf *configuration.Conf, jobId, executionId string) string {
+	return fmt.Sprintf("%s/jobs/%s/executions/%s", strings.TrimSuffix(conf.ApiConf.ExternalUrl, "/"), jobId, executionId)
+}
+
+// NotifyByMail send a mail to the job recipients if the current
+// execution is a transition regarding the previous one. Nothing is
+// done when smtp is not configured or when the job has no recipient.
+// failedStepLogs are the logs of the failed step, if any.
+func NotifyByMail(conf *configuration.Conf, job model.Job, previous *model.JobExecution, current *model.JobExecution, failedStepLogs []model.LogLine) error {
+	if conf.SmtpConf.Host == "" || len(job.MailRecipients) == 0 {
+		return nil
+	}
+	transition, notify := ComputeTransition(previous, current)
+	if !notify {
+		return nil
+	}
+	data := mailData{
+		Transition:  transition,
+		JobId:       string(job.Id),
+		JobName:     job.Name,
+		Branch:      current.BranchName,
+		ExecutionId: current.Id,
+		Status:      current.Status,
+		Link:        ExecutionLink(conf, string(job.Id), current.Id),
+	}
+	failedStep := current.FailedStep()
+	if failedStep != nil {
+		data.FailedStep = failedStep.Name
+		data.LogTail = logTail(failedStepLogs, conf.SmtpConf.LogTailSize)
+	}
+	subject, err := render(conf.SmtpConf.SubjectTemplate, defaultSubjectTemplate, data)
+	if err != nil {
+		return err
+	}
+	body, err := render(conf.SmtpConf.BodyTemplate, defaultBodyTemplate, data)
+	if err != nil {
+		return err
+	}
+	return sendMail(conf.SmtpConf, job.MailRecipients, subject, body)
+}
+
+// render execute the given template, or the
+// default one if empty, with data.
+func render(tmpl, defaultTmpl string, data mailData) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultTmpl
+	}
+	t, err := template.New("mail").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// logTail return the size last lines of logs
+func logTail(logs []model.LogLine, size int) string {
+	if size > 0 && len(logs) > size {
+		logs = logs[len(logs)-size:]
+	}
+	lines := make([]string, len(logs))
+	for i, line := range logs {
+		lines[i] = line.Content
+	}
+	return strings.Join(lines, "\n")
+}
+
+var subjectReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+func sendMail(conf configuration.Smtp, recipients []string, subject, body string) error {
+	if conf.From == "" {
+		return errors.New("notification >> no sender configured for mail notifications")
+	}
+	var auth smtp.Auth
+	if conf.User != "" {
+		auth = smtp.PlainAuth("", conf.User, conf.Password, conf.Host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	// a subject is one line only, a job name or a branch
+	// must not be able to add headers
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subjectReplacer.Replace(strings.TrimSpace(subject)))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	addr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+	return smtp.SendMail(addr, auth, conf.From, recipients, msg.Bytes())
+}
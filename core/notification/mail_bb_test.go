@@ -0,0 +1,269 @@
+package notification_test
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/notification"
+)
+
+// received mail on the fake smtp server
+type receivedMail struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// startFakeSmtpServer start a minimal smtp server on
+// localhost. Each received mail is sent on the returned chan.
+func startFakeSmtpServer(t *testing.T) (net.Listener, chan receivedMail) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expect to start the fake smtp server, but got %s", err.Error())
+	}
+	mails := make(chan receivedMail, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleSmtpConn(conn, mails)
+		}
+	}()
+	return l, mails
+}
+
+func handleSmtpConn(conn net.Conn, mails chan receivedMail) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(line string) {
+		w.WriteString(line + "\r\n")
+		w.Flush()
+	}
+	reply("220 localhost fake smtp")
+	var mail receivedMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			mail = receivedMail{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			mail.recipients = append(mail.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			mail.data = data.String()
+			mails <- mail
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func smtpConf(t *testing.T, l net.Listener) *configuration.Conf {
+	conf := configuration.InitConf()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	conf.SmtpConf.Host = host
+	conf.SmtpConf.Port, _ = strconv.Atoi(port)
+	conf.SmtpConf.From = "dahu@test.com"
+	conf.ApiConf.ExternalUrl = "http://dahu.test.com"
+	return conf
+}
+
+// test that a mail is sent with the failing step log tail
+// when a job that was working breaks.
+func TestNotifyByMailOnBrokenJob(t *testing.T) {
+	// given
+	l, mails := startFakeSmtpServer(t)
+	defer l.Close()
+	conf := smtpConf(t, l)
+	conf.SmtpConf.LogTailSize = 2
+	job := model.Job{Id: []byte("42"), Name: "test", MailRecipients: []string{"team@test.com"}}
+	previous := &model.JobExecution{Id: "1", Status: model.Success}
+	current := &model.JobExecution{Id: "2", BranchName: "master", Status: model.Failure, Steps: []*model.StepExecution{
+		&model.StepExecution{Name: "Code fetching", Status: model.Success},
+		&model.StepExecution{Name: "unit tests", Status: model.Failure, LogLines: 3},
+	}}
+	logs := []model.LogLine{
+		model.LogLine{Number: 1, Content: "first line"},
+		model.LogLine{Number: 2, Content: "second line"},
+		model.LogLine{Number: 3, Content: "third line"},
+	}
+
+	// when
+	err := notification.NotifyByMail(conf, job, previous, current, logs)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when sending the mail, but got %s", err.Error())
+	}
+	var mail receivedMail
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect a mail to have been received, but got none")
+	}
+	if mail.from != "dahu@test.com" {
+		t.Fatalf("expect the mail to be sent by %s but got %s", "dahu@test.com", mail.from)
+	}
+	if len(mail.recipients) != 1 || mail.recipients[0] != "team@test.com" {
+		t.Fatalf("expect the mail to be sent to %s but got %+v", "team@test.com", mail.recipients)
+	}
+	if !strings.Contains(mail.data, "Subject: [Dahu] test on master is broken") {
+		t.Fatalf("expect the mail to have the broken subject, but got %s", mail.data)
+	}
+	if !strings.Contains(mail.data, "http://dahu.test.com/jobs/42/executions/2") {
+		t.Fatalf("expect the mail to contains the execution link, but got %s", mail.data)
+	}
+	if !strings.Contains(mail.data, "second line\r\nthird line") || strings.Contains(mail.data, "first line") {
+		t.Fatalf("expect the mail to contains the two last lines of logs only, but got %s", mail.data)
+	}
+}
+
+// test that custom templates are used
+// when a job is fixed.
+func TestNotifyByMailOnFixedJobWithCustomTemplates(t *testing.T) {
+	// given
+	l, mails := startFakeSmtpServer(t)
+	defer l.Close()
+	conf := smtpConf(t, l)
+	conf.SmtpConf.SubjectTemplate = "{{.JobName}} {{.Transition}}"
+	conf.SmtpConf.BodyTemplate = "see {{.Link}}"
+	job := model.Job{Id: []byte("42"), Name: "test", MailRecipients: []string{"team@test.com"}}
+	previous := &model.JobExecution{Id: "1", Status: model.Failure}
+	current := &model.JobExecution{Id: "2", BranchName: "master", Status: model.Success}
+
+	// when
+	err := notification.NotifyByMail(conf, job, previous, current, nil)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when sending the mail, but got %s", err.Error())
+	}
+	var mail receivedMail
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect a mail to have been received, but got none")
+	}
+	if !strings.Contains(mail.data, "Subject: test fixed") {
+		t.Fatalf("expect the mail to have the custom subject, but got %s", mail.data)
+	}
+	if !strings.Contains(mail.data, "see http://dahu.test.com/jobs/42/executions/2") {
+		t.Fatalf("expect the mail to have the custom body, but got %s", mail.data)
+	}
+}
+
+// test that a branch holding a CRLF
+// can't add headers to the mail
+func TestNotifyByMailWithCrlfInBranch(t *testing.T) {
+	// given
+	l, mails := startFakeSmtpServer(t)
+	defer l.Close()
+	conf := smtpConf(t, l)
+	job := model.Job{Id: []byte("42"), Name: "test", MailRecipients: []string{"team@test.com"}}
+	current := &model.JobExecution{Id: "2", BranchName: "master\r\nBcc: attacker@test.com", Status: model.Failure}
+
+	// when
+	err := notification.NotifyByMail(conf, job, nil, current, nil)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when sending the mail, but got %s", err.Error())
+	}
+	var mail receivedMail
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect a mail to have been received, but got none")
+	}
+	headers := strings.SplitN(mail.data, "\r\n\r\n", 2)[0]
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Fatalf("expect the branch not to add a header, but got %s", headers)
+	}
+	if !strings.Contains(headers, "Subject: [Dahu] test on master  Bcc: attacker@test.com is broken") {
+		t.Fatalf("expect the subject to stay on one line, but got %s", headers)
+	}
+}
+
+// test that no mail is sent when the
+// status doesn't change
+func TestNotifyByMailNoTransition(t *testing.T) {
+	// given
+	l, mails := startFakeSmtpServer(t)
+	defer l.Close()
+	conf := smtpConf(t, l)
+	job := model.Job{Id: []byte("42"), Name: "test", MailRecipients: []string{"team@test.com"}}
+	previous := &model.JobExecution{Id: "1", Status: model.Failure}
+	current := &model.JobExecution{Id: "2", BranchName: "master", Status: model.Failure}
+
+	// when
+	err := notification.NotifyByMail(conf, job, previous, current, nil)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	select {
+	case mail := <-mails:
+		t.Fatalf("expect no mail to have been sent, but got %+v", mail)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestComputeTransition(t *testing.T) {
+	cases := []struct {
+		previous           *model.JobExecution
+		current            *model.JobExecution
+		expectedTransition notification.Transition
+		expectedNotify     bool
+	}{
+		{nil, &model.JobExecution{Status: model.Failure}, notification.Broken, true},
+		{nil, &model.JobExecution{Status: model.Success}, "", false},
+		{&model.JobExecution{Status: model.Success}, &model.JobExecution{Status: model.Failure}, notification.Broken, true},
+		{&model.JobExecution{Status: model.Canceled}, &model.JobExecution{Status: model.Failure}, notification.Broken, true},
+		{&model.JobExecution{Status: model.Failure}, &model.JobExecution{Status: model.Success}, notification.Fixed, true},
+		{&model.JobExecution{Status: model.Success}, &model.JobExecution{Status: model.Success}, "", false},
+		{&model.JobExecution{Status: model.Failure}, &model.JobExecution{Status: model.Canceled}, "", false},
+	}
+	for i, c := range cases {
+		// when
+		transition, notify := notification.ComputeTransition(c.previous, c.current)
+
+		// then
+		if transition != c.expectedTransition || notify != c.expectedNotify {
+			t.Fatalf("case %d : expect (%s, %t) but got (%s, %t)", i, c.expectedTransition, c.expectedNotify, transition, notify)
+		}
+	}
+}
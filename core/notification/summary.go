@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"strconv"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// path of the json summary inside
+// notifier containers
+const SummaryFilePath = "/dahu/execution.json"
+
+// Summary of a finished execution as
+// exposed to notifier containers
+type Summary struct {
+	JobId       string                `json:"jobId"`
+	JobName     string                `json:"jobName"`
+	ExecutionId string                `json:"executionId"`
+	Branch      string                `json:"branch"`
+	Status      model.ExecutionStatus `json:"status"`
+	Duration    int64                 `json:"duration"` // in milliseconds
+	Link        string                `json:"link"`
+	FailedStep  string                `json:"failedStep"`
+	Steps       []StepSummary         `json:"steps"`
+}
+
+// StepSummary is the summary of
+// one step of an execution
+type StepSummary struct {
+	Name     string                `json:"name"`
+	Status   model.ExecutionStatus `json:"status"`
+	Duration int64                 `json:"duration"` // in milliseconds
+}
+
+// NewSummary build the summary of the
+// given execution of job
+func NewSummary(conf *configuration.Conf, job model.Job, execution *model.JobExecution) Summary {
+	s := Summary{
+		JobId:       string(job.Id),
+		JobName:     job.Name,
+		ExecutionId: execution.Id,
+		Branch:      execution.BranchName,
+		Status:      execution.Status,
+		Duration:    int64(execution.Duration.Seconds() * 1000),
+		Link:        ExecutionLink(conf, string(job.Id), execution.Id),
+		Steps:       make([]StepSummary, 0, len(execution.Steps)),
+	}
+	failedStep := execution.FailedStep()
+	if failedStep != nil {
+		s.FailedStep = failedStep.Name
+	}
+	for _, step := range execution.Steps {
+		s.Steps = append(s.Steps, StepSummary{
+			Name:     step.Name,
+			Status:   step.Status,
+			Duration: int64(step.Duration.Seconds() * 1000),
+		})
+	}
+	return s
+}
+
+// Envs return the summary as env
+// variables for notifier containers
+func (s Summary) Envs() map[string]string {
+	return map[string]string{
+		"DAHU_JOB_ID":         s.JobId,
+		"DAHU_JOB_NAME":       s.JobName,
+		"DAHU_EXECUTION_ID":   s.ExecutionId,
+		"DAHU_BRANCH":         s.Branch,
+		"DAHU_STATUS":         string(s.Status),
+		"DAHU_DURATION":       strconv.FormatInt(s.Duration, 10),
+		"DAHU_EXECUTION_URL":  s.Link,
+		"DAHU_FAILED_STEP":    s.FailedStep,
+		"DAHU_EXECUTION_FILE": SummaryFilePath,
+	}
+}
@@ -0,0 +1,52 @@
+package notification_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/notification"
+)
+
+func TestSummaryEnvs(t *testing.T) {
+	// given
+	conf := configuration.InitConf()
+	conf.ApiConf.ExternalUrl = "http://dahu.test.com/"
+	job := model.Job{Id: []byte("42"), Name: "test"}
+	execution := &model.JobExecution{
+		Id:         "2",
+		BranchName: "master",
+		Status:     model.Failure,
+		Duration:   2 * time.Second,
+		Steps: []*model.StepExecution{
+			&model.StepExecution{Name: "Code fetching", Status: model.Success, Duration: time.Second},
+			&model.StepExecution{Name: "unit tests", Status: model.Failure},
+		},
+	}
+
+	// when
+	summary := notification.NewSummary(conf, job, execution)
+	envs := summary.Envs()
+
+	// then
+	expectedEnvs := map[string]string{
+		"DAHU_JOB_ID":         "42",
+		"DAHU_JOB_NAME":       "test",
+		"DAHU_EXECUTION_ID":   "2",
+		"DAHU_BRANCH":         "master",
+		"DAHU_STATUS":         "failure",
+		"DAHU_DURATION":       "2000",
+		"DAHU_EXECUTION_URL":  "http://dahu.test.com/jobs/42/executions/2",
+		"DAHU_FAILED_STEP":    "unit tests",
+		"DAHU_EXECUTION_FILE": notification.SummaryFilePath,
+	}
+	for key, value := range expectedEnvs {
+		if envs[key] != value {
+			t.Fatalf("expect env %s to be %s but got %s", key, value, envs[key])
+		}
+	}
+	if len(summary.Steps) != 2 || summary.Steps[0].Duration != 1000 {
+		t.Fatalf("expect the steps to be summarized, but got %+v", summary.Steps)
+	}
+}
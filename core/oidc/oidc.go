@@ -0,0 +1,298 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// time given to a user to authenticate
+// against the identity provider
+const pendingValidityDuration = 10 * time.Minute
+
+// subset of the discovery document
+// of the identity provider
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// authentication started by a redirection
+// to the identity provider, waiting for
+// its callback
+type pendingAuthentication struct {
+	nonce       string
+	verifier    string // PKCE code verifier
+	redirectUrl string
+	expiresAt   time.Time
+}
+
+// Dahu user an id token is mapped to
+type Identity struct {
+	Issuer  string
+	Subject string     // the sub claim, identifying the user at the issuer
+	Login   string     // login of the user provisioned for the identity
+	Role    model.Role // empty when none of the groups of the user is mapped to a role
+}
+
+// Provider drives the authorization code flow with PKCE
+// against an OpenID Connect identity provider. The discovery
+// document and the keys of the provider are fetched lazily.
+type Provider struct {
+	conf     configuration.Oidc
+	client   *http.Client
+	mutex    sync.Mutex
+	metadata *metadata
+	keys     map[string]interface{} // signing keys of the provider by kid
+	pending  map[string]pendingAuthentication
+}
+
+func NewProvider(conf configuration.Oidc) *Provider {
+	return &Provider{
+		conf:    conf,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		pending: make(map[string]pendingAuthentication),
+	}
+}
+
+// AuthenticationUrl start a new authentication and return
+// the url of the identity provider the user must be redirected to.
+// The provider will redirect the user back to redirectUrl.
+func (p *Provider) AuthenticationUrl(ctx context.Context, redirectUrl string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	p.mutex.Lock()
+	now := time.Now()
+	for key, pending := range p.pending {
+		if now.After(pending.expiresAt) {
+			delete(p.pending, key)
+		}
+	}
+	p.pending[state] = pendingAuthentication{nonce: nonce, verifier: verifier, redirectUrl: redirectUrl, expiresAt: now.Add(pendingValidityDuration)}
+	p.mutex.Unlock()
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.conf.ClientId)
+	params.Set("redirect_uri", redirectUrl)
+	params.Set("scope", strings.Join(append([]string{"openid"}, p.conf.Scopes...), " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return m.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Authenticate end the authentication identified by state: the code
+// is exchanged against an id token, which is verified and mapped
+// to a Dahu identity. Each state can only be used once.
+func (p *Provider) Authenticate(ctx context.Context, code, state string) (*Identity, error) {
+	p.mutex.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mutex.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, errors.New("oidc >> unknown or expired authentication state")
+	}
+	rawIdToken, err := p.exchange(ctx, code, pending)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.verify(ctx, rawIdToken, pending.nonce)
+	if err != nil {
+		return nil, err
+	}
+	return p.identity(claims)
+}
+
+// exchange the authorization code
+// against an id token
+func (p *Provider) exchange(ctx context.Context, code string, pending pendingAuthentication) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", pending.redirectUrl)
+	form.Set("code_verifier", pending.verifier)
+	req, err := http.NewRequest(http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.conf.ClientId), url.QueryEscape(p.conf.ClientSecret))
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc >> the token endpoint answered %d", resp.StatusCode)
+	}
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return "", err
+	}
+	if tokens.IdToken == "" {
+		return "", errors.New("oidc >> no id token returned by the token endpoint")
+	}
+	return tokens.IdToken, nil
+}
+
+// verify the signature, the issuer, the
+// audience and the nonce of an id token
+func (p *Provider) verify(ctx context.Context, rawIdToken, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(rawIdToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if !compatible(token.Method, key) {
+			return nil, fmt.Errorf("oidc >> unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("oidc >> invalid id token")
+	}
+	if claims["iss"] != p.conf.Issuer {
+		return nil, fmt.Errorf("oidc >> unexpected issuer %v", claims["iss"])
+	}
+	if !hasAudience(claims, p.conf.ClientId) {
+		return nil, fmt.Errorf("oidc >> the id token is not issued for %s", p.conf.ClientId)
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("oidc >> unexpected nonce")
+	}
+	return claims, nil
+}
+
+// map the claims of an id token to a Dahu identity
+func (p *Provider) identity(claims jwt.MapClaims) (*Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("oidc >> no sub claim in the id token")
+	}
+	login, _ := claims[p.conf.LoginClaim].(string)
+	if login == "" {
+		return nil, fmt.Errorf("oidc >> no %s claim in the id token", p.conf.LoginClaim)
+	}
+	claimGroups, _ := claims[p.conf.GroupsClaim].([]interface{})
+	groups := make([]string, 0, len(claimGroups))
+	for _, group := range claimGroups {
+		if name, ok := group.(string); ok {
+			groups = append(groups, name)
+		}
+	}
+	return &Identity{
+		Issuer:  p.conf.Issuer,
+		Subject: subject,
+		Login:   login,
+		Role:    model.RoleOfGroups(groups, p.conf.GroupRoles),
+	}, nil
+}
+
+// fetch the discovery document of the
+// identity provider, once
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mutex.Lock()
+	m := p.metadata
+	p.mutex.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	m = new(metadata)
+	err := p.getJson(ctx, strings.TrimSuffix(p.conf.Issuer, "/")+"/.well-known/openid-configuration", m)
+	if err != nil {
+		return nil, err
+	}
+	if m.Issuer != p.conf.Issuer {
+		return nil, fmt.Errorf("oidc >> the discovery document is issued by %s instead of %s", m.Issuer, p.conf.Issuer)
+	}
+	p.mutex.Lock()
+	p.metadata = m
+	p.mutex.Unlock()
+	return m, nil
+}
+
+func (p *Provider) getJson(ctx context.Context, url string, value interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc >> %s answered %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+func hasAudience(claims jwt.MapClaims, clientId string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,129 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/oidc"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+const redirectUrl = "http://dahu.test/oidc/callback"
+
+func providerConf(idp *tests.IdentityProvider) configuration.Oidc {
+	conf := configuration.InitConf().OidcConf
+	conf.Issuer = idp.Issuer()
+	conf.ClientId = "dahu"
+	conf.ClientSecret = "dahu-secret"
+	conf.GroupRoles = map[string]string{"devs": "maintainer", "ops": "admin"}
+	return conf
+}
+
+// go through the identity provider authorization
+// endpoint and return the code and state of its redirection
+func authorize(t *testing.T, p *oidc.Provider) (code, state string) {
+	authUrl, err := p.AuthenticationUrl(context.Background(), redirectUrl)
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	cli := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := cli.Get(authUrl)
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// test a complete authentication, with
+// the mapping of the groups to a role
+func TestAuthenticate(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	idp.Claims["groups"] = []string{"devs", "ops", "others"}
+	p := oidc.NewProvider(providerConf(idp))
+	code, state := authorize(t, p)
+
+	// when
+	identity, err := p.Authenticate(context.Background(), code, state)
+	_, replayErr := p.Authenticate(context.Background(), code, state)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if identity.Login != "bob" || identity.Role != model.RoleAdmin {
+		t.Fatalf("expect bob to be admin, but got %+v", identity)
+	}
+	if replayErr == nil {
+		t.Fatal("expect an error when reusing a state")
+	}
+}
+
+// test that an id token issued
+// for another client is rejected
+func TestAuthenticateWithAnotherAudience(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	idp.Audience = "another-client"
+	p := oidc.NewProvider(providerConf(idp))
+	code, state := authorize(t, p)
+
+	// when
+	_, err := p.Authenticate(context.Background(), code, state)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error with an id token issued for another client")
+	}
+}
+
+// test that the client must be
+// known by the identity provider
+func TestAuthenticateWithWrongClientSecret(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["preferred_username"] = "bob"
+	conf := providerConf(idp)
+	conf.ClientSecret = "wrong"
+	p := oidc.NewProvider(conf)
+	code, state := authorize(t, p)
+
+	// when
+	_, err := p.Authenticate(context.Background(), code, state)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error with a wrong client secret")
+	}
+}
+
+// test that an id token without the
+// login claim can't be mapped to a user
+func TestAuthenticateWithoutLoginClaim(t *testing.T) {
+	// given
+	idp := tests.NewIdentityProvider("dahu", "dahu-secret")
+	defer idp.Close()
+	idp.Claims["email"] = "bob@dahu.test"
+	p := oidc.NewProvider(providerConf(idp))
+	code, state := authorize(t, p)
+
+	// when
+	_, err := p.Authenticate(context.Background(), code, state)
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error without preferred_username claim")
+	}
+}
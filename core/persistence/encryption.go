@@ -0,0 +1,421 @@
+package persistence
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// prefix of the encrypted credentials. The values
+// without it are stored in clear, like the ones
+// written before a master key was configured.
+const sealedPrefix = "enc:v1:"
+
+// envelope encryption of the credentials stored with the jobs, the
+// registries and the teams, and of the signing keys. Each value is encrypted (AES-256-GCM) by its own random data
+// key, itself encrypted by the master key. A value is stored as
+// enc:v1:<master key id>:<encrypted data key>:<encrypted value>, so that
+// changing the master key only needs the data keys to be decrypted.
+// A nil secretBox stores the credentials in clear.
+type secretBox struct {
+	master cipher.AEAD
+	id     string // fingerprint of the master key, telling which one encrypted a value
+}
+
+// the master key of conf, MasterKey or the content of MasterKeyFile. The
+// AES-256 key is the sha256 of this secret. Return nil when none is configured.
+func newSecretBox(conf configuration.Persistence) (*secretBox, error) {
+	secret := []byte(conf.MasterKey)
+	if len(secret) == 0 && conf.MasterKeyFile != "" {
+		var err error
+		secret, err = ioutil.ReadFile(conf.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("persistence >> unable to read the master key file : %s", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("persistence >> the master key file %s is empty", conf.MasterKeyFile)
+		}
+	}
+	if len(secret) == 0 {
+		return nil, nil
+	}
+	key := sha256.Sum256(secret)
+	master, err := newAead(key[:])
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(key[:])
+	return &secretBox{master: master, id: hex.EncodeToString(fingerprint[:4])}, nil
+}
+
+// the secretBox of a repository. Dahu may not
+// start without its master key : any failure is fatal.
+func loadSecretBox(conf configuration.Persistence) *secretBox {
+	secrets, err := newSecretBox(conf)
+	if err != nil {
+		log.Fatalf("FATAL >> %s", err.Error())
+	}
+	if secrets == nil {
+		log.Printf("WARN >> no master key is configured, the credentials are stored in clear")
+	}
+	return secrets
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt plain with aead. The nonce is put in front of the result
+func encrypt(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decrypt(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("persistence >> the encrypted credential is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// encrypt a credential. The empty ones are kept empty.
+func (b *secretBox) seal(value string) (string, error) {
+	if b == nil || value == "" || strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := encrypt(aead, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := encrypt(b.master, dataKey)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawStdEncoding
+	return sealedPrefix + b.id + ":" + encoding.EncodeToString(sealedKey) + ":" + encoding.EncodeToString(sealedValue), nil
+}
+
+// decrypt a credential. The ones stored in clear are returned as is.
+func (b *secretBox) open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("persistence >> malformed encrypted credential")
+	}
+	if b == nil {
+		return "", errors.New("persistence >> the credentials are encrypted, but no master key is configured")
+	}
+	if parts[0] != b.id {
+		return "", fmt.Errorf("persistence >> the credentials are encrypted by another master key (%s) than the configured one (%s)", parts[0], b.id)
+	}
+	encoding := base64.RawStdEncoding
+	sealedKey, keyErr := encoding.DecodeString(parts[1])
+	sealedValue, valueErr := encoding.DecodeString(parts[2])
+	if keyErr != nil || valueErr != nil {
+		return "", errors.New("persistence >> malformed encrypted credential")
+	}
+	dataKey, err := decrypt(b.master, sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("persistence >> unable to decrypt a credential : %s", err)
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := decrypt(aead, sealedValue)
+	if err != nil {
+		return "", fmt.Errorf("persistence >> unable to decrypt a credential : %s", err)
+	}
+	return string(plain), nil
+}
+
+// the credentials of a job : the ones of its repository, the token
+// of its forge, and the passwords of the registries resolved by GetJob
+func jobCredentials(job *model.Job) []*string {
+	res := make([]*string, 0)
+	if job.GitConf.HttpAuth != nil {
+		res = append(res, &job.GitConf.HttpAuth.Password)
+	}
+	if job.GitConf.SshAuth != nil {
+		res = append(res, &job.GitConf.SshAuth.Key, &job.GitConf.SshAuth.KeyPassword)
+	}
+	if job.CommitStatus != nil {
+		res = append(res, &job.CommitStatus.Token)
+	}
+	for index := range job.Steps {
+		if job.Steps[index].Image.Registry != nil {
+			res = append(res, &job.Steps[index].Image.Registry.Password)
+		}
+	}
+	for index := range job.Notifiers {
+		if job.Notifiers[index].Image.Registry != nil {
+			res = append(res, &job.Notifiers[index].Image.Registry.Password)
+		}
+	}
+	return res
+}
+
+// copy of a job, whose credentials may be
+// changed without changing the ones of job
+func copyJobCredentials(job *model.Job) *model.Job {
+	res := *job
+	if job.GitConf.HttpAuth != nil {
+		auth := *job.GitConf.HttpAuth
+		res.GitConf.HttpAuth = &auth
+	}
+	if job.GitConf.SshAuth != nil {
+		auth := *job.GitConf.SshAuth
+		res.GitConf.SshAuth = &auth
+	}
+	if job.CommitStatus != nil {
+		commitStatus := *job.CommitStatus
+		res.CommitStatus = &commitStatus
+	}
+	if job.Steps != nil {
+		res.Steps = append([]model.Step(nil), job.Steps...)
+		for index := range res.Steps {
+			if registry := res.Steps[index].Image.Registry; registry != nil {
+				copied := *registry
+				res.Steps[index].Image.Registry = &copied
+			}
+		}
+	}
+	if job.Notifiers != nil {
+		res.Notifiers = append([]model.Notifier(nil), job.Notifiers...)
+		for index := range res.Notifiers {
+			if registry := res.Notifiers[index].Image.Registry; registry != nil {
+				copied := *registry
+				res.Notifiers[index].Image.Registry = &copied
+			}
+		}
+	}
+	return &res
+}
+
+// copy of job to be stored, its credentials encrypted.
+// The job itself is left untouched.
+func (b *secretBox) sealJob(job *model.Job) (*model.Job, error) {
+	res := copyJobCredentials(job)
+	for _, credential := range jobCredentials(res) {
+		sealed, err := b.seal(*credential)
+		if err != nil {
+			return nil, err
+		}
+		*credential = sealed
+	}
+	return res, nil
+}
+
+// decrypt the credentials of a stored job
+func (b *secretBox) openJob(job *model.Job) error {
+	for _, credential := range jobCredentials(job) {
+		plain, err := b.open(*credential)
+		if err != nil {
+			return err
+		}
+		*credential = plain
+	}
+	return nil
+}
+
+// copy of registry to be stored, its password encrypted.
+// The registry itself is left untouched.
+func (b *secretBox) sealDockerRegistry(registry *model.DockerRegistry) (*model.DockerRegistry, error) {
+	res := *registry
+	var err error
+	res.Password, err = b.seal(registry.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// decrypt the password of a stored registry
+func (b *secretBox) openDockerRegistry(registry *model.DockerRegistry) error {
+	var err error
+	registry.Password, err = b.open(registry.Password)
+	return err
+}
+
+// copy of team to be stored, its secret values encrypted.
+// The team itself is left untouched.
+func (b *secretBox) sealTeam(team *model.Team) (*model.Team, error) {
+	res := *team
+	if team.Secrets != nil {
+		res.Secrets = make(map[string]string, len(team.Secrets))
+		for name, value := range team.Secrets {
+			sealed, err := b.seal(value)
+			if err != nil {
+				return nil, err
+			}
+			res.Secrets[name] = sealed
+		}
+	}
+	return &res, nil
+}
+
+// decrypt the secret values of a stored team
+func (b *secretBox) openTeam(team *model.Team) error {
+	for name, value := range team.Secrets {
+		plain, err := b.open(value)
+		if err != nil {
+			return err
+		}
+		team.Secrets[name] = plain
+	}
+	return nil
+}
+
+// copy of key to be stored, its secret encrypted.
+// The key itself is left untouched.
+func (b *secretBox) sealSigningKey(key *model.SigningKey) (*model.SigningKey, error) {
+	res := *key
+	sealed, err := b.seal(string(key.Secret))
+	if err != nil {
+		return nil, err
+	}
+	res.Secret = []byte(sealed)
+	return &res, nil
+}
+
+// decrypt the secret of a stored signing key
+func (b *secretBox) openSigningKey(key *model.SigningKey) error {
+	plain, err := b.open(string(key.Secret))
+	if err != nil {
+		return err
+	}
+	key.Secret = []byte(plain)
+	return nil
+}
+
+// decrypt the credentials of a stored job with
+// current, and encrypt them again with next
+func reencryptJob(data []byte, current, next *secretBox) ([]byte, error) {
+	var job model.Job
+	err := json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, err
+	}
+	err = current.openJob(&job)
+	if err != nil {
+		return nil, fmt.Errorf("job %s : %s", string(job.Id), err)
+	}
+	sealed, err := next.sealJob(&job)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// see reencryptJob
+func reencryptDockerRegistry(data []byte, current, next *secretBox) ([]byte, error) {
+	var registry model.DockerRegistry
+	err := json.Unmarshal(data, &registry)
+	if err != nil {
+		return nil, err
+	}
+	err = current.openDockerRegistry(&registry)
+	if err != nil {
+		return nil, fmt.Errorf("docker registry %s : %s", registry.Id, err)
+	}
+	sealed, err := next.sealDockerRegistry(&registry)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// see reencryptJob
+func reencryptTeam(data []byte, current, next *secretBox) ([]byte, error) {
+	var team model.Team
+	err := json.Unmarshal(data, &team)
+	if err != nil {
+		return nil, err
+	}
+	err = current.openTeam(&team)
+	if err != nil {
+		return nil, fmt.Errorf("team %s : %s", team.Id, err)
+	}
+	sealed, err := next.sealTeam(&team)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// see reencryptJob
+func reencryptSigningKey(data []byte, current, next *secretBox) ([]byte, error) {
+	var key model.SigningKey
+	err := json.Unmarshal(data, &key)
+	if err != nil {
+		return nil, err
+	}
+	err = current.openSigningKey(&key)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s : %s", key.Id, err)
+	}
+	sealed, err := next.sealSigningKey(&key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealed)
+}
+
+// encrypt the credentials of the database of conf with the master key
+// of newKeyFile, after having decrypted them with the current master
+// key of conf. The ones stored in clear are encrypted too. Return the
+// number of jobs, registries, teams and signing keys rewritten. The database must not be
+// used by a running server, which must then be started with the new key.
+func RotateMasterKey(conf *configuration.Conf, newKeyFile string) (int, error) {
+	current, err := newSecretBox(conf.PersistenceConf)
+	if err != nil {
+		return 0, err
+	}
+	next, err := newSecretBox(configuration.Persistence{MasterKeyFile: newKeyFile})
+	if err != nil {
+		return 0, err
+	}
+	if next == nil {
+		return 0, errors.New("persistence >> a new master key file is needed")
+	}
+	switch conf.PersistenceConf.Type {
+	case configuration.PostgreSQL:
+		return rotateSqlMasterKey(conf, postgres{}, current, next)
+	case configuration.SQLite:
+		return rotateSqlMasterKey(conf, sqlite{}, current, next)
+	default:
+		return rotateBoltMasterKey(conf, current, next)
+	}
+}
@@ -0,0 +1,129 @@
+package persistence_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that the credentials of the jobs, the registries and
+// the teams, and the signing keys, are read in clear, but
+// never written in clear in the file
+func TestCredentialsShouldBeEncryptedInTheDatabase(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type == configuration.PostgreSQL {
+		t.Skip("the database file is read")
+	}
+	c.PersistenceConf.MasterKey = "master key"
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	job := &model.Job{Name: "job", GitConf: model.GitConfig{SshAuth: &model.SshAuthConfig{Url: "git@some.url", Key: "sshPrivateKey", KeyPassword: "sshKeyPassword"}},
+		CommitStatus: &model.CommitStatusConfig{Token: "forgeToken"}}
+	registry := &model.DockerRegistry{Name: "registry", Url: "some.url", User: "tester", Password: "registryPassword"}
+	team := &model.Team{Name: "team", Secrets: map[string]string{"token": "teamSecret"}}
+	key := &model.SigningKey{Algorithm: "HS256", Secret: []byte("signingSecret"), CreatedAt: time.Now()}
+
+	// when
+	job, jobErr := rep.CreateJob(job, ctx)
+	registry, registryErr := rep.CreateDockerRegistry(registry, ctx)
+	team, teamErr := rep.CreateTeam(team, ctx)
+	_, keyErr := rep.RotateSigningKey(key, ctx)
+	storedJob, getJobErr := rep.GetJob(job.Id, ctx)
+	storedRegistries, getRegistriesErr := rep.GetDockerRegistries(ctx)
+	storedTeams, getTeamsErr := rep.GetTeams(ctx)
+	storedKeys, getKeysErr := rep.GetSigningKeys(ctx)
+
+	// close and read the db
+	tests.ClosePersistence(c)
+	data, readErr := ioutil.ReadFile(c.PersistenceConf.Path())
+	tests.DeletePersistence(c)
+
+	// then
+	if jobErr != nil || registryErr != nil || teamErr != nil || keyErr != nil || readErr != nil {
+		t.Fatalf("expect to have no error, but got %v %v %v %v %v", jobErr, registryErr, teamErr, keyErr, readErr)
+	}
+	if getJobErr != nil || getRegistriesErr != nil || getTeamsErr != nil || getKeysErr != nil {
+		t.Fatalf("expect to have no error, but got %v %v %v %v", getJobErr, getRegistriesErr, getTeamsErr, getKeysErr)
+	}
+	if job.GitConf.SshAuth.Key != "sshPrivateKey" || registry.Password != "registryPassword" || team.Secrets["token"] != "teamSecret" || string(key.Secret) != "signingSecret" {
+		t.Errorf("expect the created entities to be left in clear, but got %+v, %+v, %+v and %+v", job.GitConf.SshAuth, registry, team, key)
+	}
+	if storedJob.GitConf.SshAuth.Key != "sshPrivateKey" || storedJob.GitConf.SshAuth.KeyPassword != "sshKeyPassword" || storedJob.CommitStatus.Token != "forgeToken" {
+		t.Errorf("expect the credentials of the job to be decrypted, but got %+v and %+v", storedJob.GitConf.SshAuth, storedJob.CommitStatus)
+	}
+	if len(storedRegistries) != 1 || storedRegistries[0].Password != "registryPassword" {
+		t.Errorf("expect the password of the registry to be decrypted, but got %+v", storedRegistries)
+	}
+	if len(storedTeams) != 1 || storedTeams[0].Secrets["token"] != "teamSecret" {
+		t.Errorf("expect the secrets of the team to be decrypted, but got %+v", storedTeams)
+	}
+	if len(storedKeys) != 1 || string(storedKeys[0].Secret) != "signingSecret" {
+		t.Errorf("expect the signing key to be decrypted, but got %+v", storedKeys)
+	}
+	// the secret of a signing key is stored as base64
+	signingSecret := base64.StdEncoding.EncodeToString([]byte("signingSecret"))
+	for _, credential := range []string{"sshPrivateKey", "sshKeyPassword", "forgeToken", "registryPassword", "teamSecret", "signingSecret", signingSecret} {
+		if bytes.Contains(data, []byte(credential)) {
+			t.Errorf("expect %s not to be stored in clear", credential)
+		}
+	}
+}
+
+// test that #RotateMasterKey encrypts the credentials with
+// the new key, including the ones stored in clear
+func TestRotateMasterKeyShouldReencryptTheCredentials(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-keys")
+	defer os.RemoveAll(dir)
+	newKeyFile := filepath.Join(dir, "new.key")
+	ioutil.WriteFile(newKeyFile, []byte("new master key\n"), 0600)
+	c := tests.InitConf()
+	legacy := model.Job{Id: []byte("legacy"), Name: "legacy", GitConf: model.GitConfig{HttpAuth: &model.HttpAuthConfig{Url: "http://some.url", Password: "legacyPassword"}}}
+	tests.InsertObject(c, []byte("jobs"), legacy.Id, legacy)
+	c.PersistenceConf.MasterKey = "old master key"
+	ctx := context.Background()
+	registry, _ := persistence.GetRepository(c).CreateDockerRegistry(&model.DockerRegistry{Name: "registry", Url: "some.url", Password: "registryPassword"}, ctx)
+	team, _ := persistence.GetRepository(c).CreateTeam(&model.Team{Name: "team", Secrets: map[string]string{"token": "teamSecret"}}, ctx)
+	persistence.GetRepository(c).RotateSigningKey(&model.SigningKey{Algorithm: "HS256", Secret: []byte("signingSecret"), CreatedAt: time.Now()}, ctx)
+	tests.ClosePersistence(c)
+
+	// when
+	changes, err := persistence.RotateMasterKey(c, newKeyFile)
+
+	// then
+	c.Close = make(chan interface{})
+	c.PersistenceConf.MasterKey = ""
+	c.PersistenceConf.MasterKeyFile = newKeyFile
+	rep := persistence.GetRepository(c)
+	job, jobErr := rep.GetJob(legacy.Id, ctx)
+	storedRegistry, registryErr := rep.GetDockerRegistry([]byte(registry.Id), ctx)
+	storedTeam, teamErr := rep.GetTeam(team.Id, ctx)
+	keys, keysErr := rep.GetSigningKeys(ctx)
+	tests.CleanPersistence(c)
+	if err != nil || changes != 4 {
+		t.Fatalf("expect 4 records to be re-encrypted, but got %d and %v", changes, err)
+	}
+	if jobErr != nil || job.GitConf.HttpAuth.Password != "legacyPassword" {
+		t.Errorf("expect the password of the job to be read with the new key, but got %v %v", jobErr, job)
+	}
+	if registryErr != nil || storedRegistry.Password != "registryPassword" {
+		t.Errorf("expect the password of the registry to be read with the new key, but got %v %v", registryErr, storedRegistry)
+	}
+	if teamErr != nil || storedTeam.Secrets["token"] != "teamSecret" {
+		t.Errorf("expect the secrets of the team to be read with the new key, but got %v %v", teamErr, storedTeam)
+	}
+	if keysErr != nil || len(keys) != 1 || string(keys[0].Secret) != "signingSecret" {
+		t.Errorf("expect the signing key to be read with the new key, but got %v %v", keysErr, keys)
+	}
+}
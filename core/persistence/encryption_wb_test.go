@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+/*
+* Test case of seal and open. Should encrypt each value with
+* its own data key, and decrypt it with the same master key only
+ */
+func TestSecretBoxSealAndOpen(t *testing.T) {
+	// given
+	box, _ := newSecretBox(configuration.Persistence{MasterKey: "master key"})
+	otherBox, _ := newSecretBox(configuration.Persistence{MasterKey: "other master key"})
+
+	// when
+	first, firstErr := box.seal("password")
+	second, _ := box.seal("password")
+	plain, openErr := box.open(first)
+	_, otherErr := otherBox.open(first)
+	_, noKeyErr := (*secretBox)(nil).open(first)
+
+	// then
+	if firstErr != nil || !strings.HasPrefix(first, sealedPrefix) || first == second {
+		t.Fatalf("expect two different encrypted values, but got %s, %s and %v", first, second, firstErr)
+	}
+	if openErr != nil || plain != "password" {
+		t.Errorf("expect to decrypt the value, but got %s and %v", plain, openErr)
+	}
+	if otherErr == nil || noKeyErr == nil {
+		t.Errorf("expect to fail without the master key, but got %v and %v", otherErr, noKeyErr)
+	}
+}
+
+/*
+* Test case of sealJob. Should encrypt the credentials
+* of a copy of the job, leaving the job in clear
+ */
+func TestSecretBoxSealJobLeavesTheJobInClear(t *testing.T) {
+	// given
+	box, _ := newSecretBox(configuration.Persistence{MasterKey: "master key"})
+	job := &model.Job{
+		GitConf: model.GitConfig{HttpAuth: &model.HttpAuthConfig{Url: "http://some.url", Password: "gitPassword"}},
+		Steps:   []model.Step{{Image: model.Image{RegistryId: "registry", Registry: &model.DockerRegistry{Password: "registryPassword"}}}},
+	}
+
+	// when
+	sealed, err := box.sealJob(job)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if job.GitConf.HttpAuth.Password != "gitPassword" || job.Steps[0].Image.Registry.Password != "registryPassword" {
+		t.Errorf("expect the job to be left in clear, but got %+v", job)
+	}
+	if !strings.HasPrefix(sealed.GitConf.HttpAuth.Password, sealedPrefix) || !strings.HasPrefix(sealed.Steps[0].Image.Registry.Password, sealedPrefix) {
+		t.Errorf("expect the credentials of the copy to be encrypted, but got %+v", sealed)
+	}
+}
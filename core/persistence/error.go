@@ -1,11 +1,20 @@
 package persistence
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
 type PersistenceErrorType int
 
 const (
 	NotFound PersistenceErrorType = 1 + iota
 	Conflict
 	OtherError
+	NoMorePersisted // the entity has been purged, see model.NewNoMorePersisted
+	Timeout         // the context is done, or PersistenceConf.TimeOut elapsed
 )
 
 type PersistenceError interface {
@@ -37,7 +46,30 @@ func wrapError(err error) PersistenceError {
 	persistenceErr, isPersistenceErr := err.(PersistenceError)
 	if isPersistenceErr {
 		return persistenceErr
+	} else if err == context.DeadlineExceeded || err == context.Canceled {
+		return timeoutError(err)
 	} else {
 		return newPersistenceError(err.Error(), OtherError)
 	}
 }
+
+func timeoutError(err error) PersistenceError {
+	return newPersistenceError(fmt.Sprintf("persistence >> the operation has been given up : %s", err.Error()), Timeout)
+}
+
+// an error met once ctx is done is most likely
+// caused by it, and reported as a Timeout
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return timeoutError(ctx.Err())
+	}
+	return err
+}
+
+// bound ctx by conf.TimeOut, when there is one
+func withTimeout(ctx context.Context, conf configuration.Persistence) (context.Context, context.CancelFunc) {
+	if conf.TimeOut <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, conf.TimeOut)
+}
@@ -1,11 +1,13 @@
 package persistence
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
-	"path"
+	"path/filepath"
 	"sync"
 
 	bolt "github.com/coreos/bbolt"
@@ -33,50 +35,88 @@ func getOrCreateInMemory(conf *configuration.Conf) Repository {
 	return inMemorySingleton
 }
 
-// prepare a db inMemory instance
+// prepare a db inMemory instance. Dahu may not start
+// without its database : any failure is fatal.
 func createInMemory(conf *configuration.Conf) {
 	inMemorySingleton = new(inMemory)
 	inMemorySingleton.close = make(chan interface{})
 	inMemorySingleton.conf = conf
 	inMemorySingleton.rwMutex = &sync.RWMutex{}
-	newDb := isNewDb(conf.PersistenceConf.Name)
-	db, _ := bolt.Open(conf.PersistenceConf.Name, 0600, nil) // todo handle this error
-	dbInitialization(db)
-	if newDb {
-		defaultUserCreation(db)
+	path, err := filepath.Abs(conf.PersistenceConf.Path())
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
 	}
+	if err != nil {
+		log.Fatalf("FATAL >> unable to create the data directory of %s : %s", conf.PersistenceConf.Path(), err.Error())
+	}
+	inMemorySingleton.secrets = loadSecretBox(conf.PersistenceConf)
+	db, err := openBolt(conf.PersistenceConf, false)
+	if err != nil {
+		log.Fatalf("FATAL >> unable to open the database %s : %s", path, err.Error())
+	}
+	version := dbInitialization(db)
+	log.Printf("INFO >> bbolt database %s opened (schema version %d)", path, version)
 	inMemorySingleton.db = db
 	go waitForGracefullShutdown()
 }
 
-func isNewDb(dbName string) bool {
-	ex, _ := os.Executable()
-	dir := path.Dir(ex)
-	_, err := os.Stat(dir + "/" + dbName)
-	return os.IsNotExist(err)
+// open the bbolt file of conf. ErrDatabaseInUse is returned when
+// another process doesn't release its lock within conf.OpenTimeOut.
+func openBolt(conf configuration.Persistence, readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(conf.Path(), 0600, &bolt.Options{Timeout: conf.OpenTimeOut, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDatabaseInUse
+	}
+	return db, err
 }
 
-func dbInitialization(db *bolt.DB) {
-	db.Update(func(tx *bolt.Tx) error {
-		return createBucketsIfNeeded(tx)
-	})
+// play the pending migrations and create the default user if
+// there is no user at all. Dahu may not start on a database it
+// doesn't understand. Return the schema version of the database.
+func dbInitialization(db *bolt.DB) int {
+	report, err := migrateBolt(db, false)
+	if err != nil {
+		log.Fatalf("FATAL >> unable to migrate the database : %s", err.Error())
+	}
+	if len(report.Migrations) > 0 {
+		log.Printf("INFO >> %s", report.String())
+	}
+	err = defaultUserCreation(db)
+	if err != nil {
+		log.Fatalf("FATAL >> unable to create the default user : %s", err.Error())
+	}
+	return report.To
 }
 
-// will insert the default user.
-// should only be used if a previous call
-// of isNewDb has returned true !
-func defaultUserCreation(db *bolt.DB) {
-	db.Update(func(tx *bolt.Tx) error {
-		u := model.User{Login: "dahu"}
-		u.SetPassword([]byte("dahuDefaultPassword"))
-		b, _ := tx.CreateBucketIfNotExists([]byte("users"))
-		var data []byte
-		data, _ = json.Marshal(u)
-		b.Put([]byte(u.Login), data)
-		return nil
+// insert the default user when
+// there is no user in the database
+func defaultUserCreation(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+		}
+		if k, _ := b.Cursor().First(); k != nil {
+			return nil
+		}
+		u := defaultUser()
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(u.Login), data)
 	})
 }
 
+// the user created with a new database. Its password is
+// known by everyone, and must be changed before anything
+// else can be done.
+func defaultUser() model.User {
+	u := model.User{Login: "dahu", Role: model.RoleAdmin, MustChangePassword: true}
+	u.SetPassword([]byte("dahuDefaultPassword"))
+	return u
+}
+
 func waitForGracefullShutdown() {
 	<-inMemorySingleton.conf.Close
 	// when closing, the first thing
@@ -111,6 +151,38 @@ func createBucketsIfNeeded(tx bucketCreationTransaction) error {
 	if err != nil {
 		return fmt.Errorf("ERROR >> jobsExecutions bucket creation failed : %s", err)
 	}
+	_, err = tx.CreateBucketIfNotExists([]byte("logs"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> logs bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("teams"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> teams bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("apiTokens"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> apiTokens bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("sessions"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> sessions bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("revokedTokens"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> revokedTokens bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("loginAttempts"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> loginAttempts bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("audit"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> audit bucket creation failed : %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("signingKeys"))
+	if err != nil {
+		return fmt.Errorf("ERROR >> signingKeys bucket creation failed : %s", err)
+	}
 	return nil
 }
 
@@ -122,6 +194,7 @@ type inMemory struct {
 	db      *bolt.DB
 	rwMutex *sync.RWMutex
 	close   chan interface{}
+	secrets *secretBox
 }
 
 func (i *inMemory) WaitClose() {
@@ -132,31 +205,76 @@ func (i *inMemory) WaitClose() {
 // db is available and then execute the function
 // inside a read/write transaction. An error is returned
 // if an issue appears.
-func (i *inMemory) doUpdateAction(action func(tx *bolt.Tx) error) error {
-	i.rwMutex.Lock()
-	defer i.rwMutex.Unlock()
-	select {
-	case <-i.conf.Close:
-		return errors.New("persistence >> the database is close or closing. Operation impossible.")
-	default:
-		// only one read/write transaction is allowed.
-		return i.db.Update(action)
-	}
-
+func (i *inMemory) doUpdateAction(ctx context.Context, action func(tx *bolt.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, i.conf.PersistenceConf)
+	defer cancel()
+	return i.doTransaction(ctx, true, action)
 }
 
 // doViewAction will ensure that the
 // db is available and then execute the function
 // inside a read transaction. An error is returned
 // if an issue appears.
-func (i *inMemory) doViewAction(action func(tx *bolt.Tx) error) error {
-	i.rwMutex.RLock()
-	defer i.rwMutex.RUnlock()
+func (i *inMemory) doViewAction(ctx context.Context, action func(tx *bolt.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, i.conf.PersistenceConf)
+	defer cancel()
+	return i.doTransaction(ctx, false, action)
+}
+
+// doTransaction execute the function inside a transaction,
+// unless ctx is done before the transaction starts. Neither the
+// mutex nor bbolt may be waited for with a context : the transaction
+// is run by its own goroutine, that gives up if ctx is done once
+// the lock is taken. A transaction still running when ctx is done
+// is rolled back. Either way, a Timeout error is returned.
+func (i *inMemory) doTransaction(ctx context.Context, writable bool, action func(tx *bolt.Tx) error) error {
+	started := make(chan interface{})
+	result := make(chan error, 1)
+	go func() {
+		if writable {
+			i.rwMutex.Lock()
+			defer i.rwMutex.Unlock()
+		} else {
+			i.rwMutex.RLock()
+			defer i.rwMutex.RUnlock()
+		}
+		if ctx.Err() != nil {
+			result <- timeoutError(ctx.Err())
+			return
+		}
+		select {
+		case <-i.conf.Close:
+			result <- errors.New("persistence >> the database is close or closing. Operation impossible.")
+			return
+		default:
+		}
+		close(started)
+		transaction := i.db.View
+		if writable {
+			// only one read/write transaction is allowed.
+			transaction = i.db.Update
+		}
+		result <- transaction(func(tx *bolt.Tx) error {
+			err := action(tx)
+			if err == nil && ctx.Err() != nil {
+				return timeoutError(ctx.Err())
+			}
+			return err
+		})
+	}()
 	select {
-	case <-i.conf.Close:
-		return errors.New("persistence >> the database is close or closing. Operation impossible.")
-	default:
-		return i.db.View(action)
+	case <-started:
+		return <-result
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		// the transaction may have started meanwhile,
+		// its result is then the one of the operation
+		select {
+		case <-started:
+			return <-result
+		default:
+			return timeoutError(ctx.Err())
+		}
 	}
-
 }
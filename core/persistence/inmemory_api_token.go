@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// api tokens are stored by hash, because
+// this is the way they are searched for on
+// every authenticated request.
+func (i *inMemory) CreateApiToken(token *model.ApiToken, ctx context.Context) (*model.ApiToken, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("apiTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+		}
+		if token.Hash == "" {
+			return errors.New("persistence >> Cannot persist an api token without hash !")
+		}
+		if b.Get([]byte(token.Hash)) != nil {
+			return newPersistenceError("An api token with the same hash already exists", Conflict)
+		}
+		updateErr = token.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		var data []byte
+		data, updateErr = json.Marshal(token)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(token.Hash), data)
+	})
+	if err == nil {
+		return token, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetApiTokenByHash(hash string, ctx context.Context) (*model.ApiToken, PersistenceError) {
+	var token model.ApiToken
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("apiTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+		}
+		data := b.Get([]byte(hash))
+		if data == nil {
+			return newPersistenceError("No api token found", NotFound)
+		}
+		return json.Unmarshal(data, &token)
+	})
+	if err == nil {
+		return &token, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetApiTokens(login string, ctx context.Context) ([]*model.ApiToken, PersistenceError) {
+	tokens := make([]*model.ApiToken, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("apiTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var token model.ApiToken
+			mErr := json.Unmarshal(v, &token)
+			if mErr != nil {
+				return mErr
+			}
+			if token.Login == login {
+				tokens = append(tokens, &token)
+			}
+			return nil
+		})
+	})
+	if err == nil {
+		sort.Slice(tokens, func(i, j int) bool {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		})
+		return tokens, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) DeleteApiToken(ctx context.Context, login, id string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("apiTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var token model.ApiToken
+			mErr := json.Unmarshal(v, &token)
+			if mErr != nil {
+				return mErr
+			}
+			if token.Id == id && token.Login == login {
+				return b.Delete(k)
+			}
+		}
+		return newPersistenceError(fmt.Sprintf("No api token with id %s found", id), NotFound)
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) TouchApiToken(hash string, usedAt time.Time, ctx context.Context) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("apiTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+		}
+		data := b.Get([]byte(hash))
+		if data == nil {
+			return newPersistenceError("No api token found", NotFound)
+		}
+		var token model.ApiToken
+		mErr := json.Unmarshal(data, &token)
+		if mErr != nil {
+			return mErr
+		}
+		token.LastUsedAt = &usedAt
+		data, mErr = json.Marshal(token)
+		if mErr != nil {
+			return mErr
+		}
+		return b.Put([]byte(hash), data)
+	})
+	return wrapError(err)
+}
+
+// revoke all api tokens of a user
+func deleteApiTokensOf(tx *bolt.Tx, login string) error {
+	b := tx.Bucket([]byte("apiTokens"))
+	if b == nil {
+		return errors.New("persistence >> CRITICAL error. No bucket for storing api tokens. The database may be corrupted !")
+	}
+	hashes := make([][]byte, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		var token model.ApiToken
+		mErr := json.Unmarshal(v, &token)
+		if mErr != nil {
+			return mErr
+		}
+		if token.Login == login {
+			hashes = append(hashes, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// keys can't be deleted while iterating with ForEach
+	for _, hash := range hashes {
+		err = b.Delete(hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test the creation of an api token, and
+// its retrieval by hash and by owner
+func TestCreateApiTokenAndGetIt(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	token := model.ApiToken{Name: "ci", Login: "bob", Hash: "hash", Scopes: []model.Scope{model.ScopeRead}}
+
+	// when
+	_, err := rep.CreateApiToken(&token, ctx)
+	_, conflictErr := rep.CreateApiToken(&model.ApiToken{Name: "other", Login: "bob", Hash: "hash"}, ctx)
+	byHash, hashErr := rep.GetApiTokenByHash("hash", ctx)
+	bobTokens, _ := rep.GetApiTokens("bob", ctx)
+	aliceTokens, _ := rep.GetApiTokens("alice", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || hashErr != nil {
+		t.Fatalf("expect no error, but got %v and %v", err, hashErr)
+	}
+	if conflictErr == nil || conflictErr.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect a Conflict error when reusing a hash, but got %v", conflictErr)
+	}
+	if byHash.Id == "" || byHash.Name != "ci" {
+		t.Fatalf("expect to get the created token, but got %+v", byHash)
+	}
+	if len(bobTokens) != 1 || len(aliceTokens) != 0 {
+		t.Fatalf("expect only bob to have a token, but got %v and %v", bobTokens, aliceTokens)
+	}
+}
+
+// test that the last use of a token is recorded
+func TestTouchApiToken(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("apiTokens"), []byte("hash"), model.ApiToken{Id: "1", Login: "bob", Hash: "hash"})
+	rep := persistence.GetRepository(c)
+	usedAt := time.Now()
+
+	// when
+	err := rep.TouchApiToken("hash", usedAt, ctx)
+	token, _ := rep.GetApiTokenByHash("hash", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if token.LastUsedAt == nil || !token.LastUsedAt.Equal(usedAt) {
+		t.Fatalf("expect the last use to be %s, but got %v", usedAt, token.LastUsedAt)
+	}
+}
+
+// test that revoking a token only
+// works for its owner
+func TestDeleteApiToken(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	tests.InsertObject(c, []byte("apiTokens"), []byte("hash"), model.ApiToken{Id: "1", Login: "bob", Hash: "hash"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	otherErr := rep.DeleteApiToken(context.Background(), "alice", "1")
+	err := rep.DeleteApiToken(context.Background(), "bob", "1")
+	_, getErr := rep.GetApiTokenByHash("hash", context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if otherErr == nil || otherErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error when revoking the token of someone else, but got %v", otherErr)
+	}
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if getErr == nil || getErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect the token to be deleted, but got %v", getErr)
+	}
+}
+
+// test that deleting a user revokes its tokens
+func TestDeleteUserRevokesItsApiTokens(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	tests.InsertObject(c, []byte("users"), []byte("bob"), model.User{Login: "bob"})
+	tests.InsertObject(c, []byte("apiTokens"), []byte("hash1"), model.ApiToken{Id: "1", Login: "bob", Hash: "hash1"})
+	tests.InsertObject(c, []byte("apiTokens"), []byte("hash2"), model.ApiToken{Id: "2", Login: "bob", Hash: "hash2"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	err := rep.DeleteUser(context.Background(), "bob")
+	tokens, _ := rep.GetApiTokens("bob", context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("expect the tokens of bob to be revoked, but got %v", tokens)
+	}
+}
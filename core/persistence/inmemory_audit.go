@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// audit entries are stored by time
+// then id, so that the cursor of the
+// bucket iterates them chronologically.
+func (i *inMemory) CreateAuditEntry(entry *model.AuditEntry, ctx context.Context) (*model.AuditEntry, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("audit"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing audit entries. The database may be corrupted !")
+		}
+		updateErr = entry.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		var data []byte
+		data, updateErr = json.Marshal(entry)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(fmt.Sprintf("%020d-%s", entry.Time.UnixNano(), entry.Id)), data)
+	})
+	if err == nil {
+		return entry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetAuditEntries(filter model.AuditFilter, ctx context.Context) ([]*model.AuditEntry, PersistenceError) {
+	entries := make([]*model.AuditEntry, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("audit"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing audit entries. The database may be corrupted !")
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && (filter.Limit <= 0 || len(entries) < filter.Limit); k, v = c.Prev() {
+			var entry model.AuditEntry
+			mErr := json.Unmarshal(v, &entry)
+			if mErr != nil {
+				return mErr
+			}
+			if !filter.Until.IsZero() && !entry.Time.Before(filter.Until) {
+				continue
+			}
+			if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+				break
+			}
+			if filter.Matches(&entry) {
+				entries = append(entries, &entry)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return entries, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
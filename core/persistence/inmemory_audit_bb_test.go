@@ -0,0 +1,50 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that the audit entries are
+// returned newest first, and filtered
+func TestGetAuditEntries(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	rep.CreateAuditEntry(&model.AuditEntry{Actor: "bob", Action: model.AuditCreate, ResourceType: model.AuditJob, ResourceId: "1", Time: now.Add(-2 * time.Minute)}, ctx)
+	rep.CreateAuditEntry(&model.AuditEntry{Actor: "alice", Action: model.AuditStart, ResourceType: model.AuditExecution, ResourceId: "2", Time: now.Add(-time.Minute)}, ctx)
+	rep.CreateAuditEntry(&model.AuditEntry{Actor: "bob", Action: model.AuditCancel, ResourceType: model.AuditExecution, ResourceId: "2", Time: now}, ctx)
+
+	// when
+	all, err := rep.GetAuditEntries(model.AuditFilter{}, ctx)
+	bob, bobErr := rep.GetAuditEntries(model.AuditFilter{Actor: "bob", ResourceType: model.AuditExecution}, ctx)
+	recent, recentErr := rep.GetAuditEntries(model.AuditFilter{Since: now.Add(-90 * time.Second), Limit: 1}, ctx)
+	old, oldErr := rep.GetAuditEntries(model.AuditFilter{Until: now.Add(-90 * time.Second)}, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || bobErr != nil || recentErr != nil || oldErr != nil {
+		t.Fatalf("expect no error, but got %v, %v, %v and %v", err, bobErr, recentErr, oldErr)
+	}
+	if len(all) != 3 || all[0].Action != model.AuditCancel || all[2].Action != model.AuditCreate {
+		t.Fatalf("expect all entries newest first, but got %+v", all)
+	}
+	if len(bob) != 1 || bob[0].Action != model.AuditCancel {
+		t.Fatalf("expect the cancelation by bob only, but got %+v", bob)
+	}
+	if len(recent) != 1 || !recent[0].Time.Equal(now) {
+		t.Fatalf("expect the last entry only, but got %+v", recent)
+	}
+	if len(old) != 1 || old[0].Action != model.AuditCreate {
+		t.Fatalf("expect the first entry only, but got %+v", old)
+	}
+}
@@ -0,0 +1,102 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+// returned when the file of the database is locked by another process
+var ErrDatabaseInUse = errors.New("persistence >> the database is used by another process, like a running server")
+
+// the backups are bbolt files
+var errSqlBackup = errors.New("persistence >> the backup of the sql databases is done with their own tools")
+
+// the stream of a large database may last longer than
+// PersistenceConf.TimeOut, only ctx bounds it
+func (i *inMemory) Backup(ctx context.Context, w io.Writer) PersistenceError {
+	err := i.doTransaction(ctx, false, func(tx *bolt.Tx) error {
+		_, writeErr := tx.WriteTo(w)
+		return writeErr
+	})
+	return wrapError(err)
+}
+
+// write a consistent snapshot of the database of conf
+// to w, without starting the repository. A running
+// server holds the database : ErrDatabaseInUse is returned.
+func Snapshot(conf *configuration.Conf, w io.Writer) error {
+	if conf.PersistenceConf.Type != configuration.InMemory {
+		return errSqlBackup
+	}
+	db, err := openBolt(conf.PersistenceConf, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		_, writeErr := tx.WriteTo(w)
+		return writeErr
+	})
+}
+
+// replace the database of conf with the snapshot file, which
+// is moved and must be on the same file system. The snapshot
+// is checked first : it must be a sound bbolt file, whose schema
+// version is known by this binary. The pending migrations are
+// played on the next start. The schema version of the
+// snapshot is returned.
+func RestoreSnapshot(conf *configuration.Conf, snapshot string) (int, error) {
+	if conf.PersistenceConf.Type != configuration.InMemory {
+		return 0, errSqlBackup
+	}
+	version, err := snapshotVersion(snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("persistence >> invalid snapshot : %s", err)
+	}
+	// the current database must not be in use
+	db, err := openBolt(conf.PersistenceConf, false)
+	if err != nil {
+		return 0, err
+	}
+	db.Close()
+	return version, os.Rename(snapshot, conf.PersistenceConf.Path())
+}
+
+func snapshotVersion(snapshot string) (int, error) {
+	db, err := openBolt(configuration.Persistence{Name: snapshot, OpenTimeOut: time.Second}, true)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	version := 0
+	err = db.View(func(tx *bolt.Tx) error {
+		// the channel must be drained
+		var corruption error
+		for checkErr := range tx.Check() {
+			if corruption == nil {
+				corruption = checkErr
+			}
+		}
+		if corruption != nil {
+			return corruption
+		}
+		if meta := tx.Bucket([]byte("meta")); meta != nil {
+			if data := meta.Get([]byte("schemaVersion")); data != nil {
+				mErr := json.Unmarshal(data, &version)
+				if mErr != nil {
+					return mErr
+				}
+			}
+		}
+		return checkSchemaVersion(version)
+	})
+	return version, err
+}
@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (i *inMemory) ApplyConfigurationChanges(ctx context.Context, changes *model.ConfigurationChanges) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		buckets := make(map[string]*bolt.Bucket)
+		for _, name := range []string{"jobs", "jobsExecutions", "logs", "purgedExecutions", "dockerRegistries", "users", "teams"} {
+			buckets[name] = tx.Bucket([]byte(name))
+			if buckets[name] == nil {
+				return fmt.Errorf("persistence >> CRITICAL error. No bucket %s. The database may be corrupted !", name)
+			}
+		}
+		for _, team := range changes.Teams {
+			sealed, err := i.secrets.sealTeam(team)
+			if err == nil {
+				err = putDocument(buckets["teams"], []byte(team.Id), sealed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, registry := range changes.DockerRegistries {
+			sealed, err := i.secrets.sealDockerRegistry(registry)
+			if err == nil {
+				err = putDocument(buckets["dockerRegistries"], []byte(registry.Id), sealed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, job := range changes.Jobs {
+			sealed, err := i.secrets.sealJob(job)
+			if err == nil {
+				err = putDocument(buckets["jobs"], job.Id, sealed)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, user := range changes.Users {
+			if err := putDocument(buckets["users"], []byte(user.Login), user); err != nil {
+				return err
+			}
+		}
+		for _, id := range changes.DeletedJobs {
+			if err := buckets["jobs"].Delete([]byte(id)); err != nil {
+				return err
+			}
+			// the executions, their logs and the tombstones
+			// of the purged ones are nested by job
+			for _, name := range []string{"jobsExecutions", "logs", "purgedExecutions"} {
+				if buckets[name].Bucket([]byte(id)) == nil {
+					continue
+				}
+				if err := buckets[name].DeleteBucket([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, id := range changes.DeletedDockerRegistries {
+			if err := buckets["dockerRegistries"].Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		for _, login := range changes.DeletedUsers {
+			if err := deleteApiTokensOf(tx, login); err != nil {
+				return err
+			}
+			_, err := deleteSessions(tx, func(session *model.Session) bool {
+				return session.Login == login
+			})
+			if err != nil {
+				return err
+			}
+			if err = buckets["users"].Delete([]byte(login)); err != nil {
+				return err
+			}
+		}
+		for _, id := range changes.DeletedTeams {
+			if err := buckets["teams"].Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(err)
+}
+
+func putDocument(b *bolt.Bucket, key []byte, document interface{}) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
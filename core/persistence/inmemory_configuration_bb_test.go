@@ -0,0 +1,67 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func TestApplyConfigurationChangesShouldStoreAndDelete(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	rep.CreateUser(&model.User{Login: "old"}, ctx)
+	old, _ := rep.CreateJob(&model.Job{Name: "old", GitConf: model.GitConfig{HttpAuth: &model.HttpAuthConfig{Url: "http://some.url"}}}, ctx)
+	rep.UpsertJobExecution(ctx, string(old.Id), &model.JobExecution{Id: "1", Status: model.Success})
+	changes := model.ConfigurationChanges{
+		Jobs:             []*model.Job{{Id: []byte("new"), Name: "new", TeamId: "team"}},
+		DockerRegistries: []*model.DockerRegistry{{Id: "registry", Name: "registry", Url: "some.url", TeamId: "team"}},
+		Users:            []*model.User{{Login: "new", Role: model.RoleMaintainer}},
+		Teams:            []*model.Team{{Id: "team", Name: "team", Secrets: map[string]string{"token": "s3cr3t"}}},
+		DeletedJobs:      []string{string(old.Id)},
+		DeletedUsers:     []string{"old", "unknown"},
+	}
+
+	// when
+	err := rep.ApplyConfigurationChanges(ctx, &changes)
+	job, jobErr := rep.GetJob([]byte("new"), ctx)
+	registry, registryErr := rep.GetDockerRegistry([]byte("registry"), ctx)
+	user, userErr := rep.GetUser("new", ctx)
+	team, teamErr := rep.GetTeam("team", ctx)
+	_, deletedJobErr := rep.GetJob(old.Id, ctx)
+	executions, executionsErr := rep.GetJobExecutions(ctx, string(old.Id))
+	_, deletedUserErr := rep.GetUser("old", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when applying the changes, but got %s", err.Error())
+	}
+	if jobErr != nil || job.TeamId != "team" {
+		t.Fatalf("expect the job to be stored, but got %+v and %+v", job, jobErr)
+	}
+	if registryErr != nil || registry.TeamId != "team" {
+		t.Fatalf("expect the registry to be stored, but got %+v and %+v", registry, registryErr)
+	}
+	if userErr != nil || user.Role != model.RoleMaintainer {
+		t.Fatalf("expect the user to be stored, but got %+v and %+v", user, userErr)
+	}
+	if teamErr != nil || team.Secrets["token"] != "s3cr3t" {
+		t.Fatalf("expect the team to be stored, but got %+v and %+v", team, teamErr)
+	}
+	if deletedJobErr == nil || deletedJobErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error for the deleted job, but got %+v", deletedJobErr)
+	}
+	if executionsErr != nil || len(executions) != 0 {
+		t.Fatalf("expect the executions of the deleted job to be deleted, but got %+v and %+v", executions, executionsErr)
+	}
+	if deletedUserErr == nil || deletedUserErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error for the deleted user, but got %+v", deletedUserErr)
+	}
+}
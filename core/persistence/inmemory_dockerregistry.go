@@ -13,7 +13,7 @@ import (
 
 func (i *inMemory) CreateDockerRegistry(registry *model.DockerRegistry, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
 	// todo see if factorization can be done
-	err := i.doUpdateAction(func(tx *bolt.Tx) error {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
 		// todo check that docker registry is non-nil
 		var updateErr error
 		b := tx.Bucket([]byte("dockerRegistries"))
@@ -24,12 +24,19 @@ func (i *inMemory) CreateDockerRegistry(registry *model.DockerRegistry, ctx cont
 		if updateErr != nil {
 			return updateErr
 		}
+		if b.Get([]byte(registry.Id)) != nil {
+			return newPersistenceError(fmt.Sprintf("A docker registry with id %s already exists", registry.Id), Conflict)
+		}
 		// initialization of LastModificationDate field
 		// that will be use later for optimistic lock on
 		// update requests.
 		registry.NewLastModificationTime()
+		sealed, updateErr := i.secrets.sealDockerRegistry(registry)
+		if updateErr != nil {
+			return updateErr
+		}
 		var data []byte
-		data, updateErr = json.Marshal(registry)
+		data, updateErr = json.Marshal(sealed)
 		if updateErr != nil {
 			return updateErr
 		}
@@ -44,28 +51,45 @@ func (i *inMemory) CreateDockerRegistry(registry *model.DockerRegistry, ctx cont
 }
 
 func (i *inMemory) GetDockerRegistry(id []byte, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
-	var registry model.DockerRegistry
-	err := i.doViewAction(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("dockerRegistries"))
-		if b == nil {
-			return errors.New("persistence >> CRITICAL error. No bucket for storing docker registries. The database may be corrupted !")
-		}
-		data := b.Get(id)
-		if data == nil {
-			return newPersistenceError(fmt.Sprintf("No docker registry with id %s found", string(id)), NotFound)
-		}
-		mErr := json.Unmarshal(data, &registry)
-		return mErr
+	var registry *model.DockerRegistry
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		var getErr error
+		registry, getErr = i.readDockerRegistry(tx, id)
+		return getErr
 	})
 	if err == nil {
-		return &registry, nil
+		return registry, nil
 	} else {
 		return nil, wrapError(err)
 	}
 }
 
-func (i *inMemory) DeleteDockerRegistry(id []byte) PersistenceError {
-	err := i.doUpdateAction(func(tx *bolt.Tx) error {
+// read and decrypt the registry within the given
+// transaction, so that it may be used while another
+// entity is read
+func (i *inMemory) readDockerRegistry(tx *bolt.Tx, id []byte) (*model.DockerRegistry, error) {
+	b := tx.Bucket([]byte("dockerRegistries"))
+	if b == nil {
+		return nil, errors.New("persistence >> CRITICAL error. No bucket for storing docker registries. The database may be corrupted !")
+	}
+	data := b.Get(id)
+	if data == nil {
+		return nil, newPersistenceError(fmt.Sprintf("No docker registry with id %s found", string(id)), NotFound)
+	}
+	var registry model.DockerRegistry
+	mErr := json.Unmarshal(data, &registry)
+	if mErr != nil {
+		return nil, mErr
+	}
+	mErr = i.secrets.openDockerRegistry(&registry)
+	if mErr != nil {
+		return nil, mErr
+	}
+	return &registry, nil
+}
+
+func (i *inMemory) DeleteDockerRegistry(ctx context.Context, id []byte) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("dockerRegistries"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing docker registries. The database may be corrupted !")
@@ -83,7 +107,7 @@ func (i *inMemory) DeleteDockerRegistry(id []byte) PersistenceError {
 
 func (i *inMemory) UpdateDockerRegistry(id []byte, registryUpdate *model.DockerRegistryUpdate, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
 	var updatedRegistry model.DockerRegistry
-	err := i.doUpdateAction(func(tx *bolt.Tx) error {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("dockerRegistries"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing docker registries. The database may be corrupted !")
@@ -94,6 +118,9 @@ func (i *inMemory) UpdateDockerRegistry(id []byte, registryUpdate *model.DockerR
 			return newPersistenceError(fmt.Sprintf("No docker registry with id %s found", string(id)), NotFound)
 		}
 		mErr := json.Unmarshal(data, &existingRegistry)
+		if mErr == nil {
+			mErr = i.secrets.openDockerRegistry(&existingRegistry)
+		}
 		if mErr != nil {
 			return mErr
 		}
@@ -104,7 +131,11 @@ func (i *inMemory) UpdateDockerRegistry(id []byte, registryUpdate *model.DockerR
 		}
 		registryUpdate.NewLastModificationTime()
 		registry := registryUpdate.MergeForUpdate(&existingRegistry)
-		data, updateErr := json.Marshal(registry)
+		sealed, updateErr := i.secrets.sealDockerRegistry(registry)
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr = json.Marshal(sealed)
 		if updateErr != nil {
 			return updateErr
 		}
@@ -117,14 +148,14 @@ func (i *inMemory) UpdateDockerRegistry(id []byte, registryUpdate *model.DockerR
 
 func (i *inMemory) GetDockerRegistries(ctx context.Context) ([]*model.DockerRegistry, PersistenceError) {
 	registries := make([]*model.DockerRegistry, 0)
-	err := i.doViewAction(func(tx *bolt.Tx) error {
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
 		var mErr error
 		b := tx.Bucket([]byte("dockerRegistries"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing docker registries. The database may be corrupted !")
 		}
 		c := b.Cursor()
-		registries, mErr = doFetchDockerRegistries(c, registries)
+		registries, mErr = doFetchDockerRegistries(c, registries, i.secrets)
 		return mErr
 	})
 	if err == nil {
@@ -135,11 +166,14 @@ func (i *inMemory) GetDockerRegistries(ctx context.Context) ([]*model.DockerRegi
 	return nil, nil
 }
 
-func doFetchDockerRegistries(c *bolt.Cursor, registries []*model.DockerRegistry) ([]*model.DockerRegistry, error) {
+func doFetchDockerRegistries(c *bolt.Cursor, registries []*model.DockerRegistry, secrets *secretBox) ([]*model.DockerRegistry, error) {
 	res := registries
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		var registry model.DockerRegistry
 		mErr := json.Unmarshal(v, &registry)
+		if mErr == nil {
+			mErr = secrets.openDockerRegistry(&registry)
+		}
 		if mErr != nil {
 			return nil, mErr
 		} else {
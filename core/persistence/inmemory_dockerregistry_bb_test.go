@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/model"
 	"github.com/jeromedoucet/dahu/core/persistence"
 	"github.com/jeromedoucet/dahu/tests"
@@ -18,7 +17,7 @@ func TestCreateDockerRegistryIdExist(t *testing.T) {
 	registry := &model.DockerRegistry{Name: "test", Url: "localhost:5000", User: "tester", Password: "test"}
 	registry.GenerateId()
 	expectedErrorMsg := fmt.Sprintf("the id %+v already defined", string(registry.Id))
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	r := persistence.GetRepository(c)
@@ -42,3 +41,31 @@ func TestCreateDockerRegistryIdExist(t *testing.T) {
 		t.Fatalf("wrong error messager got %s, expected %s", err.Error(), expectedErrorMsg)
 	}
 }
+
+// test that #UpdateDockerRegistry rejects an update based on a stale
+// version of the registry, and returns the current version
+func TestUpdateDockerRegistryShouldDetectConflict(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	r := persistence.GetRepository(c)
+	registry, _ := r.CreateDockerRegistry(&model.DockerRegistry{Name: "test", Url: "localhost:5000"}, ctx)
+	staleTime := registry.LastModificationTime
+	first := &model.DockerRegistryUpdate{DockerRegistry: model.DockerRegistry{Name: "first", LastModificationTime: staleTime}, ChangedFields: []string{"name"}}
+	second := &model.DockerRegistryUpdate{DockerRegistry: model.DockerRegistry{Name: "second", LastModificationTime: staleTime}, ChangedFields: []string{"name"}}
+	r.UpdateDockerRegistry([]byte(registry.Id), first, ctx)
+
+	// when
+	current, err := r.UpdateDockerRegistry([]byte(registry.Id), second, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect a Conflict error, but got %+v", err)
+	}
+	if current.Name != "first" || current.LastModificationTime == staleTime {
+		t.Fatalf("expect to get the registry updated by the first update, but got %+v", current)
+	}
+}
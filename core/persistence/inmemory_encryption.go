@@ -0,0 +1,54 @@
+package persistence
+
+import (
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+// re-encrypt the jobs and the registries in a single transaction
+func rotateBoltMasterKey(conf *configuration.Conf, current, next *secretBox) (int, error) {
+	db, err := openBolt(conf.PersistenceConf, false)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	changes := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		for bucketName, reencrypt := range map[string]func([]byte, *secretBox, *secretBox) ([]byte, error){
+			"jobs":             reencryptJob,
+			"dockerRegistries": reencryptDockerRegistry,
+			"teams":            reencryptTeam,
+			"signingKeys":      reencryptSigningKey,
+		} {
+			b := tx.Bucket([]byte(bucketName))
+			if b == nil {
+				return fmt.Errorf("persistence >> CRITICAL error. No bucket %s. The database may be corrupted !", bucketName)
+			}
+			// a bucket must not be modified while iterated
+			updated := make(map[string][]byte)
+			err := b.ForEach(func(k, v []byte) error {
+				data, err := reencrypt(v, current, next)
+				if err == nil {
+					updated[string(k)] = data
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for k, data := range updated {
+				if err = b.Put([]byte(k), data); err != nil {
+					return err
+				}
+			}
+			changes += len(updated)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changes, nil
+}
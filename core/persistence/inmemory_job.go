@@ -5,13 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	bolt "github.com/coreos/bbolt"
 	"github.com/jeromedoucet/dahu/core/model"
 )
 
 func (i *inMemory) CreateJob(job *model.Job, ctx context.Context) (*model.Job, PersistenceError) {
-	err := i.doUpdateAction(func(tx *bolt.Tx) error {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
 		// todo check that job is non-nil
 		var updateErr error
 		b := tx.Bucket([]byte("jobs"))
@@ -22,8 +24,15 @@ func (i *inMemory) CreateJob(job *model.Job, ctx context.Context) (*model.Job, P
 		if updateErr != nil {
 			return updateErr
 		}
+		if b.Get(job.Id) != nil {
+			return newPersistenceError(fmt.Sprintf("A job with id %s already exists", string(job.Id)), Conflict)
+		}
+		sealed, updateErr := i.secrets.sealJob(job)
+		if updateErr != nil {
+			return updateErr
+		}
 		var data []byte
-		data, updateErr = json.Marshal(job)
+		data, updateErr = json.Marshal(sealed)
 		if updateErr != nil {
 			return updateErr
 		}
@@ -39,7 +48,7 @@ func (i *inMemory) CreateJob(job *model.Job, ctx context.Context) (*model.Job, P
 
 func (i *inMemory) GetJob(id []byte, ctx context.Context) (*model.Job, PersistenceError) {
 	var job model.Job
-	err := i.doViewAction(func(tx *bolt.Tx) error {
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("jobs"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs. The database may be corrupted !")
@@ -49,10 +58,13 @@ func (i *inMemory) GetJob(id []byte, ctx context.Context) (*model.Job, Persisten
 			return newPersistenceError(fmt.Sprintf("No Job with id %s found", string(id)), NotFound)
 		}
 		mErr := json.Unmarshal(data, &job)
+		if mErr == nil {
+			mErr = i.secrets.openJob(&job)
+		}
 		if mErr == nil {
 			for index, step := range job.Steps {
 				if step.Image.RegistryId != "" {
-					registry, regErr := i.GetDockerRegistry([]byte(step.Image.RegistryId), ctx)
+					registry, regErr := i.readDockerRegistry(tx, []byte(step.Image.RegistryId))
 					if regErr != nil {
 						return regErr
 					} else {
@@ -60,6 +72,16 @@ func (i *inMemory) GetJob(id []byte, ctx context.Context) (*model.Job, Persisten
 					}
 				}
 			}
+			for index, notifier := range job.Notifiers {
+				if notifier.Image.RegistryId != "" {
+					registry, regErr := i.readDockerRegistry(tx, []byte(notifier.Image.RegistryId))
+					if regErr != nil {
+						return regErr
+					} else {
+						job.Notifiers[index].Image.Registry = registry
+					}
+				}
+			}
 		}
 		return mErr
 	})
@@ -73,14 +95,14 @@ func (i *inMemory) GetJob(id []byte, ctx context.Context) (*model.Job, Persisten
 func (i *inMemory) GetJobs(ctx context.Context) ([]*model.Job, PersistenceError) {
 	// todo add missing tests
 	jobs := make([]*model.Job, 0)
-	err := i.doViewAction(func(tx *bolt.Tx) error {
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
 		var mErr error
 		b := tx.Bucket([]byte("jobs"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs. The database may be corrupted !")
 		}
 		c := b.Cursor()
-		jobs, mErr = doFetchJobs(c, jobs)
+		jobs, mErr = doFetchJobs(c, jobs, i.secrets)
 		return mErr
 	})
 	if err == nil {
@@ -90,8 +112,39 @@ func (i *inMemory) GetJobs(ctx context.Context) ([]*model.Job, PersistenceError)
 	}
 }
 
+func (i *inMemory) CreateJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		if execution.Id == "" {
+			if err := execution.GenerateId(); err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket([]byte("jobsExecutions"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs execution. The database may be corrupted !")
+		}
+		eb, err := b.CreateBucketIfNotExists([]byte(jobId))
+		if err != nil {
+			return err
+		}
+		if eb.Get([]byte(execution.Id)) != nil {
+			return newPersistenceError(fmt.Sprintf("An execution with id %s already exists for job %s", execution.Id, jobId), Conflict)
+		}
+		data, err := json.Marshal(execution)
+		if err != nil {
+			return err
+		}
+		return eb.Put([]byte(execution.Id), data)
+	})
+	if err == nil {
+		return execution, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
 func (i *inMemory) UpsertJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError) {
-	err := i.doUpdateAction(func(tx *bolt.Tx) error {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
 		var updateErr error
 		if execution.Id == "" {
 			return errors.New("persistence >> Cannot persist a job execution without id !")
@@ -100,6 +153,13 @@ func (i *inMemory) UpsertJobExecution(ctx context.Context, jobId string, executi
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs execution. The database may be corrupted !")
 		}
+		pb := tx.Bucket([]byte("purgedExecutions"))
+		if pb == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing purged executions. The database may be corrupted !")
+		}
+		if jpb := pb.Bucket([]byte(jobId)); jpb != nil && jpb.Get([]byte(execution.Id)) != nil {
+			return newPersistenceError(fmt.Sprintf("The execution %s of job %s has been purged", execution.Id, jobId), NoMorePersisted)
+		}
 		eb, err := b.CreateBucketIfNotExists([]byte(jobId))
 		if err != nil {
 			return err
@@ -119,11 +179,217 @@ func (i *inMemory) UpsertJobExecution(ctx context.Context, jobId string, executi
 	}
 }
 
-func doFetchJobs(c *bolt.Cursor, jobs []*model.Job) ([]*model.Job, error) {
+func (i *inMemory) DeleteJobExecutions(ctx context.Context, jobId string, executionIds []string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("jobsExecutions"))
+		lb := tx.Bucket([]byte("logs"))
+		pb := tx.Bucket([]byte("purgedExecutions"))
+		if b == nil || lb == nil || pb == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs execution. The database may be corrupted !")
+		}
+		jpb, err := pb.CreateBucketIfNotExists([]byte(jobId))
+		if err != nil {
+			return err
+		}
+		purgedAt, err := json.Marshal(time.Now())
+		if err != nil {
+			return err
+		}
+		eb := b.Bucket([]byte(jobId))
+		jlb := lb.Bucket([]byte(jobId))
+		for _, executionId := range executionIds {
+			if eb != nil {
+				err = eb.Delete([]byte(executionId))
+				if err != nil {
+					return err
+				}
+			}
+			if jlb != nil && jlb.Bucket([]byte(executionId)) != nil {
+				err = jlb.DeleteBucket([]byte(executionId))
+				if err != nil {
+					return err
+				}
+			}
+			err = jpb.Put([]byte(executionId), purgedAt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) ForgetPurgedExecutions(ctx context.Context, before time.Time) (int, PersistenceError) {
+	forgotten := 0
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		pb := tx.Bucket([]byte("purgedExecutions"))
+		if pb == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing purged executions. The database may be corrupted !")
+		}
+		type purgedExecution struct {
+			jobId       []byte
+			executionId []byte
+		}
+		// a bucket must not be modified while iterated
+		toForget := make([]purgedExecution, 0)
+		err := pb.ForEach(func(jobId, v []byte) error {
+			jpb := pb.Bucket(jobId)
+			if jpb == nil {
+				return nil
+			}
+			return jpb.ForEach(func(executionId, data []byte) error {
+				var purgedAt time.Time
+				err := json.Unmarshal(data, &purgedAt)
+				if err == nil && purgedAt.Before(before) {
+					toForget = append(toForget, purgedExecution{jobId: append([]byte(nil), jobId...), executionId: append([]byte(nil), executionId...)})
+				}
+				return err
+			})
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range toForget {
+			err = pb.Bucket(p.jobId).Delete(p.executionId)
+			if err != nil {
+				return err
+			}
+		}
+		forgotten = len(toForget)
+		return nil
+	})
+	if err == nil {
+		return forgotten, nil
+	} else {
+		return 0, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetJobExecution(ctx context.Context, jobId, executionId string) (*model.JobExecution, PersistenceError) {
+	var execution model.JobExecution
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("jobsExecutions"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs execution. The database may be corrupted !")
+		}
+		var data []byte
+		eb := b.Bucket([]byte(jobId))
+		if eb != nil {
+			data = eb.Get([]byte(executionId))
+		}
+		if data == nil {
+			return newPersistenceError(fmt.Sprintf("No execution with id %s found for job %s", executionId, jobId), NotFound)
+		}
+		return json.Unmarshal(data, &execution)
+	})
+	if err == nil {
+		return &execution, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetJobExecutions(ctx context.Context, jobId string) ([]*model.JobExecution, PersistenceError) {
+	executions := make([]*model.JobExecution, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		var mErr error
+		b := tx.Bucket([]byte("jobsExecutions"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing jobs execution. The database may be corrupted !")
+		}
+		eb := b.Bucket([]byte(jobId))
+		if eb == nil {
+			// no execution yet for this job
+			return nil
+		}
+		executions, mErr = doFetchJobExecutions(eb.Cursor(), executions)
+		return mErr
+	})
+	if err == nil {
+		return executions, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// bbolt has no index on the executions, so
+// they are all read then filtered in memory.
+func (i *inMemory) SearchJobExecutions(ctx context.Context, jobId string, filter model.ExecutionFilter) ([]*model.JobExecution, PersistenceError) {
+	executions, err := i.GetJobExecutions(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.JobExecution, 0)
+	skipped := 0
+	for index := len(executions) - 1; index >= 0 && (filter.Limit <= 0 || len(res) < filter.Limit); index-- {
+		if !filter.Matches(executions[index]) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		res = append(res, executions[index])
+	}
+	return res, nil
+}
+
+func (i *inMemory) GetJobExecutionStats(ctx context.Context, jobId string, filter model.ExecutionFilter) (*model.ExecutionStats, PersistenceError) {
+	executions, err := i.GetJobExecutions(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	stats := model.ExecutionStats{ByStatus: make(map[model.ExecutionStatus]int)}
+	var successDuration time.Duration
+	for _, execution := range executions {
+		if !filter.Matches(execution) {
+			continue
+		}
+		stats.Count++
+		stats.ByStatus[execution.Status]++
+		if execution.Status == model.Success {
+			successDuration += execution.Duration
+		}
+	}
+	if successes := stats.ByStatus[model.Success]; successes > 0 {
+		stats.AverageDuration = successDuration / time.Duration(successes)
+	}
+	return &stats, nil
+}
+
+func doFetchJobExecutions(c *bolt.Cursor, executions []*model.JobExecution) ([]*model.JobExecution, error) {
+	res := executions
+	sorted := true
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var execution model.JobExecution
+		mErr := json.Unmarshal(v, &execution)
+		if mErr != nil {
+			return nil, mErr
+		} else {
+			res = append(res, &execution)
+			sorted = sorted && model.IsSortableId(execution.Id)
+		}
+	}
+	// the ids are sorted by creation time, and so are the keys. The
+	// executions created by the former versions of Dahu have random
+	// ids, which don't reflect the chronology of the executions.
+	if !sorted {
+		sort.SliceStable(res, func(i, j int) bool {
+			return res[i].Date.Before(res[j].Date)
+		})
+	}
+	return res, nil
+}
+
+func doFetchJobs(c *bolt.Cursor, jobs []*model.Job, secrets *secretBox) ([]*model.Job, error) {
 	res := jobs
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		var job model.Job
 		mErr := json.Unmarshal(v, &job)
+		if mErr == nil {
+			mErr = secrets.openJob(&job)
+		}
 		if mErr != nil {
 			return nil, mErr
 		} else {
@@ -3,8 +3,8 @@ package persistence_test
 import (
 	"context"
 	"testing"
+	"time"
 
-	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/model"
 	"github.com/jeromedoucet/dahu/core/persistence"
 	"github.com/jeromedoucet/dahu/tests"
@@ -16,7 +16,7 @@ func TestCreateJobShouldReturnAnErrorWhenJobHasAnId(t *testing.T) {
 	// given
 	j := model.Job{Name: "test"}
 	j.GenerateId()
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	r := persistence.GetRepository(c)
@@ -43,7 +43,7 @@ func TestGetJobShouldReturnTheJobWhenItExists(t *testing.T) {
 	// given
 	j := model.Job{Name: "test"}
 	j.GenerateId()
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	tests.InsertObject(c, []byte("jobs"), []byte(j.Id), j)
@@ -64,12 +64,44 @@ func TestGetJobShouldReturnTheJobWhenItExists(t *testing.T) {
 	}
 }
 
+// test that #GetJob resolves the registries of
+// the steps and of the notifiers
+func TestGetJobShouldResolveTheRegistries(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	registry, _ := rep.CreateDockerRegistry(&model.DockerRegistry{Name: "registry", Url: "some.url", Password: "registryPassword"}, ctx)
+	j, _ := rep.CreateJob(&model.Job{
+		Name:      "test",
+		Steps:     []model.Step{model.Step{Name: "build", Image: model.Image{Name: "golang", RegistryId: registry.Id}}},
+		Notifiers: []model.Notifier{model.Notifier{Name: "slack", Image: model.Image{Name: "notifier", RegistryId: registry.Id}}},
+	}, ctx)
+
+	// when
+	actualJob, err := rep.GetJob(j.Id, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when finding existing job, but got %s", err.Error())
+	}
+	if actualJob.Steps[0].Image.Registry == nil || actualJob.Steps[0].Image.Registry.Password != "registryPassword" {
+		t.Errorf("expect the registry of the step to be resolved, but got %+v", actualJob.Steps[0].Image.Registry)
+	}
+	if actualJob.Notifiers[0].Image.Registry == nil || actualJob.Notifiers[0].Image.Registry.Password != "registryPassword" {
+		t.Errorf("expect the registry of the notifier to be resolved, but got %+v", actualJob.Notifiers[0].Image.Registry)
+	}
+}
+
 // test the nominal case of #GetJob
 func TestGetJobShouldReturnAnErrorWhenItDoesntExists(t *testing.T) {
 	// given
 	j := model.Job{Name: "test"}
 	j.GenerateId()
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	tests.InsertObject(c, []byte("jobs"), []byte(j.Id), j)
@@ -89,3 +121,285 @@ func TestGetJobShouldReturnAnErrorWhenItDoesntExists(t *testing.T) {
 		t.Errorf("expect to get nil but got %s", actualJob.String())
 	}
 }
+
+// test that #GetJobExecutions return executions
+// of the job sorted by date
+func TestGetJobExecutionsShouldReturnExecutionsSortedByDate(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	recent := &model.JobExecution{Id: "1", Date: now, Status: model.Success}
+	old := &model.JobExecution{Id: "2", Date: now.Add(-1 * time.Hour), Status: model.Failure}
+	rep.UpsertJobExecution(ctx, "some-job", recent)
+	rep.UpsertJobExecution(ctx, "some-job", old)
+	rep.UpsertJobExecution(ctx, "other-job", &model.JobExecution{Id: "3", Date: now})
+
+	// when
+	executions, err := rep.GetJobExecutions(ctx, "some-job")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching executions, but got %s", err.Error())
+	}
+	if len(executions) != 2 {
+		t.Fatalf("expect to get %d executions but got %d", 2, len(executions))
+	}
+	if executions[0].Id != old.Id || executions[1].Id != recent.Id {
+		t.Fatalf("expect executions to be sorted by date, but got %s then %s", executions[0].Id, executions[1].Id)
+	}
+}
+
+// test that #GetJobExecutions return the executions created
+// with #CreateJobExecution in creation order, even inside
+// the same millisecond
+func TestGetJobExecutionsShouldReturnExecutionsInCreationOrder(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	created := make([]string, 0)
+	for index := 0; index < 5; index++ {
+		execution, _ := rep.CreateJobExecution(ctx, "some-job", &model.JobExecution{Date: now, Status: model.Success})
+		created = append(created, execution.Id)
+	}
+
+	// when
+	executions, err := rep.GetJobExecutions(ctx, "some-job")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching executions, but got %s", err.Error())
+	}
+	if len(executions) != len(created) {
+		t.Fatalf("expect to get %d executions but got %d", len(created), len(executions))
+	}
+	for index, execution := range executions {
+		if execution.Id != created[index] {
+			t.Fatalf("expect the execution %d to be %s, but got %s", index, created[index], execution.Id)
+		}
+	}
+}
+
+// test that #CreateJobExecution return a Conflict
+// error when the id is already used by the job
+func TestCreateJobExecutionShouldReturnConflictWhenTheIdIsUsed(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	execution, _ := rep.CreateJobExecution(ctx, "some-job", &model.JobExecution{Date: time.Now(), Status: model.Running})
+
+	// when
+	_, err := rep.CreateJobExecution(ctx, "some-job", &model.JobExecution{Id: execution.Id, Date: time.Now(), Status: model.Success})
+	_, otherJobErr := rep.CreateJobExecution(ctx, "other-job", &model.JobExecution{Id: execution.Id, Date: time.Now()})
+	stored, getErr := rep.GetJobExecution(ctx, "some-job", execution.Id)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect to have a Conflict error, but got %v", err)
+	}
+	if otherJobErr != nil {
+		t.Errorf("expect the id to be free for another job, but got %s", otherJobErr.Error())
+	}
+	if getErr != nil || stored.Status != model.Running {
+		t.Errorf("expect the first execution to be kept, but got %v %v", getErr, stored)
+	}
+}
+
+// test that #GetJobExecutions return an empty
+// slice when the job has never been executed
+func TestGetJobExecutionsShouldReturnEmptySliceWhenNoExecution(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	executions, err := rep.GetJobExecutions(ctx, "some-job")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching executions, but got %s", err.Error())
+	}
+	if len(executions) != 0 {
+		t.Fatalf("expect to get no executions but got %d", len(executions))
+	}
+}
+
+// test the nominal case of #GetJobExecution
+func TestGetJobExecution(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", BranchName: "master"})
+
+	// when
+	execution, err := rep.GetJobExecution(ctx, "some-job", "1")
+	_, notFoundErr := rep.GetJobExecution(ctx, "some-job", "2")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching the execution, but got %s", err.Error())
+	}
+	if execution.BranchName != "master" {
+		t.Fatalf("expect to get the execution on master, but got %+v", execution)
+	}
+	if notFoundErr == nil || notFoundErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error for an unknown execution, but got %+v", notFoundErr)
+	}
+}
+
+// test that #SearchJobExecutions filters the executions
+// of the job, newest first, one page at a time
+func TestSearchJobExecutionsShouldFilterAndPaginate(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", BranchName: "master", Date: now.Add(-4 * time.Hour), Status: model.Failure})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "2", BranchName: "master", Date: now.Add(-3 * time.Hour), Status: model.Success})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "3", BranchName: "feature", Date: now.Add(-2 * time.Hour), Status: model.Failure})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "4", BranchName: "master", Date: now.Add(-1 * time.Hour), Status: model.Failure})
+	rep.UpsertJobExecution(ctx, "other-job", &model.JobExecution{Id: "5", BranchName: "master", Date: now, Status: model.Failure})
+
+	// when
+	failures, err := rep.SearchJobExecutions(ctx, "some-job", model.ExecutionFilter{Status: model.Failure})
+	page, pageErr := rep.SearchJobExecutions(ctx, "some-job", model.ExecutionFilter{Branch: "master", Offset: 1, Limit: 1})
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || pageErr != nil {
+		t.Fatalf("expect to have no error when searching executions, but got %+v and %+v", err, pageErr)
+	}
+	if len(failures) != 3 || failures[0].Id != "4" || failures[1].Id != "3" || failures[2].Id != "1" {
+		t.Fatalf("expect to get the failures 4, 3 then 1, but got %+v", failures)
+	}
+	if len(page) != 1 || page[0].Id != "2" {
+		t.Fatalf("expect to get the execution 2 as second execution on master, but got %+v", page)
+	}
+}
+
+// test that #GetJobExecutionStats counts the executions
+// by status and averages the duration of the successes
+func TestGetJobExecutionStats(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", BranchName: "master", Date: now, Status: model.Success, Duration: time.Minute})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "2", BranchName: "master", Date: now, Status: model.Success, Duration: 3 * time.Minute})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "3", BranchName: "master", Date: now, Status: model.Failure, Duration: time.Hour})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "4", BranchName: "feature", Date: now, Status: model.Success, Duration: time.Hour})
+
+	// when
+	stats, err := rep.GetJobExecutionStats(ctx, "some-job", model.ExecutionFilter{Branch: "master"})
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when computing the stats, but got %s", err.Error())
+	}
+	if stats.Count != 3 || stats.ByStatus[model.Success] != 2 || stats.ByStatus[model.Failure] != 1 {
+		t.Fatalf("expect 2 successes and 1 failure on master, but got %+v", stats)
+	}
+	if stats.AverageDuration != 2*time.Minute {
+		t.Fatalf("expect an average duration of %s, but got %s", 2*time.Minute, stats.AverageDuration)
+	}
+}
+
+// test that #DeleteJobExecutions deletes the executions with their
+// logs, and that they may not be updated afterward
+func TestDeleteJobExecutionsShouldPurgeExecutionsAndLogs(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", Status: model.Success})
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "2", Status: model.Success})
+	rep.AppendLogs(ctx, "some-job", "1", 0, []model.LogLine{{Number: 1, Content: "some line"}})
+
+	// when
+	err := rep.DeleteJobExecutions(ctx, "some-job", []string{"1", "unknown"})
+	_, purgedErr := rep.GetJobExecution(ctx, "some-job", "1")
+	_, keptErr := rep.GetJobExecution(ctx, "some-job", "2")
+	lines, logsErr := rep.GetLogs(ctx, "some-job", "1", 0, 0, 0)
+	_, updateErr := rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", Status: model.Failure})
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when deleting executions, but got %s", err.Error())
+	}
+	if purgedErr == nil || purgedErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error for a purged execution, but got %+v", purgedErr)
+	}
+	if keptErr != nil {
+		t.Fatalf("expect the execution 2 to be kept, but got %s", keptErr.Error())
+	}
+	if logsErr != nil || len(lines) != 0 {
+		t.Fatalf("expect the logs to be deleted, but got %+v and %+v", lines, logsErr)
+	}
+	if updateErr == nil || updateErr.ErrorType() != persistence.NoMorePersisted {
+		t.Fatalf("expect a NoMorePersisted error when updating a purged execution, but got %+v", updateErr)
+	}
+}
+
+// test that #ForgetPurgedExecutions only forgets the
+// executions purged before the given instant, whose
+// updates are then accepted again
+func TestForgetPurgedExecutions(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", Status: model.Success})
+	rep.UpsertJobExecution(ctx, "other-job", &model.JobExecution{Id: "2", Status: model.Success})
+	rep.DeleteJobExecutions(ctx, "some-job", []string{"1"})
+	rep.DeleteJobExecutions(ctx, "other-job", []string{"2"})
+
+	// when
+	none, noneErr := rep.ForgetPurgedExecutions(ctx, time.Now().Add(-time.Hour))
+	all, allErr := rep.ForgetPurgedExecutions(ctx, time.Now().Add(time.Second))
+	_, updateErr := rep.UpsertJobExecution(ctx, "some-job", &model.JobExecution{Id: "1", Status: model.Failure})
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if noneErr != nil || none != 0 {
+		t.Fatalf("expect no execution purged an hour ago to be forgotten, but got %d and %+v", none, noneErr)
+	}
+	if allErr != nil || all != 2 {
+		t.Fatalf("expect the two purged executions to be forgotten, but got %d and %+v", all, allErr)
+	}
+	if updateErr != nil {
+		t.Fatalf("expect a forgotten execution to be updated, but got %s", updateErr.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package persistence
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// logs are stored in nested buckets : logs -> job id -> execution id -> step index.
+// Inside the bucket of a step, each entry is a chunk of consecutive lines. The key
+// of a chunk is the number of its first line, big endian encoded, so that a cursor
+// iterates on the chunks in the lines order.
+
+func (i *inMemory) AppendLogs(ctx context.Context, jobId, executionId string, step int, lines []model.LogLine) PersistenceError {
+	if len(lines) == 0 {
+		return nil
+	}
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("logs"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing logs. The database may be corrupted !")
+		}
+		sb, err := createNestedBuckets(b, []byte(jobId), []byte(executionId), []byte(strconv.Itoa(step)))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(lines)
+		if err != nil {
+			return err
+		}
+		return sb.Put(chunkKey(lines[0].Number), data)
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) GetLogs(ctx context.Context, jobId, executionId string, step, from, to int) ([]model.LogLine, PersistenceError) {
+	lines := make([]model.LogLine, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("logs"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing logs. The database may be corrupted !")
+		}
+		sb := nestedBucket(b, []byte(jobId), []byte(executionId), []byte(strconv.Itoa(step)))
+		if sb == nil {
+			// no log yet for this step
+			return nil
+		}
+		c := sb.Cursor()
+		// the line from may be inside the
+		// chunk before the one found by Seek.
+		k, v := c.Seek(chunkKey(from))
+		if k == nil || binary.BigEndian.Uint64(k) > uint64(from) {
+			k, v = c.Prev()
+			if k == nil {
+				k, v = c.First()
+			}
+		}
+		for ; k != nil; k, v = c.Next() {
+			if to > 0 && binary.BigEndian.Uint64(k) > uint64(to) {
+				break
+			}
+			var chunk []model.LogLine
+			mErr := json.Unmarshal(v, &chunk)
+			if mErr != nil {
+				return mErr
+			}
+			for _, line := range chunk {
+				if line.Number >= from && (to <= 0 || line.Number <= to) {
+					lines = append(lines, line)
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return lines, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func chunkKey(firstLine int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(firstLine))
+	return key
+}
+
+// createNestedBuckets create if needed the buckets
+// identified by names, each one inside the previous.
+func createNestedBuckets(root *bolt.Bucket, names ...[]byte) (*bolt.Bucket, error) {
+	var err error
+	b := root
+	for _, name := range names {
+		b, err = b.CreateBucketIfNotExists(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// nestedBucket return the bucket identified by names, each
+// one inside the previous, or nil if one doesn't exist.
+func nestedBucket(root *bolt.Bucket, names ...[]byte) *bolt.Bucket {
+	b := root
+	for _, name := range names {
+		b = b.Bucket(name)
+		if b == nil {
+			return nil
+		}
+	}
+	return b
+}
@@ -0,0 +1,111 @@
+package persistence_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that appended chunks of logs are
+// returned in the lines order
+func TestAppendAndGetLogs(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	firstChunk := []model.LogLine{
+		model.LogLine{Number: 1, Time: now, Stream: model.Stdout, Content: "first"},
+		model.LogLine{Number: 2, Time: now, Stream: model.Stderr, Content: "second"},
+	}
+	secondChunk := []model.LogLine{
+		model.LogLine{Number: 3, Time: now, Stream: model.Stdout, Content: "third"},
+	}
+	rep.AppendLogs(ctx, "job", "execution", 1, firstChunk)
+	rep.AppendLogs(ctx, "job", "execution", 1, secondChunk)
+	rep.AppendLogs(ctx, "job", "execution", 2, []model.LogLine{model.LogLine{Number: 1, Content: "other step"}})
+
+	// when
+	lines, err := rep.GetLogs(ctx, "job", "execution", 1, 0, 0)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching logs, but got %s", err.Error())
+	}
+	if len(lines) != 3 {
+		t.Fatalf("expect to get %d lines, but got %d", 3, len(lines))
+	}
+	for i, expected := range []string{"first", "second", "third"} {
+		if lines[i].Content != expected || lines[i].Number != i+1 {
+			t.Fatalf("expect line %d to be %s, but got %+v", i+1, expected, lines[i])
+		}
+	}
+	if lines[1].Stream != model.Stderr {
+		t.Fatalf("expect the stream of the line to be kept, but got %s", lines[1].Stream)
+	}
+}
+
+// test that no logs is returned
+// for an unknown step
+func TestGetLogsUnknownStep(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	lines, err := rep.GetLogs(ctx, "job", "execution", 1, 0, 0)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching logs, but got %s", err.Error())
+	}
+	if len(lines) != 0 {
+		t.Fatalf("expect to get no line, but got %d", len(lines))
+	}
+}
+
+// test that only the lines of the
+// range are returned, across chunks
+func TestGetLogsRange(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	for chunk := 0; chunk < 3; chunk++ {
+		var lines []model.LogLine
+		for i := 1; i <= 10; i++ {
+			number := chunk*10 + i
+			lines = append(lines, model.LogLine{Number: number, Content: strconv.Itoa(number)})
+		}
+		rep.AppendLogs(ctx, "job", "execution", 1, lines)
+	}
+
+	// when
+	lines, err := rep.GetLogs(ctx, "job", "execution", 1, 5, 22)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when fetching logs, but got %s", err.Error())
+	}
+	if len(lines) != 18 {
+		t.Fatalf("expect to get %d lines, but got %d", 18, len(lines))
+	}
+	if lines[0].Number != 5 || lines[17].Number != 22 {
+		t.Fatalf("expect lines from 5 to 22, but got from %d to %d", lines[0].Number, lines[17].Number)
+	}
+}
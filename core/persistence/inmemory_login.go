@@ -0,0 +1,66 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// login attempts are stored by time
+// then id, so that the cursor of the
+// bucket iterates them chronologically.
+func (i *inMemory) CreateLoginAttempt(attempt *model.LoginAttempt, ctx context.Context) (*model.LoginAttempt, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("loginAttempts"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing login attempts. The database may be corrupted !")
+		}
+		updateErr = attempt.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		var data []byte
+		data, updateErr = json.Marshal(attempt)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(fmt.Sprintf("%020d-%s", attempt.Time.UnixNano(), attempt.Id)), data)
+	})
+	if err == nil {
+		return attempt, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetLoginAttempts(login string, limit int, ctx context.Context) ([]*model.LoginAttempt, PersistenceError) {
+	attempts := make([]*model.LoginAttempt, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("loginAttempts"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing login attempts. The database may be corrupted !")
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(attempts) < limit; k, v = c.Prev() {
+			var attempt model.LoginAttempt
+			mErr := json.Unmarshal(v, &attempt)
+			if mErr != nil {
+				return mErr
+			}
+			if login == "" || attempt.Login == login {
+				attempts = append(attempts, &attempt)
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return attempts, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
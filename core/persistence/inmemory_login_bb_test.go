@@ -0,0 +1,46 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that the login attempts are
+// returned newest first, and filtered
+func TestGetLoginAttempts(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	rep.CreateLoginAttempt(&model.LoginAttempt{Login: "bob", Reason: "invalid credentials", Time: now.Add(-2 * time.Minute)}, ctx)
+	rep.CreateLoginAttempt(&model.LoginAttempt{Login: "alice", Success: true, Time: now.Add(-time.Minute)}, ctx)
+	rep.CreateLoginAttempt(&model.LoginAttempt{Login: "bob", Success: true, Time: now}, ctx)
+
+	// when
+	all, err := rep.GetLoginAttempts("", 10, ctx)
+	bob, bobErr := rep.GetLoginAttempts("bob", 10, ctx)
+	last, lastErr := rep.GetLoginAttempts("", 1, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || bobErr != nil || lastErr != nil {
+		t.Fatalf("expect no error, but got %v, %v and %v", err, bobErr, lastErr)
+	}
+	if len(all) != 3 || all[0].Login != "bob" || all[1].Login != "alice" {
+		t.Fatalf("expect all attempts newest first, but got %+v", all)
+	}
+	if len(bob) != 2 || !bob[0].Success || bob[1].Success {
+		t.Fatalf("expect the two attempts of bob, but got %+v", bob)
+	}
+	if len(last) != 1 || !last[0].Time.Equal(now) {
+		t.Fatalf("expect the last attempt only, but got %+v", last)
+	}
+}
@@ -0,0 +1,195 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// sessions are stored by hash of their refresh
+// token, because this is the way they are searched for.
+func (i *inMemory) CreateSession(session *model.Session, ctx context.Context) (*model.Session, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("sessions"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing sessions. The database may be corrupted !")
+		}
+		if session.Hash == "" {
+			return errors.New("persistence >> Cannot persist a session without hash !")
+		}
+		if b.Get([]byte(session.Hash)) != nil {
+			return newPersistenceError("A session with the same hash already exists", Conflict)
+		}
+		updateErr = session.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		var data []byte
+		data, updateErr = json.Marshal(session)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(session.Hash), data)
+	})
+	if err == nil {
+		return session, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) RotateSession(hash, newHash string, expiresAt time.Time, ctx context.Context) (*model.Session, PersistenceError) {
+	var session model.Session
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing sessions. The database may be corrupted !")
+		}
+		data := b.Get([]byte(hash))
+		if data == nil {
+			return newPersistenceError("No session found", NotFound)
+		}
+		mErr := json.Unmarshal(data, &session)
+		if mErr != nil {
+			return mErr
+		}
+		if session.IsExpired(time.Now()) {
+			return newPersistenceError("No session found", NotFound)
+		}
+		// the previous refresh token must
+		// not be usable anymore.
+		mErr = b.Delete([]byte(hash))
+		if mErr != nil {
+			return mErr
+		}
+		session.Hash = newHash
+		session.ExpiresAt = expiresAt
+		data, mErr = json.Marshal(session)
+		if mErr != nil {
+			return mErr
+		}
+		return b.Put([]byte(newHash), data)
+	})
+	if err == nil {
+		return &session, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) DeleteSession(ctx context.Context, id string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		deleted, deleteErr := deleteSessions(tx, func(session *model.Session) bool {
+			return session.Id == id
+		})
+		if deleteErr == nil && deleted == 0 {
+			return newPersistenceError("No session found", NotFound)
+		}
+		return deleteErr
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) DeleteSessionsOf(ctx context.Context, login string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		_, deleteErr := deleteSessions(tx, func(session *model.Session) bool {
+			return session.Login == login
+		})
+		return deleteErr
+	})
+	return wrapError(err)
+}
+
+// delete the sessions matching the given
+// predicate and return how many were deleted.
+// Expired sessions are purged on the way.
+func deleteSessions(tx *bolt.Tx, match func(session *model.Session) bool) (int, error) {
+	b := tx.Bucket([]byte("sessions"))
+	if b == nil {
+		return 0, errors.New("persistence >> CRITICAL error. No bucket for storing sessions. The database may be corrupted !")
+	}
+	now := time.Now()
+	matchingHashes := make([][]byte, 0)
+	hashes := make([][]byte, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		var session model.Session
+		mErr := json.Unmarshal(v, &session)
+		if mErr != nil {
+			return mErr
+		}
+		if match(&session) {
+			matchingHashes = append(matchingHashes, k)
+		} else if session.IsExpired(now) {
+			hashes = append(hashes, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// keys can't be deleted while iterating with ForEach
+	for _, hash := range append(hashes, matchingHashes...) {
+		err = b.Delete(hash)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(matchingHashes), nil
+}
+
+func (i *inMemory) RevokeToken(id string, expiresAt time.Time, ctx context.Context) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("revokedTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing revoked tokens. The database may be corrupted !")
+		}
+		// a revoked token that has expired is rejected
+		// anyway, there is no need to keep it.
+		now := time.Now()
+		expiredIds := make([][]byte, 0)
+		forEachErr := b.ForEach(func(k, v []byte) error {
+			var tokenExpiration time.Time
+			mErr := json.Unmarshal(v, &tokenExpiration)
+			if mErr != nil {
+				return mErr
+			}
+			if now.After(tokenExpiration) {
+				expiredIds = append(expiredIds, k)
+			}
+			return nil
+		})
+		if forEachErr != nil {
+			return forEachErr
+		}
+		for _, expiredId := range expiredIds {
+			deleteErr := b.Delete(expiredId)
+			if deleteErr != nil {
+				return deleteErr
+			}
+		}
+		data, mErr := json.Marshal(expiresAt)
+		if mErr != nil {
+			return mErr
+		}
+		return b.Put([]byte(id), data)
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) IsTokenRevoked(id string, ctx context.Context) (bool, PersistenceError) {
+	revoked := false
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("revokedTokens"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing revoked tokens. The database may be corrupted !")
+		}
+		revoked = b.Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked, wrapError(err)
+}
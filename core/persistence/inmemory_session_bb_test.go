@@ -0,0 +1,119 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that rotating a session replaces
+// its refresh token hash
+func TestRotateSession(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	session := model.Session{Login: "bob", Hash: "hash", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	created, err := rep.CreateSession(&session, ctx)
+
+	// when
+	rotated, rotateErr := rep.RotateSession("hash", "newHash", time.Now().Add(2*time.Hour), ctx)
+	_, oldHashErr := rep.RotateSession("hash", "otherHash", time.Now().Add(2*time.Hour), ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || rotateErr != nil {
+		t.Fatalf("expect no error, but got %v and %v", err, rotateErr)
+	}
+	if rotated.Id != created.Id || rotated.Hash != "newHash" {
+		t.Fatalf("expect the session %s to be rotated, but got %+v", created.Id, rotated)
+	}
+	if oldHashErr == nil || oldHashErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error when reusing a rotated hash, but got %v", oldHashErr)
+	}
+}
+
+// test that an expired session can't be rotated
+func TestRotateExpiredSession(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	tests.InsertObject(c, []byte("sessions"), []byte("hash"),
+		model.Session{Id: "1", Login: "bob", Hash: "hash", ExpiresAt: time.Now().Add(-time.Minute)})
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.RotateSession("hash", "newHash", time.Now().Add(time.Hour), context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error, but got %v", err)
+	}
+}
+
+// test that closing all sessions of a
+// user leaves other users sessions alone
+func TestDeleteSessionsOf(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	tests.InsertObject(c, []byte("sessions"), []byte("hash1"), model.Session{Id: "1", Login: "bob", Hash: "hash1", ExpiresAt: expiresAt})
+	tests.InsertObject(c, []byte("sessions"), []byte("hash2"), model.Session{Id: "2", Login: "bob", Hash: "hash2", ExpiresAt: expiresAt})
+	tests.InsertObject(c, []byte("sessions"), []byte("hash3"), model.Session{Id: "3", Login: "alice", Hash: "hash3", ExpiresAt: expiresAt})
+	rep := persistence.GetRepository(c)
+
+	// when
+	err := rep.DeleteSessionsOf(ctx, "bob")
+	_, bobErr := rep.RotateSession("hash1", "newHash1", expiresAt, ctx)
+	_, aliceErr := rep.RotateSession("hash3", "newHash3", expiresAt, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if bobErr == nil || bobErr.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect the sessions of bob to be closed, but got %v", bobErr)
+	}
+	if aliceErr != nil {
+		t.Fatalf("expect the session of alice to be kept, but got %s", aliceErr.Error())
+	}
+}
+
+// test the deny-list of access tokens
+func TestRevokeToken(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	err := rep.RevokeToken("jti", time.Now().Add(time.Hour), ctx)
+	revoked, revokedErr := rep.IsTokenRevoked("jti", ctx)
+	other, otherErr := rep.IsTokenRevoked("other", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || revokedErr != nil || otherErr != nil {
+		t.Fatalf("expect no error, but got %v, %v and %v", err, revokedErr, otherErr)
+	}
+	if !revoked {
+		t.Fatal("expect the token to be revoked")
+	}
+	if other {
+		t.Fatal("expect another token to not be revoked")
+	}
+}
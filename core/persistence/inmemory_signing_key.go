@@ -0,0 +1,116 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (i *inMemory) RotateSigningKey(key *model.SigningKey, ctx context.Context) (*model.SigningKey, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("signingKeys"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing signing keys. The database may be corrupted !")
+		}
+		updateErr = key.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		retiredKeys := make([]model.SigningKey, 0)
+		updateErr = b.ForEach(func(k, v []byte) error {
+			var existingKey model.SigningKey
+			mErr := json.Unmarshal(v, &existingKey)
+			if mErr != nil {
+				return mErr
+			}
+			if !existingKey.IsRetired() {
+				existingKey.RetiredAt = key.CreatedAt
+				retiredKeys = append(retiredKeys, existingKey)
+			}
+			return nil
+		})
+		if updateErr != nil {
+			return updateErr
+		}
+		// the retired keys are stored as read, already encrypted
+		sealed, updateErr := i.secrets.sealSigningKey(key)
+		if updateErr != nil {
+			return updateErr
+		}
+		for _, storedKey := range append(retiredKeys, *sealed) {
+			var data []byte
+			data, updateErr = json.Marshal(storedKey)
+			if updateErr != nil {
+				return updateErr
+			}
+			updateErr = b.Put([]byte(storedKey.Id), data)
+			if updateErr != nil {
+				return updateErr
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return key, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetSigningKeys(ctx context.Context) ([]*model.SigningKey, PersistenceError) {
+	keys := make([]*model.SigningKey, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("signingKeys"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing signing keys. The database may be corrupted !")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var key model.SigningKey
+			mErr := json.Unmarshal(v, &key)
+			if mErr == nil {
+				mErr = i.secrets.openSigningKey(&key)
+			}
+			if mErr != nil {
+				return mErr
+			}
+			keys = append(keys, &key)
+			return nil
+		})
+	})
+	if err == nil {
+		sort.Slice(keys, func(a, b int) bool {
+			return keys[a].CreatedAt.Before(keys[b].CreatedAt)
+		})
+		return keys, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) DeleteSigningKey(ctx context.Context, id string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("signingKeys"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing signing keys. The database may be corrupted !")
+		}
+		data := b.Get([]byte(id))
+		if data == nil {
+			return newPersistenceError("No signing key found", NotFound)
+		}
+		var key model.SigningKey
+		mErr := json.Unmarshal(data, &key)
+		if mErr != nil {
+			return mErr
+		}
+		if !key.IsRetired() {
+			return newPersistenceError("The current signing key cannot be deleted", Conflict)
+		}
+		return b.Delete([]byte(id))
+	})
+	return wrapError(err)
+}
@@ -0,0 +1,49 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that a rotation retires the previous
+// key, and that only retired keys can be deleted
+func TestRotateSigningKey(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	now := time.Now()
+	first, _ := rep.RotateSigningKey(&model.SigningKey{Algorithm: "HS256", Secret: []byte("first"), CreatedAt: now.Add(-time.Hour)}, ctx)
+	second, _ := rep.RotateSigningKey(&model.SigningKey{Algorithm: "HS256", Secret: []byte("second"), CreatedAt: now}, ctx)
+
+	// when
+	keys, err := rep.GetSigningKeys(ctx)
+	currentErr := rep.DeleteSigningKey(ctx, second.Id)
+	retiredErr := rep.DeleteSigningKey(ctx, first.Id)
+	remainingKeys, _ := rep.GetSigningKeys(ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %v", err)
+	}
+	if len(keys) != 2 || keys[0].Id != first.Id || keys[1].Id != second.Id {
+		t.Fatalf("expect both keys oldest first, but got %+v", keys)
+	}
+	if !keys[0].RetiredAt.Equal(now) || keys[1].IsRetired() {
+		t.Fatalf("expect the first key to be retired by the second one, but got %+v and %+v", keys[0], keys[1])
+	}
+	if currentErr == nil || currentErr.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect a conflict when deleting the current key, but got %v", currentErr)
+	}
+	if retiredErr != nil || len(remainingKeys) != 1 || remainingKeys[0].Id != second.Id {
+		t.Fatalf("expect the retired key to be deleted, but got %v and %+v", retiredErr, remainingKeys)
+	}
+}
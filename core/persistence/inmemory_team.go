@@ -0,0 +1,247 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (i *inMemory) CreateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		var updateErr error
+		b := tx.Bucket([]byte("teams"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing teams. The database may be corrupted !")
+		}
+		updateErr = team.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		sealed, updateErr := i.secrets.sealTeam(team)
+		if updateErr != nil {
+			return updateErr
+		}
+		var data []byte
+		data, updateErr = json.Marshal(sealed)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(team.Id), data)
+	})
+	if err == nil {
+		return team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetTeam(id string, ctx context.Context) (*model.Team, PersistenceError) {
+	var team *model.Team
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		var mErr error
+		team, mErr = fetchTeam(tx, id)
+		if mErr != nil {
+			return mErr
+		}
+		return i.secrets.openTeam(team)
+	})
+	if err == nil {
+		return team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetTeams(ctx context.Context) ([]*model.Team, PersistenceError) {
+	teams := make([]*model.Team, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("teams"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing teams. The database may be corrupted !")
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var team model.Team
+			mErr := json.Unmarshal(v, &team)
+			if mErr == nil {
+				mErr = i.secrets.openTeam(&team)
+			}
+			if mErr != nil {
+				return mErr
+			}
+			teams = append(teams, &team)
+		}
+		return nil
+	})
+	if err == nil {
+		sort.Slice(teams, func(i, j int) bool {
+			return teams[i].Name < teams[j].Name
+		})
+		return teams, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) UpdateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("teams"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing teams. The database may be corrupted !")
+		}
+		if b.Get([]byte(team.Id)) == nil {
+			return newPersistenceError(fmt.Sprintf("No team with id %s found", team.Id), NotFound)
+		}
+		sealed, updateErr := i.secrets.sealTeam(team)
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := json.Marshal(sealed)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(team.Id), data)
+	})
+	if err == nil {
+		return team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// delete one existing team. A team
+// that still owns jobs or registries
+// can't be deleted.
+func (i *inMemory) DeleteTeam(ctx context.Context, id string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("teams"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing teams. The database may be corrupted !")
+		}
+		if b.Get([]byte(id)) == nil {
+			return newPersistenceError(fmt.Sprintf("No team with id %s found", id), NotFound)
+		}
+		for _, bucketName := range []string{"jobs", "dockerRegistries"} {
+			owned, ownErr := ownsResources(tx, bucketName, id)
+			if ownErr != nil {
+				return ownErr
+			}
+			if owned {
+				return newPersistenceError(fmt.Sprintf("The team %s still owns some %s", id, bucketName), Conflict)
+			}
+		}
+		return b.Delete([]byte(id))
+	})
+	return wrapError(err)
+}
+
+func (i *inMemory) SetJobTeam(ctx context.Context, jobId []byte, teamId string) (*model.Job, PersistenceError) {
+	var job model.Job
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		err := moveResource(tx, "jobs", jobId, teamId, &job, func() { job.TeamId = teamId })
+		if err != nil {
+			return err
+		}
+		return i.secrets.openJob(&job)
+	})
+	if err == nil {
+		return &job, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) SetDockerRegistryTeam(ctx context.Context, registryId []byte, teamId string) (*model.DockerRegistry, PersistenceError) {
+	var registry model.DockerRegistry
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		err := moveResource(tx, "dockerRegistries", registryId, teamId, &registry, func() {
+			registry.TeamId = teamId
+			// the registry has changed, so pending
+			// updates must be rejected by the optimistic lock.
+			registry.NewLastModificationTime()
+		})
+		if err != nil {
+			return err
+		}
+		return i.secrets.openDockerRegistry(&registry)
+	})
+	if err == nil {
+		return &registry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// load the resource identified by id from the given bucket into
+// resource, apply the move on it and save it back. The target team
+// must exist, unless the resource is made global (empty teamId).
+func moveResource(tx *bolt.Tx, bucketName string, id []byte, teamId string, resource interface{}, move func()) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return fmt.Errorf("persistence >> CRITICAL error. No bucket %s. The database may be corrupted !", bucketName)
+	}
+	data := b.Get(id)
+	if data == nil {
+		return newPersistenceError(fmt.Sprintf("No resource with id %s found in %s", string(id), bucketName), NotFound)
+	}
+	if teamId != "" {
+		if _, err := fetchTeam(tx, teamId); err != nil {
+			return err
+		}
+	}
+	err := json.Unmarshal(data, resource)
+	if err != nil {
+		return err
+	}
+	move()
+	data, err = json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	return b.Put(id, data)
+}
+
+func fetchTeam(tx *bolt.Tx, id string) (*model.Team, error) {
+	b := tx.Bucket([]byte("teams"))
+	if b == nil {
+		return nil, errors.New("persistence >> CRITICAL error. No bucket for storing teams. The database may be corrupted !")
+	}
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, newPersistenceError(fmt.Sprintf("No team with id %s found", id), NotFound)
+	}
+	var team model.Team
+	err := json.Unmarshal(data, &team)
+	if err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// true if at least one resource of the
+// bucket is owned by the given team
+func ownsResources(tx *bolt.Tx, bucketName, teamId string) (bool, error) {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return false, fmt.Errorf("persistence >> CRITICAL error. No bucket %s. The database may be corrupted !", bucketName)
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var owned struct {
+			TeamId string `json:"teamId"`
+		}
+		err := json.Unmarshal(v, &owned)
+		if err != nil {
+			return false, err
+		}
+		if owned.TeamId == teamId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
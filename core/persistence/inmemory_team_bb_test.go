@@ -0,0 +1,117 @@
+package persistence_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test the nominal case of #CreateTeam and #GetTeam
+func TestCreateTeamAndGetIt(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	team := model.Team{Name: "test", Members: map[string]model.Role{"bob": model.RoleMaintainer}}
+
+	// when
+	createdTeam, err := rep.CreateTeam(&team, ctx)
+	var actualTeam *model.Team
+	if err == nil {
+		actualTeam, err = rep.GetTeam(createdTeam.Id, ctx)
+	}
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error, but got %s", err.Error())
+	}
+	if createdTeam.Id == "" {
+		t.Fatal("expect the team to get an id")
+	}
+	if actualTeam.Name != "test" || actualTeam.Members["bob"] != model.RoleMaintainer {
+		t.Fatalf("expect to get the created team, but got %+v", actualTeam)
+	}
+}
+
+// test that a team owning a job can't be deleted
+func TestDeleteTeamOwningAJob(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("teams"), []byte("team"), model.Team{Id: "team", Name: "test"})
+	tests.InsertObject(c, []byte("jobs"), []byte("job"), model.Job{Id: []byte("job"), Name: "job", TeamId: "team"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	conflictErr := rep.DeleteTeam(ctx, "team")
+	_, moveErr := rep.SetJobTeam(ctx, []byte("job"), "")
+	deleteErr := rep.DeleteTeam(ctx, "team")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if conflictErr == nil || conflictErr.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect a Conflict error when deleting a team owning a job, but got %v", conflictErr)
+	}
+	if moveErr != nil {
+		t.Fatalf("expect no error when making the job global, but got %s", moveErr.Error())
+	}
+	if deleteErr != nil {
+		t.Fatalf("expect no error when deleting an empty team, but got %s", deleteErr.Error())
+	}
+}
+
+// test that a resource can't be moved to an unknown team
+func TestSetDockerRegistryTeamUnknownTeam(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("dockerRegistries"), []byte("registry"), model.DockerRegistry{Id: "registry", Name: "registry"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.SetDockerRegistryTeam(ctx, []byte("registry"), "unknown")
+	registry, _ := rep.GetDockerRegistry([]byte("registry"), ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect a NotFound error, but got %v", err)
+	}
+	if registry.TeamId != "" {
+		t.Fatalf("expect the registry to stay global, but got team %s", registry.TeamId)
+	}
+}
+
+// test the nominal case of #SetDockerRegistryTeam
+func TestSetDockerRegistryTeam(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("teams"), []byte("team"), model.Team{Id: "team", Name: "test"})
+	tests.InsertObject(c, []byte("dockerRegistries"), []byte("registry"), model.DockerRegistry{Id: "registry", Name: "registry", LastModificationTime: "1"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	movedRegistry, err := rep.SetDockerRegistryTeam(ctx, []byte("registry"), "team")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err.Error())
+	}
+	if movedRegistry.TeamId != "team" || movedRegistry.LastModificationTime == "1" {
+		t.Fatalf("expect the registry to be moved and its modification time renewed, but got %+v", movedRegistry)
+	}
+}
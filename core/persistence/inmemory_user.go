@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 
 	bolt "github.com/coreos/bbolt"
 	"github.com/jeromedoucet/dahu/core/model"
@@ -11,12 +13,15 @@ import (
 
 func (i *inMemory) GetUser(id string, ctx context.Context) (*model.User, PersistenceError) {
 	var user model.User
-	err := i.doViewAction(func(tx *bolt.Tx) error {
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("users"))
 		if b == nil {
 			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
 		}
 		data := b.Get([]byte(id))
+		if data == nil {
+			return newPersistenceError(fmt.Sprintf("No user with id %s found", id), NotFound)
+		}
 		mErr := json.Unmarshal(data, &user)
 		return mErr
 	})
@@ -26,3 +31,106 @@ func (i *inMemory) GetUser(id string, ctx context.Context) (*model.User, Persist
 		return nil, wrapError(err)
 	}
 }
+
+func (i *inMemory) CreateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+		}
+		if user.Login == "" {
+			return errors.New("persistence >> Cannot persist a user without login !")
+		}
+		if b.Get([]byte(user.Login)) != nil {
+			return newPersistenceError(fmt.Sprintf("A user with id %s already exists", user.Login), Conflict)
+		}
+		data, updateErr := json.Marshal(user)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(user.Login), data)
+	})
+	if err == nil {
+		return user, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) GetUsers(ctx context.Context) ([]*model.User, PersistenceError) {
+	users := make([]*model.User, 0)
+	err := i.doViewAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var user model.User
+			mErr := json.Unmarshal(v, &user)
+			if mErr != nil {
+				return mErr
+			}
+			users = append(users, &user)
+		}
+		return nil
+	})
+	if err == nil {
+		// keys are logins, so the cursor order is the
+		// right one. Sort anyway to not rely on it.
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].Login < users[j].Login
+		})
+		return users, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) UpdateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError) {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+		}
+		if b.Get([]byte(user.Login)) == nil {
+			return newPersistenceError(fmt.Sprintf("No user with id %s found", user.Login), NotFound)
+		}
+		data, updateErr := json.Marshal(user)
+		if updateErr != nil {
+			return updateErr
+		}
+		return b.Put([]byte(user.Login), data)
+	})
+	if err == nil {
+		return user, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (i *inMemory) DeleteUser(ctx context.Context, id string) PersistenceError {
+	err := i.doUpdateAction(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("users"))
+		if b == nil {
+			return errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+		}
+		// a get request is needed here because #Delete doesn't return an error
+		// when key not found. This behavior is not consistent regarding the Api contract
+		if b.Get([]byte(id)) == nil {
+			return newPersistenceError(fmt.Sprintf("No user with id %s found", id), NotFound)
+		}
+		deleteErr := deleteApiTokensOf(tx, id)
+		if deleteErr != nil {
+			return deleteErr
+		}
+		_, deleteErr = deleteSessions(tx, func(session *model.Session) bool {
+			return session.Login == id
+		})
+		if deleteErr != nil {
+			return deleteErr
+		}
+		return b.Delete([]byte(id))
+	})
+	return wrapError(err)
+}
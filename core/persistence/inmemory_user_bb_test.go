@@ -4,19 +4,16 @@ import (
 	"context"
 	"testing"
 
-	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/model"
 	"github.com/jeromedoucet/dahu/core/persistence"
 	"github.com/jeromedoucet/dahu/tests"
 )
 
-// todo test unicity for user
-
 // test to ensure that a default user is inserted
 // when create the db
 func TestInsertDefaultUser(t *testing.T) {
 	// given
-	c := configuration.InitConf()
+	c := tests.InitConf()
 	ctx := context.Background()
 	login := "dahu"
 
@@ -34,6 +31,9 @@ func TestInsertDefaultUser(t *testing.T) {
 	if actualUser.Login != login {
 		t.Errorf("expect to get user %s but got %s", login, actualUser.String())
 	}
+	if !actualUser.MustChangePassword {
+		t.Error("expect the default user to have to change its password")
+	}
 }
 
 // test the nominal case of #GetUser
@@ -41,7 +41,7 @@ func TestGetUserShouldReturnTheUserWhenItExists(t *testing.T) {
 	// given
 	u := model.User{Login: "test"}
 	u.SetPassword([]byte("test_test_test_test"))
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	tests.InsertObject(c, []byte("users"), []byte(u.Login), u)
@@ -68,7 +68,7 @@ func TestGetUserShouldReturnAnErrorWhenItDoesntExist(t *testing.T) {
 	// given
 	u := model.User{Login: "test"}
 	u.SetPassword([]byte("test_test_test_test"))
-	c := configuration.InitConf()
+	c := tests.InitConf()
 
 	ctx := context.Background()
 	rep := persistence.GetRepository(c)
@@ -96,7 +96,7 @@ func TestGetUserShouldReturnAnErrorWhenNoBucket(t *testing.T) {
 	// given
 	u := model.User{Login: "test"}
 	u.SetPassword([]byte("test_test_test_test"))
-	c := configuration.InitConf()
+	c := tests.InitConf()
 	tests.DeleteBucket(c, []byte("users"))
 
 	ctx := context.Background()
@@ -116,3 +116,227 @@ func TestGetUserShouldReturnAnErrorWhenNoBucket(t *testing.T) {
 		t.Errorf("expect to get nil but got %s", actualUser.String())
 	}
 }
+
+// test that #GetUser return a NotFound
+// error when the user doesn't exist
+func TestGetUserShouldReturnNotFoundWhenItDoesntExist(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.GetUser("unknown", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect to have a NotFound error, but got %v", err)
+	}
+}
+
+// test that #GetUser return a Timeout
+// error when the context is canceled
+func TestGetUserShouldReturnTimeoutWhenTheContextIsCanceled(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.GetUser("dahu", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.Timeout {
+		t.Fatalf("expect to have a Timeout error, but got %v", err)
+	}
+}
+
+// test that #CreateUser doesn't persist
+// anything when the context is canceled
+func TestCreateUserShouldReturnTimeoutWhenTheContextIsCanceled(t *testing.T) {
+	// given
+	u := model.User{Login: "test", Password: []byte("hash")}
+	c := tests.InitConf()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.CreateUser(&u, ctx)
+
+	// then
+	_, getErr := rep.GetUser("test", context.Background())
+	tests.CleanPersistence(c)
+	if err == nil || err.ErrorType() != persistence.Timeout {
+		t.Fatalf("expect to have a Timeout error, but got %v", err)
+	}
+	if getErr == nil {
+		t.Fatal("expect the user not to be created")
+	}
+}
+
+// test the nominal case of #CreateUser
+func TestCreateUserShouldPersistTheUser(t *testing.T) {
+	// given
+	u := model.User{Login: "test", Password: []byte("hash"), MustChangePassword: true}
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.CreateUser(&u, ctx)
+
+	// then
+	actualUser, _ := rep.GetUser(u.Login, ctx)
+	tests.CleanPersistence(c)
+	if err != nil {
+		t.Fatalf("expect to have no error when creating a user, but got %s", err.Error())
+	}
+	if actualUser.Login != u.Login || string(actualUser.Password) != "hash" || !actualUser.MustChangePassword {
+		t.Fatalf("expect to get %+v persisted, but got %+v", u, actualUser)
+	}
+}
+
+// test that #CreateUser refuse to
+// override an existing user
+func TestCreateUserShouldReturnAConflictWhenTheLoginIsUsed(t *testing.T) {
+	// given
+	u := model.User{Login: "test", Password: []byte("hash")}
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("users"), []byte(u.Login), u)
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.CreateUser(&model.User{Login: "test", Password: []byte("other")}, ctx)
+
+	// then
+	actualUser, _ := rep.GetUser(u.Login, ctx)
+	tests.CleanPersistence(c)
+	if err == nil || err.ErrorType() != persistence.Conflict {
+		t.Fatalf("expect to have a Conflict error, but got %v", err)
+	}
+	if string(actualUser.Password) != "hash" {
+		t.Fatal("expect the existing user to be left untouched")
+	}
+}
+
+// test the nominal case of #GetUsers
+func TestGetUsersShouldReturnAllUsersSortedByLogin(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	rep.CreateUser(&model.User{Login: "zorro"}, ctx)
+	rep.CreateUser(&model.User{Login: "alice"}, ctx)
+
+	// when
+	users, err := rep.GetUsers(ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when listing users, but got %s", err.Error())
+	}
+	// the default user is created on an empty database
+	if len(users) != 3 || users[0].Login != "alice" || users[1].Login != "dahu" || users[2].Login != "zorro" {
+		t.Fatalf("expect to get alice, dahu and zorro users, but got %v", users)
+	}
+}
+
+// test the nominal case of #UpdateUser
+func TestUpdateUserShouldReplaceTheUser(t *testing.T) {
+	// given
+	u := model.User{Login: "test", Password: []byte("hash")}
+	c := tests.InitConf()
+	ctx := context.Background()
+	tests.InsertObject(c, []byte("users"), []byte(u.Login), u)
+	rep := persistence.GetRepository(c)
+	u.Disabled = true
+
+	// when
+	_, err := rep.UpdateUser(&u, ctx)
+
+	// then
+	actualUser, _ := rep.GetUser(u.Login, ctx)
+	tests.CleanPersistence(c)
+	if err != nil {
+		t.Fatalf("expect to have no error when updating a user, but got %s", err.Error())
+	}
+	if !actualUser.Disabled {
+		t.Fatal("expect the user to be disabled")
+	}
+}
+
+// test that #UpdateUser doesn't
+// create unknown users
+func TestUpdateUserShouldReturnNotFoundWhenItDoesntExist(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+
+	// when
+	_, err := rep.UpdateUser(&model.User{Login: "test"}, ctx)
+
+	// then
+	_, getErr := rep.GetUser("test", context.Background())
+	exist := getErr == nil
+	tests.CleanPersistence(c)
+	if err == nil || err.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect to have a NotFound error, but got %v", err)
+	}
+	if exist {
+		t.Fatal("expect the user to not be created")
+	}
+}
+
+// test the nominal case of #DeleteUser
+func TestDeleteUserShouldRemoveTheUser(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	tests.InsertObject(c, []byte("users"), []byte("test"), model.User{Login: "test"})
+	rep := persistence.GetRepository(c)
+
+	// when
+	err := rep.DeleteUser(context.Background(), "test")
+
+	// then
+	_, getErr := rep.GetUser("test", context.Background())
+	exist := getErr == nil
+	tests.CleanPersistence(c)
+	if err != nil {
+		t.Fatalf("expect to have no error when deleting a user, but got %s", err.Error())
+	}
+	if exist {
+		t.Fatal("expect the user to be deleted")
+	}
+}
+
+// test that #DeleteUser return a NotFound
+// error when the user doesn't exist
+func TestDeleteUserShouldReturnNotFoundWhenItDoesntExist(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	rep := persistence.GetRepository(c)
+
+	// when
+	err := rep.DeleteUser(context.Background(), "unknown")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err == nil || err.ErrorType() != persistence.NotFound {
+		t.Fatalf("expect to have a NotFound error, but got %v", err)
+	}
+}
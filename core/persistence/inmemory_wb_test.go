@@ -1,10 +1,17 @@
 package persistence
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/configuration"
 )
 
 type txMock struct {
@@ -66,3 +73,125 @@ func TestCreateBucketsIfNeededJObsBucketError(t *testing.T) {
 	}
 
 }
+
+/*
+* Test case of createBucketIfNeeded when the logs bucket creation
+* fail. Should return an error
+ */
+func TestCreateBucketsIfNeededLogsBucketError(t *testing.T) {
+	// given
+	tx := new(txMock)
+	tx.bucketToReject = "logs"
+	expectedErrorMsg := "ERROR >> logs bucket creation failed : some error"
+
+	// when
+	err := createBucketsIfNeeded(tx)
+
+	// then
+	if err == nil {
+		t.Fatal("expect to have an error, but got nil")
+	}
+	if err.Error() != expectedErrorMsg {
+		t.Errorf("expect to have an error with text %s, but got %s", expectedErrorMsg, err.Error())
+	}
+}
+
+/*
+* Test case of openBolt when the file is locked by another
+* process. Should return ErrDatabaseInUse once the timeout is over
+ */
+func TestOpenBoltLockedDatabase(t *testing.T) {
+	// given
+	dir, _ := ioutil.TempDir("", "dahu-data")
+	defer os.RemoveAll(dir)
+	conf := configuration.Persistence{DataDir: dir, Name: "locked", OpenTimeOut: 100 * time.Millisecond}
+	db, _ := bolt.Open(filepath.Join(dir, "locked"), 0600, nil)
+	defer db.Close()
+
+	// when
+	_, err := openBolt(conf, false)
+
+	// then
+	if err != ErrDatabaseInUse {
+		t.Errorf("expect to have ErrDatabaseInUse, but got %+v", err)
+	}
+}
+
+func openTimedOutRepository(t *testing.T) (*inMemory, func()) {
+	dir, _ := ioutil.TempDir("", "dahu-data")
+	db, err := bolt.Open(filepath.Join(dir, "timeout"), 0600, nil)
+	if err != nil {
+		t.Fatalf("expect to open the database, but got %s", err.Error())
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("test"))
+		return err
+	})
+	conf := &configuration.Conf{Close: make(chan interface{})}
+	conf.PersistenceConf.TimeOut = 100 * time.Millisecond
+	i := &inMemory{conf: conf, db: db, rwMutex: &sync.RWMutex{}}
+	return i, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func hasTestKey(i *inMemory) bool {
+	var found bool
+	i.doViewAction(context.Background(), func(tx *bolt.Tx) error {
+		found = tx.Bucket([]byte("test")).Get([]byte("key")) != nil
+		return nil
+	})
+	return found
+}
+
+/*
+* Test case of doUpdateAction when the lock is held longer
+* than the timeout. Should return a Timeout error, and
+* give up the transaction once the lock is released
+ */
+func TestDoUpdateActionLockedLongerThanTheTimeOut(t *testing.T) {
+	// given
+	i, closeDb := openTimedOutRepository(t)
+	defer closeDb()
+	i.rwMutex.RLock()
+
+	// when
+	err := i.doUpdateAction(context.Background(), func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("test")).Put([]byte("key"), []byte("value"))
+	})
+	i.rwMutex.RUnlock()
+
+	// then
+	if err == nil || wrapError(err).ErrorType() != Timeout {
+		t.Fatalf("expect to have a Timeout error, but got %+v", err)
+	}
+	if hasTestKey(i) {
+		t.Error("expect the transaction to be given up")
+	}
+}
+
+/*
+* Test case of doUpdateAction when the transaction lasts
+* longer than the timeout. Should return a Timeout
+* error and roll the transaction back
+ */
+func TestDoUpdateActionLongerThanTheTimeOut(t *testing.T) {
+	// given
+	i, closeDb := openTimedOutRepository(t)
+	defer closeDb()
+
+	// when
+	err := i.doUpdateAction(context.Background(), func(tx *bolt.Tx) error {
+		time.Sleep(200 * time.Millisecond)
+		return tx.Bucket([]byte("test")).Put([]byte("key"), []byte("value"))
+	})
+
+	// then
+	if err == nil || wrapError(err).ErrorType() != Timeout {
+		t.Fatalf("expect to have a Timeout error, but got %+v", err)
+	}
+	if hasTestKey(i) {
+		t.Error("expect the transaction to be rolled back")
+	}
+}
@@ -0,0 +1,851 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// one step of the evolution of the stored data. Each
+// migration is implemented for bolt and for the sql
+// databases, so that a given schema version means the
+// same thing whatever the backend. Both implementations
+// must be idempotent: a migration interrupted before the
+// version has been recorded will be played again.
+// They return the number of changed buckets, tables or
+// records.
+type migration struct {
+	version     int
+	description string
+	bolt        func(tx *bolt.Tx) (int, error)
+	sql         func(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error)
+}
+
+// the migrations, ordered by version. A migration
+// must never be modified once released, only new
+// ones may be appended.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create the buckets / tables",
+		bolt:        createBucketsMigration,
+		sql:         createTablesMigration,
+	},
+	{
+		version:     2,
+		description: "move the executions embedded in the jobs to their own bucket / table",
+		bolt:        moveEmbeddedExecutionsBoltMigration,
+		sql:         moveEmbeddedExecutionsSqlMigration,
+	},
+	{
+		version:     3,
+		description: "create the bucket / table of the purged executions",
+		bolt:        createPurgedExecutionsBucketMigration,
+		sql:         createPurgedExecutionsTableMigration,
+	},
+	{
+		version:     4,
+		description: "move the logs embedded in the step executions to log lines",
+		bolt:        moveEmbeddedLogsBoltMigration,
+		sql:         moveEmbeddedLogsSqlMigration,
+	},
+	{
+		version:     5,
+		description: "derive the status and the duration of the executions from their steps",
+		bolt:        deriveExecutionStatusBoltMigration,
+		sql:         deriveExecutionStatusSqlMigration,
+	},
+	{
+		version:     6,
+		description: "make administrators the users stored before the roles",
+		bolt:        grantAdminRoleBoltMigration,
+		sql:         grantAdminRoleSqlMigration,
+	},
+}
+
+// schema version of the data written by this binary
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// result of the migration of a database
+type MigrationReport struct {
+	From       int               `json:"from"` // version of the database before the migration
+	To         int               `json:"to"`   // version of the database after the migration
+	DryRun     bool              `json:"dryRun"`
+	Migrations []MigrationResult `json:"migrations"`
+}
+
+// what a single migration has changed, or
+// would have changed for a dry-run.
+type MigrationResult struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Changes     int    `json:"changes"`
+}
+
+// human readable report, for the command line
+func (r MigrationReport) String() string {
+	var res strings.Builder
+	if len(r.Migrations) == 0 {
+		fmt.Fprintf(&res, "the database is up to date (version %d)\n", r.From)
+		return res.String()
+	}
+	verb := "has been migrated"
+	if r.DryRun {
+		verb = "would be migrated"
+	}
+	fmt.Fprintf(&res, "the database %s from version %d to version %d :\n", verb, r.From, r.To)
+	for _, m := range r.Migrations {
+		fmt.Fprintf(&res, "  %d - %s : %d change(s)\n", m.Version, m.Description, m.Changes)
+	}
+	return res.String()
+}
+
+// returned by the dry-run actions to
+// rollback the transaction
+var errDryRun = errors.New("persistence >> dry-run, the transaction is rolled back")
+
+// return an error when the database has been written
+// by a more recent binary that this one : its data
+// may not be understood
+func checkSchemaVersion(version int) error {
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("persistence >> the schema version of the database (%d) is newer than the one of this binary (%d). Please upgrade Dahu", version, LatestSchemaVersion())
+	}
+	return nil
+}
+
+// migrations to play on a database at the given version
+func pendingMigrations(version int) []migration {
+	res := make([]migration, 0)
+	for _, m := range migrations {
+		if m.version > version {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// play the pending migrations on the database whose settings
+// are conf.PersistenceConf, then close it. With dryRun, nothing is
+// written and the report tells what would have been changed.
+// The database must not be in use : bolt would
+// not be opened, and sql would be migrated twice.
+func Migrate(conf *configuration.Conf, dryRun bool) (MigrationReport, error) {
+	switch conf.PersistenceConf.Type {
+	case configuration.PostgreSQL:
+		return migrateSqlDatabase(conf, postgres{}, dryRun)
+	case configuration.SQLite:
+		return migrateSqlDatabase(conf, sqlite{}, dryRun)
+	default:
+		return migrateBoltFile(conf, dryRun)
+	}
+}
+
+func migrateBoltFile(conf *configuration.Conf, dryRun bool) (MigrationReport, error) {
+	_, err := os.Stat(conf.PersistenceConf.Path())
+	if dryRun && os.IsNotExist(err) {
+		// opening the file would create it. Every
+		// migration will be played on an empty database
+		report := MigrationReport{DryRun: true, To: LatestSchemaVersion(), Migrations: make([]MigrationResult, 0)}
+		for _, m := range migrations {
+			report.Migrations = append(report.Migrations, MigrationResult{Version: m.version, Description: m.description})
+		}
+		return report, nil
+	}
+	err = os.MkdirAll(filepath.Dir(conf.PersistenceConf.Path()), 0700)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	db, err := openBolt(conf.PersistenceConf, false)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	defer db.Close()
+	return migrateBolt(db, dryRun)
+}
+
+// play the pending migrations on db, in a single
+// transaction, and record the new schema version
+// in the meta bucket.
+func migrateBolt(db *bolt.DB, dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{DryRun: dryRun, Migrations: make([]MigrationResult, 0)}
+	err := db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		if err != nil {
+			return fmt.Errorf("ERROR >> meta bucket creation failed : %s", err)
+		}
+		if data := meta.Get([]byte("schemaVersion")); data != nil {
+			err = json.Unmarshal(data, &report.From)
+			if err != nil {
+				return err
+			}
+		}
+		err = checkSchemaVersion(report.From)
+		if err != nil {
+			return err
+		}
+		report.To = report.From
+		for _, m := range pendingMigrations(report.From) {
+			changes, err := m.bolt(tx)
+			if err != nil {
+				return fmt.Errorf("ERROR >> migration %d (%s) failed : %s", m.version, m.description, err)
+			}
+			report.Migrations = append(report.Migrations, MigrationResult{Version: m.version, Description: m.description, Changes: changes})
+			report.To = m.version
+		}
+		data, err := json.Marshal(report.To)
+		if err != nil {
+			return err
+		}
+		err = meta.Put([]byte("schemaVersion"), data)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return report, err
+}
+
+func migrateSqlDatabase(conf *configuration.Conf, d dialect, dryRun bool) (MigrationReport, error) {
+	_, err := d.location(conf.PersistenceConf)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	db, err := sql.Open(d.driverName(), d.dataSourceName(conf.PersistenceConf))
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	defer db.Close()
+	s := &sqlRepository{conf: conf, db: db, dialect: d}
+	err = ping(db, conf.PersistenceConf)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	// like on start, the migrations are
+	// not bounded by PersistenceConf.TimeOut
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	defer tx.Rollback()
+	report, err := s.migrate(ctx, tx, dryRun)
+	if err != nil || dryRun {
+		return report, err
+	}
+	return report, tx.Commit()
+}
+
+// play the pending migrations inside tx, and record the
+// new schema version in the schema_version table. The
+// caller is responsible for the commit or the rollback.
+func (s *sqlRepository) migrate(ctx context.Context, tx *sql.Tx, dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{DryRun: dryRun, Migrations: make([]MigrationResult, 0)}
+	_, err := s.exec(ctx, tx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return report, fmt.Errorf("ERROR >> schema_version table creation failed : %s", err)
+	}
+	err = s.queryRow(ctx, tx, "SELECT version FROM schema_version").Scan(&report.From)
+	newDatabase := err == sql.ErrNoRows
+	if err != nil && !newDatabase {
+		return report, err
+	}
+	err = checkSchemaVersion(report.From)
+	if err != nil {
+		return report, err
+	}
+	report.To = report.From
+	for _, m := range pendingMigrations(report.From) {
+		changes, err := m.sql(ctx, s, tx)
+		if err != nil {
+			return report, fmt.Errorf("ERROR >> migration %d (%s) failed : %s", m.version, m.description, err)
+		}
+		report.Migrations = append(report.Migrations, MigrationResult{Version: m.version, Description: m.description, Changes: changes})
+		report.To = m.version
+	}
+	if newDatabase {
+		_, err = s.exec(ctx, tx, "INSERT INTO schema_version (version) VALUES (?)", report.To)
+	} else {
+		_, err = s.exec(ctx, tx, "UPDATE schema_version SET version = ?", report.To)
+	}
+	return report, err
+}
+
+// version 1. The changes are the created buckets.
+func createBucketsMigration(tx *bolt.Tx) (int, error) {
+	before := countBuckets(tx)
+	err := createBucketsIfNeeded(tx)
+	if err != nil {
+		return 0, err
+	}
+	return countBuckets(tx) - before, nil
+}
+
+func countBuckets(tx *bolt.Tx) int {
+	count := 0
+	tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		count++
+		return nil
+	})
+	return count
+}
+
+// version 1. The changes are the executed statements, every
+// one of them being a no-op when the table or index exists.
+func createTablesMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	for _, statement := range s.dialect.schema() {
+		_, err := s.exec(ctx, tx, statement)
+		if err != nil {
+			return 0, fmt.Errorf("ERROR >> schema creation failed : %s", err)
+		}
+	}
+	return len(s.dialect.schema()), nil
+}
+
+// a job as stored before version 2. Its executions are kept as
+// they were written : the fields unknown to the model, like the
+// logs embedded before version 4, must not be lost.
+type jobWithEmbeddedExecutions struct {
+	Id         []byte            `json:"id"`
+	Executions []json.RawMessage `json:"executions"`
+}
+
+// the job of data, without its embedded executions
+func removeEmbeddedExecutions(data []byte) (model.Job, error) {
+	var job model.Job
+	err := json.Unmarshal(data, &job)
+	job.Executions = nil
+	return job, err
+}
+
+// version 2. The executions used to be a field of the job
+// (json "executions"). The changes are the updated jobs.
+func moveEmbeddedExecutionsBoltMigration(tx *bolt.Tx) (int, error) {
+	jobs := tx.Bucket([]byte("jobs"))
+	executions := tx.Bucket([]byte("jobsExecutions"))
+	if jobs == nil || executions == nil {
+		return 0, errors.New("persistence >> CRITICAL error. No bucket for storing jobs. The database may be corrupted !")
+	}
+	// a bucket must not be modified while iterated
+	toMigrate := make([]jobWithEmbeddedExecutions, 0)
+	err := jobs.ForEach(func(k, v []byte) error {
+		var job jobWithEmbeddedExecutions
+		err := json.Unmarshal(v, &job)
+		if err == nil && len(job.Executions) > 0 {
+			toMigrate = append(toMigrate, job)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, embedded := range toMigrate {
+		eb, err := executions.CreateBucketIfNotExists(embedded.Id)
+		if err != nil {
+			return 0, err
+		}
+		for _, data := range embedded.Executions {
+			var execution model.JobExecution
+			err = json.Unmarshal(data, &execution)
+			if err != nil {
+				return 0, err
+			}
+			if execution.Id == "" || eb.Get([]byte(execution.Id)) != nil {
+				continue
+			}
+			err = eb.Put([]byte(execution.Id), data)
+			if err != nil {
+				return 0, err
+			}
+		}
+		job, err := removeEmbeddedExecutions(jobs.Get(embedded.Id))
+		if err != nil {
+			return 0, err
+		}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return 0, err
+		}
+		err = jobs.Put(job.Id, data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// version 2, see moveEmbeddedExecutionsBoltMigration
+func moveEmbeddedExecutionsSqlMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	rows, err := s.query(ctx, tx, "SELECT data FROM jobs")
+	if err != nil {
+		return 0, err
+	}
+	toMigrate := make([]string, 0)
+	for rows.Next() {
+		var data string
+		var job jobWithEmbeddedExecutions
+		err = rows.Scan(&data)
+		if err == nil {
+			err = json.Unmarshal([]byte(data), &job)
+		}
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(job.Executions) > 0 {
+			toMigrate = append(toMigrate, data)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, data := range toMigrate {
+		var embedded jobWithEmbeddedExecutions
+		err = json.Unmarshal([]byte(data), &embedded)
+		if err != nil {
+			return 0, err
+		}
+		for _, executionData := range embedded.Executions {
+			var execution model.JobExecution
+			err = json.Unmarshal(executionData, &execution)
+			if err != nil {
+				return 0, err
+			}
+			if execution.Id == "" {
+				continue
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO job_executions (job_id, id, started_at, status, branch, duration, data) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (job_id, id) DO NOTHING",
+				string(embedded.Id), execution.Id, unixNano(execution.Date), string(execution.Status), execution.BranchName, int64(execution.Duration), string(executionData))
+			if err != nil {
+				return 0, err
+			}
+		}
+		job, err := removeEmbeddedExecutions([]byte(data))
+		if err != nil {
+			return 0, err
+		}
+		jobData, err := document(job)
+		if err != nil {
+			return 0, err
+		}
+		_, err = s.exec(ctx, tx, "UPDATE jobs SET data = ? WHERE id = ?", jobData, string(job.Id))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// version 3. The changes are the created buckets.
+func createPurgedExecutionsBucketMigration(tx *bolt.Tx) (int, error) {
+	if tx.Bucket([]byte("purgedExecutions")) != nil {
+		return 0, nil
+	}
+	_, err := tx.CreateBucket([]byte("purgedExecutions"))
+	if err != nil {
+		return 0, fmt.Errorf("ERROR >> purgedExecutions bucket creation failed : %s", err)
+	}
+	return 1, nil
+}
+
+// version 3, see createTablesMigration
+func createPurgedExecutionsTableMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	_, err := s.exec(ctx, tx, "CREATE TABLE IF NOT EXISTS purged_executions (job_id VARCHAR(64) NOT NULL, id VARCHAR(64) NOT NULL, purged_at BIGINT NOT NULL, PRIMARY KEY (job_id, id))")
+	if err != nil {
+		return 0, fmt.Errorf("ERROR >> schema creation failed : %s", err)
+	}
+	return 1, nil
+}
+
+// a step execution as stored before version 4, when
+// its logs were a single string
+type stepWithEmbeddedLogs struct {
+	Logs string
+}
+
+// split the logs embedded in the steps of the stored execution
+// data into stdout lines, dated by the start of the execution.
+// Return the execution without them, and the lines by step index.
+// The execution is nil when there is no embedded log.
+func splitEmbeddedLogs(data []byte) (*model.JobExecution, map[int][]model.LogLine, error) {
+	var embedded struct {
+		Steps []stepWithEmbeddedLogs
+	}
+	err := json.Unmarshal(data, &embedded)
+	if err != nil {
+		return nil, nil, err
+	}
+	var execution model.JobExecution
+	err = json.Unmarshal(data, &execution)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := make(map[int][]model.LogLine)
+	for index, step := range embedded.Steps {
+		if step.Logs == "" {
+			continue
+		}
+		stepLines := make([]model.LogLine, 0)
+		for _, content := range strings.Split(strings.TrimSuffix(step.Logs, "\n"), "\n") {
+			stepLines = append(stepLines, model.LogLine{
+				Number:  len(stepLines) + 1,
+				Time:    execution.Date,
+				Stream:  model.Stdout,
+				Content: strings.TrimSuffix(content, "\r"),
+			})
+		}
+		lines[index] = stepLines
+		if execution.Steps[index] != nil {
+			execution.Steps[index].LogLines = len(stepLines)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil, nil
+	}
+	return &execution, lines, nil
+}
+
+// version 4. The changes are the updated executions.
+func moveEmbeddedLogsBoltMigration(tx *bolt.Tx) (int, error) {
+	executions := tx.Bucket([]byte("jobsExecutions"))
+	logs := tx.Bucket([]byte("logs"))
+	if executions == nil || logs == nil {
+		return 0, errors.New("persistence >> CRITICAL error. No bucket for storing logs. The database may be corrupted !")
+	}
+	type withEmbeddedLogs struct {
+		jobId     []byte
+		execution *model.JobExecution
+		lines     map[int][]model.LogLine
+	}
+	// a bucket must not be modified while iterated
+	toMigrate := make([]withEmbeddedLogs, 0)
+	err := executions.ForEach(func(jobId, v []byte) error {
+		eb := executions.Bucket(jobId)
+		if eb == nil {
+			return nil
+		}
+		return eb.ForEach(func(k, v []byte) error {
+			execution, lines, err := splitEmbeddedLogs(v)
+			if err == nil && execution != nil {
+				toMigrate = append(toMigrate, withEmbeddedLogs{jobId: append([]byte(nil), jobId...), execution: execution, lines: lines})
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range toMigrate {
+		for step, lines := range m.lines {
+			sb, err := createNestedBuckets(logs, m.jobId, []byte(m.execution.Id), []byte(strconv.Itoa(step)))
+			if err != nil {
+				return 0, err
+			}
+			data, err := json.Marshal(lines)
+			if err != nil {
+				return 0, err
+			}
+			err = sb.Put(chunkKey(lines[0].Number), data)
+			if err != nil {
+				return 0, err
+			}
+		}
+		data, err := json.Marshal(m.execution)
+		if err != nil {
+			return 0, err
+		}
+		err = executions.Bucket(m.jobId).Put([]byte(m.execution.Id), data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// version 4, see moveEmbeddedLogsBoltMigration
+func moveEmbeddedLogsSqlMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	rows, err := s.query(ctx, tx, "SELECT job_id, data FROM job_executions")
+	if err != nil {
+		return 0, err
+	}
+	type withEmbeddedLogs struct {
+		jobId     string
+		execution *model.JobExecution
+		lines     map[int][]model.LogLine
+	}
+	toMigrate := make([]withEmbeddedLogs, 0)
+	for rows.Next() {
+		var jobId, data string
+		var execution *model.JobExecution
+		var lines map[int][]model.LogLine
+		err = rows.Scan(&jobId, &data)
+		if err == nil {
+			execution, lines, err = splitEmbeddedLogs([]byte(data))
+		}
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if execution != nil {
+			toMigrate = append(toMigrate, withEmbeddedLogs{jobId: jobId, execution: execution, lines: lines})
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, m := range toMigrate {
+		for step, lines := range m.lines {
+			for _, line := range lines {
+				data, err := document(line)
+				if err != nil {
+					return 0, err
+				}
+				_, err = s.exec(ctx, tx, "INSERT INTO log_lines (job_id, execution_id, step, number, data) VALUES (?, ?, ?, ?, ?) "+
+					"ON CONFLICT (job_id, execution_id, step, number) DO UPDATE SET data = excluded.data",
+					m.jobId, m.execution.Id, step, line.Number, data)
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		data, err := document(m.execution)
+		if err != nil {
+			return 0, err
+		}
+		_, err = s.exec(ctx, tx, "UPDATE job_executions SET data = ? WHERE job_id = ? AND id = ?", data, m.jobId, m.execution.Id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// set the status of an execution stored before it had one,
+// from the status of its steps, and its duration, from their
+// durations. No job runs while the migrations are played : an
+// execution whose steps have not all succeeded has failed, or
+// has been interrupted by the stop of the server. Return false
+// when the execution already has a status.
+func deriveExecutionStatus(execution *model.JobExecution) bool {
+	if execution.Status != "" {
+		return false
+	}
+	execution.Status = model.Success
+	for _, step := range execution.Steps {
+		if step == nil {
+			continue
+		}
+		if step.Status == model.Canceled {
+			execution.Status = model.Canceled
+			break
+		}
+		if step.Status != model.Success {
+			execution.Status = model.Failure
+		}
+	}
+	if len(execution.Steps) == 0 {
+		execution.Status = model.Failure
+	}
+	if execution.Duration == 0 {
+		for _, step := range execution.Steps {
+			if step != nil {
+				execution.Duration += step.Duration
+			}
+		}
+	}
+	return true
+}
+
+// version 5. The changes are the updated executions.
+func deriveExecutionStatusBoltMigration(tx *bolt.Tx) (int, error) {
+	executions := tx.Bucket([]byte("jobsExecutions"))
+	if executions == nil {
+		return 0, errors.New("persistence >> CRITICAL error. No bucket for storing executions. The database may be corrupted !")
+	}
+	type withoutStatus struct {
+		jobId     []byte
+		execution *model.JobExecution
+	}
+	// a bucket must not be modified while iterated
+	toMigrate := make([]withoutStatus, 0)
+	err := executions.ForEach(func(jobId, v []byte) error {
+		eb := executions.Bucket(jobId)
+		if eb == nil {
+			return nil
+		}
+		return eb.ForEach(func(k, v []byte) error {
+			var execution model.JobExecution
+			err := json.Unmarshal(v, &execution)
+			if err == nil && deriveExecutionStatus(&execution) {
+				toMigrate = append(toMigrate, withoutStatus{jobId: append([]byte(nil), jobId...), execution: &execution})
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range toMigrate {
+		data, err := json.Marshal(m.execution)
+		if err != nil {
+			return 0, err
+		}
+		err = executions.Bucket(m.jobId).Put([]byte(m.execution.Id), data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// version 5, see deriveExecutionStatusBoltMigration. The
+// status and duration columns are updated with the data.
+func deriveExecutionStatusSqlMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	rows, err := s.query(ctx, tx, "SELECT job_id, data FROM job_executions WHERE status = ?", "")
+	if err != nil {
+		return 0, err
+	}
+	type withoutStatus struct {
+		jobId     string
+		execution *model.JobExecution
+	}
+	toMigrate := make([]withoutStatus, 0)
+	for rows.Next() {
+		var jobId, data string
+		var execution model.JobExecution
+		err = rows.Scan(&jobId, &data)
+		if err == nil {
+			err = json.Unmarshal([]byte(data), &execution)
+		}
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if deriveExecutionStatus(&execution) {
+			toMigrate = append(toMigrate, withoutStatus{jobId: jobId, execution: &execution})
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, m := range toMigrate {
+		data, err := document(m.execution)
+		if err != nil {
+			return 0, err
+		}
+		_, err = s.exec(ctx, tx, "UPDATE job_executions SET status = ?, duration = ?, data = ? WHERE job_id = ? AND id = ?",
+			string(m.execution.Status), int64(m.execution.Duration), data, m.jobId, m.execution.Id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// return the users to make administrators. A database without
+// any administrator has been written before the roles : its users
+// had every right, they keep them. Otherwise, the users without role
+// have been created as viewers, and nothing is returned.
+func usersWithoutRole(users []*model.User) []*model.User {
+	res := make([]*model.User, 0)
+	for _, user := range users {
+		if user.Role == model.RoleAdmin {
+			return nil
+		}
+		if user.Role == "" {
+			res = append(res, user)
+		}
+	}
+	return res
+}
+
+// version 6. The changes are the updated users.
+func grantAdminRoleBoltMigration(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket([]byte("users"))
+	if b == nil {
+		return 0, errors.New("persistence >> CRITICAL error. No bucket for storing users. The database may be corrupted !")
+	}
+	users := make([]*model.User, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		var user model.User
+		err := json.Unmarshal(v, &user)
+		users = append(users, &user)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	toMigrate := usersWithoutRole(users)
+	for _, user := range toMigrate {
+		user.Role = model.RoleAdmin
+		data, err := json.Marshal(user)
+		if err != nil {
+			return 0, err
+		}
+		err = b.Put([]byte(user.Login), data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
+
+// version 6, see grantAdminRoleBoltMigration
+func grantAdminRoleSqlMigration(ctx context.Context, s *sqlRepository, tx *sql.Tx) (int, error) {
+	rows, err := s.query(ctx, tx, "SELECT data FROM users")
+	if err != nil {
+		return 0, err
+	}
+	users := make([]*model.User, 0)
+	for rows.Next() {
+		var data string
+		var user model.User
+		err = rows.Scan(&data)
+		if err == nil {
+			err = json.Unmarshal([]byte(data), &user)
+		}
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, &user)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	toMigrate := usersWithoutRole(users)
+	for _, user := range toMigrate {
+		user.Role = model.RoleAdmin
+		data, err := document(user)
+		if err != nil {
+			return 0, err
+		}
+		_, err = s.exec(ctx, tx, "UPDATE users SET data = ? WHERE login = ?", data, user.Login)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(toMigrate), nil
+}
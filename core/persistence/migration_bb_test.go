@@ -0,0 +1,287 @@
+package persistence_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+// test that #Migrate moves the executions embedded in the
+// jobs, only reporting the change with a dry-run
+func TestMigrateShouldMoveEmbeddedExecutions(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the legacy records are inserted in the bbolt file")
+	}
+	j := model.Job{Id: []byte("job"), Name: "job", Executions: []model.JobExecution{{Id: "1", BranchName: "master"}}}
+	tests.InsertObject(c, []byte("jobs"), j.Id, j)
+
+	// when
+	dryRunReport, dryRunErr := persistence.Migrate(c, true)
+	report, err := persistence.Migrate(c, false)
+	upToDateReport, upToDateErr := persistence.Migrate(c, false)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	execution, executionErr := rep.GetJobExecution(ctx, "job", "1")
+	job, jobErr := rep.GetJob(j.Id, ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if dryRunErr != nil || err != nil || upToDateErr != nil {
+		t.Fatalf("expect to have no error when migrating, but got %+v, %+v and %+v", dryRunErr, err, upToDateErr)
+	}
+	if dryRunReport.From != 0 || dryRunReport.To != persistence.LatestSchemaVersion() || dryRunReport.Migrations[1].Changes != 1 {
+		t.Fatalf("expect the dry-run to report the migration of one job, but got %+v", dryRunReport)
+	}
+	if report.From != 0 || report.Migrations[1].Changes != 1 {
+		t.Fatalf("expect the dry-run to have written nothing, but got %+v", report)
+	}
+	if upToDateReport.From != persistence.LatestSchemaVersion() || len(upToDateReport.Migrations) != 0 {
+		t.Fatalf("expect the database to be up to date, but got %+v", upToDateReport)
+	}
+	if executionErr != nil || execution.BranchName != "master" {
+		t.Fatalf("expect the execution to be moved, but got %+v and %+v", execution, executionErr)
+	}
+	if jobErr != nil || len(job.Executions) != 0 {
+		t.Fatalf("expect the job to have no embedded execution anymore, but got %+v and %+v", job, jobErr)
+	}
+}
+
+// the jobs and their executions, as
+// stored by the first versions of Dahu
+type legacyStepExecution struct {
+	Name     string
+	Status   model.ExecutionStatus
+	Duration time.Duration
+	Logs     string
+}
+
+type legacyJobExecution struct {
+	Id         string
+	BranchName string
+	Date       time.Time
+	Steps      []legacyStepExecution
+}
+
+type legacyJob struct {
+	Id         []byte               `json:"id"`
+	Name       string               `json:"name"`
+	Executions []legacyJobExecution `json:"executions"`
+}
+
+// test that #Migrate turns the logs embedded in
+// the step executions into log lines
+func TestMigrateShouldMoveEmbeddedLogs(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the legacy records are inserted in the bbolt file")
+	}
+	date := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	j := legacyJob{Id: []byte("job"), Name: "job", Executions: []legacyJobExecution{{Id: "1", BranchName: "master", Date: date, Steps: []legacyStepExecution{
+		{Name: "Code fetching", Status: model.Success, Logs: "Cloning into '.'...\r\nClone finished without error\n"},
+		{Name: "build", Status: model.Failure, Logs: "some error"},
+	}}}}
+	tests.InsertObject(c, []byte("jobs"), j.Id, j)
+
+	// when
+	report, err := persistence.Migrate(c, false)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	execution, executionErr := rep.GetJobExecution(ctx, "job", "1")
+	fetchLogs, fetchErr := rep.GetLogs(ctx, "job", "1", 0, 0, 0)
+	buildLogs, buildErr := rep.GetLogs(ctx, "job", "1", 1, 0, 0)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || report.Migrations[3].Changes != 1 {
+		t.Fatalf("expect the logs of one execution to be migrated, but got %+v and %+v", report, err)
+	}
+	if executionErr != nil || len(execution.Steps) != 2 || execution.Steps[0].LogLines != 2 || execution.Steps[1].LogLines != 1 {
+		t.Fatalf("expect the steps to count their lines, but got %+v and %+v", execution, executionErr)
+	}
+	if fetchErr != nil || len(fetchLogs) != 2 || fetchLogs[0].Content != "Cloning into '.'..." || fetchLogs[1].Content != "Clone finished without error" {
+		t.Fatalf("expect the logs of the fetch to be split in lines, but got %+v and %+v", fetchLogs, fetchErr)
+	}
+	if buildErr != nil || len(buildLogs) != 1 || buildLogs[0].Number != 1 || !buildLogs[0].Time.Equal(date) || buildLogs[0].Stream != model.Stdout {
+		t.Fatalf("expect the logs of the build to be a line dated by the execution, but got %+v and %+v", buildLogs, buildErr)
+	}
+}
+
+// test that #Migrate gives the executions stored
+// without status the one of their steps
+func TestMigrateShouldDeriveTheExecutionStatus(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the legacy records are inserted in the bbolt file")
+	}
+	date := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	j := legacyJob{Id: []byte("job"), Name: "job", Executions: []legacyJobExecution{
+		{Id: "1", Date: date, Steps: []legacyStepExecution{
+			{Name: "Code fetching", Status: model.Success, Duration: time.Second},
+			{Name: "build", Status: model.Success, Duration: 2 * time.Second},
+		}},
+		{Id: "2", Date: date, Steps: []legacyStepExecution{
+			{Name: "Code fetching", Status: model.Success},
+			{Name: "build", Status: model.Failure},
+		}},
+		{Id: "3", Date: date, Steps: []legacyStepExecution{
+			{Name: "Code fetching", Status: model.Success},
+			{Name: "build", Status: model.Running},
+		}},
+	}}
+	tests.InsertObject(c, []byte("jobs"), j.Id, j)
+
+	// when
+	report, err := persistence.Migrate(c, false)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	success, successErr := rep.GetJobExecution(ctx, "job", "1")
+	failure, failureErr := rep.GetJobExecution(ctx, "job", "2")
+	interrupted, interruptedErr := rep.GetJobExecution(ctx, "job", "3")
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || report.Migrations[4].Changes != 3 {
+		t.Fatalf("expect the status of three executions to be derived, but got %+v and %+v", report, err)
+	}
+	if successErr != nil || success.Status != model.Success || success.Duration != 3*time.Second {
+		t.Fatalf("expect a successful execution lasting as long as its steps, but got %+v and %+v", success, successErr)
+	}
+	if failureErr != nil || failure.Status != model.Failure {
+		t.Fatalf("expect an execution with a failed step to fail, but got %+v and %+v", failure, failureErr)
+	}
+	if interruptedErr != nil || interrupted.Status != model.Failure {
+		t.Fatalf("expect an unfinished execution to fail, but got %+v and %+v", interrupted, interruptedErr)
+	}
+}
+
+// a user as stored before the roles
+type legacyUser struct {
+	Login    string
+	Password []byte
+}
+
+// test that #Migrate makes administrators the
+// users of a database written before the roles
+func TestMigrateShouldMakeTheLegacyUsersAdministrators(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the legacy records are inserted in the bbolt file")
+	}
+	tests.InsertObject(c, []byte("users"), []byte("dahu"), legacyUser{Login: "dahu", Password: []byte("hash")})
+	tests.InsertObject(c, []byte("users"), []byte("tester"), legacyUser{Login: "tester", Password: []byte("hash")})
+
+	// when
+	report, err := persistence.Migrate(c, false)
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	dahu, dahuErr := rep.GetUser("dahu", ctx)
+	tester, testerErr := rep.GetUser("tester", ctx)
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || report.Migrations[5].Changes != 2 {
+		t.Fatalf("expect two users to be made administrators, but got %+v and %+v", report, err)
+	}
+	if dahuErr != nil || dahu.Role != model.RoleAdmin || string(dahu.Password) != "hash" {
+		t.Fatalf("expect dahu to be an administrator keeping its password, but got %+v and %+v", dahu, dahuErr)
+	}
+	if testerErr != nil || tester.Role != model.RoleAdmin {
+		t.Fatalf("expect tester to be an administrator, but got %+v and %+v", tester, testerErr)
+	}
+}
+
+// test that #Migrate keeps the viewers of a
+// database that already has an administrator
+func TestMigrateShouldKeepTheViewers(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the records are inserted in the bbolt file")
+	}
+	tests.InsertObject(c, []byte("users"), []byte("dahu"), model.User{Login: "dahu", Role: model.RoleAdmin})
+	tests.InsertObject(c, []byte("users"), []byte("tester"), model.User{Login: "tester"})
+
+	// when
+	report, err := persistence.Migrate(c, false)
+	tester, testerErr := persistence.GetRepository(c).GetUser("tester", context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil || report.Migrations[5].Changes != 0 {
+		t.Fatalf("expect no user to be changed, but got %+v and %+v", report, err)
+	}
+	if testerErr != nil || tester.Role != "" {
+		t.Fatalf("expect tester to stay a viewer, but got %+v and %+v", tester, testerErr)
+	}
+}
+
+// test that #Migrate refuses a database
+// written by a more recent version of Dahu
+func TestMigrateShouldRefuseNewerDatabase(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	if c.PersistenceConf.Type != configuration.InMemory {
+		t.Skip("the schema version is inserted in the bbolt file")
+	}
+	tests.InsertObject(c, []byte("meta"), []byte("schemaVersion"), persistence.LatestSchemaVersion()+1)
+
+	// when
+	_, err := persistence.Migrate(c, true)
+
+	// remove the db
+	tests.DeletePersistence(c)
+
+	// then
+	if err == nil {
+		t.Fatal("expect to have an error when migrating a newer database, but got nil")
+	}
+}
+
+// test that a database migrated before the first
+// start, inside a new data directory, still gets
+// the default user
+func TestMigrateThenStartShouldCreateTheDefaultUser(t *testing.T) {
+	// given
+	c := tests.InitConf()
+	dir, _ := ioutil.TempDir("", "dahu-data")
+	defer os.RemoveAll(dir)
+	c.PersistenceConf.DataDir = filepath.Join(dir, "data")
+
+	// when
+	_, err := persistence.Migrate(c, false)
+	user, userErr := persistence.GetRepository(c).GetUser("dahu", context.Background())
+
+	// close and remove the db
+	tests.CleanPersistence(c)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect to have no error when migrating, but got %s", err.Error())
+	}
+	if userErr != nil || !user.MustChangePassword {
+		t.Fatalf("expect the default user to be created, but got %+v and %+v", user, userErr)
+	}
+}
@@ -2,6 +2,8 @@ package persistence
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/jeromedoucet/dahu/configuration"
 	"github.com/jeromedoucet/dahu/core/model"
@@ -22,11 +24,98 @@ type Repository interface {
 	// get all existing jobs
 	GetJobs(ctx context.Context) ([]*model.Job, PersistenceError)
 
+	// create a new jobExecution of the job identified by the given id, generating
+	// its id if it has none. A Conflict error is returned when its id is already used
+	CreateJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError)
 	// create or update the jobExecution of the job identified by the given id
 	UpsertJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError)
+	// get one execution of the job identified by the given id
+	GetJobExecution(ctx context.Context, jobId, executionId string) (*model.JobExecution, PersistenceError)
+	// get all executions of the job identified by the given id, oldest first
+	GetJobExecutions(ctx context.Context, jobId string) ([]*model.JobExecution, PersistenceError)
+	// get the executions of the job matching the filter, newest first
+	SearchJobExecutions(ctx context.Context, jobId string, filter model.ExecutionFilter) ([]*model.JobExecution, PersistenceError)
+	// delete executions of the job identified by the given id, with their
+	// logs. Later updates of these executions return a NoMorePersisted
+	// PersistenceError. Unknown executions are ignored.
+	DeleteJobExecutions(ctx context.Context, jobId string, executionIds []string) PersistenceError
+	// forget the executions purged before the given instant, of every job : their
+	// updates are accepted again. Return the number of forgotten executions.
+	ForgetPurgedExecutions(ctx context.Context, before time.Time) (int, PersistenceError)
+	// get aggregates over the executions of the job
+	// matching the filter. Offset and Limit are ignored.
+	GetJobExecutionStats(ctx context.Context, jobId string, filter model.ExecutionFilter) (*model.ExecutionStats, PersistenceError)
 
+	// append lines to the logs of one step of a job execution. step is
+	// the index of the step in the execution.
+	AppendLogs(ctx context.Context, jobId, executionId string, step int, lines []model.LogLine) PersistenceError
+	// get the logs of one step of a job execution, ordered by line number, from the
+	// line from to the line to, both included. to <= 0 means up to the last line.
+	GetLogs(ctx context.Context, jobId, executionId string, step, from, to int) ([]model.LogLine, PersistenceError)
 	// get an existing user identified by the id parameter.
 	GetUser(id string, ctx context.Context) (*model.User, PersistenceError)
+	// user creation. If a user with the same login already
+	// exists, a Conflict PersistenceError is returned.
+	CreateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError)
+	// get all existing users, sorted by login
+	GetUsers(ctx context.Context) ([]*model.User, PersistenceError)
+	// replace an existing user
+	UpdateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError)
+	// delete one existing user
+	DeleteUser(ctx context.Context, id string) PersistenceError
+
+	// api token creation. The token must have a hash. If
+	// the token already has an id, an PersistenceError is returned.
+	CreateApiToken(token *model.ApiToken, ctx context.Context) (*model.ApiToken, PersistenceError)
+	// get the api token with the given hash
+	GetApiTokenByHash(hash string, ctx context.Context) (*model.ApiToken, PersistenceError)
+	// get all api tokens of a user, oldest first
+	GetApiTokens(login string, ctx context.Context) ([]*model.ApiToken, PersistenceError)
+	// delete (revoke) one api token of a user
+	DeleteApiToken(ctx context.Context, login, id string) PersistenceError
+	// record the last use of an api token
+	TouchApiToken(hash string, usedAt time.Time, ctx context.Context) PersistenceError
+
+	// session creation. The session must have the hash of its
+	// refresh token. If the session already has an id, an
+	// PersistenceError is returned.
+	CreateSession(session *model.Session, ctx context.Context) (*model.Session, PersistenceError)
+	// replace the refresh token hash and the expiration of the session
+	// identified by hash. A NotFound PersistenceError is returned if there is no
+	// such session or if it has expired. The old hash is unusable afterward.
+	RotateSession(hash, newHash string, expiresAt time.Time, ctx context.Context) (*model.Session, PersistenceError)
+	// delete (close) one session
+	DeleteSession(ctx context.Context, id string) PersistenceError
+	// delete all sessions of a user
+	DeleteSessionsOf(ctx context.Context, login string) PersistenceError
+	// add the id of an access token to the deny-list. The entry
+	// is kept until the expiration of the token.
+	RevokeToken(id string, expiresAt time.Time, ctx context.Context) PersistenceError
+	// true if the id of the access token is in the deny-list
+	IsTokenRevoked(id string, ctx context.Context) (bool, PersistenceError)
+
+	// record a login attempt. If the attempt
+	// already has an id, an PersistenceError is returned.
+	CreateLoginAttempt(attempt *model.LoginAttempt, ctx context.Context) (*model.LoginAttempt, PersistenceError)
+	// get the last login attempts, newest first. All logins
+	// are considered when login is empty.
+	GetLoginAttempts(login string, limit int, ctx context.Context) ([]*model.LoginAttempt, PersistenceError)
+
+	// append an entry to the audit trail. If the entry already
+	// has an id, an PersistenceError is returned. Entries can
+	// be neither updated nor deleted.
+	CreateAuditEntry(entry *model.AuditEntry, ctx context.Context) (*model.AuditEntry, PersistenceError)
+	// get the audit entries matching the filter, newest first.
+	GetAuditEntries(filter model.AuditFilter, ctx context.Context) ([]*model.AuditEntry, PersistenceError)
+
+	// store a new current signing key and retire the previous
+	// one at the creation time of the new key. If the key
+	// already has an id, an PersistenceError is returned.
+	RotateSigningKey(key *model.SigningKey, ctx context.Context) (*model.SigningKey, PersistenceError)
+	// get all signing keys, oldest first
+	GetSigningKeys(ctx context.Context) ([]*model.SigningKey, PersistenceError)
+	// delete one retired signing key
+	DeleteSigningKey(ctx context.Context, id string) PersistenceError
 
 	// docker registry creation. If the docker regitry already has an id,
 	// an PersistenceError is returned.
@@ -39,19 +128,55 @@ type Repository interface {
 	GetDockerRegistries(ctx context.Context) ([]*model.DockerRegistry, PersistenceError)
 
 	// delete one existing docker registry
-	DeleteDockerRegistry(id []byte) PersistenceError
+	DeleteDockerRegistry(ctx context.Context, id []byte) PersistenceError
 
 	// update one existing docker registry
 	UpdateDockerRegistry(id []byte, registry *model.DockerRegistryUpdate, ctx context.Context) (*model.DockerRegistry, PersistenceError)
 
+	// team creation. If the team already has an id,
+	// an PersistenceError is returned.
+	CreateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError)
+	// get an existing team identified by the id parameter.
+	GetTeam(id string, ctx context.Context) (*model.Team, PersistenceError)
+	// get all existing teams, sorted by name
+	GetTeams(ctx context.Context) ([]*model.Team, PersistenceError)
+	// replace an existing team
+	UpdateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError)
+	// delete one existing team. A Conflict PersistenceError is
+	// returned if the team still owns jobs or registries.
+	DeleteTeam(ctx context.Context, id string) PersistenceError
+	// move a job to the given team. An empty teamId makes the job global.
+	SetJobTeam(ctx context.Context, jobId []byte, teamId string) (*model.Job, PersistenceError)
+	// move a docker registry to the given team. An empty teamId makes the registry global.
+	SetDockerRegistryTeam(ctx context.Context, registryId []byte, teamId string) (*model.DockerRegistry, PersistenceError)
+
+	// store the jobs, registries, users and teams of the changes as they
+	// are, created or replaced, and delete the listed ones, in a single
+	// transaction. A deleted job loses its executions and their logs,
+	// a deleted user its api tokens and sessions. Unknown deleted
+	// entities are ignored.
+	ApplyConfigurationChanges(ctx context.Context, changes *model.ConfigurationChanges) PersistenceError
+
+	// write a consistent snapshot of the whole database
+	// to w. Only the bbolt persistence supports it, the sql
+	// databases are saved with their own tools.
+	Backup(ctx context.Context, w io.Writer) PersistenceError
+
 	// this call will block until the underlying
 	// connection or persistence system is open.
 	WaitClose()
 }
 
 // return an instance of Repository configured with given configuration.
-// for the moment, only the 'in-memory' persistence layer is
-// supported
+// The implementation is chosen by conf.PersistenceConf.Type, the
+// 'in-memory' (bbolt) one being the default.
 func GetRepository(conf *configuration.Conf) Repository {
-	return getOrCreateInMemory(conf)
+	switch conf.PersistenceConf.Type {
+	case configuration.PostgreSQL:
+		return getOrCreatePostgres(conf)
+	case configuration.SQLite:
+		return getOrCreateSqlite(conf)
+	default:
+		return getOrCreateInMemory(conf)
+	}
 }
@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/lib/pq"
+)
+
+// PostgreSQL specificities of the
+// sql implementation of Repository
+type postgres struct{}
+
+func (p postgres) driverName() string {
+	return "postgres"
+}
+
+func (p postgres) dataSourceName(conf configuration.Persistence) string {
+	return conf.Url
+}
+
+// the url, without its password
+func (p postgres) location(conf configuration.Persistence) (string, error) {
+	u, err := url.Parse(conf.Url)
+	if err != nil || u.Host == "" {
+		return "", nil
+	}
+	if u.User != nil {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String(), nil
+}
+
+// postgres placeholders are numbered: $1, $2 ...
+func (p postgres) rebind(query string) string {
+	var res strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			res.WriteString("$" + strconv.Itoa(n))
+		} else {
+			res.WriteRune(c)
+		}
+	}
+	return res.String()
+}
+
+func (p postgres) schema() []string {
+	return sqlSchema("JSONB")
+}
+
+func (p postgres) isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// return the PostgreSQL repository singleton instance.
+// create it if necessary
+func getOrCreatePostgres(conf *configuration.Conf) Repository {
+	return getOrCreateSql(conf, postgres{})
+}
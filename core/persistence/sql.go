@@ -0,0 +1,365 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// specificities of one sql database
+type dialect interface {
+	// name of the database/sql driver
+	driverName() string
+	// data source name given to the driver
+	dataSourceName(conf configuration.Persistence) string
+	// replace the ? placeholders of the query
+	// by the ones of the database, if they differ
+	rebind(query string) string
+	// statements creating the tables
+	// and the indexes, if they don't exist
+	schema() []string
+	// true if the error is the violation of
+	// a primary key or of an unique index
+	isUniqueViolation(err error) bool
+	// where the database is, for the startup report. Its
+	// directory is created if the database is a file.
+	location(conf configuration.Persistence) (string, error)
+}
+
+// tables of the sql implementations. Each bucket of the
+// bbolt implementation is a table, whose data column
+// holds the json documents, of type documentType.
+func sqlSchema(documentType string) []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS jobs (id VARCHAR(64) PRIMARY KEY, team_id VARCHAR(64) NOT NULL, data %s NOT NULL)", documentType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS job_executions (job_id VARCHAR(64) NOT NULL, id VARCHAR(64) NOT NULL, started_at BIGINT NOT NULL, status VARCHAR(16) NOT NULL, branch VARCHAR(255) NOT NULL, duration BIGINT NOT NULL, data %s NOT NULL, PRIMARY KEY (job_id, id))", documentType),
+		"CREATE INDEX IF NOT EXISTS job_executions_by_start ON job_executions (job_id, started_at)",
+		"CREATE INDEX IF NOT EXISTS job_executions_by_status ON job_executions (job_id, status, started_at)",
+		"CREATE INDEX IF NOT EXISTS job_executions_by_branch ON job_executions (job_id, branch, started_at)",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS log_lines (job_id VARCHAR(64) NOT NULL, execution_id VARCHAR(64) NOT NULL, step INTEGER NOT NULL, number INTEGER NOT NULL, data %s NOT NULL, PRIMARY KEY (job_id, execution_id, step, number))", documentType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS users (login VARCHAR(255) PRIMARY KEY, data %s NOT NULL)", documentType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS docker_registries (id VARCHAR(64) PRIMARY KEY, team_id VARCHAR(64) NOT NULL, last_modification_time VARCHAR(32) NOT NULL, data %s NOT NULL)", documentType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS teams (id VARCHAR(64) PRIMARY KEY, data %s NOT NULL)", documentType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS api_tokens (hash VARCHAR(64) PRIMARY KEY, id VARCHAR(64) NOT NULL, login VARCHAR(255) NOT NULL, created_at BIGINT NOT NULL, data %s NOT NULL)", documentType),
+		"CREATE INDEX IF NOT EXISTS api_tokens_by_login ON api_tokens (login)",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS sessions (hash VARCHAR(64) PRIMARY KEY, id VARCHAR(64) NOT NULL, login VARCHAR(255) NOT NULL, expires_at BIGINT NOT NULL, data %s NOT NULL)", documentType),
+		"CREATE INDEX IF NOT EXISTS sessions_by_login ON sessions (login)",
+		"CREATE INDEX IF NOT EXISTS sessions_by_id ON sessions (id)",
+		"CREATE TABLE IF NOT EXISTS revoked_tokens (id VARCHAR(64) PRIMARY KEY, expires_at BIGINT NOT NULL)",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS login_attempts (id VARCHAR(64) PRIMARY KEY, login VARCHAR(255) NOT NULL, attempted_at BIGINT NOT NULL, data %s NOT NULL)", documentType),
+		"CREATE INDEX IF NOT EXISTS login_attempts_by_time ON login_attempts (attempted_at)",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS audit_entries (id VARCHAR(64) PRIMARY KEY, recorded_at BIGINT NOT NULL, actor VARCHAR(255) NOT NULL, action VARCHAR(16) NOT NULL, resource_type VARCHAR(32) NOT NULL, resource_id VARCHAR(255) NOT NULL, data %s NOT NULL)", documentType),
+		"CREATE INDEX IF NOT EXISTS audit_entries_by_time ON audit_entries (recorded_at)",
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS signing_keys (id VARCHAR(64) PRIMARY KEY, created_at BIGINT NOT NULL, retired_at BIGINT NOT NULL, data %s NOT NULL)", documentType),
+	}
+}
+
+// part of the database/sql api shared by
+// the connections pool and the transactions
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var sqlSingleton *sqlRepository
+
+// return the sqlRepository singleton instance.
+// create it if necessary
+func getOrCreateSql(conf *configuration.Conf, d dialect) Repository {
+	// same strategy than #getOrCreateInMemory
+	singletonMutex.Lock()
+	defer singletonMutex.Unlock()
+	if sqlSingleton == nil {
+		s, err := createSql(conf, d)
+		if err != nil {
+			log.Fatalf("FATAL >> unable to open the %s database : %s", d.driverName(), err.Error())
+		}
+		sqlSingleton = s
+		go s.waitForGracefullShutdown()
+	}
+	return sqlSingleton
+}
+
+// open the database, migrate it and
+// create the default user if needed.
+func createSql(conf *configuration.Conf, d dialect) (*sqlRepository, error) {
+	location, err := d.location(conf.PersistenceConf)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(d.driverName(), d.dataSourceName(conf.PersistenceConf))
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlRepository{conf: conf, db: db, dialect: d, close: make(chan interface{}), secrets: loadSecretBox(conf.PersistenceConf)}
+	err = ping(db, conf.PersistenceConf)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// the migrations may rewrite every row of a large
+	// database, they are not bounded by PersistenceConf.TimeOut
+	version, err := s.initialization(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Printf("INFO >> %s database %s opened (schema version %d)", d.driverName(), location, version)
+	return s, nil
+}
+
+// check that the database is reachable
+// within PersistenceConf.TimeOut
+func ping(db *sql.DB, conf configuration.Persistence) error {
+	ctx, cancel := withTimeout(context.Background(), conf)
+	defer cancel()
+	return contextError(ctx, db.PingContext(ctx))
+}
+
+// play the pending migrations, and create the default user
+// if there is no user at all. Return the schema version.
+func (s *sqlRepository) initialization(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	report, err := s.migrate(ctx, tx, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(report.Migrations) > 0 {
+		log.Printf("INFO >> %s", report.String())
+	}
+	var users int
+	err = s.queryRow(ctx, tx, "SELECT COUNT(*) FROM users").Scan(&users)
+	if err != nil {
+		return 0, err
+	}
+	if users == 0 {
+		err = s.insertUser(ctx, tx, defaultUser())
+		if err != nil {
+			return 0, err
+		}
+	}
+	return report.To, tx.Commit()
+}
+
+func (s *sqlRepository) waitForGracefullShutdown() {
+	<-s.conf.Close
+	singletonMutex.Lock()
+	defer singletonMutex.Unlock()
+	// wait for the current queries,
+	// then refuse the new ones.
+	s.db.Close() // todo handle this error
+	close(s.close)
+	sqlSingleton = nil
+}
+
+// sql implementation of persistence.Repository. Each
+// bucket of the bbolt implementation is a table. The
+// objects are stored as json documents, beside the
+// columns needed to search and sort them.
+type sqlRepository struct {
+	conf    *configuration.Conf
+	db      *sql.DB
+	dialect dialect
+	close   chan interface{}
+	secrets *secretBox
+}
+
+func (s *sqlRepository) WaitClose() {
+	<-s.close
+}
+
+// doUpdateAction will ensure that the
+// db is available and then execute the function
+// inside a transaction, committed if the function
+// returns no error, rolled back otherwise. The function
+// is given ctx, bounded by PersistenceConf.TimeOut.
+func (s *sqlRepository) doUpdateAction(ctx context.Context, action func(ctx context.Context, tx *sql.Tx) error) error {
+	select {
+	case <-s.conf.Close:
+		return errors.New("persistence >> the database is close or closing. Operation impossible.")
+	default:
+	}
+	ctx, cancel := withTimeout(ctx, s.conf.PersistenceConf)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	err = action(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return contextError(ctx, err)
+	}
+	return contextError(ctx, tx.Commit())
+}
+
+// doViewAction will ensure that the db is available
+// and then execute the function outside of any transaction.
+// The function is given ctx, bounded by PersistenceConf.TimeOut.
+func (s *sqlRepository) doViewAction(ctx context.Context, action func(ctx context.Context, q querier) error) error {
+	select {
+	case <-s.conf.Close:
+		return errors.New("persistence >> the database is close or closing. Operation impossible.")
+	default:
+	}
+	ctx, cancel := withTimeout(ctx, s.conf.PersistenceConf)
+	defer cancel()
+	return contextError(ctx, action(ctx, s.db))
+}
+
+func (s *sqlRepository) exec(ctx context.Context, q querier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlRepository) query(ctx context.Context, q querier, query string, args ...interface{}) (*sql.Rows, error) {
+	return q.QueryContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlRepository) queryRow(ctx context.Context, q querier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// execute a delete or an update statement, and
+// return a NotFound error if no row was affected
+func (s *sqlRepository) execOne(ctx context.Context, q querier, notFound string, query string, args ...interface{}) error {
+	res, err := s.exec(ctx, q, query, args...)
+	if err != nil {
+		return err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return newPersistenceError(notFound, NotFound)
+	}
+	return nil
+}
+
+// return a Conflict error with the given message when
+// the count query finds a row, like one with the same id
+func (s *sqlRepository) checkUnused(ctx context.Context, q querier, conflict string, query string, args ...interface{}) error {
+	var count int
+	err := s.queryRow(ctx, q, query, args...).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return newPersistenceError(conflict, Conflict)
+	}
+	return nil
+}
+
+// fetch the json document of one row into value. A
+// NotFound error with the given message is returned
+// when there is no such row.
+func (s *sqlRepository) getDocument(ctx context.Context, q querier, value interface{}, notFound string, query string, args ...interface{}) error {
+	var data []byte
+	err := s.queryRow(ctx, q, query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return newPersistenceError(notFound, NotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// fetch the json documents of the rows. newValue must
+// return a pointer on a new element of the result.
+func (s *sqlRepository) getDocuments(ctx context.Context, q querier, newValue func() interface{}, query string, args ...interface{}) error {
+	rows, err := s.query(ctx, q, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, newValue())
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InsertObject store an object as it is, like tests.InsertObject
+// does with bbolt. The bucket is the name of its bbolt bucket. It
+// allows the persistence tests to be shared by every implementation.
+func (s *sqlRepository) InsertObject(bucketName string, key []byte, data []byte) error {
+	ctx := context.Background()
+	return s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		switch bucketName {
+		case "jobs":
+			var job model.Job
+			return unmarshalAnd(data, &job, func() error { return s.insertJob(ctx, tx, &job) })
+		case "users":
+			var user model.User
+			return unmarshalAnd(data, &user, func() error { return s.insertUser(ctx, tx, user) })
+		case "dockerRegistries":
+			var registry model.DockerRegistry
+			return unmarshalAnd(data, &registry, func() error { return s.insertDockerRegistry(ctx, tx, &registry) })
+		case "teams":
+			var team model.Team
+			return unmarshalAnd(data, &team, func() error { return s.insertTeam(ctx, tx, &team) })
+		case "apiTokens":
+			var token model.ApiToken
+			return unmarshalAnd(data, &token, func() error { return s.insertApiToken(ctx, tx, &token) })
+		case "sessions":
+			var session model.Session
+			return unmarshalAnd(data, &session, func() error { return s.insertSession(ctx, tx, &session) })
+		default:
+			return fmt.Errorf("persistence >> no table for the bucket %s", bucketName)
+		}
+	})
+}
+
+func unmarshalAnd(data []byte, value interface{}, insert func() error) error {
+	err := json.Unmarshal(data, value)
+	if err != nil {
+		return err
+	}
+	return insert()
+}
+
+// json document of a value, as a string
+// so that every driver sends it as text
+func document(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// unix time in nanoseconds, zero for the zero time. Times are stored
+// this way to be sorted and compared the same way by every database.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// join the conditions of a where clause.
+// Return an empty string without condition.
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
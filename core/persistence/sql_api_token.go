@@ -0,0 +1,100 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// api tokens are stored by hash, because
+// this is the way they are searched for on
+// every authenticated request.
+func (s *sqlRepository) CreateApiToken(token *model.ApiToken, ctx context.Context) (*model.ApiToken, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if token.Hash == "" {
+			return errors.New("persistence >> Cannot persist an api token without hash !")
+		}
+		updateErr := token.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		updateErr = s.insertApiToken(ctx, tx, token)
+		if s.dialect.isUniqueViolation(updateErr) {
+			token.Id = ""
+			return newPersistenceError("An api token with the same hash already exists", Conflict)
+		}
+		return updateErr
+	})
+	if err == nil {
+		return token, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertApiToken(ctx context.Context, q querier, token *model.ApiToken) error {
+	data, err := document(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO api_tokens (hash, id, login, created_at, data) VALUES (?, ?, ?, ?, ?)",
+		token.Hash, token.Id, token.Login, unixNano(token.CreatedAt), data)
+	return err
+}
+
+func (s *sqlRepository) GetApiTokenByHash(hash string, ctx context.Context) (*model.ApiToken, PersistenceError) {
+	var token model.ApiToken
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocument(ctx, q, &token, "No api token found", "SELECT data FROM api_tokens WHERE hash = ?", hash)
+	})
+	if err == nil {
+		return &token, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetApiTokens(login string, ctx context.Context) ([]*model.ApiToken, PersistenceError) {
+	tokens := make([]*model.ApiToken, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocuments(ctx, q, func() interface{} {
+			token := new(model.ApiToken)
+			tokens = append(tokens, token)
+			return token
+		}, "SELECT data FROM api_tokens WHERE login = ? ORDER BY created_at", login)
+	})
+	if err == nil {
+		return tokens, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) DeleteApiToken(ctx context.Context, login, id string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.execOne(ctx, tx, fmt.Sprintf("No api token with id %s found", id), "DELETE FROM api_tokens WHERE id = ? AND login = ?", id, login)
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) TouchApiToken(hash string, usedAt time.Time, ctx context.Context) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var token model.ApiToken
+		mErr := s.getDocument(ctx, tx, &token, "No api token found", "SELECT data FROM api_tokens WHERE hash = ?", hash)
+		if mErr != nil {
+			return mErr
+		}
+		token.LastUsedAt = &usedAt
+		data, mErr := document(token)
+		if mErr != nil {
+			return mErr
+		}
+		_, mErr = s.exec(ctx, tx, "UPDATE api_tokens SET data = ? WHERE hash = ?", data, hash)
+		return mErr
+	})
+	return wrapError(err)
+}
@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) CreateAuditEntry(entry *model.AuditEntry, ctx context.Context) (*model.AuditEntry, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := entry.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := document(entry)
+		if updateErr != nil {
+			return updateErr
+		}
+		_, updateErr = s.exec(ctx, tx, "INSERT INTO audit_entries (id, recorded_at, actor, action, resource_type, resource_id, data) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			entry.Id, unixNano(entry.Time), entry.Actor, string(entry.Action), string(entry.ResourceType), entry.ResourceId, data)
+		return updateErr
+	})
+	if err == nil {
+		return entry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetAuditEntries(filter model.AuditFilter, ctx context.Context) ([]*model.AuditEntry, PersistenceError) {
+	entries := make([]*model.AuditEntry, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		conditions := make([]string, 0)
+		args := make([]interface{}, 0)
+		for _, criterion := range []struct {
+			column string
+			value  string
+		}{
+			{"actor", filter.Actor},
+			{"action", string(filter.Action)},
+			{"resource_type", string(filter.ResourceType)},
+			{"resource_id", filter.ResourceId},
+		} {
+			if criterion.value != "" {
+				conditions = append(conditions, criterion.column+" = ?")
+				args = append(args, criterion.value)
+			}
+		}
+		if !filter.Since.IsZero() {
+			conditions = append(conditions, "recorded_at >= ?")
+			args = append(args, filter.Since.UnixNano())
+		}
+		if !filter.Until.IsZero() {
+			conditions = append(conditions, "recorded_at < ?")
+			args = append(args, filter.Until.UnixNano())
+		}
+		query := "SELECT data FROM audit_entries" + where(conditions) + " ORDER BY recorded_at DESC, id DESC"
+		if filter.Limit > 0 {
+			query += " LIMIT ?"
+			args = append(args, filter.Limit)
+		}
+		return s.getDocuments(ctx, q, func() interface{} {
+			entry := new(model.AuditEntry)
+			entries = append(entries, entry)
+			return entry
+		}, query, args...)
+	})
+	if err == nil {
+		return entries, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
@@ -0,0 +1,10 @@
+package persistence
+
+import (
+	"context"
+	"io"
+)
+
+func (s *sqlRepository) Backup(ctx context.Context, w io.Writer) PersistenceError {
+	return wrapError(errSqlBackup)
+}
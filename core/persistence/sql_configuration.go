@@ -0,0 +1,102 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) ApplyConfigurationChanges(ctx context.Context, changes *model.ConfigurationChanges) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for _, team := range changes.Teams {
+			sealed, err := s.secrets.sealTeam(team)
+			if err != nil {
+				return err
+			}
+			data, err := document(sealed)
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO teams (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data", team.Id, data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, registry := range changes.DockerRegistries {
+			sealed, err := s.secrets.sealDockerRegistry(registry)
+			if err != nil {
+				return err
+			}
+			data, err := document(sealed)
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO docker_registries (id, team_id, last_modification_time, data) VALUES (?, ?, ?, ?) "+
+				"ON CONFLICT (id) DO UPDATE SET team_id = excluded.team_id, last_modification_time = excluded.last_modification_time, data = excluded.data",
+				registry.Id, registry.TeamId, registry.LastModificationTime, data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, job := range changes.Jobs {
+			sealed, err := s.secrets.sealJob(job)
+			if err != nil {
+				return err
+			}
+			data, err := document(sealed)
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO jobs (id, team_id, data) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET team_id = excluded.team_id, data = excluded.data",
+				string(job.Id), job.TeamId, data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, user := range changes.Users {
+			data, err := document(user)
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO users (login, data) VALUES (?, ?) ON CONFLICT (login) DO UPDATE SET data = excluded.data", user.Login, data)
+			if err != nil {
+				return err
+			}
+		}
+		deletions := make([]struct{ query, id string }, 0)
+		for _, id := range changes.DeletedJobs {
+			for _, query := range []string{
+				"DELETE FROM jobs WHERE id = ?",
+				"DELETE FROM job_executions WHERE job_id = ?",
+				"DELETE FROM log_lines WHERE job_id = ?",
+				"DELETE FROM purged_executions WHERE job_id = ?",
+			} {
+				deletions = append(deletions, struct{ query, id string }{query, id})
+			}
+		}
+		for _, id := range changes.DeletedDockerRegistries {
+			deletions = append(deletions, struct{ query, id string }{"DELETE FROM docker_registries WHERE id = ?", id})
+		}
+		for _, login := range changes.DeletedUsers {
+			for _, query := range []string{
+				"DELETE FROM users WHERE login = ?",
+				"DELETE FROM api_tokens WHERE login = ?",
+				"DELETE FROM sessions WHERE login = ?",
+			} {
+				deletions = append(deletions, struct{ query, id string }{query, login})
+			}
+		}
+		for _, id := range changes.DeletedTeams {
+			deletions = append(deletions, struct{ query, id string }{"DELETE FROM teams WHERE id = ?", id})
+		}
+		for _, deletion := range deletions {
+			_, err := s.exec(ctx, tx, deletion.query, deletion.id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(err)
+}
@@ -0,0 +1,140 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) CreateDockerRegistry(registry *model.DockerRegistry, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := registry.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		updateErr = s.checkUnused(ctx, tx, fmt.Sprintf("A docker registry with id %s already exists", registry.Id), "SELECT COUNT(*) FROM docker_registries WHERE id = ?", registry.Id)
+		if updateErr != nil {
+			return updateErr
+		}
+		// initialization of LastModificationDate field
+		// that will be use later for optimistic lock on
+		// update requests.
+		registry.NewLastModificationTime()
+		return s.insertDockerRegistry(ctx, tx, registry)
+	})
+	if err == nil {
+		return registry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertDockerRegistry(ctx context.Context, q querier, registry *model.DockerRegistry) error {
+	sealed, err := s.secrets.sealDockerRegistry(registry)
+	if err != nil {
+		return err
+	}
+	data, err := document(sealed)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO docker_registries (id, team_id, last_modification_time, data) VALUES (?, ?, ?, ?)",
+		registry.Id, registry.TeamId, registry.LastModificationTime, data)
+	return err
+}
+
+func (s *sqlRepository) GetDockerRegistry(id []byte, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
+	var registry model.DockerRegistry
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.fetchDockerRegistry(ctx, q, string(id), &registry)
+	})
+	if err == nil {
+		return &registry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) fetchDockerRegistry(ctx context.Context, q querier, id string, registry *model.DockerRegistry) error {
+	err := s.getDocument(ctx, q, registry, fmt.Sprintf("No docker registry with id %s found", id), "SELECT data FROM docker_registries WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return s.secrets.openDockerRegistry(registry)
+}
+
+func (s *sqlRepository) DeleteDockerRegistry(ctx context.Context, id []byte) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return s.execOne(ctx, tx, fmt.Sprintf("No docker registry with id %s found", string(id)), "DELETE FROM docker_registries WHERE id = ?", string(id))
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) UpdateDockerRegistry(id []byte, registryUpdate *model.DockerRegistryUpdate, ctx context.Context) (*model.DockerRegistry, PersistenceError) {
+	var updatedRegistry model.DockerRegistry
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var existingRegistry model.DockerRegistry
+		mErr := s.fetchDockerRegistry(ctx, tx, string(id), &existingRegistry)
+		if mErr != nil {
+			return mErr
+		}
+		// optimisitic lock check
+		if existingRegistry.LastModificationTime != registryUpdate.LastModificationTime {
+			updatedRegistry = existingRegistry
+			return newPersistenceError(fmt.Sprintf("Conflict when trying to update registry with id %s", string(id)), Conflict)
+		}
+		registryUpdate.NewLastModificationTime()
+		registry := registryUpdate.MergeForUpdate(&existingRegistry)
+		sealed, updateErr := s.secrets.sealDockerRegistry(registry)
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := document(sealed)
+		if updateErr != nil {
+			return updateErr
+		}
+		// the check above is done without lock. A concurrent
+		// update is detected here, by the lock of the row.
+		updateErr = s.execOne(ctx, tx, "", "UPDATE docker_registries SET last_modification_time = ?, data = ? WHERE id = ? AND last_modification_time = ?",
+			registry.LastModificationTime, data, registry.Id, existingRegistry.LastModificationTime)
+		if updateErr != nil {
+			if persistenceErr, ok := updateErr.(PersistenceError); ok && persistenceErr.ErrorType() == NotFound {
+				s.fetchDockerRegistry(ctx, tx, string(id), &updatedRegistry)
+				return newPersistenceError(fmt.Sprintf("Conflict when trying to update registry with id %s", string(id)), Conflict)
+			}
+			return updateErr
+		}
+		updatedRegistry = *registry
+		return nil
+	})
+	return &updatedRegistry, wrapError(err)
+}
+
+func (s *sqlRepository) GetDockerRegistries(ctx context.Context) ([]*model.DockerRegistry, PersistenceError) {
+	registries := make([]*model.DockerRegistry, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		err := s.getDocuments(ctx, q, func() interface{} {
+			registry := new(model.DockerRegistry)
+			registries = append(registries, registry)
+			return registry
+		}, "SELECT data FROM docker_registries")
+		for _, registry := range registries {
+			if err != nil {
+				break
+			}
+			err = s.secrets.openDockerRegistry(registry)
+		}
+		return err
+	})
+	if err == nil {
+		sort.Slice(registries, func(i, j int) bool {
+			return registries[i].Name < registries[j].Name
+		})
+		return registries, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/configuration"
+)
+
+// re-encrypt the jobs and the registries in a single transaction
+func rotateSqlMasterKey(conf *configuration.Conf, d dialect, current, next *secretBox) (int, error) {
+	_, err := d.location(conf.PersistenceConf)
+	if err != nil {
+		return 0, err
+	}
+	db, err := sql.Open(d.driverName(), d.dataSourceName(conf.PersistenceConf))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	s := &sqlRepository{conf: conf, db: db, dialect: d}
+	ctx, cancel := context.WithTimeout(context.Background(), conf.PersistenceConf.TimeOut)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	changes := 0
+	for table, reencrypt := range map[string]func([]byte, *secretBox, *secretBox) ([]byte, error){
+		"jobs":              reencryptJob,
+		"docker_registries": reencryptDockerRegistry,
+		"teams":             reencryptTeam,
+		"signing_keys":      reencryptSigningKey,
+	} {
+		rows, err := s.query(ctx, tx, "SELECT id, data FROM "+table)
+		if err != nil {
+			return 0, err
+		}
+		updated := make(map[string]string)
+		for rows.Next() {
+			var id, data string
+			err = rows.Scan(&id, &data)
+			var reencrypted []byte
+			if err == nil {
+				reencrypted, err = reencrypt([]byte(data), current, next)
+			}
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+			updated[id] = string(reencrypted)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return 0, err
+		}
+		for id, data := range updated {
+			_, err = s.exec(ctx, tx, "UPDATE "+table+" SET data = ? WHERE id = ?", data, id)
+			if err != nil {
+				return 0, err
+			}
+		}
+		changes += len(updated)
+	}
+	return changes, tx.Commit()
+}
@@ -0,0 +1,298 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) CreateJob(job *model.Job, ctx context.Context) (*model.Job, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := job.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		updateErr = s.checkUnused(ctx, tx, fmt.Sprintf("A job with id %s already exists", string(job.Id)), "SELECT COUNT(*) FROM jobs WHERE id = ?", string(job.Id))
+		if updateErr != nil {
+			return updateErr
+		}
+		return s.insertJob(ctx, tx, job)
+	})
+	if err == nil {
+		return job, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertJob(ctx context.Context, q querier, job *model.Job) error {
+	sealed, err := s.secrets.sealJob(job)
+	if err != nil {
+		return err
+	}
+	data, err := document(sealed)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO jobs (id, team_id, data) VALUES (?, ?, ?)", string(job.Id), job.TeamId, data)
+	return err
+}
+
+func (s *sqlRepository) GetJob(id []byte, ctx context.Context) (*model.Job, PersistenceError) {
+	var job model.Job
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		mErr := s.getDocument(ctx, q, &job, fmt.Sprintf("No Job with id %s found", string(id)), "SELECT data FROM jobs WHERE id = ?", string(id))
+		if mErr == nil {
+			mErr = s.secrets.openJob(&job)
+		}
+		if mErr != nil {
+			return mErr
+		}
+		for index, step := range job.Steps {
+			if step.Image.RegistryId != "" {
+				registry, regErr := s.GetDockerRegistry([]byte(step.Image.RegistryId), ctx)
+				if regErr != nil {
+					return regErr
+				}
+				job.Steps[index].Image.Registry = registry
+			}
+		}
+		for index, notifier := range job.Notifiers {
+			if notifier.Image.RegistryId != "" {
+				registry, regErr := s.GetDockerRegistry([]byte(notifier.Image.RegistryId), ctx)
+				if regErr != nil {
+					return regErr
+				}
+				job.Notifiers[index].Image.Registry = registry
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return &job, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetJobs(ctx context.Context) ([]*model.Job, PersistenceError) {
+	jobs := make([]*model.Job, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		err := s.getDocuments(ctx, q, func() interface{} {
+			job := new(model.Job)
+			jobs = append(jobs, job)
+			return job
+		}, "SELECT data FROM jobs ORDER BY id")
+		for _, job := range jobs {
+			if err != nil {
+				break
+			}
+			err = s.secrets.openJob(job)
+		}
+		return err
+	})
+	if err == nil {
+		return jobs, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) CreateJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if execution.Id == "" {
+			if err := execution.GenerateId(); err != nil {
+				return err
+			}
+		}
+		err := s.checkUnused(ctx, tx, fmt.Sprintf("An execution with id %s already exists for job %s", execution.Id, jobId),
+			"SELECT COUNT(*) FROM job_executions WHERE job_id = ? AND id = ?", jobId, execution.Id)
+		if err != nil {
+			return err
+		}
+		data, err := document(execution)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec(ctx, tx, "INSERT INTO job_executions (job_id, id, started_at, status, branch, duration, data) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			jobId, execution.Id, unixNano(execution.Date), string(execution.Status), execution.BranchName, int64(execution.Duration), data)
+		return err
+	})
+	if err == nil {
+		return execution, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) UpsertJobExecution(ctx context.Context, jobId string, execution *model.JobExecution) (*model.JobExecution, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if execution.Id == "" {
+			return errors.New("persistence >> Cannot persist a job execution without id !")
+		}
+		var purged int
+		updateErr := s.queryRow(ctx, tx, "SELECT COUNT(*) FROM purged_executions WHERE job_id = ? AND id = ?", jobId, execution.Id).Scan(&purged)
+		if updateErr != nil {
+			return updateErr
+		}
+		if purged > 0 {
+			return newPersistenceError(fmt.Sprintf("The execution %s of job %s has been purged", execution.Id, jobId), NoMorePersisted)
+		}
+		data, updateErr := document(execution)
+		if updateErr != nil {
+			return updateErr
+		}
+		_, updateErr = s.exec(ctx, tx, "INSERT INTO job_executions (job_id, id, started_at, status, branch, duration, data) VALUES (?, ?, ?, ?, ?, ?, ?) "+
+			"ON CONFLICT (job_id, id) DO UPDATE SET started_at = excluded.started_at, status = excluded.status, branch = excluded.branch, duration = excluded.duration, data = excluded.data",
+			jobId, execution.Id, unixNano(execution.Date), string(execution.Status), execution.BranchName, int64(execution.Duration), data)
+		return updateErr
+	})
+	if err == nil {
+		return execution, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) DeleteJobExecutions(ctx context.Context, jobId string, executionIds []string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		purgedAt := time.Now().UnixNano()
+		for _, executionId := range executionIds {
+			_, updateErr := s.exec(ctx, tx, "DELETE FROM job_executions WHERE job_id = ? AND id = ?", jobId, executionId)
+			if updateErr != nil {
+				return updateErr
+			}
+			_, updateErr = s.exec(ctx, tx, "DELETE FROM log_lines WHERE job_id = ? AND execution_id = ?", jobId, executionId)
+			if updateErr != nil {
+				return updateErr
+			}
+			_, updateErr = s.exec(ctx, tx, "INSERT INTO purged_executions (job_id, id, purged_at) VALUES (?, ?, ?) ON CONFLICT (job_id, id) DO NOTHING", jobId, executionId, purgedAt)
+			if updateErr != nil {
+				return updateErr
+			}
+		}
+		return nil
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) ForgetPurgedExecutions(ctx context.Context, before time.Time) (int, PersistenceError) {
+	var forgotten int64
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, updateErr := s.exec(ctx, tx, "DELETE FROM purged_executions WHERE purged_at < ?", before.UnixNano())
+		if updateErr != nil {
+			return updateErr
+		}
+		forgotten, updateErr = res.RowsAffected()
+		return updateErr
+	})
+	if err == nil {
+		return int(forgotten), nil
+	} else {
+		return 0, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetJobExecution(ctx context.Context, jobId, executionId string) (*model.JobExecution, PersistenceError) {
+	var execution model.JobExecution
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocument(ctx, q, &execution, fmt.Sprintf("No execution with id %s found for job %s", executionId, jobId),
+			"SELECT data FROM job_executions WHERE job_id = ? AND id = ?", jobId, executionId)
+	})
+	if err == nil {
+		return &execution, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetJobExecutions(ctx context.Context, jobId string) ([]*model.JobExecution, PersistenceError) {
+	executions := make([]*model.JobExecution, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocuments(ctx, q, func() interface{} {
+			execution := new(model.JobExecution)
+			executions = append(executions, execution)
+			return execution
+		}, "SELECT data FROM job_executions WHERE job_id = ? ORDER BY started_at, id", jobId)
+	})
+	if err == nil {
+		return executions, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) SearchJobExecutions(ctx context.Context, jobId string, filter model.ExecutionFilter) ([]*model.JobExecution, PersistenceError) {
+	executions := make([]*model.JobExecution, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		conditions, args := executionConditions(jobId, filter)
+		// OFFSET needs a LIMIT with some databases
+		limit := int64(math.MaxInt64)
+		if filter.Limit > 0 {
+			limit = int64(filter.Limit)
+		}
+		return s.getDocuments(ctx, q, func() interface{} {
+			execution := new(model.JobExecution)
+			executions = append(executions, execution)
+			return execution
+		}, "SELECT data FROM job_executions"+where(conditions)+" ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?", append(args, limit, filter.Offset)...)
+	})
+	if err == nil {
+		return executions, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetJobExecutionStats(ctx context.Context, jobId string, filter model.ExecutionFilter) (*model.ExecutionStats, PersistenceError) {
+	stats := model.ExecutionStats{ByStatus: make(map[model.ExecutionStatus]int)}
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		conditions, args := executionConditions(jobId, filter)
+		rows, err := s.query(ctx, q, "SELECT status, COUNT(*), COALESCE(SUM(duration), 0) FROM job_executions"+where(conditions)+" GROUP BY status", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var status string
+			var count int
+			var duration int64
+			err = rows.Scan(&status, &count, &duration)
+			if err != nil {
+				return err
+			}
+			stats.Count += count
+			stats.ByStatus[model.ExecutionStatus(status)] = count
+			if model.ExecutionStatus(status) == model.Success {
+				stats.AverageDuration = time.Duration(duration / int64(count))
+			}
+		}
+		return rows.Err()
+	})
+	if err == nil {
+		return &stats, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// conditions of a search in the executions of a job. Each
+// criterion has an index starting with the job id.
+func executionConditions(jobId string, filter model.ExecutionFilter) ([]string, []interface{}) {
+	conditions := []string{"job_id = ?"}
+	args := []interface{}{jobId}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if filter.Branch != "" {
+		conditions = append(conditions, "branch = ?")
+		args = append(args, filter.Branch)
+	}
+	return conditions, args
+}
@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// each log line is a row, so that
+// a range of lines is a range of keys.
+func (s *sqlRepository) AppendLogs(ctx context.Context, jobId, executionId string, step int, lines []model.LogLine) PersistenceError {
+	if len(lines) == 0 {
+		return nil
+	}
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for _, line := range lines {
+			data, err := document(line)
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(ctx, tx, "INSERT INTO log_lines (job_id, execution_id, step, number, data) VALUES (?, ?, ?, ?, ?) "+
+				"ON CONFLICT (job_id, execution_id, step, number) DO UPDATE SET data = excluded.data",
+				jobId, executionId, step, line.Number, data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) GetLogs(ctx context.Context, jobId, executionId string, step, from, to int) ([]model.LogLine, PersistenceError) {
+	lines := make([]*model.LogLine, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		query := "SELECT data FROM log_lines WHERE job_id = ? AND execution_id = ? AND step = ? AND number >= ?"
+		args := []interface{}{jobId, executionId, step, from}
+		if to > 0 {
+			query += " AND number <= ?"
+			args = append(args, to)
+		}
+		return s.getDocuments(ctx, q, func() interface{} {
+			line := new(model.LogLine)
+			lines = append(lines, line)
+			return line
+		}, query+" ORDER BY number", args...)
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	res := make([]model.LogLine, 0, len(lines))
+	for _, line := range lines {
+		res = append(res, *line)
+	}
+	return res, nil
+}
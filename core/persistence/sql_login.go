@@ -0,0 +1,54 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) CreateLoginAttempt(attempt *model.LoginAttempt, ctx context.Context) (*model.LoginAttempt, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := attempt.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := document(attempt)
+		if updateErr != nil {
+			return updateErr
+		}
+		_, updateErr = s.exec(ctx, tx, "INSERT INTO login_attempts (id, login, attempted_at, data) VALUES (?, ?, ?, ?)",
+			attempt.Id, attempt.Login, unixNano(attempt.Time), data)
+		return updateErr
+	})
+	if err == nil {
+		return attempt, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetLoginAttempts(login string, limit int, ctx context.Context) ([]*model.LoginAttempt, PersistenceError) {
+	attempts := make([]*model.LoginAttempt, 0)
+	if limit <= 0 {
+		return attempts, nil
+	}
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		conditions := make([]string, 0)
+		args := make([]interface{}, 0)
+		if login != "" {
+			conditions = append(conditions, "login = ?")
+			args = append(args, login)
+		}
+		return s.getDocuments(ctx, q, func() interface{} {
+			attempt := new(model.LoginAttempt)
+			attempts = append(attempts, attempt)
+			return attempt
+		}, "SELECT data FROM login_attempts"+where(conditions)+" ORDER BY attempted_at DESC, id DESC LIMIT ?", append(args, limit)...)
+	})
+	if err == nil {
+		return attempts, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
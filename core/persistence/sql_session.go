@@ -0,0 +1,130 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+// sessions are stored by hash of their refresh
+// token, because this is the way they are searched for.
+func (s *sqlRepository) CreateSession(session *model.Session, ctx context.Context) (*model.Session, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if session.Hash == "" {
+			return errors.New("persistence >> Cannot persist a session without hash !")
+		}
+		updateErr := session.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		updateErr = s.insertSession(ctx, tx, session)
+		if s.dialect.isUniqueViolation(updateErr) {
+			session.Id = ""
+			return newPersistenceError("A session with the same hash already exists", Conflict)
+		}
+		return updateErr
+	})
+	if err == nil {
+		return session, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertSession(ctx context.Context, q querier, session *model.Session) error {
+	data, err := document(session)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO sessions (hash, id, login, expires_at, data) VALUES (?, ?, ?, ?, ?)",
+		session.Hash, session.Id, session.Login, unixNano(session.ExpiresAt), data)
+	return err
+}
+
+func (s *sqlRepository) RotateSession(hash, newHash string, expiresAt time.Time, ctx context.Context) (*model.Session, PersistenceError) {
+	var session model.Session
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		mErr := s.getDocument(ctx, tx, &session, "No session found", "SELECT data FROM sessions WHERE hash = ?", hash)
+		if mErr != nil {
+			return mErr
+		}
+		if session.IsExpired(time.Now()) {
+			return newPersistenceError("No session found", NotFound)
+		}
+		// the previous refresh token must not be usable anymore. If
+		// a concurrent rotation has already deleted it, this one fails.
+		mErr = s.execOne(ctx, tx, "No session found", "DELETE FROM sessions WHERE hash = ?", hash)
+		if mErr != nil {
+			return mErr
+		}
+		session.Hash = newHash
+		session.ExpiresAt = expiresAt
+		return s.insertSession(ctx, tx, &session)
+	})
+	if err == nil {
+		return &session, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) DeleteSession(ctx context.Context, id string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		deleted, deleteErr := s.deleteSessions(ctx, tx, "id", id)
+		if deleteErr == nil && deleted == 0 {
+			return newPersistenceError("No session found", NotFound)
+		}
+		return deleteErr
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) DeleteSessionsOf(ctx context.Context, login string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, deleteErr := s.deleteSessions(ctx, tx, "login", login)
+		return deleteErr
+	})
+	return wrapError(err)
+}
+
+// delete the sessions whose column has the given
+// value and return how many were deleted.
+// Expired sessions are purged on the way.
+func (s *sqlRepository) deleteSessions(ctx context.Context, tx *sql.Tx, column, value string) (int64, error) {
+	res, err := s.exec(ctx, tx, "DELETE FROM sessions WHERE "+column+" = ?", value)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.exec(ctx, tx, "DELETE FROM sessions WHERE expires_at < ?", time.Now().UnixNano())
+	return deleted, err
+}
+
+func (s *sqlRepository) RevokeToken(id string, expiresAt time.Time, ctx context.Context) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		// a revoked token that has expired is rejected
+		// anyway, there is no need to keep it.
+		_, updateErr := s.exec(ctx, tx, "DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().UnixNano())
+		if updateErr != nil {
+			return updateErr
+		}
+		_, updateErr = s.exec(ctx, tx, "INSERT INTO revoked_tokens (id, expires_at) VALUES (?, ?) "+
+			"ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at", id, unixNano(expiresAt))
+		return updateErr
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) IsTokenRevoked(id string, ctx context.Context) (bool, PersistenceError) {
+	var revoked int
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.queryRow(ctx, q, "SELECT COUNT(*) FROM revoked_tokens WHERE id = ?", id).Scan(&revoked)
+	})
+	return revoked > 0, wrapError(err)
+}
@@ -0,0 +1,96 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) RotateSigningKey(key *model.SigningKey, ctx context.Context) (*model.SigningKey, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := key.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		currentKeys := make([]*model.SigningKey, 0)
+		updateErr = s.getDocuments(ctx, tx, func() interface{} {
+			currentKey := new(model.SigningKey)
+			currentKeys = append(currentKeys, currentKey)
+			return currentKey
+		}, "SELECT data FROM signing_keys WHERE retired_at = 0")
+		if updateErr != nil {
+			return updateErr
+		}
+		// the retired keys are stored as read, already encrypted
+		for _, currentKey := range currentKeys {
+			currentKey.RetiredAt = key.CreatedAt
+			var data string
+			data, updateErr = document(currentKey)
+			if updateErr != nil {
+				return updateErr
+			}
+			_, updateErr = s.exec(ctx, tx, "UPDATE signing_keys SET retired_at = ?, data = ? WHERE id = ?", unixNano(currentKey.RetiredAt), data, currentKey.Id)
+			if updateErr != nil {
+				return updateErr
+			}
+		}
+		sealed, updateErr := s.secrets.sealSigningKey(key)
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := document(sealed)
+		if updateErr != nil {
+			return updateErr
+		}
+		_, updateErr = s.exec(ctx, tx, "INSERT INTO signing_keys (id, created_at, retired_at, data) VALUES (?, ?, ?, ?)",
+			key.Id, unixNano(key.CreatedAt), unixNano(key.RetiredAt), data)
+		return updateErr
+	})
+	if err == nil {
+		return key, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) GetSigningKeys(ctx context.Context) ([]*model.SigningKey, PersistenceError) {
+	keys := make([]*model.SigningKey, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		getErr := s.getDocuments(ctx, q, func() interface{} {
+			key := new(model.SigningKey)
+			keys = append(keys, key)
+			return key
+		}, "SELECT data FROM signing_keys ORDER BY created_at, id")
+		if getErr != nil {
+			return getErr
+		}
+		for _, key := range keys {
+			if openErr := s.secrets.openSigningKey(key); openErr != nil {
+				return openErr
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return keys, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) DeleteSigningKey(ctx context.Context, id string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var key model.SigningKey
+		mErr := s.getDocument(ctx, tx, &key, "No signing key found", "SELECT data FROM signing_keys WHERE id = ?", id)
+		if mErr != nil {
+			return mErr
+		}
+		if !key.IsRetired() {
+			return newPersistenceError("The current signing key cannot be deleted", Conflict)
+		}
+		_, mErr = s.exec(ctx, tx, "DELETE FROM signing_keys WHERE id = ?", id)
+		return mErr
+	})
+	return wrapError(err)
+}
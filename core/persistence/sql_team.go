@@ -0,0 +1,195 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) CreateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		updateErr := team.GenerateId()
+		if updateErr != nil {
+			return updateErr
+		}
+		return s.insertTeam(ctx, tx, team)
+	})
+	if err == nil {
+		return team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertTeam(ctx context.Context, q querier, team *model.Team) error {
+	sealed, err := s.secrets.sealTeam(team)
+	if err != nil {
+		return err
+	}
+	data, err := document(sealed)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO teams (id, data) VALUES (?, ?)", team.Id, data)
+	return err
+}
+
+func (s *sqlRepository) GetTeam(id string, ctx context.Context) (*model.Team, PersistenceError) {
+	var team model.Team
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		fetchErr := s.fetchTeam(ctx, q, id, &team)
+		if fetchErr != nil {
+			return fetchErr
+		}
+		return s.secrets.openTeam(&team)
+	})
+	if err == nil {
+		return &team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) fetchTeam(ctx context.Context, q querier, id string, team *model.Team) error {
+	return s.getDocument(ctx, q, team, fmt.Sprintf("No team with id %s found", id), "SELECT data FROM teams WHERE id = ?", id)
+}
+
+func (s *sqlRepository) GetTeams(ctx context.Context) ([]*model.Team, PersistenceError) {
+	teams := make([]*model.Team, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		getErr := s.getDocuments(ctx, q, func() interface{} {
+			team := new(model.Team)
+			teams = append(teams, team)
+			return team
+		}, "SELECT data FROM teams")
+		if getErr != nil {
+			return getErr
+		}
+		for _, team := range teams {
+			if openErr := s.secrets.openTeam(team); openErr != nil {
+				return openErr
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		sort.Slice(teams, func(i, j int) bool {
+			return teams[i].Name < teams[j].Name
+		})
+		return teams, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) UpdateTeam(team *model.Team, ctx context.Context) (*model.Team, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		sealed, updateErr := s.secrets.sealTeam(team)
+		if updateErr != nil {
+			return updateErr
+		}
+		data, updateErr := document(sealed)
+		if updateErr != nil {
+			return updateErr
+		}
+		return s.execOne(ctx, tx, fmt.Sprintf("No team with id %s found", team.Id), "UPDATE teams SET data = ? WHERE id = ?", data, team.Id)
+	})
+	if err == nil {
+		return team, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// delete one existing team. A team
+// that still owns jobs or registries
+// can't be deleted.
+func (s *sqlRepository) DeleteTeam(ctx context.Context, id string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var team model.Team
+		fetchErr := s.fetchTeam(ctx, tx, id, &team)
+		if fetchErr != nil {
+			return fetchErr
+		}
+		for _, owner := range []struct{ bucketName, table string }{{"jobs", "jobs"}, {"dockerRegistries", "docker_registries"}} {
+			var owned int
+			countErr := s.queryRow(ctx, tx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE team_id = ?", owner.table), id).Scan(&owned)
+			if countErr != nil {
+				return countErr
+			}
+			if owned > 0 {
+				return newPersistenceError(fmt.Sprintf("The team %s still owns some %s", id, owner.bucketName), Conflict)
+			}
+		}
+		_, deleteErr := s.exec(ctx, tx, "DELETE FROM teams WHERE id = ?", id)
+		return deleteErr
+	})
+	return wrapError(err)
+}
+
+func (s *sqlRepository) SetJobTeam(ctx context.Context, jobId []byte, teamId string) (*model.Job, PersistenceError) {
+	var job model.Job
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		moveErr := s.moveResource(ctx, tx, "jobs", string(jobId), teamId, &job, func() { job.TeamId = teamId })
+		if moveErr != nil {
+			return moveErr
+		}
+		return s.secrets.openJob(&job)
+	})
+	if err == nil {
+		return &job, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) SetDockerRegistryTeam(ctx context.Context, registryId []byte, teamId string) (*model.DockerRegistry, PersistenceError) {
+	var registry model.DockerRegistry
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		moveErr := s.moveResource(ctx, tx, "docker_registries", string(registryId), teamId, &registry, func() {
+			registry.TeamId = teamId
+			// the registry has changed, so pending
+			// updates must be rejected by the optimistic lock.
+			registry.NewLastModificationTime()
+		})
+		if moveErr != nil {
+			return moveErr
+		}
+		_, moveErr = s.exec(ctx, tx, "UPDATE docker_registries SET last_modification_time = ? WHERE id = ?", registry.LastModificationTime, registry.Id)
+		if moveErr != nil {
+			return moveErr
+		}
+		return s.secrets.openDockerRegistry(&registry)
+	})
+	if err == nil {
+		return &registry, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+// load the resource identified by id from the given table into
+// resource, apply the move on it and save it back. The target team
+// must exist, unless the resource is made global (empty teamId).
+func (s *sqlRepository) moveResource(ctx context.Context, tx *sql.Tx, table, id, teamId string, resource interface{}, move func()) error {
+	err := s.getDocument(ctx, tx, resource, fmt.Sprintf("No resource with id %s found in %s", id, table), fmt.Sprintf("SELECT data FROM %s WHERE id = ?", table), id)
+	if err != nil {
+		return err
+	}
+	if teamId != "" {
+		var team model.Team
+		if err = s.fetchTeam(ctx, tx, teamId, &team); err != nil {
+			return err
+		}
+	}
+	move()
+	data, err := document(resource)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, tx, fmt.Sprintf("UPDATE %s SET team_id = ?, data = ? WHERE id = ?", table), teamId, data, id)
+	return err
+}
@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jeromedoucet/dahu/core/model"
+)
+
+func (s *sqlRepository) GetUser(id string, ctx context.Context) (*model.User, PersistenceError) {
+	var user model.User
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocument(ctx, q, &user, fmt.Sprintf("No user with id %s found", id), "SELECT data FROM users WHERE login = ?", id)
+	})
+	if err == nil {
+		return &user, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) CreateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if user.Login == "" {
+			return errors.New("persistence >> Cannot persist a user without login !")
+		}
+		insertErr := s.insertUser(ctx, tx, *user)
+		if s.dialect.isUniqueViolation(insertErr) {
+			return newPersistenceError(fmt.Sprintf("A user with id %s already exists", user.Login), Conflict)
+		}
+		return insertErr
+	})
+	if err == nil {
+		return user, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) insertUser(ctx context.Context, q querier, user model.User) error {
+	data, err := document(user)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, q, "INSERT INTO users (login, data) VALUES (?, ?)", user.Login, data)
+	return err
+}
+
+func (s *sqlRepository) GetUsers(ctx context.Context) ([]*model.User, PersistenceError) {
+	users := make([]*model.User, 0)
+	err := s.doViewAction(ctx, func(ctx context.Context, q querier) error {
+		return s.getDocuments(ctx, q, func() interface{} {
+			user := new(model.User)
+			users = append(users, user)
+			return user
+		}, "SELECT data FROM users")
+	})
+	if err == nil {
+		// sorted here rather than by the database,
+		// whose collation may differ from the go order.
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].Login < users[j].Login
+		})
+		return users, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) UpdateUser(user *model.User, ctx context.Context) (*model.User, PersistenceError) {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		data, updateErr := document(user)
+		if updateErr != nil {
+			return updateErr
+		}
+		return s.execOne(ctx, tx, fmt.Sprintf("No user with id %s found", user.Login), "UPDATE users SET data = ? WHERE login = ?", data, user.Login)
+	})
+	if err == nil {
+		return user, nil
+	} else {
+		return nil, wrapError(err)
+	}
+}
+
+func (s *sqlRepository) DeleteUser(ctx context.Context, id string) PersistenceError {
+	err := s.doUpdateAction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		deleteErr := s.execOne(ctx, tx, fmt.Sprintf("No user with id %s found", id), "DELETE FROM users WHERE login = ?", id)
+		if deleteErr != nil {
+			return deleteErr
+		}
+		_, deleteErr = s.exec(ctx, tx, "DELETE FROM api_tokens WHERE login = ?", id)
+		if deleteErr != nil {
+			return deleteErr
+		}
+		_, deleteErr = s.exec(ctx, tx, "DELETE FROM sessions WHERE login = ? OR expires_at < ?", id, time.Now().UnixNano())
+		return deleteErr
+	})
+	return wrapError(err)
+}
@@ -0,0 +1,52 @@
+package persistence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite specificities of the
+// sql implementation of Repository
+type sqlite struct{}
+
+func (l sqlite) driverName() string {
+	return "sqlite3"
+}
+
+// every transaction takes the write lock when it begins, so that
+// two transactions reading then writing can't deadlock. Concurrent
+// ones wait for the lock up to the persistence timeout.
+func (l sqlite) dataSourceName(conf configuration.Persistence) string {
+	return fmt.Sprintf("file:%s?_busy_timeout=%d&_txlock=immediate", conf.Path(), conf.TimeOut.Nanoseconds()/1e6)
+}
+
+func (l sqlite) rebind(query string) string {
+	return query
+}
+
+func (l sqlite) schema() []string {
+	return sqlSchema("TEXT")
+}
+
+func (l sqlite) isUniqueViolation(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique)
+}
+
+func (l sqlite) location(conf configuration.Persistence) (string, error) {
+	path, err := filepath.Abs(conf.Path())
+	if err != nil {
+		return "", err
+	}
+	return path, os.MkdirAll(filepath.Dir(path), 0700)
+}
+
+// return the SQLite repository singleton instance.
+// create it if necessary
+func getOrCreateSqlite(conf *configuration.Conf) Repository {
+	return getOrCreateSql(conf, sqlite{})
+}
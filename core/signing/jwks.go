@@ -0,0 +1,105 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// json web key, as served on
+// /.well-known/jwks.json
+type JsonWebKey struct {
+	Kid string `json:"kid,omitempty"`
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JsonWebKeySet struct {
+	Keys []JsonWebKey `json:"keys"`
+}
+
+// return the public keys, current one first, that allow
+// other services to check the tokens. HMAC secrets are
+// never published, the set is empty with HS256.
+func (s *KeySet) Jwks() JsonWebKeySet {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	set := JsonWebKeySet{Keys: make([]JsonWebKey, 0, len(s.keys))}
+	for _, k := range s.keys {
+		jwk, err := toJsonWebKey(k.public())
+		if err != nil {
+			continue
+		}
+		jwk.Kid, jwk.Use, jwk.Alg = k.id, "sig", k.method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid == s.current.id || set.Keys[j].Kid != s.current.id && set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+func toJsonWebKey(public interface{}) (JsonWebKey, error) {
+	switch p := public.(type) {
+	case *rsa.PublicKey:
+		return JsonWebKey{Kty: "RSA", N: encode(p.N.Bytes()), E: encode(big.NewInt(int64(p.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		size := (p.Curve.Params().BitSize + 7) / 8
+		return JsonWebKey{Kty: "EC", Crv: p.Curve.Params().Name, X: encode(padded(p.X, size)), Y: encode(padded(p.Y, size))}, nil
+	default:
+		return JsonWebKey{}, errors.New("signing >> not a public key")
+	}
+}
+
+// RFC 7638 thumbprint of a public key
+func thumbprint(public interface{}) (string, error) {
+	jwk, err := toJsonWebKey(public)
+	if err != nil {
+		return "", err
+	}
+	// the required members only, in lexicographic order
+	var members interface{}
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encode(sum[:]), nil
+}
+
+func padded(value *big.Int, size int) []byte {
+	data := value.Bytes()
+	if len(data) >= size {
+		return data
+	}
+	return append(make([]byte, size-len(data)), data...)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,308 @@
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/model"
+	"github.com/jeromedoucet/dahu/core/persistence"
+)
+
+// kid of a HMAC secret given by the configuration
+const configuredSecretId = "config"
+
+var ErrConfiguredKey = errors.New("signing >> the signing key is given by the configuration and cannot be rotated")
+
+// public description of a signing key
+type KeyInfo struct {
+	Id        string    `json:"id"`
+	Algorithm string    `json:"algorithm"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// key ready to sign or to check tokens
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	private   interface{} // []byte, *rsa.PrivateKey or *ecdsa.PrivateKey
+	createdAt time.Time
+	retiredAt time.Time // zero for the current key
+}
+
+// KeySet signs the access tokens with its current key, and checks
+// them with any of its keys, found by the kid header of the token.
+// A retired key is kept as long as the tokens it signed may be valid,
+// so that a rotation doesn't close the sessions.
+type KeySet struct {
+	conf       configuration.Api
+	repository persistence.Repository
+	method     jwt.SigningMethod
+	configured bool // true when the current key is given by the configuration
+	mutex      sync.RWMutex
+	current    *key
+	keys       map[string]*key // every known key by id, the current one included
+}
+
+// load the keys stored in the repository. When the configuration
+// doesn't give the key, one is generated and stored on first start,
+// or when the configured algorithm changes.
+func NewKeySet(conf configuration.Api, repository persistence.Repository, ctx context.Context) (*KeySet, error) {
+	method, err := signingMethod(conf.SigningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	s := &KeySet{conf: conf, repository: repository, method: method, keys: make(map[string]*key)}
+	storedKeys, persistenceErr := repository.GetSigningKeys(ctx)
+	if persistenceErr != nil {
+		return nil, persistenceErr
+	}
+	for _, storedKey := range storedKeys {
+		k, parseErr := fromModel(storedKey)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		s.keys[k.id] = k
+		if !storedKey.IsRetired() {
+			s.current = k
+		}
+	}
+	s.purge(ctx, time.Now())
+	configuredKey, err := s.configuredKey()
+	if err != nil {
+		return nil, err
+	}
+	if configuredKey != nil {
+		s.configured = true
+		s.current = configuredKey
+		s.keys[configuredKey.id] = configuredKey
+	} else if s.current == nil || s.current.method != method {
+		_, err = s.Rotate(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// generate a new current key and retire the previous one
+func (s *KeySet) Rotate(ctx context.Context) (KeyInfo, error) {
+	if s.configured {
+		return KeyInfo{}, ErrConfiguredKey
+	}
+	secret, err := generateSecret(s.method)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	newKey := &model.SigningKey{Algorithm: s.method.Alg(), Secret: secret, CreatedAt: time.Now()}
+	newKey, persistenceErr := s.repository.RotateSigningKey(newKey, ctx)
+	if persistenceErr != nil {
+		return KeyInfo{}, persistenceErr
+	}
+	k, err := fromModel(newKey)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current != nil {
+		s.current.retiredAt = k.createdAt
+	}
+	s.current = k
+	s.keys[k.id] = k
+	s.purge(ctx, k.createdAt)
+	log.Printf("INFO >> new %s signing key %s", k.method.Alg(), k.id)
+	return k.info(), nil
+}
+
+// sign the claims with the current key
+func (s *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	s.mutex.RLock()
+	current := s.current
+	s.mutex.RUnlock()
+	token := jwt.NewWithClaims(current.method, claims)
+	token.Header["kid"] = current.id
+	return token.SignedString(current.private)
+}
+
+// KeyFunc return the key checking the given token. The key is
+// found by the kid header, and must match the algorithm of the
+// token. Tokens without kid are only accepted with a HMAC
+// current key.
+func (s *KeySet) KeyFunc(token *jwt.Token) (interface{}, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	kid, _ := token.Header["kid"].(string)
+	k := s.keys[kid]
+	if kid == "" {
+		k = s.current
+	}
+	if k == nil || k.isExpired(time.Now(), s.conf.TokenValidityDuration) {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() || (kid == "" && k.method.Alg() != jwt.SigningMethodHS256.Alg()) {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.public(), nil
+}
+
+// return the current key as a
+// HMAC secret or a PEM private key
+func (s *KeySet) configuredKey() (*key, error) {
+	if s.method == jwt.SigningMethodHS256 {
+		if s.conf.Secret == "" {
+			return nil, nil
+		}
+		return &key{id: configuredSecretId, method: s.method, private: []byte(s.conf.Secret)}, nil
+	}
+	if s.conf.SigningKeyFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(s.conf.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing >> no PEM data in %s", s.conf.SigningKeyFile)
+	}
+	private, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	k := &key{method: s.method, private: private}
+	if !k.matchesMethod() {
+		return nil, fmt.Errorf("signing >> the key of %s cannot be used with %s", s.conf.SigningKeyFile, s.method.Alg())
+	}
+	k.id, err = thumbprint(k.public())
+	return k, err
+}
+
+// forget the keys retired for longer than the validity of
+// the tokens. A failure is logged, the purge will be retried
+// on next rotation or start.
+func (s *KeySet) purge(ctx context.Context, now time.Time) {
+	for id, k := range s.keys {
+		if k.isExpired(now, s.conf.TokenValidityDuration) {
+			delete(s.keys, id)
+			err := s.repository.DeleteSigningKey(ctx, id)
+			if err != nil {
+				log.Printf("ERROR >> unable to delete the signing key %s : %s", id, err.Error())
+			}
+		}
+	}
+}
+
+func (k *key) isExpired(now time.Time, tokenValidity time.Duration) bool {
+	return !k.retiredAt.IsZero() && now.Sub(k.retiredAt) > tokenValidity
+}
+
+func (k *key) info() KeyInfo {
+	return KeyInfo{Id: k.id, Algorithm: k.method.Alg(), CreatedAt: k.createdAt}
+}
+
+// return the key checking the signatures
+func (k *key) public() interface{} {
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		return &private.PublicKey
+	case *ecdsa.PrivateKey:
+		return &private.PublicKey
+	default:
+		return private
+	}
+}
+
+func (k *key) matchesMethod() bool {
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		return k.method == jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		return k.method == jwt.SigningMethodES256 && private.Curve == elliptic.P256()
+	case []byte:
+		return k.method == jwt.SigningMethodHS256
+	default:
+		return false
+	}
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case "HS256":
+		return jwt.SigningMethodHS256, nil
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "ES256":
+		return jwt.SigningMethodES256, nil
+	default:
+		return nil, fmt.Errorf("signing >> unsupported signing algorithm %s", algorithm)
+	}
+}
+
+func fromModel(storedKey *model.SigningKey) (*key, error) {
+	method, err := signingMethod(storedKey.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	k := &key{id: storedKey.Id, method: method, createdAt: storedKey.CreatedAt, retiredAt: storedKey.RetiredAt}
+	if method == jwt.SigningMethodHS256 {
+		k.private = storedKey.Secret
+	} else {
+		k.private, err = x509.ParsePKCS8PrivateKey(storedKey.Secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !k.matchesMethod() {
+		return nil, fmt.Errorf("signing >> the stored key %s cannot be used with %s", k.id, method.Alg())
+	}
+	return k, nil
+}
+
+// return a new HMAC secret or a
+// new private key in PKCS #8 form
+func generateSecret(method jwt.SigningMethod) ([]byte, error) {
+	var private interface{}
+	var err error
+	switch method {
+	case jwt.SigningMethodHS256:
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		return secret, err
+	case jwt.SigningMethodRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(private)
+}
+
+// parse a PKCS #8, PKCS #1 (RSA)
+// or SEC 1 (EC) private key
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if private, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return private, nil
+	}
+	if private, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return private, nil
+	}
+	if private, err := x509.ParseECPrivateKey(der); err == nil {
+		return private, nil
+	}
+	return nil, errors.New("signing >> unsupported private key, expect a PKCS #8, PKCS #1 or EC key")
+}
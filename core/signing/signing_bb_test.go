@@ -0,0 +1,178 @@
+package signing_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jeromedoucet/dahu/configuration"
+	"github.com/jeromedoucet/dahu/core/persistence"
+	"github.com/jeromedoucet/dahu/core/signing"
+	"github.com/jeromedoucet/dahu/tests"
+)
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func check(keys *signing.KeySet, value string) error {
+	_, err := jwt.Parse(value, keys.KeyFunc)
+	return err
+}
+
+// test that the generated secret is stored
+// and used again on next start
+func TestGeneratedSecretIsPersisted(t *testing.T) {
+	// given
+	c := configuration.InitConf()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	defer tests.CleanPersistence(c)
+	keys, err := signing.NewKeySet(c.ApiConf, rep, ctx)
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err)
+	}
+	value, _ := keys.Sign(claims())
+
+	// when
+	restartedKeys, restartErr := signing.NewKeySet(c.ApiConf, rep, ctx)
+
+	// then
+	if restartErr != nil {
+		t.Fatalf("expect no error, but got %s", restartErr)
+	}
+	if err := check(restartedKeys, value); err != nil {
+		t.Fatalf("expect the token to stay valid after a restart, but got %s", err)
+	}
+	if len(restartedKeys.Jwks().Keys) != 0 {
+		t.Fatalf("expect the HMAC secret to not be published, but got %+v", restartedKeys.Jwks())
+	}
+}
+
+// test that the tokens signed by a retired key are
+// accepted until the end of the validity of the tokens
+func TestRotationKeepsRetiredKey(t *testing.T) {
+	// given
+	c := configuration.InitConf()
+	c.ApiConf.SigningAlgorithm = "ES256"
+	c.ApiConf.TokenValidityDuration = 200 * time.Millisecond
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	defer tests.CleanPersistence(c)
+	keys, _ := signing.NewKeySet(c.ApiConf, rep, ctx)
+	oldValue, _ := keys.Sign(claims())
+
+	// when
+	newKey, err := keys.Rotate(ctx)
+	newValue, _ := keys.Sign(claims())
+	retainedErr := check(keys, oldValue)
+	time.Sleep(300 * time.Millisecond)
+	expiredErr := check(keys, oldValue)
+
+	// then
+	if err != nil || newKey.Algorithm != "ES256" {
+		t.Fatalf("expect a new ES256 key, but got %+v and %v", newKey, err)
+	}
+	token, newErr := jwt.Parse(newValue, keys.KeyFunc)
+	if newErr != nil || token.Header["kid"] != newKey.Id {
+		t.Fatalf("expect the new token to be signed by the new key, but got %v", newErr)
+	}
+	if retainedErr != nil {
+		t.Fatalf("expect the old token to stay valid after the rotation, but got %s", retainedErr)
+	}
+	if expiredErr == nil {
+		t.Fatal("expect the old token to be rejected once its key expired")
+	}
+}
+
+// test the use of a RSA key given by
+// the configuration
+func TestConfiguredKeyFile(t *testing.T) {
+	// given
+	private, _ := rsa.GenerateKey(rand.Reader, 2048)
+	file, _ := ioutil.TempFile("", "dahu-key")
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+	file.Close()
+	c := configuration.InitConf()
+	c.ApiConf.SigningAlgorithm = "RS256"
+	c.ApiConf.SigningKeyFile = file.Name()
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	defer tests.CleanPersistence(c)
+
+	// when
+	keys, err := signing.NewKeySet(c.ApiConf, rep, ctx)
+
+	// then
+	if err != nil {
+		t.Fatalf("expect no error, but got %s", err)
+	}
+	value, _ := keys.Sign(claims())
+	if _, err := jwt.Parse(value, func(*jwt.Token) (interface{}, error) { return &private.PublicKey, nil }); err != nil {
+		t.Fatalf("expect the token to be signed by the configured key, but got %s", err)
+	}
+	jwks := keys.Jwks()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid == "" {
+		t.Fatalf("expect the configured key to be published, but got %+v", jwks)
+	}
+	if _, rotationErr := keys.Rotate(ctx); rotationErr != signing.ErrConfiguredKey {
+		t.Fatalf("expect a configured key to not be rotated, but got %v", rotationErr)
+	}
+}
+
+// test that a key file not matching
+// the algorithm is refused
+func TestConfiguredKeyFileOfAnotherAlgorithm(t *testing.T) {
+	// given
+	private, _ := rsa.GenerateKey(rand.Reader, 2048)
+	file, _ := ioutil.TempFile("", "dahu-key")
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)})
+	file.Close()
+	c := configuration.InitConf()
+	c.ApiConf.SigningAlgorithm = "ES256"
+	c.ApiConf.SigningKeyFile = file.Name()
+	rep := persistence.GetRepository(c)
+	defer tests.CleanPersistence(c)
+
+	// when
+	_, err := signing.NewKeySet(c.ApiConf, rep, context.Background())
+
+	// then
+	if err == nil {
+		t.Fatal("expect an error")
+	}
+}
+
+// test that a token without kid is
+// refused with an asymmetric key
+func TestTokenWithoutKid(t *testing.T) {
+	// given
+	c := configuration.InitConf()
+	c.ApiConf.SigningAlgorithm = "ES256"
+	ctx := context.Background()
+	rep := persistence.GetRepository(c)
+	defer tests.CleanPersistence(c)
+	keys, _ := signing.NewKeySet(c.ApiConf, rep, ctx)
+	value, _ := keys.Sign(claims())
+	token, _ := jwt.Parse(value, nil)
+	delete(token.Header, "kid")
+	token.Method = jwt.SigningMethodHS256
+	forged, _ := token.SignedString([]byte("guess"))
+
+	// when
+	err := check(keys, forged)
+
+	// then
+	if err == nil {
+		t.Fatal("expect the token without kid to be refused")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"time"
 
 	"github.com/jeromedoucet/dahu/configuration"
@@ -14,14 +15,27 @@ import (
 )
 
 func main() {
+	// todo parse arguments or conf file ?
+	conf := configuration.InitConf()
+	conf.ApiConf.Port = 4444 // todo look if it is really necessary
+	if err := configuration.ReadEnv(conf, os.LookupEnv); err != nil {
+		log.Fatalf("FATAL >> %s", err.Error())
+	}
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(conf, os.Args[1], os.Args[2:]))
+	}
+	serve(conf)
+}
 
+// start the api, and stop it
+// gracefully on interruption
+func serve(conf *configuration.Conf) {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
-	// todo parse arguments or conf file ?
-	conf := configuration.InitConf()
-	conf.ApiConf.Port = 4444       // todo look if it is really necessary
-	conf.ApiConf.Secret = "secret" // todo generate it
+	dataDir, _ := filepath.Abs(conf.PersistenceConf.DataDir)
+	log.Printf("INFO >> data directory : %s", dataDir)
 	apiInstance := api.InitRoute(conf)
 
 	s := &http.Server{
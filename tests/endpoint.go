@@ -6,9 +6,24 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
+// return an admin token
+// not bound to any user
 func GetToken(secret string, exp time.Time) string {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"exp": exp.Unix(),
+		"role": "admin",
+		"exp":  exp.Unix(),
+	})
+	res, _ := token.SignedString([]byte(secret))
+	return res
+}
+
+// return a token issued for the
+// user identified by login
+func GetUserToken(secret, login, role string, exp time.Time) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  login,
+		"role": role,
+		"exp":  exp.Unix(),
 	})
 	res, _ := token.SignedString([]byte(secret))
 	return res
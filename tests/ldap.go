@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v3"
+)
+
+// match the (attribute=value) items of a filter
+var ldapFilterItemRegexp = regexp.MustCompile(`\(([^()=&|!]+)=([^()]*)\)`)
+
+// entry of the stand-in directory
+type LdapEntry struct {
+	Dn         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Directory is a minimal in-process LDAP server. It only
+// supports simple binds and searches whose filters are
+// conjunctions of equality or presence items.
+type Directory struct {
+	Url      string
+	Entries  []LdapEntry
+	listener net.Listener
+}
+
+func NewDirectory(entries ...LdapEntry) *Directory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	d := &Directory{Url: "ldap://" + listener.Addr().String(), Entries: entries, listener: listener}
+	go d.serve()
+	return d
+}
+
+func (d *Directory) Close() {
+	d.listener.Close()
+}
+
+func (d *Directory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Directory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			conn.Write(ldapMessage(id, ldapResult(ldap.ApplicationBindResponse, d.bind(op))))
+		case ldap.ApplicationSearchRequest:
+			for _, entry := range d.search(op) {
+				conn.Write(ldapMessage(id, entry))
+			}
+			conn.Write(ldapMessage(id, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// return the result code of a bind request
+func (d *Directory) bind(op *ber.Packet) int {
+	dn, _ := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+	if dn == "" {
+		return ldap.LDAPResultSuccess
+	}
+	for _, entry := range d.Entries {
+		if strings.EqualFold(entry.Dn, dn) && entry.Password != "" && entry.Password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// return the entries matching a search request
+func (d *Directory) search(op *ber.Packet) []*ber.Packet {
+	baseDn, _ := op.Children[0].Value.(string)
+	filter, err := ldap.DecompileFilter(op.Children[6])
+	if err != nil {
+		return nil
+	}
+	requested := make([]string, 0)
+	for _, attribute := range op.Children[7].Children {
+		name, _ := attribute.Value.(string)
+		requested = append(requested, strings.ToLower(name))
+	}
+	res := make([]*ber.Packet, 0)
+	for _, entry := range d.Entries {
+		if strings.HasSuffix(strings.ToLower(entry.Dn), strings.ToLower(baseDn)) && entry.matches(filter) {
+			res = append(res, entry.packet(requested))
+		}
+	}
+	return res
+}
+
+func (e LdapEntry) matches(filter string) bool {
+	for _, item := range ldapFilterItemRegexp.FindAllStringSubmatch(filter, -1) {
+		values := e.Attributes[item[1]]
+		found := item[2] == "*" && len(values) > 0
+		for _, value := range values {
+			found = found || strings.EqualFold(value, item[2])
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (e LdapEntry) packet(requested []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.Dn, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.Attributes {
+		if len(requested) > 0 && !contains(requested, strings.ToLower(name)) {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return op
+}
+
+func ldapMessage(id int64, op *ber.Packet) []byte {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	envelope.AppendChild(op)
+	return envelope.Bytes()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// authorization granted by the stand-in
+// identity provider, waiting to be exchanged
+type authorization struct {
+	clientId    string
+	redirectUri string
+	nonce       string
+	challenge   string
+}
+
+// IdentityProvider is a minimal OpenID Connect provider
+// serving the discovery, authorization, token and jwks
+// endpoints. Every authorization request is granted
+// to a user having the claims of Claims.
+type IdentityProvider struct {
+	Server       *httptest.Server
+	ClientId     string
+	ClientSecret string
+	Claims       map[string]interface{} // claims added to the id tokens
+	Audience     string                 // audience of the id tokens. ClientId when empty
+	key          *rsa.PrivateKey
+	mutex        sync.Mutex
+	codes        map[string]authorization
+}
+
+func NewIdentityProvider(clientId, clientSecret string) *IdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	idp := &IdentityProvider{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		Claims:       make(map[string]interface{}),
+		key:          key,
+		codes:        make(map[string]authorization),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.onDiscovery)
+	mux.HandleFunc("/authorize", idp.onAuthorize)
+	mux.HandleFunc("/token", idp.onToken)
+	mux.HandleFunc("/jwks", idp.onJwks)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *IdentityProvider) Issuer() string {
+	return idp.Server.URL
+}
+
+func (idp *IdentityProvider) Close() {
+	idp.Server.Close()
+}
+
+func (idp *IdentityProvider) onDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.Issuer(),
+		"authorization_endpoint": idp.Issuer() + "/authorize",
+		"token_endpoint":         idp.Issuer() + "/token",
+		"jwks_uri":               idp.Issuer() + "/jwks",
+	})
+}
+
+// grant the authorization and redirect
+// immediately to the client
+func (idp *IdentityProvider) onAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	code := randomHex()
+	idp.mutex.Lock()
+	idp.codes[code] = authorization{
+		clientId:    query.Get("client_id"),
+		redirectUri: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+	}
+	idp.mutex.Unlock()
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+params.Encode(), http.StatusFound)
+}
+
+// exchange a code against an id token, after checking
+// the client credentials and the PKCE verifier
+func (idp *IdentityProvider) onToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	idp.mutex.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mutex.Unlock()
+	clientId, clientSecret, _ := r.BasicAuth()
+	clientId, _ = url.QueryUnescape(clientId)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || clientId != idp.ClientId || clientSecret != idp.ClientSecret || auth.clientId != idp.ClientId ||
+		auth.redirectUri != r.PostForm.Get("redirect_uri") || base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	audience := idp.Audience
+	if audience == "" {
+		audience = idp.ClientId
+	}
+	claims := jwt.MapClaims{
+		"iss":   idp.Issuer(),
+		"aud":   audience,
+		"sub":   randomHex(),
+		"nonce": auth.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range idp.Claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	idToken, _ := token.SignedString(idp.key)
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": randomHex(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (idp *IdentityProvider) onJwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": "test-key",
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func randomHex() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -10,6 +11,28 @@ import (
 	"github.com/jeromedoucet/dahu/core/persistence"
 )
 
+// implemented by the repositories that are not bbolt
+// files, to insert test data as tests.InsertObject does
+type objectInserter interface {
+	InsertObject(bucketName string, key []byte, data []byte) error
+}
+
+// the default configuration, with the persistence chosen by the
+// DAHU_TEST_PERSISTENCE environment variable: 'sqlite', 'postgres', reached
+// with DAHU_TEST_POSTGRES_URL, or bbolt when unset. The persistence tests
+// use it, so that every implementation passes the same suite.
+func InitConf() *configuration.Conf {
+	conf := configuration.InitConf()
+	switch os.Getenv("DAHU_TEST_PERSISTENCE") {
+	case "sqlite":
+		conf.PersistenceConf.Type = configuration.SQLite
+	case "postgres":
+		conf.PersistenceConf.Type = configuration.PostgreSQL
+		conf.PersistenceConf.Url = os.Getenv("DAHU_TEST_POSTGRES_URL")
+	}
+	return conf
+}
+
 type MockRepository struct {
 	persistence.Repository
 	CreateJobRunCount int
@@ -43,13 +66,29 @@ func ClosePersistence(conf *configuration.Conf) {
 }
 
 func DeletePersistence(conf *configuration.Conf) {
-	os.Remove(conf.PersistenceConf.Name)
+	switch conf.PersistenceConf.Type {
+	case configuration.PostgreSQL:
+		db, err := sql.Open("postgres", conf.PersistenceConf.Url)
+		if err == nil {
+			db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public")
+			db.Close()
+		}
+	default:
+		os.Remove(conf.PersistenceConf.Path())
+	}
 }
 
 // insert some objet inside a given bucket. Create the bucket
-// if needed
+// if needed. With a sql persistence, the bucket is the
+// one of the bbolt implementation, mapped to its table.
 func InsertObject(conf *configuration.Conf, bucketName, key []byte, object interface{}) {
-	db, _ := bolt.Open(conf.PersistenceConf.Name, 0600, nil)
+	if conf.PersistenceConf.Type != configuration.InMemory {
+		data, _ := json.Marshal(object)
+		inserter := persistence.GetRepository(conf).(objectInserter)
+		inserter.InsertObject(string(bucketName), key, data)
+		return
+	}
+	db, _ := bolt.Open(conf.PersistenceConf.Path(), 0600, nil)
 	db.Update(func(tx *bolt.Tx) error {
 		b, _ := tx.CreateBucketIfNotExists(bucketName)
 		var data []byte
@@ -60,9 +99,10 @@ func InsertObject(conf *configuration.Conf, bucketName, key []byte, object inter
 	db.Close()
 }
 
+// only usable with the bbolt persistence
 func ObjectExist(conf *configuration.Conf, bucketName, key []byte) bool {
 	res := false
-	db, _ := bolt.Open(conf.PersistenceConf.Name, 0600, nil)
+	db, _ := bolt.Open(conf.PersistenceConf.Path(), 0600, nil)
 	db.View(func(tx *bolt.Tx) error {
 		data := tx.Bucket(bucketName).Get(key)
 		if data != nil {
@@ -74,8 +114,9 @@ func ObjectExist(conf *configuration.Conf, bucketName, key []byte) bool {
 	return res
 }
 
+// only usable with the bbolt persistence
 func DeleteBucket(conf *configuration.Conf, bucketName []byte) {
-	db, _ := bolt.Open(conf.PersistenceConf.Name, 0600, nil)
+	db, _ := bolt.Open(conf.PersistenceConf.Path(), 0600, nil)
 	db.Update(func(tx *bolt.Tx) error {
 		tx.DeleteBucket(bucketName)
 		return nil